@@ -4,7 +4,17 @@
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
+
+	"google.golang.org/grpc"
+
+	"github.com/zcash/lightwalletd/common"
 )
 
 func TestFileExists(t *testing.T) {
@@ -20,3 +30,64 @@ func TestFileExists(t *testing.T) {
 		t.Fatal("fileExists failed")
 	}
 }
+
+func TestHealthzHandler(t *testing.T) {
+	var backendErr error
+	common.RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		if backendErr != nil {
+			return nil, backendErr
+		}
+		return json.Marshal(&common.ZcashdRpcReplyGetblockchaininfo{Blocks: 380640})
+	}
+	handler := healthzHandler(nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatal("unexpected status code", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `"height":380640`) ||
+		!strings.Contains(body, `"lag":0`) || strings.Contains(body, "error") {
+		t.Fatal("unexpected body", body)
+	}
+
+	backendErr = errors.New("connection refused")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatal("unexpected status code", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "connection refused") ||
+		strings.Contains(body, "height") {
+		t.Fatal("unexpected body", body)
+	}
+}
+
+// The darkside controls are served only in darkside mode.
+func TestRegisterServices(t *testing.T) {
+	defer func(saved bool) { common.DarksideEnabled = saved }(common.DarksideEnabled)
+	services := func(opts *common.Options) ([]string, error) {
+		server := grpc.NewServer()
+		err := registerServices(server, nil, "main", opts)
+		var names []string
+		for name := range server.GetServiceInfo() {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return names, err
+	}
+	const lwd, darkside = "cash.z.wallet.sdk.rpc.CompactTxStreamer", "cash.z.wallet.sdk.rpc.DarksideStreamer"
+
+	common.DarksideEnabled = false
+	if names, err := services(&common.Options{}); err != nil || !slices.Equal(names, []string{lwd}) {
+		t.Fatal("unexpected services", names, err)
+	}
+	// The darkside service refuses to run with a real backend node.
+	if _, err := services(&common.Options{Darkside: true}); err == nil {
+		t.Fatal("darkside service registered without darkside mode")
+	}
+	common.DarksideEnabled = true
+	if names, err := services(&common.Options{Darkside: true}); err != nil || !slices.Equal(names, []string{lwd, darkside}) {
+		t.Fatal("unexpected services", names, err)
+	}
+}
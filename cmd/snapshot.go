@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/zcash/lightwalletd/common"
+)
+
+// exportSnapshotCmd writes a snapshot of the compact block cache, which can
+// be used to seed another server's cache (see --import-snapshot).
+var exportSnapshotCmd = &cobra.Command{
+	Use:   "export-snapshot CHAIN FILE",
+	Short: "Write a snapshot of the compact block cache",
+	Long: `Write a snapshot of the compact block cache of the given chain (such as
+"main") to FILE, which can be used to seed another server's cache
+(see --import-snapshot). The cache may be in use by a running lightwalletd.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dataDir, err := cmd.Flags().GetString("data-dir")
+		if err != nil {
+			return err
+		}
+		cache, err := common.NewBlockCacheReadOnly(filepath.Join(dataDir, "db"), args[0])
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+		f, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		n, err := cache.ExportSnapshot(f)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Println("exported", n, "blocks starting at height", cache.GetFirstHeight())
+		return nil
+	},
+}
+
+func init() {
+	exportSnapshotCmd.Flags().String("data-dir", "/var/lib/lightwalletd", "data directory (such as db)")
+}
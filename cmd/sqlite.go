@@ -0,0 +1,11 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+//go:build sqlite
+
+package cmd
+
+// Building with -tags sqlite (which needs cgo) adds the "sqlite"
+// --cache-backend.
+import _ "github.com/mattn/go-sqlite3"
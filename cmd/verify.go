@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/zcash/lightwalletd/common"
+	"github.com/zcash/lightwalletd/frontend"
+)
+
+// verifyRangeCmd is a diagnostic that re-fetches and parses blocks and
+// compares them with what the backend node reports, without using the
+// disk cache.
+var verifyRangeCmd = &cobra.Command{
+	Use:   "verify-range FIRST LAST",
+	Short: "Compare blocks as parsed by lightwalletd with the backend node",
+	Long: `Fetch the blocks from height FIRST to LAST (inclusive) from the backend
+node, parse them, and compare their hashes, heights, and txids with the
+values the node reports, then print a summary of any mismatches. This is
+a dry run, for checking a node upgrade or a parser change; the disk cache
+isn't read or written. Exits with status 2 if there are mismatches.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		first, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		last, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+		if first < 0 || last < first {
+			return errors.New("invalid height range")
+		}
+		opts := &common.Options{}
+		for flag, value := range map[string]*string{
+			"zcash-conf-path": &opts.ZcashConfPath,
+			"rpcuser":         &opts.RPCUser,
+			"rpcpassword":     &opts.RPCPassword,
+			"rpchost":         &opts.RPCHost,
+			"rpcport":         &opts.RPCPort,
+		} {
+			if *value, err = cmd.Flags().GetString(flag); err != nil {
+				return err
+			}
+		}
+		var rpcClient *frontend.HTTPClient
+		if opts.RPCUser != "" && opts.RPCPassword != "" && opts.RPCHost != "" && opts.RPCPort != "" {
+			rpcClient = frontend.NewZRPCHTTPFromFlags(opts)
+		} else {
+			rpcClient, err = frontend.NewZRPCHTTPFromConf(opts.ZcashConfPath)
+		}
+		if err != nil {
+			return err
+		}
+		report, err := common.VerifyRange(context.Background(), rpcClient, first, last)
+		report.WriteSummary(os.Stdout)
+		if err != nil {
+			return err
+		}
+		if len(report.Mismatches) > 0 {
+			os.Exit(2)
+		}
+		return nil
+	},
+}
+
+func init() {
+	verifyRangeCmd.Flags().String("zcash-conf-path", "./juno.conf", "conf file to pull RPC creds from")
+	verifyRangeCmd.Flags().String("rpcuser", "", "RPC user name")
+	verifyRangeCmd.Flags().String("rpcpassword", "", "RPC password")
+	verifyRangeCmd.Flags().String("rpchost", "", "RPC host")
+	verifyRangeCmd.Flags().String("rpcport", "", "RPC host port")
+}
@@ -7,27 +7,587 @@ package common
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"hash/fnv"
 	"io"
+	"iter"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/zcash/lightwalletd/hash32"
 	"github.com/zcash/lightwalletd/walletrpc"
 	"google.golang.org/protobuf/proto"
 )
 
+// CacheMaxAge, if nonzero, is the age beyond which the block ingestor
+// prunes cached blocks (--cache-max-age); see PruneBefore.
+var CacheMaxAge time.Duration
+
+// SyncPolicy is how often the cache flushes (fsyncs) its db files as
+// blocks are added: after every SyncPolicy blocks, or, if zero, only when
+// Sync is called (which the block ingestor does whenever it has caught up
+// with the backend node). Flushing less often makes the initial sync much
+// faster, at the cost of losing (and having to re-download) the blocks
+// added since the last flush if the system crashes; a partially-written
+// block is discarded on restart (see Repair). A process crash, as opposed
+// to a system crash or power loss, loses nothing either way.
+type SyncPolicy int
+
+const (
+	SyncNever  SyncPolicy = 0 // only on Sync
+	SyncAlways SyncPolicy = 1 // after every block
+)
+
+// SyncEveryN returns the policy of flushing after every n added blocks.
+func SyncEveryN(n int) SyncPolicy {
+	return SyncPolicy(max(n, 0))
+}
+
+// CacheSyncPolicy is the SyncPolicy of the caches created by NewBlockCache
+// (--cache-sync-every).
+var CacheSyncPolicy = SyncNever
+
+// CacheWriteBuffer, if nonzero, is the number of added blocks that the
+// caches created by NewBlockCache hold in memory before writing them to the
+// db files together (--cache-write-buffer); buffered blocks are also written
+// once the oldest is cacheFlushInterval old, and by Flush. Buffered blocks
+// are served normally, but are lost if the process crashes (the ingestor
+// then downloads them again).
+var CacheWriteBuffer int
+
+// cacheFlushInterval is the longest time a block stays in the write buffer
+// (if blocks are still being added; otherwise, until Flush is called).
+const cacheFlushInterval = 5 * time.Second
+
+// CompressCache, if true, causes a new (or emptied, as by --redownload)
+// cache to store blocks gzip-compressed (--cache-compress). An existing cache
+// keeps the format it was created with, recorded in the blocks file header.
+var CompressCache bool
+
+// The store's header (see CacheStore), which for the default store is at
+// the start of the blocks file, is dbMagic followed by the (uint32
+// little-endian) format. If the format includes dbFormatChain, the header
+// goes on to record the chain the blocks belong to: the hash of its genesis
+// block (see GenesisHash) and its name (uint32 length, then the name), which
+// are checked when the cache is opened. A blocks file without a header (as written by
+// older versions) contains uncompressed blocks; older versions also wrote
+// compressed caches with a header that doesn't record the chain.
+var dbMagic = []byte("lwdb")
+
+const (
+	dbFormatPlain  = 0     // marshalled blocks
+	dbFormatGzip   = 1     // gzip-compressed marshalled blocks
+	dbFormatChain  = 0x100 // or'd into the format, header records the chain
+	dbHeaderLength = 8     // without the chain
+	dbChainLength  = 36    // genesis block hash and name length
+	maxChainName   = 64
+)
+
+// errUnknownFormat means the blocks file was written by a newer version.
+var errUnknownFormat = errors.New("unknown db blocks file format")
+
+// GenesisHash is the hash of the genesis block of the node's chain, which
+// caches record (and check when they're opened) along with the chain's name,
+// so that a cache isn't used with another chain that has the same name. The
+// node reports it at startup; it's zero (not checked) if unknown, as in
+// darkside mode and unit tests.
+var GenesisHash hash32.T
+
 // BlockCache contains a consecutive set of recent compact blocks in marshalled form.
 type BlockCache struct {
-	lengthsName, blocksName string // pathnames
-	lengthsFile, blocksFile *os.File
-	starts                  []int64  // Starting offset of each block within blocksFile
-	firstBlock              int      // height of the first block in the cache (usually Sapling activation)
-	nextBlock               int      // height of the first block not in the cache
-	latestHash              hash32.T // hash of the most recent (highest height) block, for detecting reorgs.
-	mutex                   sync.RWMutex
+	chainName      string          // such as "main" or "test"
+	dir            string          // the cache's directory, within dbPath
+	checkpointName string          // see writeCheckpoint
+	checkpoint     cacheCheckpoint // as last written by writeCheckpoint
+	store          CacheStore      // see CacheBackend
+	starts         []int64         // Starting offset of each block within the store's entries
+	firstBlock     int             // height of the first block in the cache (usually Sapling activation)
+	nextBlock      int             // height of the first block not in the cache
+	latestHash     hash32.T        // hash of the most recent (highest height) block, for detecting reorgs.
+	maxBlocks      int             // if nonzero, evict the oldest blocks beyond this many
+	confirmations  int             // see SetConfirmationDepth
+	format         uint32          // dbFormatPlain or dbFormatGzip
+	chainHeader    bool            // the store's header records the chain
+	genesis        hash32.T        // from GenesisHash
+	readOnly       bool            // see NewBlockCacheReadOnly
+	syncPolicy     SyncPolicy      // from CacheSyncPolicy
+	unsynced       int             // blocks added since the last flush, see SyncPolicy
+	writeBuffer    int             // from CacheWriteBuffer
+	actionSums     []int64         // Orchard actions before each starts[] entry, see Stats
+	pending        [][]byte        // buffered store entries (not yet written)
+	pendingSince   time.Time       // when the oldest buffered block was added
+	full           *fullBlockStore // see CacheFullBlocks, may be nil
+	mutex          sync.RWMutex
+
+	// statistics, see Metrics() and Timings()
+	hits, misses, reorgs                      atomic.Uint64
+	addTime, getTime, getRangeTime, reorgTime opTime
+
+	observer atomic.Pointer[func(CacheEvent)] // see SetObserver
+	logger   atomic.Pointer[logrus.Entry]     // see SetLogger
+
+	subsMutex   sync.Mutex // protects subscribers and subsClosed
+	subscribers []chan int // see Subscribe
+	subsClosed  bool
+}
+
+// CacheEvent describes a cache lookup or reorg, see SetObserver.
+type CacheEvent struct {
+	Hit    bool // the requested block was cached
+	Height int  // the requested height, or for a reorg, the new next height
+	Reorg  bool // blocks at Height and above were removed
+}
+
+// SetObserver arranges for f to be called for each cache lookup (each Get,
+// and each block returned by GetRange) and each reorg that removes blocks,
+// for instrumentation; nil removes the observer. The cache isn't locked
+// when f is called, so f may use the cache, but it should return quickly.
+func (c *BlockCache) SetObserver(f func(CacheEvent)) {
+	if f == nil {
+		c.observer.Store(nil)
+		return
+	}
+	c.observer.Store(&f)
+}
+
+// SetLogger sets the logger for the cache's Add and Reorg messages (which
+// have structured fields); nil (the default) means Log.
+func (c *BlockCache) SetLogger(l *logrus.Entry) {
+	c.logger.Store(l)
+}
+
+func (c *BlockCache) log() *logrus.Entry {
+	if l := c.logger.Load(); l != nil {
+		return l
+	}
+	return Log
+}
+
+// logAdd logs (at debug level) the addition of the given block, and whether
+// its prevhash links to the block before it (prevHash).
+func (c *BlockCache) logAdd(block *walletrpc.CompactBlock, prevHash hash32.T) {
+	hash, _ := hash32.FromSlice(block.Hash)
+	blockPrevHash, _ := hash32.FromSlice(block.PrevHash)
+	c.log().WithFields(logrus.Fields{
+		"height":  block.Height,
+		"hash":    hash.Short(),
+		"links":   prevHash.IsNil() || blockPrevHash == prevHash,
+		"actions": compactActionCount(block),
+	}).Debug("cache add")
+}
+
+// compactActionCount returns the number of Orchard actions in a cached
+// (compact) block; the cache doesn't have the full block, whose
+// parser.Block.CompactActionCount() this equals.
+func compactActionCount(block *walletrpc.CompactBlock) int {
+	count := 0
+	for _, tx := range block.Vtx {
+		count += len(tx.Actions)
+	}
+	return count
+}
+
+func (c *BlockCache) notify(e CacheEvent) {
+	if f := c.observer.Load(); f != nil {
+		(*f)(e)
+	}
+}
+
+// Subscribe returns a channel that receives the cache's new latest height
+// each time blocks are added (or, for a read-only cache, refreshed). The
+// channel holds only the most recent height, so a slow subscriber skips
+// heights rather than delaying the cache. The channel is closed by
+// Unsubscribe or Close.
+func (c *BlockCache) Subscribe() <-chan int {
+	c.subsMutex.Lock()
+	defer c.subsMutex.Unlock()
+	ch := make(chan int, 1)
+	if c.subsClosed {
+		close(ch)
+		return ch
+	}
+	c.subscribers = append(c.subscribers, ch)
+	return ch
+}
+
+// Unsubscribe closes and removes the given channel (from Subscribe).
+func (c *BlockCache) Unsubscribe(ch <-chan int) {
+	c.subsMutex.Lock()
+	defer c.subsMutex.Unlock()
+	for i, sub := range c.subscribers {
+		if sub == ch {
+			close(sub)
+			c.subscribers = slices.Delete(c.subscribers, i, i+1)
+			return
+		}
+	}
+}
+
+// publish sends the latest height to each subscriber, replacing any height
+// the subscriber hasn't yet received.
+func (c *BlockCache) publish(height int) {
+	c.subsMutex.Lock()
+	defer c.subsMutex.Unlock()
+	for _, ch := range c.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- height
+	}
+}
+
+// closeSubscribers closes the subscribers' channels; later subscribers get
+// a closed channel.
+func (c *BlockCache) closeSubscribers() {
+	c.subsMutex.Lock()
+	defer c.subsMutex.Unlock()
+	for _, ch := range c.subscribers {
+		close(ch)
+	}
+	c.subscribers = nil
+	c.subsClosed = true
+}
+
+// CacheMetrics is a snapshot of a BlockCache's statistics.
+type CacheMetrics struct {
+	Hits        uint64 // blocks returned by Get or GetRange
+	Misses      uint64 // Get calls for blocks that aren't cached
+	Reorgs      uint64 // Reorg calls that removed blocks
+	Blocks      int    // number of cached blocks
+	FirstHeight int    // height of the first cached block
+	NextHeight  int    // height of the first block not in the cache
+	DiskBytes   int64  // storage used by the cache
+}
+
+// Metrics returns a snapshot of the cache's statistics.
+func (c *BlockCache) Metrics() CacheMetrics {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return CacheMetrics{
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Reorgs:      c.reorgs.Load(),
+		Blocks:      c.nextBlock - c.firstBlock,
+		FirstHeight: c.firstBlock,
+		NextHeight:  c.nextBlock,
+		DiskBytes:   c.diskBytes(),
+	}
+}
+
+// diskBytes returns the storage used by the cached blocks, including the
+// buffered ones (see CacheWriteBuffer).
+// Caller should hold (at least) c.mutex.RLock().
+func (c *BlockCache) diskBytes() int64 {
+	nBlocks := c.nextBlock - c.firstBlock
+	return c.store.Size() + c.starts[nBlocks] - c.starts[nBlocks-len(c.pending)]
+}
+
+// opTime accumulates the number and total duration of a cache operation.
+type opTime struct {
+	count, nanos atomic.Uint64
+}
+
+// since records an operation that began at start; use it as
+// defer t.since(time.Now()).
+func (t *opTime) since(start time.Time) {
+	t.count.Add(1)
+	t.nanos.Add(uint64(time.Since(start)))
+}
+
+func (t *opTime) load() CacheTiming {
+	return CacheTiming{Count: t.count.Load(), Total: time.Duration(t.nanos.Load())}
+}
+
+// CacheTiming is the number of calls of a cache operation and their total
+// duration (including waiting for the cache lock).
+type CacheTiming struct {
+	Count uint64
+	Total time.Duration
+}
+
+// CacheTimings is a snapshot of the time spent in the cache's operations.
+type CacheTimings struct {
+	Add      CacheTiming // Add, AddFull, and AddBatch
+	Get      CacheTiming // Get
+	GetRange CacheTiming // each batch read by GetRange
+	Reorg    CacheTiming // Reorg
+}
+
+// Timings returns a snapshot of the time spent in the cache's operations.
+func (c *BlockCache) Timings() CacheTimings {
+	return CacheTimings{
+		Add:      c.addTime.load(),
+		Get:      c.getTime.load(),
+		GetRange: c.getRangeTime.load(),
+		Reorg:    c.reorgTime.load(),
+	}
+}
+
+// CacheStats describes the cache's contents.
+type CacheStats struct {
+	FirstBlock     int      // height of the first cached block
+	NextBlock      int      // height of the first block not in the cache
+	LatestHash     hash32.T // hash of the latest cached block
+	Blocks         int      // number of cached blocks
+	DiskBytes      int64    // storage used by the cache
+	AvgBlockSize   int64    // average size of a cached block as stored (perhaps compressed)
+	OrchardActions int64    // number of Orchard actions in the cached blocks
+}
+
+// Stats returns a description of the cache's contents, which is maintained
+// as blocks are added and removed (the store keeps each cached block's
+// action count, see CacheStore).
+func (c *BlockCache) Stats() CacheStats {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	blocks := c.nextBlock - c.firstBlock
+	s := CacheStats{
+		FirstBlock:     c.firstBlock,
+		NextBlock:      c.nextBlock,
+		LatestHash:     c.latestHash,
+		Blocks:         blocks,
+		DiskBytes:      c.diskBytes(),
+		OrchardActions: c.actionSums[blocks] - c.actionSums[0],
+	}
+	if blocks > 0 {
+		s.AvgBlockSize = (c.starts[blocks] - int64(8*blocks)) / int64(blocks)
+	}
+	return s
+}
+
+// countBlocks computes the starts and actionSums entries that are missing
+// (all of them when the cache is opened) from the store's EntryInfo. The
+// blocks whose action counts the store doesn't have are read to count them,
+// and the counts are then stored (unless the cache is read-only), so that
+// happens only once, for a cache written before the store kept them.
+// Caller should hold c.mutex.Lock().
+func (c *BlockCache) countBlocks() error {
+	first := len(c.starts) - 1
+	infos, err := c.store.Info(first, c.nextBlock-c.firstBlock)
+	if err != nil {
+		return err
+	}
+	unknown := slices.IndexFunc(infos, func(info EntryInfo) bool { return info.Actions < 0 })
+	if unknown >= 0 {
+		Log.Info("Counting the actions in ", len(infos)-unknown, " cached blocks ...")
+		for i := unknown; i < len(infos); i += getRangeBatch {
+			start := c.firstBlock + first + i
+			end := start + min(getRangeBatch, len(infos)-i)
+			entries := c.readEntries(start, end)
+			for j := range end - start {
+				if infos[i+j].Actions >= 0 {
+					continue
+				}
+				infos[i+j].Actions = 0
+				if entries == nil {
+					// Unreadable; count the block as empty.
+					continue
+				}
+				if block := c.parseBlock(start+j, entries[j]); block != nil {
+					infos[i+j].Actions = compactActionCount(block)
+				}
+			}
+		}
+		if !c.readOnly {
+			actions := make([]int, 0, len(infos)-unknown)
+			for _, info := range infos[unknown:] {
+				actions = append(actions, info.Actions)
+			}
+			if err := c.store.SetActions(first+unknown, actions); err != nil {
+				Log.Warning("storing the action counts failed: ", err)
+			}
+		}
+	}
+	for _, info := range infos {
+		c.appendSums(info.Size, info.Actions)
+	}
+	return nil
+}
+
+// blockActions returns the action counts of the cached blocks from index
+// start up to (not including) end (relative to the first block), which
+// actionSums has.
+// Caller should hold (at least) c.mutex.RLock().
+func (c *BlockCache) blockActions(start, end int) []int {
+	actions := make([]int, 0, end-start)
+	for i := start; i < end; i++ {
+		actions = append(actions, int(c.actionSums[i+1]-c.actionSums[i]))
+	}
+	return actions
+}
+
+// appendSums adds the latest cached block, whose store entry has the given
+// size and which has the given number of actions, to starts and actionSums.
+func (c *BlockCache) appendSums(size int, actions int) {
+	c.starts = append(c.starts, c.starts[len(c.starts)-1]+int64(size))
+	c.actionSums = append(c.actionSums, c.actionSums[len(c.actionSums)-1]+int64(actions))
+}
+
+// trimSums removes the starts and actionSums entries beyond the first n
+// blocks.
+func (c *BlockCache) trimSums(n int) {
+	if len(c.starts) > n+1 {
+		c.starts = c.starts[:n+1]
+	}
+	if len(c.actionSums) > n+1 {
+		c.actionSums = c.actionSums[:n+1]
+	}
+}
+
+// SetMaxBlocks limits the cache to (about) the given number of most recent
+// blocks; zero means unlimited. When the limit is exceeded, the oldest blocks
+// are evicted and the first height advances; requests for evicted blocks are
+// then served from the backend node. To avoid rewriting the db files on
+// every Add, the cache may grow up to 1/8 beyond the limit before eviction.
+func (c *BlockCache) SetMaxBlocks(n int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.maxBlocks = n
+	c.maybeEvict()
+}
+
+// SetConfirmationDepth hides the n most recent cached blocks from readers:
+// Get, GetRange, GetNearest, GetRelative, and GetLatestHeight behave as if
+// the latest block were n blocks below the latest cached block, so wallets
+// aren't served blocks that a shallow reorg is likely to replace. The
+// ingestor still caches (and reorgs) every block; GetNextHeight and
+// GetLatestHash aren't affected. Zero (the default) serves every block.
+func (c *BlockCache) SetConfirmationDepth(n int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.confirmations = n
+}
+
+// servedEnd returns the height of the first block not visible to readers
+// (see SetConfirmationDepth). Caller should hold (at least) c.mutex.RLock().
+func (c *BlockCache) servedEnd() int {
+	return max(c.nextBlock-c.confirmations, c.firstBlock)
+}
+
+// hides returns whether the block at the given height is cached but not yet
+// visible to readers because it's within the confirmation depth.
+func (c *BlockCache) hides(height int) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return height >= c.servedEnd() && height < c.nextBlock
+}
+
+// SetSyncPolicy changes the cache's SyncPolicy (initially CacheSyncPolicy).
+func (c *BlockCache) SetSyncPolicy(policy SyncPolicy) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.syncPolicy = policy
+}
+
+// Caller should hold c.mutex.Lock().
+func (c *BlockCache) maybeEvict() {
+	if c.maxBlocks <= 0 || c.readOnly {
+		return
+	}
+	nBlocks := c.nextBlock - c.firstBlock
+	if nBlocks <= c.maxBlocks+c.maxBlocks/8 {
+		return
+	}
+	if err := c.evict(nBlocks - c.maxBlocks); err != nil {
+		// This is retried when the next block is added.
+		c.log().Warning("can't evict blocks from the cache: ", err)
+	}
+}
+
+// PruneBefore evicts the cached blocks whose (header) time, as recorded in
+// the compact block, is before the given Unix time, but never the latest
+// block. It returns the number of blocks removed. Block times increase only
+// approximately, so this finds the first block at or after the given time by
+// binary search; blocks near it may not be strictly ordered by time.
+func (c *BlockCache) PruneBefore(unixTime int64) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.readOnly || c.nextBlock-c.firstBlock < 2 {
+		return 0
+	}
+	// Search the blocks other than the latest.
+	n := sort.Search(c.nextBlock-1-c.firstBlock, func(i int) bool {
+		block := c.readBlock(c.firstBlock + i)
+		// An unreadable block stops the pruning there.
+		return block == nil || int64(block.Time) >= unixTime
+	})
+	if n > 0 {
+		if err := c.evict(n); err != nil {
+			c.log().Warning("can't prune blocks from the cache: ", err)
+			return 0
+		}
+	}
+	return n
+}
+
+// DropBefore removes the cached blocks below the given height; if there
+// are none at or above it, the cache is emptied and the height becomes its
+// start height. It returns the number of blocks removed.
+func (c *BlockCache) DropBefore(height int) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.readOnly || height <= c.firstBlock {
+		return 0
+	}
+	if height >= c.nextBlock {
+		n := c.nextBlock - c.firstBlock
+		c.empty(height)
+		return n
+	}
+	n := height - c.firstBlock
+	if err := c.evict(n); err != nil {
+		c.log().Warning("can't drop blocks from the cache: ", err)
+		return 0
+	}
+	return n
+}
+
+// empty removes all the cached blocks (which, unlike evict, needs no disk
+// space) and makes the given height the cache's start height. Caller should
+// hold c.mutex.Lock().
+func (c *BlockCache) empty(height int) {
+	c.setDbFiles(c.firstBlock)
+	c.firstBlock = height
+	c.nextBlock = height
+}
+
+// evict removes the n oldest blocks from the cache. For the default store,
+// this rewrites the db files without them, which needs as much free disk
+// space as the blocks that are kept. If the store can't remove them, the
+// cache is unchanged (or, if the store had to remove all its blocks, empty)
+// and the error is returned. Caller should hold c.mutex.Lock().
+func (c *BlockCache) evict(n int) error {
+	c.flush()
+	if err := c.store.Delete(0, n); err != nil {
+		if c.store.Len() == 0 {
+			c.empty(c.nextBlock)
+			return fmt.Errorf("%w (cache emptied)", err)
+		}
+		return err
+	}
+	starts := make([]int64, 0, len(c.starts)-n)
+	for _, start := range c.starts[n:] {
+		starts = append(starts, start-c.starts[n])
+	}
+	c.starts = starts
+	c.actionSums = c.actionSums[n:]
+	c.firstBlock += n
+	if c.full != nil {
+		c.full.dropBefore(c.firstBlock)
+	}
+	Log.Info("Evicted ", n, " blocks from cache, first height now ", c.firstBlock)
+	return nil
 }
 
 // GetNextHeight returns the height of the lowest unobtained block.
@@ -56,7 +616,7 @@ func (c *BlockCache) GetLatestHash() hash32.T {
 func (c *BlockCache) HashMatch(prevhash hash32.T) bool {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
-	return c.latestHash == hash32.Nil || c.latestHash == prevhash
+	return c.latestHash.IsNil() || hash32.Equal(c.latestHash, prevhash)
 }
 
 // Make the block at the given height the lowest height that we don't have.
@@ -69,60 +629,163 @@ func (c *BlockCache) setDbFiles(height int) {
 			height = c.firstBlock
 		}
 		index := height - c.firstBlock
-		if err := c.lengthsFile.Truncate(int64(index * 4)); err != nil {
-			Log.Fatal("truncate lengths file failed: ", err)
+		c.flush()
+		if err := c.store.Delete(index, c.store.Len()); err != nil {
+			Log.Fatal(err)
 		}
-		if err := c.blocksFile.Truncate(c.starts[index]); err != nil {
-			Log.Fatal("truncate blocks file failed: ", err)
+		if !c.readOnly {
+			if c.full != nil {
+				c.full.truncate(height)
+			}
+			c.Sync()
 		}
-		c.Sync()
-		c.starts = c.starts[:index+1]
+		c.trimSums(index)
 		c.nextBlock = height
 		c.setLatestHash()
 	}
 }
 
-func copyFile(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
+// Caller should hold c.mutex.Lock().
+func (c *BlockCache) recoverFromCorruption(height int) {
+	if c.readOnly {
+		// The writer will repair the files; forget the bad blocks for now
+		// (Refresh will re-read them).
+		Log.Warning("CORRUPTION detected in read-only db blocks-cache files, height ", height)
+		c.setDbFiles(height)
+		return
 	}
-	defer in.Close()
+	Log.Warning("CORRUPTION detected in db blocks-cache files, height ", height, " redownloading")
+	if s, ok := c.store.(*fileStore); ok {
+		// Save the corrupted files for post-mortem analysis.
+		s.saveCorrupted()
+	}
+	c.setDbFiles(height)
+}
 
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
+// not including the checksum
+func (c *BlockCache) blockLength(height int) int {
+	index := height - c.firstBlock
+	return int(c.starts[index+1] - c.starts[index] - 8)
+}
+
+// header returns the store's header, which is empty for the plain format
+// of older versions.
+func (c *BlockCache) header() []byte {
+	if !c.chainHeader {
+		if c.format == dbFormatPlain {
+			return nil
+		}
+		h := make([]byte, dbHeaderLength)
+		copy(h, dbMagic)
+		binary.LittleEndian.PutUint32(h[len(dbMagic):], c.format)
+		return h
 	}
-	defer out.Close()
+	h := make([]byte, dbHeaderLength+dbChainLength, dbHeaderLength+dbChainLength+len(c.chainName))
+	copy(h, dbMagic)
+	binary.LittleEndian.PutUint32(h[len(dbMagic):], c.format|dbFormatChain)
+	copy(h[dbHeaderLength:], c.genesis[:])
+	binary.LittleEndian.PutUint32(h[dbHeaderLength+32:], uint32(len(c.chainName)))
+	return append(h, c.chainName...)
+}
 
-	_, err = io.Copy(out, in)
-	if err != nil {
-		return err
+// readBlocksHeader returns the header at the start of a blocks file (see
+// dbMagic), or nil if there's none; checkHeader checks its contents.
+func readBlocksHeader(f io.ReaderAt) []byte {
+	h := make([]byte, dbHeaderLength+dbChainLength+maxChainName)
+	n, _ := f.ReadAt(h, 0)
+	if n < dbHeaderLength || !bytes.Equal(h[:len(dbMagic)], dbMagic) {
+		return nil
 	}
-	return out.Close()
+	if headerFormat(h)&dbFormatChain == 0 || n < dbHeaderLength+dbChainLength {
+		return h[:dbHeaderLength]
+	}
+	nameLength := binary.LittleEndian.Uint32(h[dbHeaderLength+32:])
+	return h[:min(n, dbHeaderLength+dbChainLength+int(min(nameLength, maxChainName)))]
 }
 
-// Caller should hold c.mutex.Lock().
-func (c *BlockCache) recoverFromCorruption(height int) {
-	Log.Warning("CORRUPTION detected in db blocks-cache files, height ", height, " redownloading")
+// headerFormat returns the format recorded in the given header.
+func headerFormat(h []byte) uint32 {
+	if len(h) < dbHeaderLength {
+		return dbFormatPlain
+	}
+	return binary.LittleEndian.Uint32(h[len(dbMagic):])
+}
 
-	// Save the corrupted files for post-mortem analysis.
-	save := c.lengthsName + "-corrupted"
-	if err := copyFile(c.lengthsName, save); err != nil {
-		Log.Warning("Could not copy db lengths file: ", err)
+// checkHeader sets the cache's format from the store's header. It returns
+// an error wrapping errUnknownFormat if the format is unknown (written by a
+// newer version), or an error if the header records a chain other than the
+// cache's.
+func (c *BlockCache) checkHeader(h []byte) error {
+	c.format = dbFormatPlain
+	c.chainHeader = false
+	if h == nil {
+		return nil
+	}
+	format := headerFormat(h)
+	switch format {
+	case dbFormatGzip, dbFormatChain | dbFormatPlain, dbFormatChain | dbFormatGzip:
+	default:
+		return fmt.Errorf("cache in %s has %w %#x (written by a newer version?); refusing to use it",
+			c.dir, errUnknownFormat, format)
 	}
-	save = c.blocksName + "-corrupted"
-	if err := copyFile(c.blocksName, save); err != nil {
-		Log.Warning("Could not copy db lengths file: ", err)
+	if format&dbFormatChain != 0 {
+		if len(h) < dbHeaderLength+dbChainLength ||
+			len(h) != dbHeaderLength+dbChainLength+int(binary.LittleEndian.Uint32(h[dbHeaderLength+32:])) {
+			return errors.New("db blocks file header is corrupt")
+		}
+		name := string(h[dbHeaderLength+dbChainLength:])
+		genesis := hash32.T(h[dbHeaderLength : dbHeaderLength+32])
+		// A zero hash is unknown, so it matches any.
+		if name != c.chainName || !genesis.IsNil() && !c.genesis.IsNil() && genesis != c.genesis {
+			return fmt.Errorf("cache in %s is for chain %q (genesis block %s), not %q (%s); refusing to use it",
+				c.dir, name, hash32.Encode(hash32.Reverse(genesis)),
+				c.chainName, hash32.Encode(hash32.Reverse(c.genesis)))
+		}
+		c.chainHeader = true
 	}
+	c.format = format &^ dbFormatChain
+	return nil
+}
 
-	c.setDbFiles(height)
+// initFormat empties the store and sets the cache's format according to
+// CompressCache, writing the header.
+func (c *BlockCache) initFormat() {
+	c.format = dbFormatPlain
+	if CompressCache {
+		c.format = dbFormatGzip
+	}
+	c.chainHeader = true
+	if err := c.store.Reset(c.header()); err != nil {
+		Log.Fatal(err)
+	}
 }
 
-// not including the checksum
-func (c *BlockCache) blockLength(height int) int {
-	index := height - c.firstBlock
-	return int(c.starts[index+1] - c.starts[index] - 8)
+// encodeBlock returns the form in which the marshalled block is stored.
+func (c *BlockCache) encodeBlock(data []byte) ([]byte, error) {
+	if c.format != dbFormatGzip {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeBlock is the inverse of encodeBlock.
+func (c *BlockCache) decodeBlock(b []byte) ([]byte, error) {
+	if c.format != dbFormatGzip {
+		return b, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
 }
 
 // Calculate the 8-byte checksum that precedes each block in the blocks file.
@@ -137,35 +800,208 @@ func checksum(height int, b []byte) []byte {
 
 // Caller should hold (at least) c.mutex.RLock().
 func (c *BlockCache) readBlock(height int) *walletrpc.CompactBlock {
-	blockLen := c.blockLength(height)
-	b := make([]byte, blockLen+8)
-	offset := c.starts[height-c.firstBlock]
-	n, err := c.blocksFile.ReadAt(b, offset)
-	if err != nil || n != len(b) {
-		Log.Warning("blocks read offset: ", offset, " failed: ", n, err)
+	blocks := c.readBlocks(height, height+1)
+	if len(blocks) == 0 {
+		return nil
+	}
+	return blocks[0]
+}
+
+// readBlocks returns the blocks from height start up to (not including) end,
+// which must be cached. It stops at the first bad block.
+// Caller should hold (at least) c.mutex.RLock().
+func (c *BlockCache) readBlocks(start, end int) []*walletrpc.CompactBlock {
+	entries := c.readEntries(start, end)
+	blocks := make([]*walletrpc.CompactBlock, 0, len(entries))
+	for i, entry := range entries {
+		block := c.parseBlock(start+i, entry)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// readEntries returns the store entries of the blocks from height start up
+// to (not including) end, which must be cached, including the buffered
+// blocks that haven't yet been written; nil if they can't be read.
+// Caller should hold (at least) c.mutex.RLock().
+func (c *BlockCache) readEntries(start, end int) [][]byte {
+	first, last := start-c.firstBlock, end-c.firstBlock
+	stored := c.store.Len()
+	var entries [][]byte
+	if first < stored {
+		var err error
+		entries, err = c.store.Get(first, min(last, stored))
+		if err != nil {
+			Log.Warning("blocks read at height ", start, " failed: ", err)
+			return nil
+		}
+	}
+	if last > stored {
+		entries = append(entries, c.pending[max(first-stored, 0):last-stored]...)
+	}
+	return entries
+}
+
+// parseBlock verifies and unmarshals the given store entry (checksum and
+// block) of the block at the given height.
+func (c *BlockCache) parseBlock(height int, b []byte) *walletrpc.CompactBlock {
+	if len(b) < 8 {
+		Log.Warning("short block entry at height: ", height)
 		return nil
 	}
 	diskcs := b[:8]
-	b = b[8 : blockLen+8]
+	b = b[8:]
 	if !bytes.Equal(checksum(height, b), diskcs) {
-		Log.Warning("bad block checksum at height: ", height, " offset: ", offset)
+		Log.Warning("bad block checksum at height: ", height)
+		return nil
+	}
+	b, err := c.decodeBlock(b)
+	if err != nil {
+		// Could be file corruption.
+		Log.Warning("blocks decode at height: ", height, " failed: ", err)
 		return nil
 	}
 	block := &walletrpc.CompactBlock{}
 	err = proto.Unmarshal(b, block)
 	if err != nil {
 		// Could be file corruption.
-		Log.Warning("blocks unmarshal at offset: ", offset, " failed: ", err)
+		Log.Warning("blocks unmarshal at height: ", height, " failed: ", err)
 		return nil
 	}
 	if int(block.Height) != height {
 		// Could be file corruption.
-		Log.Warning("block unexpected height at height ", height, " offset: ", offset)
+		Log.Warning("block unexpected height at height ", height)
 		return nil
 	}
 	return block
 }
 
+// firstDiskHeight returns the height of the store's first block, or -1 if
+// it can't be determined. This is normally the cache's start height, but is
+// higher if older blocks have been evicted (see SetMaxBlocks).
+func (c *BlockCache) firstDiskHeight() int {
+	if c.store.Len() == 0 {
+		return -1
+	}
+	entries, err := c.store.Get(0, 1)
+	if err != nil || len(entries[0]) < 8 {
+		return -1
+	}
+	b := entries[0]
+	data, err := c.decodeBlock(b[8:])
+	if err != nil {
+		return -1
+	}
+	block := &walletrpc.CompactBlock{}
+	if err := proto.Unmarshal(data, block); err != nil {
+		return -1
+	}
+	if !bytes.Equal(checksum(int(block.Height), b[8:]), b[:8]) {
+		return -1
+	}
+	return int(block.Height)
+}
+
+// Repair validates each cached block (its length, checksum, and contents),
+// lowest height first, and truncates the cache at the first bad block. It
+// returns the number of blocks removed. This recovers from a partially-written
+// block (for example, a crash during Add) without discarding the whole cache.
+func (c *BlockCache) Repair() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.repair()
+}
+
+// Caller should hold c.mutex.Lock().
+func (c *BlockCache) repair() int {
+	for height := c.firstBlock; height < c.nextBlock; height++ {
+		if c.readBlock(height) == nil {
+			removed := c.nextBlock - height
+			Log.Warning("Truncating cache at bad block ", height, ", removing ", removed, " blocks")
+			c.setDbFiles(height)
+			return removed
+		}
+	}
+	return 0
+}
+
+// Compact removes any bytes from the db files that don't belong to a cached
+// block (see CacheStore.Compact), and returns the number of bytes reclaimed.
+// Reorg (and eviction and pruning) already remove the blocks' bytes, so
+// normally there's nothing to reclaim; data can be left beyond the last
+// cached block only by a failed write, for example, after the disk filled up.
+func (c *BlockCache) Compact() (int64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.readOnly {
+		return 0, errors.New("cache.Compact: cache is read-only")
+	}
+	c.flush()
+	return c.store.Compact()
+}
+
+// CheckInvariants verifies the consistency of the cache's in-memory state
+// with itself and with the store: that there's one starts[] (and
+// actionSums) entry per cached block (plus one), that the offsets increase,
+// that the store has the blocks and the cache's header (and, for the
+// default store, that the db files have the expected sizes), and that the
+// latest block's hash is latestHash.
+func (c *BlockCache) CheckInvariants() error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	nBlocks := c.nextBlock - c.firstBlock
+	if nBlocks < 0 {
+		return fmt.Errorf("nextBlock %d is below firstBlock %d", c.nextBlock, c.firstBlock)
+	}
+	if len(c.starts) != nBlocks+1 {
+		return fmt.Errorf("len(starts) is %d, expected %d", len(c.starts), nBlocks+1)
+	}
+	if len(c.actionSums) != nBlocks+1 {
+		return fmt.Errorf("len(actionSums) is %d, expected %d", len(c.actionSums), nBlocks+1)
+	}
+	if c.starts[0] != 0 {
+		return fmt.Errorf("starts[0] is %d, expected 0", c.starts[0])
+	}
+	for i := 1; i < len(c.starts); i++ {
+		if c.starts[i] <= c.starts[i-1]+8 {
+			return fmt.Errorf("starts[%d] (%d) doesn't follow starts[%d] (%d)",
+				i, c.starts[i], i-1, c.starts[i-1])
+		}
+	}
+	if n := c.store.Len() + len(c.pending); n != nBlocks {
+		return fmt.Errorf("store has %d blocks (and %d buffered), expected %d",
+			c.store.Len(), len(c.pending), nBlocks)
+	}
+	if !bytes.Equal(c.store.Header(), c.header()) {
+		return errors.New("store header doesn't match the cache's format")
+	}
+	if s, ok := c.store.(*fileStore); ok {
+		if err := s.check(c.starts[:s.Len()+1]); err != nil {
+			return err
+		}
+	}
+	if nBlocks == 0 {
+		if !c.latestHash.IsNil() {
+			return errors.New("latestHash is set in an empty cache")
+		}
+		return nil
+	}
+	block := c.readBlock(c.nextBlock - 1)
+	if block == nil {
+		return fmt.Errorf("can't read latest block %d", c.nextBlock-1)
+	}
+	if !bytes.Equal(block.Hash, c.latestHash[:]) {
+		return fmt.Errorf("latest block %d hash %x isn't latestHash %s",
+			c.nextBlock-1, block.Hash, c.latestHash.Short())
+	}
+	return nil
+}
+
 // Caller should hold c.mutex.Lock().
 func (c *BlockCache) setLatestHash() {
 	c.latestHash = hash32.Nil
@@ -183,85 +1019,198 @@ func (c *BlockCache) setLatestHash() {
 
 // Reset is used only for darkside testing.
 func (c *BlockCache) Reset(startHeight int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.setDbFiles(c.firstBlock) // empty the cache
 	c.firstBlock = startHeight
 	c.nextBlock = startHeight
 }
 
-// NewBlockCache returns an instance of a block cache object.
+// NewBlockCache returns an instance of a block cache object, kept in the
+// store selected by CacheBackend.
 // (No locking here, we assume this is single-threaded.)
 // syncFromHeight < 0 means latest (tip) height.
 func NewBlockCache(dbPath string, chainName string, startHeight int, syncFromHeight int) *BlockCache {
-	c := &BlockCache{}
+	c := &BlockCache{chainName: chainName, genesis: GenesisHash, syncPolicy: CacheSyncPolicy, writeBuffer: CacheWriteBuffer}
 	c.firstBlock = startHeight
 	c.nextBlock = startHeight
-	c.lengthsName, c.blocksName = DbFileNames(dbPath, chainName)
-	var err error
-	if err := os.MkdirAll(filepath.Join(dbPath, chainName), 0755); err != nil {
+	c.dir = filepath.Join(dbPath, chainName)
+	c.checkpointName = filepath.Join(c.dir, "checkpoint")
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
 		Log.Fatal("mkdir ", dbPath, " failed: ", err)
 	}
-	c.blocksFile, err = os.OpenFile(c.blocksName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
-	if err != nil {
-		Log.Fatal("open ", c.blocksName, " failed: ", err)
+	openStore := cacheStores[CacheBackend]
+	if openStore == nil {
+		Log.Fatal("unknown cache backend ", CacheBackend)
 	}
-	c.lengthsFile, err = os.OpenFile(c.lengthsName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	var err error
+	c.store, err = openStore(dbPath, chainName)
 	if err != nil {
-		Log.Fatal("open ", c.lengthsName, " failed: ", err)
+		Log.Fatal("open ", CacheBackend, " cache store failed: ", err)
 	}
-	lengths, err := os.ReadFile(c.lengthsName)
-	if err != nil {
-		Log.Fatal("read ", c.lengthsName, " failed: ", err)
+	nBlocks := c.store.Len()
+	if err := c.checkHeader(c.store.Header()); err != nil {
+		Log.Fatal(err)
+	}
+	if nBlocks > 0 {
+		if h := c.firstDiskHeight(); h > startHeight {
+			// The oldest blocks were evicted.
+			c.firstBlock = h
+			c.nextBlock = h
+		}
 	}
-	// 4 bytes per lengths[] value (block length)
 	if syncFromHeight >= 0 {
 		if syncFromHeight < startHeight {
 			syncFromHeight = startHeight
 		}
-		if (syncFromHeight-startHeight)*4 < len(lengths) {
+		if syncFromHeight < c.firstBlock {
+			// discard all entries, start over at the specified height
+			nBlocks = 0
+			c.firstBlock = syncFromHeight
+			c.nextBlock = syncFromHeight
+		} else if syncFromHeight-c.firstBlock < nBlocks {
 			// discard the entries at and beyond (newer than) the specified height
-			lengths = lengths[:(syncFromHeight-startHeight)*4]
+			nBlocks = syncFromHeight - c.firstBlock
 		}
 	}
+	if nBlocks == 0 {
+		// The cache is empty, so its format can be changed.
+		c.initFormat()
+	}
 
-	// The last entry in starts[] is where to write the next block.
-	var offset int64
-	c.starts = nil
-	c.starts = append(c.starts, 0)
-	nBlocks := len(lengths) / 4
+	c.starts = []int64{0}
+	c.actionSums = []int64{0}
 	Log.Info("Reading ", nBlocks, " blocks (since Sapling activation) from disk cache ...")
-	for i := 0; i < nBlocks; i++ {
-		if len(lengths[:4]) < 4 {
-			Log.Warning("lengths file has a partial entry")
-			c.recoverFromCorruption(c.nextBlock)
-			break
-		}
-		length := binary.LittleEndian.Uint32(lengths[i*4 : (i+1)*4])
-		if length < 74 || length > 4*1000*1000 {
-			Log.Warning("lengths file has impossible value ", length)
-			c.recoverFromCorruption(c.nextBlock)
-			break
-		}
-		offset += int64(length) + 8
-		c.starts = append(c.starts, offset)
-		c.nextBlock++
+	c.nextBlock += nBlocks
+	// A crash during Add can leave the last block incomplete.
+	if c.nextBlock > c.firstBlock && c.readBlock(c.nextBlock-1) == nil {
+		c.repair()
 	}
 	c.setDbFiles(c.nextBlock)
+	if err := c.countBlocks(); err != nil {
+		Log.Warning("reading the cached blocks' sizes failed: ", err)
+	}
+	if err := c.CheckInvariants(); err != nil {
+		Log.Warning("cache inconsistent: ", err)
+		c.recoverFromCorruption(c.firstBlock)
+	}
+	if CacheFullBlocks {
+		c.full, err = openFullBlockStore(c, dbPath, chainName)
+		if err != nil {
+			Log.Fatal("open full blocks failed: ", err)
+		}
+	}
+	if syncFromHeight >= 0 {
+		// The cache may have been truncated deliberately.
+		c.writeCheckpoint()
+	} else if err := c.verifyCheckpoint(); err != nil {
+		Log.Warning("checkpoint doesn't match the disk cache, using the cache: ", err)
+		c.writeCheckpoint()
+	}
 	Log.Info("Done reading ", c.nextBlock-c.firstBlock, " blocks from disk cache")
 	return c
 }
 
-func DbFileNames(dbPath string, chainName string) (string, string) {
-	return filepath.Join(dbPath, chainName, "lengths"),
-		filepath.Join(dbPath, chainName, "blocks")
+// refreshWindow is the number of most recent blocks that Refresh re-reads,
+// so that it detects a reorg by the writer up to this depth.
+const refreshWindow = 100
+
+// NewBlockCacheReadOnly returns a block cache that reads the db files
+// maintained by another process's (writable) BlockCache, for example, so
+// that several frontend replicas can share one ingestor's cache. It never
+// modifies the files; call Refresh to pick up the writer's changes. Only
+// the default store (see CacheBackend) can be shared this way.
+func NewBlockCacheReadOnly(dbPath string, chainName string) (*BlockCache, error) {
+	if CacheBackend != fileStoreName {
+		return nil, fmt.Errorf("a read-only cache can't use the %s cache backend", CacheBackend)
+	}
+	c := &BlockCache{chainName: chainName, genesis: GenesisHash, readOnly: true}
+	c.dir = filepath.Join(dbPath, chainName)
+	s, err := openFileStoreReadOnly(dbPath, chainName)
+	if err != nil {
+		return nil, err
+	}
+	c.store = s
+	c.starts = []int64{0}
+	c.actionSums = []int64{0}
+	if err := c.refresh(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Refresh updates a read-only cache with the blocks that the writer has
+// added or (by a reorg) removed since the cache was opened or refreshed.
+// The most recent refreshWindow blocks are re-read, so a deeper reorg
+// isn't detected. It does nothing if the cache isn't read-only.
+func (c *BlockCache) Refresh() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.readOnly {
+		return nil
+	}
+	latestHash := c.latestHash
+	err := c.refresh()
+	if c.latestHash != latestHash && c.nextBlock > c.firstBlock {
+		c.publish(c.nextBlock - 1)
+	}
+	return err
+}
+
+// Caller should hold c.mutex.Lock().
+func (c *BlockCache) refresh() error {
+	s := c.store.(*fileStore)
+	kept, err := s.reload(refreshWindow)
+	if err != nil {
+		return err
+	}
+	if kept == 0 {
+		if err := c.checkHeader(s.Header()); err != nil {
+			return err
+		}
+		if h := c.firstDiskHeight(); h >= 0 {
+			c.firstBlock = h
+		}
+	}
+	c.nextBlock = c.firstBlock + s.Len()
+	// The writer may not yet have written the last block completely.
+	for c.nextBlock > c.firstBlock && c.readBlock(c.nextBlock-1) == nil {
+		c.nextBlock--
+		s.truncate(c.nextBlock - c.firstBlock)
+	}
+	c.trimSums(min(kept, c.nextBlock-c.firstBlock))
+	if err := c.countBlocks(); err != nil {
+		return err
+	}
+	c.setLatestHash()
+	return nil
 }
 
 // Add adds the given block to the cache at the given height, returning true
 // if a reorg was detected.
 func (c *BlockCache) Add(height int, block *walletrpc.CompactBlock) error {
-	// Invariant: m[firstBlock..nextBlock) are valid.
+	defer c.addTime.since(time.Now())
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.add(height, block, nil)
+}
+
+// AddFull is like Add, but also stores the full (raw) block data, if the
+// cache is storing full blocks (see CacheFullBlocks).
+func (c *BlockCache) AddFull(height int, block *walletrpc.CompactBlock, data []byte) error {
+	defer c.addTime.since(time.Now())
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
+	return c.add(height, block, data)
+}
 
+// Caller should hold c.mutex.Lock().
+func (c *BlockCache) add(height int, block *walletrpc.CompactBlock, full []byte) error {
+	// Invariant: m[firstBlock..nextBlock) are valid.
+	if c.readOnly {
+		return errors.New("cache.Add: cache is read-only")
+	}
 	if height > c.nextBlock {
 		// Cache has been reset (for example, checksum error)
 		return nil
@@ -285,78 +1234,362 @@ func (c *BlockCache) Add(height int, block *walletrpc.CompactBlock) error {
 		return nil
 	}
 
-	// Add the new block and its length to the db files.
+	// Add the new block to the store.
 	data, err := proto.Marshal(block)
 	if err != nil {
 		return err
 	}
-	b := append(checksum(height, data), data...)
-	n, err := c.blocksFile.Write(b)
+	data, err = c.encodeBlock(data)
 	if err != nil {
-		Log.Fatal("blocks write failed: ", err)
-	}
-	if n != len(b) {
-		Log.Fatal("blocks write incorrect length: expected: ", len(b), "written: ", n)
-	}
-	b = make([]byte, 4)
-	binary.LittleEndian.PutUint32(b, uint32(len(data)))
-	n, err = c.lengthsFile.Write(b)
-	if err != nil {
-		Log.Fatal("lengths write failed: ", err)
+		return err
 	}
-	if n != len(b) {
-		Log.Fatal("lengths write incorrect length: expected: ", len(b), "written: ", n)
+	b := append(checksum(height, data), data...)
+	actions := compactActionCount(block)
+	if c.writeBuffer > 0 {
+		if len(c.pending) == 0 {
+			c.pendingSince = Time.Now()
+		}
+		c.pending = append(c.pending, b)
+	} else if err := c.store.Put([][]byte{b}, []int{actions}, false); err != nil {
+		Log.Fatal(err)
 	}
 
+	c.logAdd(block, c.latestHash)
+
 	// update the in-memory variables
-	offset := c.starts[len(c.starts)-1]
-	c.starts = append(c.starts, offset+int64(len(data)+8))
+	c.appendSums(len(b), actions)
 
 	c.latestHash = hash32.T(block.Hash)
 	c.nextBlock++
+	if c.full != nil {
+		c.full.add(block, full)
+	}
+	if c.writeBuffer > 0 && (len(c.pending) >= c.writeBuffer ||
+		Time.Now().Sub(c.pendingSince) >= cacheFlushInterval) {
+		c.flush()
+	}
+	c.unsynced++
+	if c.syncPolicy != SyncNever && c.unsynced >= int(c.syncPolicy) {
+		c.flush()
+		c.Sync()
+		c.unsynced = 0
+		c.writeCheckpoint()
+	}
+	c.maybeEvict()
+	c.publish(height)
 	// Invariant: m[firstBlock..nextBlock) are valid.
 	return nil
 }
 
+// Flush writes the buffered blocks (see CacheWriteBuffer) to the store
+// and flushes it to disk.
+func (c *BlockCache) Flush() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.readOnly {
+		return
+	}
+	c.flush()
+	c.Sync()
+	c.unsynced = 0
+	c.writeCheckpoint()
+}
+
+// cacheCheckpoint is the contents of the checkpoint file, which records the
+// cache's latest block as of the last time the db files were flushed to
+// disk, so that a restart can check that the cache still ends there.
+type cacheCheckpoint struct {
+	Height int    `json:"height"`
+	Hash   string `json:"hash"` // big-endian (display order) hex
+}
+
+// writeCheckpoint replaces the checkpoint file with one for the cache's
+// latest block (or removes it if the cache is empty). It's called after the
+// db files are flushed; the file is replaced atomically (by renaming), so a
+// crash leaves either the old or the new checkpoint.
+// Caller should hold c.mutex.Lock().
+func (c *BlockCache) writeCheckpoint() {
+	if c.checkpointName == "" {
+		return
+	}
+	// The ingestor flushes the cache on each poll while it's synced, so
+	// the checkpoint is only rewritten if the cache's tip has changed.
+	cp := cacheCheckpoint{Height: -1} // no checkpoint
+	if c.nextBlock > c.firstBlock {
+		cp = cacheCheckpoint{
+			Height: c.nextBlock - 1,
+			Hash:   hash32.Encode(hash32.Reverse(c.latestHash)),
+		}
+	}
+	if cp == c.checkpoint {
+		return
+	}
+	if cp.Height < 0 {
+		os.Remove(c.checkpointName)
+		c.checkpoint = cp
+		return
+	}
+	data, _ := json.Marshal(cp)
+	tmp := c.checkpointName + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		Log.Warning("write checkpoint failed: ", err)
+		return
+	}
+	if err := os.Rename(tmp, c.checkpointName); err != nil {
+		Log.Warning("write checkpoint failed: ", err)
+		return
+	}
+	c.checkpoint = cp
+}
+
+// verifyCheckpoint checks that the cache has the block recorded in the
+// checkpoint file (if there is one, and the block hasn't been evicted); if
+// not, the cache lost blocks or diverged from the checkpoint since it was
+// written.
+func (c *BlockCache) verifyCheckpoint() error {
+	data, err := os.ReadFile(c.checkpointName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var cp cacheCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return fmt.Errorf("can't parse checkpoint: %w", err)
+	}
+	if cp.Height < c.firstBlock {
+		return nil
+	}
+	if cp.Height >= c.nextBlock {
+		return fmt.Errorf("checkpoint is at height %d, but the cache ends at %d", cp.Height, c.nextBlock-1)
+	}
+	block := c.readBlock(cp.Height)
+	if block == nil {
+		return fmt.Errorf("can't read block %d", cp.Height)
+	}
+	hash, _ := hash32.FromSlice(block.Hash)
+	if hash := hash32.Encode(hash32.Reverse(hash)); hash != cp.Hash {
+		return fmt.Errorf("checkpoint block %d hash is %s, cached block hash is %s", cp.Height, cp.Hash, hash)
+	}
+	c.log().Info("Checkpoint matches disk cache at height ", cp.Height)
+	return nil
+}
+
+// flush writes the buffered blocks to the store.
+// Caller should hold c.mutex.Lock().
+func (c *BlockCache) flush() {
+	if len(c.pending) == 0 {
+		return
+	}
+	nBlocks := c.nextBlock - c.firstBlock
+	actions := c.blockActions(nBlocks-len(c.pending), nBlocks)
+	if err := c.store.Put(c.pending, actions, false); err != nil {
+		Log.Fatal(err)
+	}
+	c.pending = nil
+}
+
+// AddBatch adds the given blocks, which must have consecutive heights
+// starting at the next height, to the cache. It's faster than calling Add
+// for each block since the store is written once. A crash during AddBatch
+// leaves the cache as it was (or, at worst, with some of the blocks added).
+func (c *BlockCache) AddBatch(blocks []*walletrpc.CompactBlock) error {
+	defer c.addTime.since(time.Now())
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.addBatch(blocks, nil)
+}
+
+// AddFullBatch is like AddBatch, but also stores the full (raw) block data,
+// data[i] being block i's, if the cache is storing full blocks (see
+// CacheFullBlocks).
+func (c *BlockCache) AddFullBatch(blocks []*walletrpc.CompactBlock, data [][]byte) error {
+	defer c.addTime.since(time.Now())
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(data) != len(blocks) {
+		return fmt.Errorf("cache.AddBatch: %d blocks but %d full blocks", len(blocks), len(data))
+	}
+	return c.addBatch(blocks, data)
+}
+
+// Caller should hold c.mutex.Lock(). If data is nil, the stored full blocks
+// (if any) are discarded, as they are by Add.
+func (c *BlockCache) addBatch(blocks []*walletrpc.CompactBlock, data [][]byte) error {
+	if c.readOnly {
+		return errors.New("cache.AddBatch: cache is read-only")
+	}
+	if len(blocks) == 0 {
+		return nil
+	}
+	height := int(blocks[0].Height)
+	if height > c.nextBlock {
+		// Cache has been reset (for example, checksum error)
+		return nil
+	}
+	if height < c.nextBlock {
+		return fmt.Errorf("cache.AddBatch: first height %d, expecting %d", height, c.nextBlock)
+	}
+	entries, err := c.batchEntries(blocks)
+	if err != nil {
+		return err
+	}
+	actions := make([]int, 0, len(blocks))
+	for _, block := range blocks {
+		actions = append(actions, compactActionCount(block))
+	}
+	c.flush()
+	if err := c.store.Put(entries, actions, false); err != nil {
+		Log.Fatal(err)
+	}
+
+	// update the in-memory variables
+	prevHash := c.latestHash
+	for i, block := range blocks {
+		c.appendSums(len(entries[i]), actions[i])
+		c.logAdd(block, prevHash)
+		prevHash, _ = hash32.FromSlice(block.Hash)
+	}
+	c.nextBlock += len(blocks)
+	c.latestHash = hash32.T(blocks[len(blocks)-1].Hash)
+	if c.full != nil {
+		if data == nil {
+			c.full.add(blocks[len(blocks)-1], nil)
+		}
+		for i, block := range data {
+			c.full.add(blocks[i], block)
+		}
+	}
+	c.unsynced += len(blocks)
+	if c.syncPolicy != SyncNever && c.unsynced >= int(c.syncPolicy) {
+		c.Sync()
+		c.unsynced = 0
+		c.writeCheckpoint()
+	}
+	c.maybeEvict()
+	c.publish(c.nextBlock - 1)
+	return nil
+}
+
+// batchEntries returns the store entries (checksum and block) for the given
+// blocks, which are checked to have consecutive heights.
+func (c *BlockCache) batchEntries(blocks []*walletrpc.CompactBlock) ([][]byte, error) {
+	entries := make([][]byte, 0, len(blocks))
+	height := int(blocks[0].Height)
+	for i, block := range blocks {
+		if int(block.Height) != height+i {
+			return nil, fmt.Errorf("cache.AddBatch: block %d has height %d, expecting %d",
+				i, block.Height, height+i)
+		}
+		data, err := proto.Marshal(block)
+		if err != nil {
+			return nil, err
+		}
+		data, err = c.encodeBlock(data)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, append(checksum(height+i, data), data...))
+	}
+	return entries, nil
+}
+
 // Reorg resets nextBlock (the block that should be Add()ed next)
-// downward to the given height.
-func (c *BlockCache) Reorg(height int) {
+// downward to the given height. It returns the number of blocks removed
+// and the hash of the (new) latest block.
+func (c *BlockCache) Reorg(height int) (int, hash32.T) {
+	defer c.reorgTime.since(time.Now())
+	removed, latestHash := c.reorg(height)
+	if removed > 0 {
+		c.notify(CacheEvent{Height: c.GetNextHeight(), Reorg: true})
+	}
+	return removed, latestHash
+}
+
+func (c *BlockCache) reorg(height int) (int, hash32.T) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	if c.readOnly {
+		return 0, c.latestHash
+	}
+
 	// Allow the caller not to have to worry about Sapling start height.
 	if height < c.firstBlock {
 		height = c.firstBlock
 	}
 	if height >= c.nextBlock {
 		// Timing window, ignore this request
-		return
+		return 0, c.latestHash
 	}
+	c.reorgs.Add(1)
+	c.flush()
+	removed := c.nextBlock - height
 	// Remove the end of the cache.
 	c.nextBlock = height
 	newCacheLen := height - c.firstBlock
-	c.starts = c.starts[:newCacheLen+1]
+	c.trimSums(newCacheLen)
 
-	if err := c.lengthsFile.Truncate(int64(4 * newCacheLen)); err != nil {
-		Log.Fatal("truncate failed: ", err)
+	if err := c.store.Delete(newCacheLen, c.store.Len()); err != nil {
+		Log.Fatal(err)
 	}
-	if err := c.blocksFile.Truncate(c.starts[newCacheLen]); err != nil {
-		Log.Fatal("truncate failed: ", err)
+	if c.full != nil {
+		c.full.truncate(height)
 	}
 	c.setLatestHash()
+	c.log().WithFields(logrus.Fields{
+		"height":  height,
+		"removed": removed,
+		"latest":  c.latestHash.Short(),
+	}).Info("cache reorg")
+	return removed, c.latestHash
 }
 
 // Get returns the compact block at the requested height if it's
 // in the cache, else nil.
 func (c *BlockCache) Get(height int) *walletrpc.CompactBlock {
+	defer c.getTime.since(time.Now())
+	block := c.get(height)
+	c.notify(CacheEvent{Hit: block != nil, Height: height})
+	return block
+}
+
+func (c *BlockCache) get(height int) *walletrpc.CompactBlock {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
+	return c.lookup(height)
+}
+
+// GetRelative returns the compact block at the given offset from the latest
+// block: offset 0 is the latest block, -1 the one before it, and so on. It
+// returns nil if there's no such block in the cache (offset is positive, or
+// reaches below the first cached block). The block is the one at that offset
+// from the latest block at the time of the call, even if a reorg is changing
+// the latest block.
+func (c *BlockCache) GetRelative(offset int) *walletrpc.CompactBlock {
+	if offset > 0 {
+		return nil
+	}
+	c.mutex.RLock()
+	height := c.servedEnd() - 1 + offset
+	block := c.lookup(height)
+	c.mutex.RUnlock()
+	c.notify(CacheEvent{Hit: block != nil, Height: height})
+	return block
+}
 
-	if height < c.firstBlock || height >= c.nextBlock {
+// lookup returns the block at the given height, or nil if it isn't cached
+// (or is hidden, see SetConfirmationDepth).
+// Caller should hold (at least) c.mutex.RLock().
+func (c *BlockCache) lookup(height int) *walletrpc.CompactBlock {
+	if height < c.firstBlock || height >= c.servedEnd() {
+		c.misses.Add(1)
 		return nil
 	}
 	block := c.readBlock(height)
 	if block == nil {
+		c.misses.Add(1)
 		go func() {
 			// We hold only the read lock, need the exclusive lock.
 			c.mutex.Lock()
@@ -365,35 +1598,108 @@ func (c *BlockCache) Get(height int) *walletrpc.CompactBlock {
 		}()
 		return nil
 	}
+	c.hits.Add(1)
 	return block
 }
 
-// GetLatestHeight returns the height of the most recent block, or -1
-// if the cache is empty.
+// GetNearest returns the cached block closest to the given height, and its
+// height; this is the block at the given height if it's cached. If the cache
+// is empty, it returns nil and -1.
+func (c *BlockCache) GetNearest(height int) (*walletrpc.CompactBlock, int) {
+	c.mutex.RLock()
+	end := c.servedEnd()
+	if c.firstBlock == end {
+		c.mutex.RUnlock()
+		return nil, -1
+	}
+	height = min(max(height, c.firstBlock), end-1)
+	c.mutex.RUnlock()
+	// The cache may change in between, in which case Get may return nil.
+	return c.Get(height), height
+}
+
+// getRangeBatch is the maximum number of blocks GetRange reads at once.
+const getRangeBatch = 100
+
+// GetRange returns an iterator over the cached compact blocks from height
+// start to end inclusive, lowest height first. It stops early at the first
+// block that isn't cached (because it's newer than the latest cached block,
+// for example, or was evicted); the caller can tell where to continue from
+// the height of the last block yielded. Blocks are read in batches, and the
+// cache isn't locked while the caller processes them.
+func (c *BlockCache) GetRange(start, end int) iter.Seq[*walletrpc.CompactBlock] {
+	return func(yield func(*walletrpc.CompactBlock) bool) {
+		for height := start; height <= end; {
+			want := min(end+1-height, getRangeBatch)
+			blocks := c.getBatch(height, height+want)
+			for _, block := range blocks {
+				c.notify(CacheEvent{Hit: true, Height: int(block.Height)})
+				if !yield(block) {
+					return
+				}
+			}
+			if len(blocks) < want {
+				return
+			}
+			height += want
+		}
+	}
+}
+
+// getBatch returns the blocks from height start up to (not including) end,
+// stopping at the first one that isn't cached.
+func (c *BlockCache) getBatch(start, end int) []*walletrpc.CompactBlock {
+	defer c.getRangeTime.since(time.Now())
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if start < c.firstBlock || start >= c.servedEnd() {
+		return nil
+	}
+	end = min(end, c.servedEnd())
+	blocks := c.readBlocks(start, end)
+	c.hits.Add(uint64(len(blocks)))
+	if len(blocks) < end-start {
+		bad := start + len(blocks)
+		go func() {
+			// We hold only the read lock, need the exclusive lock.
+			c.mutex.Lock()
+			c.recoverFromCorruption(bad - 10000)
+			c.mutex.Unlock()
+		}()
+	}
+	return blocks
+}
+
+// GetLatestHeight returns the height of the most recent block served to
+// readers (which, with a confirmation depth, is below the most recent cached
+// block), or -1 if there isn't one.
 func (c *BlockCache) GetLatestHeight() int {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
-	if c.firstBlock == c.nextBlock {
+	if c.firstBlock == c.servedEnd() {
 		return -1
 	}
-	return c.nextBlock - 1
+	return c.servedEnd() - 1
 }
 
-// Sync ensures that the db files are flushed to disk, can be called unnecessarily.
+// Sync ensures that the store is flushed to disk, can be called unnecessarily.
+// It doesn't write the buffered blocks (use Flush).
 func (c *BlockCache) Sync() {
-	c.lengthsFile.Sync()
-	c.blocksFile.Sync()
+	c.store.Sync()
+	if c.full != nil {
+		c.full.sync()
+	}
 }
 
 // Close is Currently used only for testing.
 func (c *BlockCache) Close() {
-	// Some operating system require you to close files before you can remove them.
-	if c.lengthsFile != nil {
-		c.lengthsFile.Close()
-		c.lengthsFile = nil
+	if !c.readOnly {
+		c.flush()
 	}
-	if c.blocksFile != nil {
-		c.blocksFile.Close()
-		c.blocksFile = nil
+	c.store.Close()
+	if c.full != nil {
+		c.full.close()
 	}
+	c.closeSubscribers()
 }
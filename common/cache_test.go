@@ -7,14 +7,29 @@
 package common
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
 	"github.com/zcash/lightwalletd/hash32"
 	"github.com/zcash/lightwalletd/parser"
 	"github.com/zcash/lightwalletd/walletrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 var compacts []*walletrpc.CompactBlock
@@ -34,6 +49,7 @@ func TestCache(t *testing.T) {
 		Compact     string `json:"compact"`
 	}
 	var compactTests []compactTest
+	compacts = nil
 
 	blockJSON, err := os.ReadFile("../testdata/compact_blocks.json")
 	if err != nil {
@@ -52,13 +68,20 @@ func TestCache(t *testing.T) {
 		block := parser.NewBlock()
 		blockData, err = block.ParseFromSlice(blockData)
 		if err != nil {
-			t.Logf("Skipping block %d (likely has Sapling transactions): %v", test.BlockHeight, err)
+			if !errors.Is(err, parser.ErrUnsupportedSapling) && !errors.Is(err, parser.ErrUnsupportedSprout) {
+				t.Fatalf("block %d: %v", test.BlockHeight, err)
+			}
+			t.Logf("Skipping block %d (has unsupported transactions): %v", test.BlockHeight, err)
 			continue
 		}
 		if len(blockData) > 0 {
 			t.Error("Extra data remaining")
 		}
-		compacts = append(compacts, block.ToCompact())
+		compact := block.ToCompact()
+		if compactActionCount(compact) != block.CompactActionCount() {
+			t.Fatal("unexpected action count of block ", test.BlockHeight)
+		}
+		compacts = append(compacts, compact)
 	}
 
 	// Juno Cash: Need at least 3 blocks for meaningful cache tests
@@ -163,6 +186,9 @@ func reorgCache(t *testing.T) {
 	if len(cache.starts) != 4 {
 		t.Fatal("unexpected len(cache.starts)")
 	}
+	if err := cache.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
 
 	if cache.GetLatestHeight() != startHeight+2 {
 		t.Fatal("unexpected GetLatestHeight")
@@ -202,6 +228,10 @@ func fillCache(t *testing.T) {
 			t.Fatal("unexpected len(cache.starts)")
 		}
 
+		if err := cache.CheckInvariants(); err != nil {
+			t.Fatal(err)
+		}
+
 		// some "black-box" tests (using exported interfaces)
 		if cache.GetLatestHeight() != startHeight+i {
 			t.Fatal("unexpected GetLatestHeight")
@@ -215,3 +245,1769 @@ func fillCache(t *testing.T) {
 		}
 	}
 }
+
+// testCompactBlock returns a synthetic compact block at the given height,
+// with a hash unique to that height that links to the previous height.
+// (Its marshalled length must be at least 74, see NewBlockCache.)
+func testCompactBlock(height int) *walletrpc.CompactBlock {
+	hash := func(height int) hash32.T {
+		var h hash32.T
+		h[0] = byte(height)
+		h[1] = byte(height >> 8)
+		h[31] = 1
+		return h
+	}
+	h, prev := hash(height), hash(height-1)
+	return &walletrpc.CompactBlock{
+		Height:   uint64(height),
+		Hash:     h[:],
+		PrevHash: prev[:],
+		Time:     uint32(1700000000 + height*75),
+	}
+}
+
+// Readers (the frontend) must be able to use the cache while the ingestor
+// is adding blocks and reorging; run this with -race.
+func TestCacheConcurrentAccess(t *testing.T) {
+	const (
+		startHeight = 1000
+		nBlocks     = 200
+	)
+	c := NewBlockCache(t.TempDir(), unitTestChain, startHeight, 0)
+	defer c.Close()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				latest := c.GetLatestHeight()
+				if latest < 0 {
+					continue
+				}
+				// The block may have been reorged away by now.
+				if b := c.Get(latest); b != nil && int(b.Height) != latest {
+					t.Errorf("Get(%d) returned height %d", latest, b.Height)
+					return
+				}
+				c.GetLatestHash()
+				c.GetNextHeight()
+			}
+		}()
+	}
+
+	for height := startHeight; height < startHeight+nBlocks; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+		if height%50 == 49 {
+			// Drop and re-add the last few blocks.
+			c.Reorg(height - 3)
+			for h := height - 3; h <= height; h++ {
+				if err := c.Add(h, testCompactBlock(h)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	if c.GetLatestHeight() != startHeight+nBlocks-1 {
+		t.Fatal("unexpected GetLatestHeight: ", c.GetLatestHeight())
+	}
+	for height := startHeight; height < startHeight+nBlocks; height++ {
+		if b := c.Get(height); b == nil || int(b.Height) != height {
+			t.Fatal("unexpected block at height ", height)
+		}
+	}
+}
+
+func TestCacheMaxBlocks(t *testing.T) {
+	const startHeight = 1000
+	dbPath := t.TempDir()
+	c := NewBlockCache(dbPath, unitTestChain, startHeight, 0)
+	c.SetMaxBlocks(4)
+	for height := startHeight; height < startHeight+10; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+		if n := c.nextBlock - c.firstBlock; n > 4 {
+			t.Fatal("cache holds too many blocks: ", n)
+		}
+	}
+	checkCache := func(c *BlockCache) {
+		t.Helper()
+		// The oldest 6 blocks were dropped.
+		if c.GetFirstHeight() != startHeight+6 {
+			t.Fatal("unexpected first height: ", c.GetFirstHeight())
+		}
+		if c.GetLatestHeight() != startHeight+9 {
+			t.Fatal("unexpected latest height: ", c.GetLatestHeight())
+		}
+		if c.Get(startHeight+5) != nil {
+			t.Fatal("evicted block still cached")
+		}
+		for height := startHeight + 6; height < startHeight+10; height++ {
+			if b := c.Get(height); b == nil || int(b.Height) != height {
+				t.Fatal("unexpected block at height ", height)
+			}
+		}
+		if !c.HashMatch(hash32.T(testCompactBlock(startHeight + 10).PrevHash)) {
+			t.Fatal("unexpected latest hash")
+		}
+	}
+	checkCache(c)
+
+	// Reorging below the first height empties the cache.
+	c.Reorg(startHeight + 3)
+	if c.GetLatestHeight() != -1 || c.GetNextHeight() != startHeight+6 {
+		t.Fatal("unexpected cache state after reorg")
+	}
+	for height := startHeight + 6; height < startHeight+10; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Close()
+
+	// After a restart, the first height is that of the oldest retained block.
+	c = NewBlockCache(dbPath, unitTestChain, startHeight, -1)
+	checkCache(c)
+	c.Close()
+
+	// Re-syncing from below the first height starts over.
+	c = NewBlockCache(dbPath, unitTestChain, startHeight, startHeight+2)
+	if c.GetFirstHeight() != startHeight+2 || c.GetLatestHeight() != -1 {
+		t.Fatal("unexpected cache state after re-sync")
+	}
+	c.Close()
+}
+
+func TestCacheCompressed(t *testing.T) {
+	const startHeight = 1000
+	defer func() { CompressCache = false }()
+
+	// An existing uncompressed cache stays uncompressed.
+	plainPath := t.TempDir()
+	c := NewBlockCache(plainPath, unitTestChain, startHeight, 0)
+	if err := c.Add(startHeight, testCompactBlock(startHeight)); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	CompressCache = true
+	c = NewBlockCache(plainPath, unitTestChain, startHeight, -1)
+	if c.format != dbFormatPlain || c.GetLatestHeight() != startHeight {
+		t.Fatal("unexpected plain cache state")
+	}
+	c.Close()
+
+	// Re-syncing from the start converts it.
+	c = NewBlockCache(plainPath, unitTestChain, startHeight, 0)
+	if c.format != dbFormatGzip || c.GetLatestHeight() != -1 {
+		t.Fatal("emptied cache isn't compressed")
+	}
+	c.Close()
+
+	// A new cache is compressed.
+	dbPath := t.TempDir()
+	c = NewBlockCache(dbPath, unitTestChain, startHeight, 0)
+	if c.format != dbFormatGzip {
+		t.Fatal("new cache isn't compressed")
+	}
+	for height := startHeight; height < startHeight+10; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Reorg(startHeight + 8)
+	c.Close()
+
+	// The format comes from the file header, not CompressCache.
+	CompressCache = false
+	c = NewBlockCache(dbPath, unitTestChain, startHeight, -1)
+	defer c.Close()
+	if c.format != dbFormatGzip {
+		t.Fatal("compressed cache format not detected")
+	}
+	if c.GetLatestHeight() != startHeight+7 {
+		t.Fatal("unexpected latest height: ", c.GetLatestHeight())
+	}
+	for height := startHeight; height < startHeight+8; height++ {
+		b := c.Get(height)
+		if b == nil || !proto.Equal(b, testCompactBlock(height)) {
+			t.Fatal("unexpected block at height ", height)
+		}
+	}
+	blocks, err := os.ReadFile(fileStoreOf(c).blocksName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blocks[:len(c.header())], c.header()) {
+		t.Fatal("missing blocks file header")
+	}
+
+	// Eviction preserves the header.
+	c.SetMaxBlocks(2)
+	if c.GetFirstHeight() != startHeight+6 || c.Get(startHeight+7) == nil {
+		t.Fatal("unexpected cache state after eviction")
+	}
+	blocks, err = os.ReadFile(fileStoreOf(c).blocksName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blocks[:len(c.header())], c.header()) {
+		t.Fatal("eviction removed blocks file header")
+	}
+}
+
+// BenchmarkCacheCompression reports the size of the blocks file, with and
+// without compression, for the compact blocks in the test data.
+func BenchmarkCacheCompression(b *testing.B) {
+	var compactTests []struct {
+		Compact string `json:"compact"`
+	}
+	blockJSON, err := os.ReadFile("../testdata/compact_blocks.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := json.Unmarshal(blockJSON, &compactTests); err != nil {
+		b.Fatal(err)
+	}
+	var blocks []*walletrpc.CompactBlock
+	for _, test := range compactTests {
+		data, err := hex.DecodeString(test.Compact)
+		if err != nil {
+			b.Fatal(err)
+		}
+		block := &walletrpc.CompactBlock{}
+		if err := proto.Unmarshal(data, block); err != nil {
+			b.Fatal(err)
+		}
+		blocks = append(blocks, block)
+	}
+	defer func() { CompressCache = false }()
+	for _, compress := range []bool{false, true} {
+		name := "plain"
+		if compress {
+			name = "gzip"
+		}
+		b.Run(name, func(b *testing.B) {
+			CompressCache = compress
+			var size int64
+			for range b.N {
+				c := NewBlockCache(b.TempDir(), unitTestChain, int(blocks[0].Height), 0)
+				for i, block := range blocks {
+					block.Height = uint64(int(blocks[0].Height) + i)
+					if err := c.Add(int(block.Height), block); err != nil {
+						b.Fatal(err)
+					}
+				}
+				size = c.starts[len(c.starts)-1]
+				c.Close()
+			}
+			b.ReportMetric(float64(size), "bytes")
+		})
+	}
+}
+
+// A corrupted block in the db file must not be served; it's treated as
+// missing (so it will be re-fetched from the backend node).
+func TestCacheCorruptBlock(t *testing.T) {
+	const startHeight = 1000
+	c := NewBlockCache(t.TempDir(), unitTestChain, startHeight, 0)
+	defer c.Close()
+	for height := startHeight; height < startHeight+5; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Flip a byte in the middle of the block at startHeight+3.
+	f, err := os.OpenFile(fileStoreOf(c).blocksName, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := fileStoreOf(c).starts[3] + 8 + int64(c.blockLength(startHeight+3)/2)
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0x10
+	if _, err := f.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if c.Get(startHeight+2) == nil {
+		t.Fatal("uncorrupted block not returned")
+	}
+	if c.Get(startHeight+3) != nil {
+		t.Fatal("corrupted block returned")
+	}
+	// Get recovers (drops the cached blocks) in the background.
+	for range 100 {
+		if c.GetLatestHeight() == -1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.GetLatestHeight() != -1 || c.GetNextHeight() != startHeight {
+		t.Fatal("cache not reset after corruption")
+	}
+	if _, err := os.Stat(fileStoreOf(c).blocksName + "-corrupted"); err != nil {
+		t.Fatal("corrupted blocks file not saved: ", err)
+	}
+}
+
+func TestCacheRepair(t *testing.T) {
+	const startHeight = 1000
+	dbPath := t.TempDir()
+	c := NewBlockCache(dbPath, unitTestChain, startHeight, 0)
+	for height := startHeight; height < startHeight+5; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.Repair() != 0 || c.GetLatestHeight() != startHeight+4 {
+		t.Fatal("Repair changed a good cache")
+	}
+
+	// Simulate a crash while writing the last block: its length was
+	// written, but only part of the block itself.
+	if err := os.Truncate(fileStoreOf(c).blocksName, fileStoreOf(c).starts[4]+10); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	c = NewBlockCache(dbPath, unitTestChain, startHeight, -1)
+	if c.GetLatestHeight() != startHeight+3 {
+		t.Fatal("unexpected latest height after restart: ", c.GetLatestHeight())
+	}
+	if !c.HashMatch(hash32.T(testCompactBlock(startHeight + 4).PrevHash)) {
+		t.Fatal("unexpected latest hash after restart")
+	}
+	if err := c.Add(startHeight+4, testCompactBlock(startHeight+4)); err != nil {
+		t.Fatal(err)
+	}
+	if b := c.Get(startHeight + 4); b == nil || int(b.Height) != startHeight+4 {
+		t.Fatal("unexpected block after repair")
+	}
+
+	// A bad block truncates the cache there.
+	f, err := os.OpenFile(fileStoreOf(c).blocksName, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{0xff, 0xff}, fileStoreOf(c).starts[2]+8); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if n := c.Repair(); n != 3 {
+		t.Fatal("unexpected number of blocks removed: ", n)
+	}
+	if c.GetLatestHeight() != startHeight+1 || c.Get(startHeight+1) == nil {
+		t.Fatal("unexpected cache state after repair")
+	}
+	c.Close()
+}
+
+func TestCacheGetRange(t *testing.T) {
+	const startHeight = 1000
+	c := NewBlockCache(t.TempDir(), unitTestChain, startHeight, 0)
+	defer c.Close()
+	nBlocks := 2*getRangeBatch + 10
+	for height := startHeight; height < startHeight+nBlocks; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rangeHeights := func(start, end int) []int {
+		var heights []int
+		for block := range c.GetRange(start, end) {
+			heights = append(heights, int(block.Height))
+		}
+		return heights
+	}
+	for _, tt := range []struct {
+		start, end int
+		first, n   int // expected first height and number of blocks
+	}{
+		{startHeight, startHeight + nBlocks - 1, startHeight, nBlocks},
+		{startHeight + 5, startHeight + 5, startHeight + 5, 1},
+		{startHeight + 50, startHeight + 180, startHeight + 50, 131},
+		// Stops at the first block that isn't cached.
+		{startHeight + 150, startHeight + nBlocks + 20, startHeight + 150, nBlocks - 150},
+		{startHeight - 1, startHeight + 10, 0, 0},
+		{startHeight + nBlocks, startHeight + nBlocks + 10, 0, 0},
+		{startHeight + 10, startHeight + 5, 0, 0},
+	} {
+		heights := rangeHeights(tt.start, tt.end)
+		if len(heights) != tt.n {
+			t.Fatalf("GetRange(%d, %d) returned %d blocks, want %d",
+				tt.start, tt.end, len(heights), tt.n)
+		}
+		for i, height := range heights {
+			if height != tt.first+i {
+				t.Fatalf("GetRange(%d, %d) unexpected height %d", tt.start, tt.end, height)
+			}
+		}
+	}
+
+	// Stopping the iteration early.
+	n := 0
+	for range c.GetRange(startHeight, startHeight+nBlocks-1) {
+		n++
+		if n == 3 {
+			break
+		}
+	}
+	if n != 3 {
+		t.Fatal("unexpected number of blocks: ", n)
+	}
+}
+
+// benchCompactBlock returns a block like testCompactBlock's, but of a more
+// realistic size: a few transactions with Orchard actions (about 1.3KB).
+func benchCompactBlock(height int) *walletrpc.CompactBlock {
+	block := testCompactBlock(height)
+	field := func(n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(height + i)
+		}
+		return b
+	}
+	for i := range 4 {
+		tx := &walletrpc.CompactTx{Index: uint64(i), Txid: field(32)}
+		for range 2 {
+			tx.Actions = append(tx.Actions, &walletrpc.CompactOrchardAction{
+				Nullifier:    field(32),
+				Cmx:          field(32),
+				EphemeralKey: field(32),
+				Ciphertext:   field(52),
+			})
+		}
+		block.Vtx = append(block.Vtx, tx)
+	}
+	return block
+}
+
+func benchmarkCache(b *testing.B, nBlocks int) *BlockCache {
+	c := NewBlockCache(b.TempDir(), unitTestChain, 1000, 0)
+	for height := 1000; height < 1000+nBlocks; height++ {
+		if err := c.Add(height, benchCompactBlock(height)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return c
+}
+
+func BenchmarkCacheAdd(b *testing.B) {
+	c := NewBlockCache(b.TempDir(), unitTestChain, 1000, 0)
+	defer c.Close()
+	blocks := make([]*walletrpc.CompactBlock, b.N)
+	for i := range blocks {
+		blocks[i] = benchCompactBlock(1000 + i)
+	}
+	b.ResetTimer()
+	for i, block := range blocks {
+		if err := c.Add(1000+i, block); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCacheReorg measures a 10-block reorg (not including adding the
+// replacement blocks).
+func BenchmarkCacheReorg(b *testing.B) {
+	c := benchmarkCache(b, 1000)
+	defer c.Close()
+	b.ResetTimer()
+	for range b.N {
+		if n, _ := c.Reorg(1990); n != 10 {
+			b.Fatal("unexpected number of blocks removed: ", n)
+		}
+		b.StopTimer()
+		for height := 1990; height < 2000; height++ {
+			if err := c.Add(height, benchCompactBlock(height)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StartTimer()
+	}
+}
+
+// BenchmarkCacheMixed simulates a live server: parallel readers (wallets
+// syncing the most recent blocks) while the ingestor adds a block, and
+// occasionally reorgs, about every 100 reads. Each op is a read of 10 blocks.
+func BenchmarkCacheMixed(b *testing.B) {
+	c := benchmarkCache(b, 1000)
+	defer c.Close()
+	var reads atomic.Int64
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		height := 2000
+		for adds := 0; ; adds++ {
+			for reads.Load() < int64(adds)*100 {
+				select {
+				case <-done:
+					return
+				default:
+					runtime.Gosched()
+				}
+			}
+			if adds%10 == 9 {
+				c.Reorg(height - 1)
+				height--
+			}
+			if err := c.Add(height, benchCompactBlock(height)); err != nil {
+				b.Error(err)
+				return
+			}
+			height++
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			latest := c.GetLatestHeight()
+			for range c.GetRange(latest-10, latest-1) {
+			}
+			reads.Add(1)
+		}
+	})
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+}
+
+func BenchmarkCacheGet(b *testing.B) {
+	c := benchmarkCache(b, 1000)
+	defer c.Close()
+	b.ResetTimer()
+	for range b.N {
+		for height := 1000; height < 2000; height++ {
+			if c.Get(height) == nil {
+				b.Fatal("missing block")
+			}
+		}
+	}
+}
+
+func BenchmarkCacheGetRange(b *testing.B) {
+	c := benchmarkCache(b, 1000)
+	defer c.Close()
+	b.ResetTimer()
+	for range b.N {
+		n := 0
+		for range c.GetRange(1000, 1999) {
+			n++
+		}
+		if n != 1000 {
+			b.Fatal("missing blocks")
+		}
+	}
+}
+
+func TestCacheMetrics(t *testing.T) {
+	const startHeight = 1000
+	c := NewBlockCache(t.TempDir(), unitTestChain, startHeight, 0)
+	defer c.Close()
+	for height := startHeight; height < startHeight+5; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Get(startHeight)
+	c.Get(startHeight + 5) // miss
+	for range c.GetRange(startHeight, startHeight+2) {
+	}
+	c.Reorg(startHeight + 3)
+	c.Reorg(startHeight + 10) // no-op, not counted
+
+	m := c.Metrics()
+	blocksSize, err := os.Stat(fileStoreOf(c).blocksName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CacheMetrics{
+		Hits:        4,
+		Misses:      1,
+		Reorgs:      1,
+		Blocks:      3,
+		FirstHeight: startHeight,
+		NextHeight:  startHeight + 3,
+		DiskBytes:   blocksSize.Size() + 3*4 + 3*4, // lengths and actions files
+	}
+	if m != want {
+		t.Fatalf("Metrics() = %+v, want %+v", m, want)
+	}
+
+	reg := prometheus.NewRegistry()
+	if err := c.RegisterMetrics(reg); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, f := range families {
+		for _, metric := range f.GetMetric() {
+			values[f.GetName()] = metric.GetCounter().GetValue() + metric.GetGauge().GetValue()
+		}
+	}
+	if values["lightwalletd_cache_hits_total"] != 4 ||
+		values["lightwalletd_cache_blocks"] != 3 ||
+		values["lightwalletd_cache_next_height"] != startHeight+3 ||
+		len(values) != 9 {
+		t.Fatal("unexpected gathered metrics: ", values)
+	}
+
+	timings := c.Timings()
+	if timings.Add.Count != 5 || timings.Get.Count != 2 ||
+		timings.GetRange.Count != 1 || timings.Reorg.Count != 2 {
+		t.Fatalf("unexpected Timings() %+v", timings)
+	}
+	if timings.Add.Total <= 0 || timings.Reorg.Total <= 0 {
+		t.Fatalf("Timings() has zero durations %+v", timings)
+	}
+}
+
+func TestCacheReadOnly(t *testing.T) {
+	const startHeight = 1000
+	dbPath := t.TempDir()
+	if _, err := NewBlockCacheReadOnly(dbPath, unitTestChain); err == nil {
+		t.Fatal("NewBlockCacheReadOnly unexpected success without a db")
+	}
+	w := NewBlockCache(dbPath, unitTestChain, startHeight, 0)
+	defer w.Close()
+	add := func(start, end int) {
+		for height := start; height < end; height++ {
+			if err := w.Add(height, testCompactBlock(height)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	r, err := NewBlockCacheReadOnly(dbPath, unitTestChain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.GetLatestHeight() != -1 {
+		t.Fatal("unexpected latest height of empty cache")
+	}
+	check := func(latest int) {
+		t.Helper()
+		if err := r.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		if r.GetLatestHeight() != latest {
+			t.Fatalf("reader latest height %d, want %d", r.GetLatestHeight(), latest)
+		}
+		if r.GetLatestHash() != w.GetLatestHash() {
+			t.Fatal("reader latest hash doesn't match writer's")
+		}
+		for height := r.GetFirstHeight(); height <= latest; height++ {
+			if b := r.Get(height); b == nil || !proto.Equal(b, w.Get(height)) {
+				t.Fatal("reader unexpected block at height ", height)
+			}
+		}
+	}
+
+	// The writer advances.
+	add(startHeight, startHeight+10)
+	check(startHeight + 9)
+	if r.GetFirstHeight() != startHeight {
+		t.Fatal("unexpected first height: ", r.GetFirstHeight())
+	}
+	add(startHeight+10, startHeight+20)
+	check(startHeight + 19)
+
+	// The writer reorgs, to fewer blocks, and to the same number of blocks
+	// (with different contents).
+	w.Reorg(startHeight + 15)
+	check(startHeight + 14)
+	w.Reorg(startHeight + 12)
+	for height := startHeight + 12; height < startHeight+15; height++ {
+		block := testCompactBlock(height)
+		block.Time++
+		if err := w.Add(height, block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(startHeight + 14)
+
+	// The writer evicts old blocks (replacing the files).
+	sub := r.Subscribe()
+	w.SetMaxBlocks(5)
+	check(startHeight + 14)
+	if r.GetFirstHeight() != startHeight+10 {
+		t.Fatal("unexpected first height after eviction: ", r.GetFirstHeight())
+	}
+	// Reopening the replaced files doesn't close the subscription.
+	select {
+	case _, ok := <-sub:
+		if !ok {
+			t.Fatal("subscription closed by refresh")
+		}
+	default:
+	}
+
+	// A partially-written block isn't seen.
+	f, err := os.OpenFile(fileStoreOf(w).lengthsName, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{100, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	check(startHeight + 14)
+
+	// The reader can't write.
+	if err := r.Add(startHeight+15, testCompactBlock(startHeight+15)); err == nil {
+		t.Fatal("read-only Add unexpected success")
+	}
+	r.Reorg(startHeight)
+	if r.GetLatestHeight() != startHeight+14 {
+		t.Fatal("read-only Reorg changed the cache")
+	}
+}
+
+func TestCacheReorgCount(t *testing.T) {
+	const startHeight = 1000
+	c := NewBlockCache(t.TempDir(), unitTestChain, startHeight, 0)
+	defer c.Close()
+	for height := startHeight; height < startHeight+30; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	latestHash := func(height int) hash32.T {
+		return hash32.T(testCompactBlock(height).Hash)
+	}
+	for _, tt := range []struct {
+		height, removed int
+		latest          hash32.T
+	}{
+		{startHeight + 30, 0, latestHash(startHeight + 29)}, // nothing to remove
+		{startHeight + 29, 1, latestHash(startHeight + 28)}, // shallow
+		{startHeight + 9, 20, latestHash(startHeight + 8)},  // deep
+		{startHeight - 5, 9, hash32.Nil},                    // below the first block
+		{startHeight, 0, hash32.Nil},                        // empty
+	} {
+		removed, latest := c.Reorg(tt.height)
+		if removed != tt.removed || latest != tt.latest {
+			t.Fatalf("Reorg(%d) = %d, %s; want %d, %s", tt.height,
+				removed, latest.Short(), tt.removed, tt.latest.Short())
+		}
+	}
+}
+
+func TestCacheCheckInvariants(t *testing.T) {
+	const startHeight = 1000
+	c := NewBlockCache(t.TempDir(), unitTestChain, startHeight, 0)
+	defer c.Close()
+	if err := c.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	for height := startHeight; height < startHeight+5; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Reorg(startHeight + 3)
+	if err := c.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each kind of inconsistency is detected.
+	for _, corrupt := range []func(){
+		func() { c.nextBlock++ },
+		func() { c.starts = append(c.starts, c.starts[len(c.starts)-1]+100) },
+		func() { c.starts[1], c.starts[2] = c.starts[2], c.starts[1] },
+		func() { c.latestHash[0] ^= 1 },
+		func() { fileStoreOf(c).lengthsFile.Write([]byte{0}) },
+		func() { fileStoreOf(c).starts[2]++ },
+	} {
+		s := fileStoreOf(c)
+		starts, storeStarts := slices.Clone(c.starts), slices.Clone(s.starts)
+		nextBlock, latestHash := c.nextBlock, c.latestHash
+		corrupt()
+		if err := c.CheckInvariants(); err == nil {
+			t.Fatal("inconsistency not detected")
+		}
+		c.starts, s.starts, c.nextBlock, c.latestHash = starts, storeStarts, nextBlock, latestHash
+		fileStoreOf(c).lengthsFile.Truncate(int64(4 * (c.nextBlock - c.firstBlock)))
+		if err := c.CheckInvariants(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCachePruneBefore(t *testing.T) {
+	const startHeight = 1000
+	c := NewBlockCache(t.TempDir(), unitTestChain, startHeight, 0)
+	defer c.Close()
+	for height := startHeight; height < startHeight+20; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blockTime := func(height int) int64 {
+		return int64(testCompactBlock(height).Time)
+	}
+	for _, tt := range []struct {
+		before      int64
+		removed     int
+		firstHeight int
+		desc        string
+	}{
+		{blockTime(startHeight), 0, startHeight, "nothing older"},
+		{blockTime(startHeight+5) - 1, 5, startHeight + 5, "between blocks"},
+		{blockTime(startHeight + 8), 3, startHeight + 8, "exact block time"},
+		{blockTime(startHeight+30) + 1, 11, startHeight + 19, "keeps the latest block"},
+		{blockTime(startHeight+30) + 1, 0, startHeight + 19, "only the latest block"},
+	} {
+		if n := c.PruneBefore(tt.before); n != tt.removed {
+			t.Fatalf("%s: PruneBefore removed %d blocks, want %d", tt.desc, n, tt.removed)
+		}
+		if c.GetFirstHeight() != tt.firstHeight || c.GetLatestHeight() != startHeight+19 {
+			t.Fatalf("%s: unexpected height range %d-%d", tt.desc,
+				c.GetFirstHeight(), c.GetLatestHeight())
+		}
+		if err := c.CheckInvariants(); err != nil {
+			t.Fatal(tt.desc, ": ", err)
+		}
+		if b := c.Get(tt.firstHeight); b == nil || int(b.Height) != tt.firstHeight {
+			t.Fatal(tt.desc, ": unexpected first block")
+		}
+	}
+	// The cache continues normally.
+	if err := c.Add(startHeight+20, testCompactBlock(startHeight+20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheObserver(t *testing.T) {
+	const startHeight = 1000
+	c := NewBlockCache(t.TempDir(), unitTestChain, startHeight, 0)
+	defer c.Close()
+	for height := startHeight; height < startHeight+5; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var events []CacheEvent
+	c.SetObserver(func(e CacheEvent) {
+		// The cache isn't locked (this would deadlock if it were).
+		c.GetLatestHeight()
+		events = append(events, e)
+	})
+	c.Get(startHeight + 1)
+	c.Get(startHeight + 7)
+	for range c.GetRange(startHeight+3, startHeight+9) {
+	}
+	c.Reorg(startHeight + 2)
+	c.Reorg(startHeight + 2) // no-op
+	c.SetObserver(nil)
+	c.Get(startHeight)
+
+	want := []CacheEvent{
+		{Hit: true, Height: startHeight + 1},
+		{Hit: false, Height: startHeight + 7},
+		{Hit: true, Height: startHeight + 3},
+		{Hit: true, Height: startHeight + 4},
+		{Height: startHeight + 2, Reorg: true},
+	}
+	if !slices.Equal(events, want) {
+		t.Fatalf("events %+v, want %+v", events, want)
+	}
+}
+
+func TestCacheAddBatch(t *testing.T) {
+	const startHeight = 1000
+	dbPath := t.TempDir()
+	c := NewBlockCache(dbPath, unitTestChain, startHeight, 0)
+	var blocks []*walletrpc.CompactBlock
+	for height := startHeight; height < startHeight+10; height++ {
+		blocks = append(blocks, testCompactBlock(height))
+	}
+	if err := c.AddBatch(blocks[:3]); err != nil {
+		t.Fatal(err)
+	}
+	if c.GetLatestHeight() != startHeight+2 || !c.HashMatch(hash32.T(blocks[3].PrevHash)) {
+		t.Fatal("unexpected cache state after AddBatch")
+	}
+	// With the default SyncNever, only Flush writes a checkpoint.
+	checkpointName := filepath.Join(dbPath, unitTestChain, "checkpoint")
+	if _, err := os.Stat(checkpointName); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("AddBatch wrote a checkpoint", err)
+	}
+	c.SetSyncPolicy(SyncEveryN(3))
+	// Batches and single blocks can be mixed.
+	if err := c.Add(startHeight+3, blocks[3]); err != nil {
+		t.Fatal(err)
+	}
+	// The batch and this block together reach the sync policy's count.
+	if _, err := os.Stat(checkpointName); err != nil {
+		t.Fatal("no checkpoint after syncing ", err)
+	}
+	if err := c.AddBatch(blocks[2:5]); err == nil {
+		t.Fatal("AddBatch going backwards unexpected success")
+	}
+	if err := c.AddBatch([]*walletrpc.CompactBlock{blocks[4], blocks[6]}); err == nil {
+		t.Fatal("AddBatch with a gap unexpected success")
+	}
+	if c.GetLatestHeight() != startHeight+3 {
+		t.Fatal("failed AddBatch changed the cache")
+	}
+	if err := c.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash after the batch's blocks were written, but before
+	// (all of) their lengths were.
+	entries, err := c.batchEntries(blocks[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileStoreOf(c).blocksFile.Write(bytes.Join(entries, nil)); err != nil {
+		t.Fatal(err)
+	}
+	lengths := binary.LittleEndian.AppendUint32(nil, uint32(len(entries[0])-8))
+	if _, err := fileStoreOf(c).lengthsFile.Write(lengths[:2]); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	c = NewBlockCache(dbPath, unitTestChain, startHeight, -1)
+	if c.GetLatestHeight() != startHeight+3 || !c.HashMatch(hash32.T(blocks[4].PrevHash)) {
+		t.Fatal("unexpected cache state after restart: ", c.GetLatestHeight())
+	}
+	if err := c.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddBatch(blocks[4:]); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	c = NewBlockCache(dbPath, unitTestChain, startHeight, -1)
+	for i, block := range blocks {
+		if b := c.Get(startHeight + i); b == nil || !proto.Equal(b, block) {
+			t.Fatal("unexpected block at height ", startHeight+i)
+		}
+	}
+	c.Close()
+}
+
+func TestCacheSubscribe(t *testing.T) {
+	const startHeight = 1000
+	c := NewBlockCache(t.TempDir(), unitTestChain, startHeight, 0)
+	sub1 := c.Subscribe()
+	sub2 := c.Subscribe()
+
+	if err := c.Add(startHeight, testCompactBlock(startHeight)); err != nil {
+		t.Fatal(err)
+	}
+	if h := <-sub1; h != startHeight {
+		t.Fatal("unexpected height ", h)
+	}
+	// sub2 hasn't received yet; it gets only the latest height.
+	for height := startHeight + 1; height < startHeight+4; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+		if h := <-sub1; h != height {
+			t.Fatal("unexpected height ", h)
+		}
+	}
+	if h := <-sub2; h != startHeight+3 {
+		t.Fatal("unexpected height ", h)
+	}
+	if err := c.AddBatch([]*walletrpc.CompactBlock{
+		testCompactBlock(startHeight + 4),
+		testCompactBlock(startHeight + 5),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range []<-chan int{sub1, sub2} {
+		if h := <-sub; h != startHeight+5 {
+			t.Fatal("unexpected height ", h)
+		}
+	}
+
+	c.Unsubscribe(sub1)
+	if _, ok := <-sub1; ok {
+		t.Fatal("channel not closed by Unsubscribe")
+	}
+	if err := c.Add(startHeight+6, testCompactBlock(startHeight+6)); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if h, ok := <-sub2; !ok || h != startHeight+6 {
+		t.Fatal("unexpected height ", h)
+	}
+	if _, ok := <-sub2; ok {
+		t.Fatal("channel not closed by Close")
+	}
+	if _, ok := <-c.Subscribe(); ok {
+		t.Fatal("Subscribe after Close returned an open channel")
+	}
+}
+
+// BenchmarkCacheSyncPolicy compares the time to add 10k blocks when the db
+// files are flushed after every block and after every 1000 blocks.
+func BenchmarkCacheSyncPolicy(b *testing.B) {
+	defer func() { CacheSyncPolicy = SyncNever }()
+	const startHeight = 1000
+	blocks := make([]*walletrpc.CompactBlock, 10000)
+	for i := range blocks {
+		blocks[i] = testCompactBlock(startHeight + i)
+	}
+	for _, bb := range []struct {
+		name   string
+		policy SyncPolicy
+	}{
+		{"always", SyncAlways},
+		{"every1000", SyncEveryN(1000)},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			CacheSyncPolicy = bb.policy
+			for i := 0; i < b.N; i++ {
+				c := NewBlockCache(b.TempDir(), unitTestChain, startHeight, 0)
+				for j, block := range blocks {
+					if err := c.Add(startHeight+j, block); err != nil {
+						b.Fatal(err)
+					}
+				}
+				c.Close()
+			}
+		})
+	}
+}
+
+func TestCacheCompact(t *testing.T) {
+	const startHeight = 1000
+	dbPath := t.TempDir()
+	c := NewBlockCache(dbPath, unitTestChain, startHeight, 0)
+	for height := startHeight; height < startHeight+10; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fileSize := func() int64 {
+		fi, err := os.Stat(fileStoreOf(c).blocksName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+
+	// Several reorgs, each replacing the latest blocks; a reorg doesn't
+	// leave the replaced blocks' bytes behind.
+	for i := 0; i < 3; i++ {
+		c.Reorg(startHeight + 7)
+		for height := startHeight + 7; height < startHeight+10; height++ {
+			if err := c.Add(height, testCompactBlock(height)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	size := fileSize()
+	if n, err := c.Compact(); err != nil || n != 0 {
+		t.Fatal("unexpected Compact result ", n, err)
+	}
+
+	// Bytes left by a failed write are reclaimed.
+	if _, err := fileStoreOf(c).blocksFile.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileStoreOf(c).lengthsFile.Write(make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.Compact(); err != nil || n != 102 {
+		t.Fatal("unexpected Compact result ", n, err)
+	}
+	if fileSize() != size {
+		t.Fatal("unexpected blocks file size ", fileSize(), ", expecting ", size)
+	}
+	if err := c.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	for height := startHeight; height < startHeight+10; height++ {
+		if b := c.Get(height); b == nil || !proto.Equal(b, testCompactBlock(height)) {
+			t.Fatal("unexpected block at height ", height)
+		}
+	}
+	c.Close()
+}
+
+func TestCacheGetNearest(t *testing.T) {
+	const startHeight = 1000
+	c := NewBlockCache(t.TempDir(), unitTestChain, startHeight, 0)
+	if b, h := c.GetNearest(startHeight); b != nil || h != -1 {
+		t.Fatal("unexpected GetNearest result for an empty cache ", h)
+	}
+	for height := startHeight; height < startHeight+10; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		height, want int
+	}{
+		{0, startHeight},
+		{startHeight - 1, startHeight},
+		{startHeight, startHeight},
+		{startHeight + 5, startHeight + 5},
+		{startHeight + 9, startHeight + 9},
+		{startHeight + 10, startHeight + 9},
+		{startHeight + 1000, startHeight + 9},
+	} {
+		b, h := c.GetNearest(tt.height)
+		if h != tt.want || b == nil || int(b.Height) != tt.want {
+			t.Fatalf("GetNearest(%d) returned height %d, want %d", tt.height, h, tt.want)
+		}
+	}
+	c.Close()
+}
+
+func TestCacheChainName(t *testing.T) {
+	const startHeight = 1000
+	dbPath := t.TempDir()
+	c := NewBlockCache(dbPath, "test", startHeight, 0)
+	if err := c.Add(startHeight, testCompactBlock(startHeight)); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	c = NewBlockCache(dbPath, "test", startHeight, -1)
+	if !c.chainHeader || c.GetLatestHeight() != startHeight {
+		t.Fatal("unexpected reopened cache state")
+	}
+	c.Close()
+
+	// Copy the testnet cache to where the mainnet cache belongs.
+	if err := os.MkdirAll(filepath.Join(dbPath, "main"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"lengths", "blocks"} {
+		if err := copyFile(filepath.Join(dbPath, "test", name), filepath.Join(dbPath, "main", name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := NewBlockCacheReadOnly(dbPath, "main")
+	if err == nil || !strings.Contains(err.Error(), `is for chain "test" (genesis block `) {
+		t.Fatal("unexpected error: ", err)
+	}
+	var buf bytes.Buffer
+	defer func(saved *logrus.Entry) { Log = saved }(Log)
+	l := logrus.New()
+	l.SetOutput(&buf)
+	l.ExitFunc = func(int) { panic("exit") }
+	Log = l.WithField("app", "test")
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("NewBlockCache of another chain's cache unexpected success")
+			}
+		}()
+		NewBlockCache(dbPath, "main", startHeight, -1)
+	}()
+	if !strings.Contains(buf.String(), `is for chain \"test\"`) {
+		t.Fatal("unexpected log: ", buf.String())
+	}
+
+	// A cache of another chain with the same name is detected by its
+	// genesis block, if that's known.
+	defer func(saved hash32.T) { GenesisHash = saved }(GenesisHash)
+	GenesisHash = hash32.T{1}
+	c = NewBlockCache(dbPath, "test", startHeight, 0)
+	c.Close()
+	GenesisHash = hash32.T{2}
+	_, err = NewBlockCacheReadOnly(dbPath, "test")
+	if err == nil || !strings.Contains(err.Error(), `is for chain "test" (genesis block `+
+		hash32.Encode(hash32.Reverse(hash32.T{1}))+`), not "test" (`+hash32.Encode(hash32.Reverse(hash32.T{2}))+`)`) {
+		t.Fatal("unexpected error: ", err)
+	}
+	GenesisHash = hash32.Nil
+	c = NewBlockCache(dbPath, "test", startHeight, -1)
+	c.Close()
+
+	// A cache that predates the header's chain is used as is, and records
+	// the chain once it's emptied.
+	if err := os.RemoveAll(filepath.Join(dbPath, "main")); err != nil {
+		t.Fatal(err)
+	}
+	c = NewBlockCache(dbPath, "main", startHeight, 0)
+	if err := c.Add(startHeight, testCompactBlock(startHeight)); err != nil {
+		t.Fatal(err)
+	}
+	header := c.header()
+	c.Close()
+	b, err := os.ReadFile(fileStoreOf(c).blocksName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fileStoreOf(c).blocksName, b[len(header):], 0644); err != nil {
+		t.Fatal(err)
+	}
+	c = NewBlockCache(dbPath, "main", startHeight, -1)
+	if c.chainHeader || c.GetLatestHeight() != startHeight {
+		t.Fatal("unexpected state of older cache")
+	}
+	c.Close()
+	c = NewBlockCache(dbPath, "main", startHeight, 0)
+	defer c.Close()
+	if !c.chainHeader {
+		t.Fatal("emptied cache doesn't record the chain")
+	}
+}
+
+// A cache written by a newer version, in an unknown format, is left alone.
+func TestCacheUnknownFormat(t *testing.T) {
+	const startHeight = 1000
+	dbPath := t.TempDir()
+	c := NewBlockCache(dbPath, unitTestChain, startHeight, 0)
+	if err := c.Add(startHeight, testCompactBlock(startHeight)); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	blocksName := fileStoreOf(c).blocksName
+	b, err := os.ReadFile(blocksName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(b[len(dbMagic):], 0x1000)
+	if err := os.WriteFile(blocksName, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	defer func(saved *logrus.Entry) { Log = saved }(Log)
+	l := logrus.New()
+	l.SetOutput(&buf)
+	l.ExitFunc = func(int) { panic("exit") }
+	Log = l.WithField("app", "test")
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("NewBlockCache of an unknown format unexpected success")
+			}
+		}()
+		NewBlockCache(dbPath, unitTestChain, startHeight, -1)
+	}()
+	if !strings.Contains(buf.String(), "unknown db blocks file format 0x1000") {
+		t.Fatal("unexpected log: ", buf.String())
+	}
+	if _, err := NewBlockCacheReadOnly(dbPath, unitTestChain); !errors.Is(err, errUnknownFormat) {
+		t.Fatal("unexpected error: ", err)
+	}
+	if after, err := os.ReadFile(blocksName); err != nil || !bytes.Equal(after, b) {
+		t.Fatal("cache in an unknown format was changed: ", err)
+	}
+}
+
+func TestCacheWriteBuffer(t *testing.T) {
+	CacheWriteBuffer = 10
+	now := Time.Now
+	defer func() {
+		CacheWriteBuffer = 0
+		Time.Now = now
+	}()
+	Time.Now = time.Now
+	const startHeight = 1000
+	dbPath := t.TempDir()
+	c := NewBlockCache(dbPath, unitTestChain, startHeight, 0)
+	lengthsSize := func() int64 {
+		fi, err := os.Stat(fileStoreOf(c).lengthsName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	add := func(start, end int) {
+		for height := start; height < end; height++ {
+			if err := c.Add(height, testCompactBlock(height)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	check := func(end int) {
+		t.Helper()
+		for height := startHeight; height < end; height++ {
+			if b := c.Get(height); b == nil || !proto.Equal(b, testCompactBlock(height)) {
+				t.Fatal("unexpected block at height ", height)
+			}
+		}
+		n := 0
+		for b := range c.GetRange(startHeight, end-1) {
+			if int(b.Height) != startHeight+n {
+				t.Fatal("unexpected GetRange block at height ", b.Height)
+			}
+			n++
+		}
+		if n != end-startHeight {
+			t.Fatal("GetRange returned ", n, " blocks")
+		}
+		if err := c.CheckInvariants(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Buffered blocks are visible before they're written.
+	add(startHeight, startHeight+5)
+	if lengthsSize() != 0 {
+		t.Fatal("blocks written before the buffer filled")
+	}
+	check(startHeight + 5)
+	add(startHeight+5, startHeight+13)
+	if lengthsSize() != 4*10 {
+		t.Fatal("unexpected lengths file size ", lengthsSize())
+	}
+	// Reads span the written and buffered blocks.
+	check(startHeight + 13)
+
+	// The buffer is written after cacheFlushInterval.
+	Time.Now = func() time.Time { return time.Now().Add(cacheFlushInterval) }
+	add(startHeight+13, startHeight+14)
+	Time.Now = time.Now
+	if lengthsSize() != 4*14 {
+		t.Fatal("unexpected lengths file size after interval ", lengthsSize())
+	}
+	add(startHeight+14, startHeight+16)
+	c.Flush()
+	if lengthsSize() != 4*16 {
+		t.Fatal("unexpected lengths file size after Flush ", lengthsSize())
+	}
+
+	// A reorg of buffered blocks.
+	add(startHeight+16, startHeight+20)
+	c.Reorg(startHeight + 18)
+	check(startHeight + 18)
+
+	// A crash loses the buffered blocks; the cache is consistent without them.
+	add(startHeight+18, startHeight+21)
+	c.store.Close()
+	c = NewBlockCache(dbPath, unitTestChain, startHeight, -1)
+	if c.GetLatestHeight() != startHeight+17 {
+		t.Fatal("unexpected latest height after crash ", c.GetLatestHeight())
+	}
+	check(startHeight + 18)
+	add(startHeight+18, startHeight+21)
+	check(startHeight + 21)
+	c.Close()
+}
+
+// BenchmarkCacheWriteBuffer compares the time to add 10k blocks with and
+// without a write buffer.
+func BenchmarkCacheWriteBuffer(b *testing.B) {
+	now := Time.Now
+	defer func() {
+		CacheWriteBuffer = 0
+		Time.Now = now
+	}()
+	Time.Now = time.Now
+	const startHeight = 1000
+	blocks := make([]*walletrpc.CompactBlock, 10000)
+	for i := range blocks {
+		blocks[i] = testCompactBlock(startHeight + i)
+	}
+	for _, bb := range []struct {
+		name   string
+		buffer int
+	}{
+		{"unbuffered", 0},
+		{"buffer100", 100},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			CacheWriteBuffer = bb.buffer
+			for i := 0; i < b.N; i++ {
+				c := NewBlockCache(b.TempDir(), unitTestChain, startHeight, 0)
+				for j, block := range blocks {
+					if err := c.Add(startHeight+j, block); err != nil {
+						b.Fatal(err)
+					}
+				}
+				c.Close()
+			}
+		})
+	}
+}
+
+func TestCacheGetRelative(t *testing.T) {
+	const startHeight = 1000
+	c := NewBlockCache(t.TempDir(), unitTestChain, startHeight, 0)
+	if c.GetRelative(0) != nil {
+		t.Fatal("unexpected block in empty cache")
+	}
+	for height := startHeight; height < startHeight+10; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for offset := 0; offset > -10; offset-- {
+		if b := c.GetRelative(offset); b == nil || int(b.Height) != startHeight+9+offset {
+			t.Fatal("unexpected block at offset ", offset)
+		}
+	}
+	if c.GetRelative(-10) != nil || c.GetRelative(1) != nil {
+		t.Fatal("unexpected block beyond the cache")
+	}
+
+	// After a reorg, offsets are from the new tip.
+	c.Reorg(startHeight + 7)
+	if b := c.GetRelative(0); b == nil || int(b.Height) != startHeight+6 {
+		t.Fatal("unexpected latest block after reorg")
+	}
+	replacement := testCompactBlock(startHeight + 7)
+	replacement.Time++
+	if err := c.Add(startHeight+7, replacement); err != nil {
+		t.Fatal(err)
+	}
+	if b := c.GetRelative(0); b == nil || !proto.Equal(b, replacement) {
+		t.Fatal("unexpected latest block after reorg")
+	}
+	if b := c.GetRelative(-1); b == nil || int(b.Height) != startHeight+6 {
+		t.Fatal("unexpected previous block after reorg")
+	}
+	c.Close()
+}
+
+func TestCacheLogger(t *testing.T) {
+	const startHeight = 1000
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetLevel(logrus.DebugLevel)
+	c := NewBlockCache(t.TempDir(), unitTestChain, startHeight, 0)
+	c.SetLogger(logger.WithField("app", "test"))
+
+	entries := func() []map[string]any {
+		var entries []map[string]any
+		dec := json.NewDecoder(&buf)
+		for dec.More() {
+			var e map[string]any
+			if err := dec.Decode(&e); err != nil {
+				t.Fatal(err)
+			}
+			entries = append(entries, e)
+		}
+		return entries
+	}
+	for height := startHeight; height < startHeight+3; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A block that doesn't link (the caller should have checked HashMatch).
+	unlinked := testCompactBlock(startHeight + 3)
+	unlinked.PrevHash[0] ^= 1
+	if err := c.Add(startHeight+3, unlinked); err != nil {
+		t.Fatal(err)
+	}
+	e := entries()
+	if len(e) != 4 {
+		t.Fatal("unexpected number of log entries ", len(e))
+	}
+	for i, entry := range e {
+		block := testCompactBlock(startHeight + i)
+		if entry["msg"] != "cache add" || entry["app"] != "test" ||
+			entry["height"] != float64(startHeight+i) ||
+			entry["hash"] != hash32.T(block.Hash).Short() ||
+			entry["links"] != (i < 3) || entry["actions"] != float64(compactActionCount(block)) {
+			t.Fatal("unexpected log entry ", entry)
+		}
+	}
+
+	c.Reorg(startHeight + 2)
+	e = entries()
+	if len(e) != 1 || e[0]["msg"] != "cache reorg" || e[0]["height"] != float64(startHeight+2) ||
+		e[0]["removed"] != float64(2) || e[0]["latest"] != c.GetLatestHash().Short() {
+		t.Fatal("unexpected log entries ", e)
+	}
+	c.Close()
+}
+
+func TestCacheStats(t *testing.T) {
+	const startHeight = 1000
+	dbPath := t.TempDir()
+	c := NewBlockCache(dbPath, unitTestChain, startHeight, 0)
+	// Alternate blocks with and without actions.
+	block := func(height int) *walletrpc.CompactBlock {
+		if height%2 == 0 {
+			return benchCompactBlock(height)
+		}
+		return testCompactBlock(height)
+	}
+	add := func(start, end int) {
+		for height := start; height < end; height++ {
+			if err := c.Add(height, block(height)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// check compares Stats with the result of scanning the cache.
+	check := func() {
+		t.Helper()
+		s := c.Stats()
+		want := CacheStats{
+			FirstBlock: c.GetFirstHeight(),
+			NextBlock:  c.GetNextHeight(),
+			LatestHash: c.GetLatestHash(),
+			Blocks:     c.GetNextHeight() - c.GetFirstHeight(),
+		}
+		var dataBytes int64
+		for height := want.FirstBlock; height < want.NextBlock; height++ {
+			b := c.Get(height)
+			if b == nil {
+				t.Fatal("missing block ", height)
+			}
+			want.OrchardActions += int64(compactActionCount(b))
+			dataBytes += int64(c.blockLength(height))
+		}
+		for _, name := range []string{fileStoreOf(c).lengthsName, fileStoreOf(c).blocksName, fileStoreOf(c).actionsName} {
+			fi, err := os.Stat(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want.DiskBytes += fi.Size()
+		}
+		if want.Blocks > 0 {
+			want.AvgBlockSize = dataBytes / int64(want.Blocks)
+		}
+		if s != want {
+			t.Fatalf("Stats() = %+v, want %+v", s, want)
+		}
+	}
+	check()
+	add(startHeight, startHeight+10)
+	check()
+	add(startHeight+10, startHeight+20)
+	check()
+	c.Reorg(startHeight + 15)
+	check()
+	add(startHeight+15, startHeight+18)
+	check()
+	if n := c.PruneBefore(int64(testCompactBlock(startHeight + 5).Time)); n != 5 {
+		t.Fatal("unexpected number of blocks pruned ", n)
+	}
+	check()
+	if err := c.AddBatch([]*walletrpc.CompactBlock{block(startHeight + 18), block(startHeight + 19)}); err != nil {
+		t.Fatal(err)
+	}
+	check()
+	c.Close()
+	c = NewBlockCache(dbPath, unitTestChain, startHeight, -1)
+	if err := c.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	check()
+
+	// A read-only cache gets the counts from the writer's store.
+	w := c
+	c, err := NewBlockCacheReadOnly(dbPath, unitTestChain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check()
+	if err := w.Add(startHeight+20, block(startHeight+20)); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	if err := c.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	check()
+	c.Close()
+	w.Close()
+
+	// The counts are taken from the store, not by reading the blocks.
+	actionsName := ActionsDbFileName(dbPath, unitTestChain)
+	fi, err := os.Stat(actionsName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(actionsName, make([]byte, fi.Size()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c = NewBlockCache(dbPath, unitTestChain, startHeight, -1)
+	if s := c.Stats(); s.OrchardActions != 0 {
+		t.Fatal("actions counted from the blocks: ", s.OrchardActions)
+	}
+	c.Close()
+
+	// A cache without the counts (written before the store kept them) is
+	// counted once, when it's opened.
+	if err := os.Remove(actionsName); err != nil {
+		t.Fatal(err)
+	}
+	c = NewBlockCache(dbPath, unitTestChain, startHeight, -1)
+	check()
+	if err := c.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(actionsName); err != nil || fi.Size() != int64(4*c.Stats().Blocks) {
+		t.Fatal("action counts not stored ", err)
+	}
+	c.Close()
+}
+
+// On restart, the cache is checked against the checkpoint written when it
+// was last flushed; if they disagree, the cache wins.
+func TestCacheCheckpoint(t *testing.T) {
+	defer func(saved *logrus.Entry) { Log = saved }(Log)
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&buf)
+	Log = l.WithField("app", "test")
+
+	const startHeight = 1000
+	dir := t.TempDir()
+	name := filepath.Join(dir, unitTestChain, "checkpoint")
+	c := NewBlockCache(dir, unitTestChain, startHeight, -1)
+	if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("empty cache has a checkpoint", err)
+	}
+	for height := startHeight; height < startHeight+3; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Flush()
+	// Flushing again without adding blocks (as the ingestor does on each
+	// poll once it's synced) doesn't rewrite the checkpoint.
+	before, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Flush()
+	if after, err := os.Stat(name); err != nil || !os.SameFile(before, after) {
+		t.Fatal("checkpoint rewritten without a new block", err)
+	}
+	c.Close()
+	checkpoint := func() cacheCheckpoint {
+		t.Helper()
+		var cp cacheCheckpoint
+		data, err := os.ReadFile(name)
+		if err == nil {
+			err = json.Unmarshal(data, &cp)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cp
+	}
+	latest := hash32.T(testCompactBlock(startHeight + 2).Hash)
+	good := cacheCheckpoint{Height: startHeight + 2, Hash: hash32.Encode(hash32.Reverse(latest))}
+	if cp := checkpoint(); cp != good {
+		t.Fatal("unexpected checkpoint", cp)
+	}
+	restart := func(what string, cp *cacheCheckpoint, warning string) {
+		t.Helper()
+		if cp != nil {
+			data, _ := json.Marshal(cp)
+			os.WriteFile(name, data, 0644)
+		}
+		buf.Reset()
+		c := NewBlockCache(dir, unitTestChain, startHeight, -1)
+		defer c.Close()
+		if c.GetNextHeight() != startHeight+3 || c.GetLatestHash() != latest {
+			t.Fatal(what, ": cache changed", c.GetNextHeight())
+		}
+		if warning == "" {
+			if strings.Contains(buf.String(), "level=warning") {
+				t.Fatal(what, ": unexpected warning: ", buf.String())
+			}
+		} else if !strings.Contains(buf.String(), warning) {
+			t.Fatal(what, ": expected warning ", warning, ": ", buf.String())
+		}
+		// The checkpoint now matches the cache.
+		if cp := checkpoint(); cp != good {
+			t.Fatal(what, ": unexpected checkpoint ", cp)
+		}
+	}
+	restart("matching", nil, "")
+	restart("wrong hash", &cacheCheckpoint{Height: startHeight + 1, Hash: good.Hash},
+		"checkpoint block 1001 hash is "+good.Hash)
+	restart("lost blocks", &cacheCheckpoint{Height: startHeight + 5, Hash: good.Hash},
+		"checkpoint is at height 1005, but the cache ends at 1002")
+	os.WriteFile(name, []byte("garbage"), 0644)
+	restart("corrupt", nil, "can't parse checkpoint")
+
+	// Truncating the cache deliberately isn't a mismatch.
+	c = NewBlockCache(dir, unitTestChain, startHeight, startHeight+1)
+	defer c.Close()
+	if cp := checkpoint(); cp.Height != startHeight {
+		t.Fatal("checkpoint not rewritten after truncation", cp)
+	}
+}
+
+// Blocks within the confirmation depth of the tip are cached but not served.
+func TestCacheConfirmationDepth(t *testing.T) {
+	const startHeight = 1000
+	c := NewBlockCache(t.TempDir(), unitTestChain, startHeight, -1)
+	defer c.Close()
+	c.SetConfirmationDepth(3)
+	for height := startHeight; height < startHeight+2; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Nothing is deep enough yet.
+	if c.GetLatestHeight() != -1 || c.Get(startHeight) != nil {
+		t.Fatal("unconfirmed block served", c.GetLatestHeight())
+	}
+	if block, height := c.GetNearest(startHeight); block != nil || height != -1 {
+		t.Fatal("unconfirmed block served by GetNearest", height)
+	}
+	for height := startHeight + 2; height < startHeight+5; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.GetNextHeight() != startHeight+5 {
+		t.Fatal("blocks not cached", c.GetNextHeight())
+	}
+	if c.GetLatestHeight() != startHeight+1 {
+		t.Fatal("unexpected latest height", c.GetLatestHeight())
+	}
+	if c.Get(startHeight+1) == nil || c.Get(startHeight+2) != nil {
+		t.Fatal("unexpected Get result")
+	}
+	if block := c.GetRelative(0); block == nil || block.Height != startHeight+1 {
+		t.Fatal("unexpected GetRelative result", block)
+	}
+	if _, height := c.GetNearest(startHeight + 4); height != startHeight+1 {
+		t.Fatal("unexpected GetNearest height", height)
+	}
+	var heights []uint64
+	for block := range c.GetRange(startHeight, startHeight+4) {
+		heights = append(heights, block.Height)
+	}
+	if len(heights) != 2 || heights[1] != startHeight+1 {
+		t.Fatal("unexpected GetRange heights", heights)
+	}
+	if _, err := GetBlock(c, startHeight+3); status.Code(err) != codes.OutOfRange {
+		t.Fatal("unexpected GetBlock error", err)
+	}
+
+	c.SetConfirmationDepth(0)
+	if c.GetLatestHeight() != startHeight+4 {
+		t.Fatal("unexpected latest height without a depth", c.GetLatestHeight())
+	}
+}
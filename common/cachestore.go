@@ -0,0 +1,668 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// CacheStore is where a BlockCache keeps its blocks: a sequence of entries,
+// one per cached block, oldest first (the entry at index zero is the cache's
+// first block), and a header that describes the entries' format. Each entry
+// is a block's 8-byte checksum followed by the marshalled (perhaps
+// compressed) block; the store doesn't interpret either. With each entry,
+// the store keeps the number of Orchard actions in its block, so that the
+// cache can be opened without reading the blocks. The BlockCache's mutex
+// serializes all calls.
+type CacheStore interface {
+	// Len returns the number of entries.
+	Len() int
+	// Get returns the entries from index start up to (not including) end.
+	Get(start, end int) ([][]byte, error)
+	// Info returns the sizes and action counts of the entries from index
+	// start up to (not including) end, without reading the entries.
+	Info(start, end int) ([]EntryInfo, error)
+	// Put appends the given entries, actions[i] being the action count of
+	// entries[i]. If sync is true, they're on disk when Put returns; a
+	// crash during Put leaves the store without them or, at worst, with
+	// only some of the first ones.
+	Put(entries [][]byte, actions []int, sync bool) error
+	// SetActions sets the action counts of the entries from index start
+	// on (actions has one per entry), whose counts are unknown (see
+	// EntryInfo). The counts of the entries before start must be known.
+	SetActions(start int, actions []int) error
+	// Delete removes the entries from index start up to (not including)
+	// end, which must be either the first entries (start is zero) or the
+	// last (end is Len). If it fails, the entries are unchanged, or, if
+	// that isn't possible, all of them are removed.
+	Delete(start, end int) error
+	// Header returns the header, nil if there isn't one.
+	Header() []byte
+	// Reset removes all the entries and replaces the header.
+	Reset(header []byte) error
+	// Size returns the number of bytes of storage the store uses.
+	Size() int64
+	// Compact releases storage that isn't used by any entry, returning the
+	// number of bytes reclaimed.
+	Compact() (int64, error)
+	// Sync flushes the store to disk.
+	Sync() error
+	// Close closes the store.
+	Close() error
+}
+
+// EntryInfo describes a CacheStore entry.
+type EntryInfo struct {
+	Size    int // length of the entry, including the checksum
+	Actions int // number of Orchard actions in the block, -1 if not known
+}
+
+// CacheBackend is the name of the CacheStore implementation that
+// NewBlockCache uses (--cache-backend): "files", the default, or "sqlite"
+// if lightwalletd was built with -tags sqlite. A cache created by another
+// backend isn't converted; the new backend's cache starts out empty.
+var CacheBackend = fileStoreName
+
+// cacheStores maps the name of each CacheStore implementation to the
+// function that opens (creating if needed) its store for the given chain
+// under dbPath.
+var cacheStores = map[string]func(dbPath string, chainName string) (CacheStore, error){
+	fileStoreName: openFileStore,
+}
+
+const fileStoreName = "files"
+
+// fileStore is the default CacheStore, a set of db files. The lengths file
+// has the length of each entry's block (uint32 little-endian, not including
+// the checksum); the blocks file has the header followed by the entries.
+// Entries are appended to the files, and the files are truncated to remove
+// the last entries; removing the first entries rewrites them. The actions
+// file has the action count of each entry (uint32 little-endian); it's
+// shorter than the lengths file if some counts aren't known (for example,
+// lightwalletd versions before it was added didn't write it).
+type fileStore struct {
+	lengthsName, blocksName string // pathnames
+	lengthsFile, blocksFile *os.File
+	actionsName             string
+	actionsFile             *os.File // nil if a read-only store's writer hasn't created it
+	header                  []byte
+	starts                  []int64 // offset of each entry within blocksFile, plus the end
+	actions                 []int   // the known action counts, those of the first entries
+	readOnly                bool    // see openFileStoreReadOnly
+}
+
+func DbFileNames(dbPath string, chainName string) (string, string) {
+	return filepath.Join(dbPath, chainName, "lengths"),
+		filepath.Join(dbPath, chainName, "blocks")
+}
+
+// ActionsDbFileName returns the pathname of the actions file (see
+// fileStore), which accompanies the DbFileNames files.
+func ActionsDbFileName(dbPath string, chainName string) string {
+	return filepath.Join(dbPath, chainName, "actions")
+}
+
+func openFileStore(dbPath string, chainName string) (CacheStore, error) {
+	s := &fileStore{actionsName: ActionsDbFileName(dbPath, chainName)}
+	s.lengthsName, s.blocksName = DbFileNames(dbPath, chainName)
+	var err error
+	s.blocksFile, err = os.OpenFile(s.blocksName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.lengthsFile, err = os.OpenFile(s.lengthsName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	lengths, err := os.ReadFile(s.lengthsName)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.header = readBlocksHeader(s.blocksFile)
+	// A compressed block may be shorter than the minimum marshalled size.
+	minLength := uint32(74)
+	if headerFormat(s.header)&^dbFormatChain == dbFormatGzip {
+		minLength = 20
+	}
+	s.starts = []int64{int64(len(s.header))}
+	for i := 0; i+4 <= len(lengths); i += 4 {
+		length := binary.LittleEndian.Uint32(lengths[i:])
+		if length < minLength || length > 4*1000*1000 {
+			Log.Warning("lengths file has impossible value ", length)
+			s.saveCorrupted()
+			break
+		}
+		s.starts = append(s.starts, s.starts[len(s.starts)-1]+int64(length)+8)
+	}
+	s.actionsFile, err = os.OpenFile(s.actionsName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	actions, err := os.ReadFile(s.actionsName)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	// A crash during Put can leave counts beyond the last entry.
+	s.actions = appendActions(nil, actions[:4*min(len(actions)/4, s.Len())])
+	if err := s.actionsFile.Truncate(int64(4 * len(s.actions))); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// appendActions appends the action counts in b, as they're stored in the
+// actions file, to actions.
+func appendActions(actions []int, b []byte) []int {
+	for i := 0; i+4 <= len(b); i += 4 {
+		actions = append(actions, int(binary.LittleEndian.Uint32(b[i:])))
+	}
+	return actions
+}
+
+// openFileStoreReadOnly opens the db files that another process's
+// (writable) BlockCache maintains, see NewBlockCacheReadOnly. The store is
+// empty until reload is called; it never modifies the files.
+func openFileStoreReadOnly(dbPath string, chainName string) (*fileStore, error) {
+	s := &fileStore{actionsName: ActionsDbFileName(dbPath, chainName), readOnly: true}
+	s.lengthsName, s.blocksName = DbFileNames(dbPath, chainName)
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open (re)opens a read-only store's db files, and empties the store.
+func (s *fileStore) open() error {
+	s.Close()
+	var err error
+	if s.blocksFile, err = os.Open(s.blocksName); err != nil {
+		return err
+	}
+	if s.lengthsFile, err = os.Open(s.lengthsName); err != nil {
+		s.Close()
+		return err
+	}
+	s.openActions()
+	s.header = nil
+	s.starts = []int64{0}
+	s.actions = nil
+	return nil
+}
+
+// openActions opens a read-only store's actions file, if the writer has
+// created it; until then, the action counts aren't known.
+func (s *fileStore) openActions() {
+	if f, err := os.Open(s.actionsName); err == nil {
+		s.actionsFile = f
+	}
+}
+
+// replaced reports whether the named file is no longer the one f has open
+// (the writer replaces the db files when it evicts blocks).
+func replaced(name string, f *os.File) bool {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return true
+	}
+	ffi, err := f.Stat()
+	return err != nil || !os.SameFile(fi, ffi)
+}
+
+// reload updates a read-only store with the entries that the writer has
+// added or (by a reorg) removed. The entries other than the last window
+// entries (of those in both the store and the lengths file) are assumed not
+// to have changed, unless the writer has replaced the files, in which case
+// they're reopened and all the entries are re-read. It returns the number
+// of entries that were kept.
+func (s *fileStore) reload(window int) (int, error) {
+	if replaced(s.blocksName, s.blocksFile) || replaced(s.lengthsName, s.lengthsFile) ||
+		s.actionsFile != nil && replaced(s.actionsName, s.actionsFile) {
+		if err := s.open(); err != nil {
+			return 0, err
+		}
+	}
+	fi, err := s.lengthsFile.Stat()
+	if err != nil {
+		return 0, err
+	}
+	nDisk := int(fi.Size() / 4)
+	keep := max(0, min(s.Len(), nDisk)-window)
+	if keep == 0 {
+		// The writer may have created (or emptied) the db since.
+		s.header = readBlocksHeader(s.blocksFile)
+		s.starts = []int64{int64(len(s.header))}
+	}
+	lengths := make([]byte, 4*(nDisk-keep))
+	if n, err := s.lengthsFile.ReadAt(lengths, int64(4*keep)); err != nil && n != len(lengths) {
+		return 0, err
+	}
+	s.starts = s.starts[:keep+1]
+	offset := s.starts[keep]
+	for i := 0; i < len(lengths); i += 4 {
+		offset += int64(binary.LittleEndian.Uint32(lengths[i:i+4])) + 8
+		s.starts = append(s.starts, offset)
+	}
+	s.actions = s.actions[:min(keep, len(s.actions))]
+	if err := s.reloadActions(); err != nil {
+		return 0, err
+	}
+	return keep, nil
+}
+
+// reloadActions reads the action counts that the writer has added to the
+// actions file, up to the store's last entry.
+func (s *fileStore) reloadActions() error {
+	if s.actionsFile == nil {
+		if s.openActions(); s.actionsFile == nil {
+			return nil
+		}
+	}
+	fi, err := s.actionsFile.Stat()
+	if err != nil {
+		return err
+	}
+	n := min(int(fi.Size()/4), s.Len()) - len(s.actions)
+	if n <= 0 {
+		return nil
+	}
+	b := make([]byte, 4*n)
+	if n, err := s.actionsFile.ReadAt(b, int64(4*len(s.actions))); err != nil && n != len(b) {
+		return err
+	}
+	s.actions = appendActions(s.actions, b)
+	return nil
+}
+
+func (s *fileStore) Len() int {
+	return len(s.starts) - 1
+}
+
+// Get reads the entries with a single read of the blocks file.
+func (s *fileStore) Get(start, end int) ([][]byte, error) {
+	base := s.starts[start]
+	b := make([]byte, s.starts[end]-base)
+	if n, err := s.blocksFile.ReadAt(b, base); n != len(b) {
+		return nil, fmt.Errorf("blocks read offset %d failed: %d %w", base, n, err)
+	}
+	entries := make([][]byte, 0, end-start)
+	for i := start; i < end; i++ {
+		entries = append(entries, b[s.starts[i]-base:s.starts[i+1]-base])
+	}
+	return entries, nil
+}
+
+func (s *fileStore) Info(start, end int) ([]EntryInfo, error) {
+	infos := make([]EntryInfo, 0, end-start)
+	for i := start; i < end; i++ {
+		info := EntryInfo{Size: int(s.starts[i+1] - s.starts[i]), Actions: -1}
+		if i < len(s.actions) {
+			info.Actions = s.actions[i]
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Put writes the entries (and their action counts) before their lengths, so
+// that a crash in between leaves the store without them. The counts are
+// written only if the earlier ones are known, since they're kept in order.
+func (s *fileStore) Put(entries [][]byte, actions []int, sync bool) error {
+	if s.readOnly {
+		return errors.New("store is read-only")
+	}
+	var blocks, lengths, counts []byte
+	for i, entry := range entries {
+		blocks = append(blocks, entry...)
+		lengths = binary.LittleEndian.AppendUint32(lengths, uint32(len(entry)-8))
+		counts = binary.LittleEndian.AppendUint32(counts, uint32(actions[i]))
+	}
+	if _, err := s.blocksFile.Write(blocks); err != nil {
+		return fmt.Errorf("blocks write failed: %w", err)
+	}
+	if sync {
+		if err := s.blocksFile.Sync(); err != nil {
+			return fmt.Errorf("blocks sync failed: %w", err)
+		}
+	}
+	withActions := len(s.actions) == s.Len()
+	if withActions {
+		if _, err := s.actionsFile.Write(counts); err != nil {
+			return fmt.Errorf("actions write failed: %w", err)
+		}
+		if sync {
+			if err := s.actionsFile.Sync(); err != nil {
+				return fmt.Errorf("actions sync failed: %w", err)
+			}
+		}
+	}
+	// The entries are now written; writing their lengths commits them.
+	if _, err := s.lengthsFile.Write(lengths); err != nil {
+		return fmt.Errorf("lengths write failed: %w", err)
+	}
+	if sync {
+		if err := s.lengthsFile.Sync(); err != nil {
+			return fmt.Errorf("lengths sync failed: %w", err)
+		}
+	}
+	for _, entry := range entries {
+		s.starts = append(s.starts, s.starts[len(s.starts)-1]+int64(len(entry)))
+	}
+	if withActions {
+		s.actions = append(s.actions, actions...)
+	}
+	return nil
+}
+
+func (s *fileStore) SetActions(start int, actions []int) error {
+	if s.readOnly {
+		return errors.New("store is read-only")
+	}
+	if start > len(s.actions) || start+len(actions) != s.Len() {
+		return fmt.Errorf("can't set the action counts of entries %d to %d of %d (%d known)",
+			start, start+len(actions), s.Len(), len(s.actions))
+	}
+	var counts []byte
+	for _, n := range actions {
+		counts = binary.LittleEndian.AppendUint32(counts, uint32(n))
+	}
+	if err := s.actionsFile.Truncate(int64(4 * start)); err != nil {
+		return fmt.Errorf("truncate actions file failed: %w", err)
+	}
+	s.actions = s.actions[:start]
+	if _, err := s.actionsFile.Write(counts); err != nil {
+		s.actionsFile.Truncate(int64(4 * start))
+		return fmt.Errorf("actions write failed: %w", err)
+	}
+	s.actions = append(s.actions, actions...)
+	return nil
+}
+
+func (s *fileStore) Delete(start, end int) error {
+	switch {
+	case end == s.Len():
+		return s.truncate(start)
+	case start == 0:
+		return s.deleteFront(end)
+	}
+	return fmt.Errorf("can't delete entries %d to %d of %d", start, end, s.Len())
+}
+
+// truncate removes the entries from index n on (from the db files, even if
+// there are none, so that nothing follows the last entry). A read-only
+// store only forgets them.
+func (s *fileStore) truncate(n int) error {
+	if !s.readOnly {
+		if err := s.lengthsFile.Truncate(int64(4 * n)); err != nil {
+			return fmt.Errorf("truncate lengths file failed: %w", err)
+		}
+		if err := s.blocksFile.Truncate(s.starts[n]); err != nil {
+			return fmt.Errorf("truncate blocks file failed: %w", err)
+		}
+		if err := s.actionsFile.Truncate(int64(4 * min(n, len(s.actions)))); err != nil {
+			return fmt.Errorf("truncate actions file failed: %w", err)
+		}
+	}
+	s.starts = s.starts[:n+1]
+	s.actions = s.actions[:min(n, len(s.actions))]
+	return nil
+}
+
+// deleteFront removes the first n entries by rewriting the db files without
+// them, which needs as much free disk space as the entries that are kept.
+func (s *fileStore) deleteFront(n int) error {
+	if s.readOnly {
+		return errors.New("store is read-only")
+	}
+	offset := s.starts[n]
+	blocksTmp, err := rewriteFront(s.blocksName, s.blocksFile, s.header, offset)
+	if err != nil {
+		return fmt.Errorf("rewriting blocks file: %w", err)
+	}
+	lengthsTmp, err := rewriteFront(s.lengthsName, s.lengthsFile, nil, int64(n*4))
+	if err != nil {
+		discardTemp(blocksTmp)
+		return fmt.Errorf("rewriting lengths file: %w", err)
+	}
+	dropped := min(n, len(s.actions))
+	actionsTmp, err := rewriteFront(s.actionsName, s.actionsFile, nil, int64(dropped*4))
+	if err != nil {
+		discardTemp(blocksTmp)
+		discardTemp(lengthsTmp)
+		return fmt.Errorf("rewriting actions file: %w", err)
+	}
+	if err := replaceFile(s.blocksName, &s.blocksFile, blocksTmp); err != nil {
+		discardTemp(lengthsTmp)
+		discardTemp(actionsTmp)
+		return fmt.Errorf("replacing blocks file: %w", err)
+	}
+	if err := replaceFile(s.lengthsName, &s.lengthsFile, lengthsTmp); err != nil {
+		discardTemp(actionsTmp)
+		// The lengths file no longer matches the (replaced) blocks file;
+		// emptying the store makes them consistent again.
+		if err := s.truncate(0); err != nil {
+			Log.Fatal(err)
+		}
+		return fmt.Errorf("replacing lengths file: %w", err)
+	}
+	starts := make([]int64, 0, len(s.starts)-n)
+	for _, start := range s.starts[n:] {
+		starts = append(starts, start-offset+int64(len(s.header)))
+	}
+	s.starts = starts
+	if err := replaceFile(s.actionsName, &s.actionsFile, actionsTmp); err != nil {
+		// The counts can be recomputed (see CacheStore.SetActions), so
+		// they're forgotten rather than failing.
+		Log.Warning("replacing actions file failed, discarding the action counts: ", err)
+		if err := s.actionsFile.Truncate(0); err != nil {
+			Log.Fatal("truncate actions file failed: ", err)
+		}
+		s.actions = nil
+		return nil
+	}
+	s.actions = s.actions[dropped:]
+	return nil
+}
+
+// rewriteFront writes header followed by the contents of the named file,
+// which f has open, from offset onwards, to a temporary file, returning it
+// open for appending; replaceFile then replaces the named file with it (or
+// discardTemp removes it). Writing the temporary file is the step that can
+// fail for lack of disk space; if it does, it's removed.
+func rewriteFront(name string, f *os.File, header []byte, offset int64) (*os.File, error) {
+	tmp, err := os.OpenFile(name+".tmp", os.O_CREATE|os.O_TRUNC|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tmp.Write(header); err == nil {
+		if _, err = io.Copy(tmp, io.NewSectionReader(f, offset, 1<<62)); err == nil {
+			err = tmp.Sync()
+		}
+	}
+	if err != nil {
+		discardTemp(tmp)
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// replaceFile renames the temporary file tmp (see rewriteFront) to name,
+// and replaces *f, which has the named file open, with it. Renaming needs no
+// disk space.
+func replaceFile(name string, f **os.File, tmp *os.File) error {
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		discardTemp(tmp)
+		return err
+	}
+	(*f).Close()
+	*f = tmp
+	return nil
+}
+
+// discardTemp closes and removes the temporary file tmp.
+func discardTemp(tmp *os.File) {
+	tmp.Close()
+	os.Remove(tmp.Name())
+}
+
+func (s *fileStore) Header() []byte {
+	return s.header
+}
+
+func (s *fileStore) Reset(header []byte) error {
+	if s.readOnly {
+		return errors.New("store is read-only")
+	}
+	if err := s.lengthsFile.Truncate(0); err != nil {
+		return fmt.Errorf("truncate lengths file failed: %w", err)
+	}
+	if err := s.blocksFile.Truncate(0); err != nil {
+		return fmt.Errorf("truncate blocks file failed: %w", err)
+	}
+	if err := s.actionsFile.Truncate(0); err != nil {
+		return fmt.Errorf("truncate actions file failed: %w", err)
+	}
+	if _, err := s.blocksFile.Write(header); err != nil {
+		return fmt.Errorf("blocks header write failed: %w", err)
+	}
+	s.header = header
+	s.starts = []int64{int64(len(header))}
+	s.actions = nil
+	return nil
+}
+
+// Size returns the size of the db files (as they would be without anything
+// beyond the last entry, see Compact).
+func (s *fileStore) Size() int64 {
+	return s.starts[s.Len()] + int64(4*s.Len()) + int64(4*len(s.actions))
+}
+
+// Compact removes any bytes from the db files beyond the last entry. Data
+// can be left there only by a failed write, for example, after the disk
+// filled up.
+func (s *fileStore) Compact() (int64, error) {
+	if s.readOnly {
+		return 0, errors.New("store is read-only")
+	}
+	var reclaimed int64
+	for _, f := range []struct {
+		file *os.File
+		size int64
+	}{
+		{s.lengthsFile, int64(4 * s.Len())},
+		{s.blocksFile, s.starts[s.Len()]},
+		{s.actionsFile, int64(4 * len(s.actions))},
+	} {
+		fi, err := f.file.Stat()
+		if err != nil {
+			return reclaimed, err
+		}
+		if fi.Size() <= f.size {
+			continue
+		}
+		if err := f.file.Truncate(f.size); err != nil {
+			return reclaimed, err
+		}
+		reclaimed += fi.Size() - f.size
+	}
+	if reclaimed > 0 {
+		s.Sync()
+	}
+	return reclaimed, nil
+}
+
+// check verifies that the entries have the given offsets (relative to the
+// first entry), and that the db files have the expected sizes.
+func (s *fileStore) check(starts []int64) error {
+	if len(starts) != len(s.starts) {
+		return fmt.Errorf("store has %d entries, expected %d", s.Len(), len(starts)-1)
+	}
+	if s.starts[0] != int64(len(s.header)) {
+		return fmt.Errorf("first entry offset is %d, expected %d", s.starts[0], len(s.header))
+	}
+	for i, start := range starts {
+		if s.starts[i]-s.starts[0] != start {
+			return fmt.Errorf("entry %d offset is %d, expected %d", i, s.starts[i]-s.starts[0], start)
+		}
+	}
+	if len(s.actions) > s.Len() {
+		return fmt.Errorf("store has %d action counts for %d entries", len(s.actions), s.Len())
+	}
+	if s.readOnly {
+		// (A read-only store's files may have been extended by the writer.)
+		return nil
+	}
+	if fi, err := s.lengthsFile.Stat(); err != nil || fi.Size() != int64(4*s.Len()) {
+		return fmt.Errorf("lengths file size is wrong (%v)", err)
+	}
+	if fi, err := s.blocksFile.Stat(); err != nil || fi.Size() != s.starts[s.Len()] {
+		return fmt.Errorf("blocks file size is wrong (%v)", err)
+	}
+	if fi, err := s.actionsFile.Stat(); err != nil || fi.Size() != int64(4*len(s.actions)) {
+		return fmt.Errorf("actions file size is wrong (%v)", err)
+	}
+	return nil
+}
+
+// saveCorrupted saves copies of the db files for post-mortem analysis.
+func (s *fileStore) saveCorrupted() {
+	if err := copyFile(s.lengthsName, s.lengthsName+"-corrupted"); err != nil {
+		Log.Warning("Could not copy db lengths file: ", err)
+	}
+	if err := copyFile(s.blocksName, s.blocksName+"-corrupted"); err != nil {
+		Log.Warning("Could not copy db blocks file: ", err)
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func (s *fileStore) Sync() error {
+	return errors.Join(s.actionsFile.Sync(), s.lengthsFile.Sync(), s.blocksFile.Sync())
+}
+
+func (s *fileStore) Close() error {
+	// Some operating system require you to close files before you can remove them.
+	var err error
+	if s.lengthsFile != nil {
+		err = s.lengthsFile.Close()
+		s.lengthsFile = nil
+	}
+	if s.blocksFile != nil {
+		err = errors.Join(err, s.blocksFile.Close())
+		s.blocksFile = nil
+	}
+	if s.actionsFile != nil {
+		err = errors.Join(err, s.actionsFile.Close())
+		s.actionsFile = nil
+	}
+	return err
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+//go:build sqlite
+
+package common
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SQLiteDriver is the database/sql driver the "sqlite" cache backend uses.
+// This package doesn't import one; lightwalletd built with -tags sqlite
+// registers github.com/mattn/go-sqlite3.
+var SQLiteDriver = "sqlite3"
+
+func init() {
+	cacheStores["sqlite"] = openSQLiteStore
+}
+
+// sqliteStore is a CacheStore in an SQLite database, blocks.db in the
+// cache's directory. Each entry is a row keyed by a sequence number that
+// increases by one per entry; the first entry's number is first. The row
+// also has the entry's action count (-1 if not known).
+type sqliteStore struct {
+	name   string // pathname of the database
+	db     *sql.DB
+	header []byte
+	first  int64 // sequence number of the entry at index zero
+	n      int   // number of entries
+}
+
+func openSQLiteStore(dbPath string, chainName string) (CacheStore, error) {
+	s := &sqliteStore{name: filepath.Join(dbPath, chainName, "blocks.db")}
+	var err error
+	s.db, err = sql.Open(SQLiteDriver, s.name)
+	if err != nil {
+		return nil, err
+	}
+	// All calls are serialized anyway; one connection keeps the pragmas.
+	s.db.SetMaxOpenConns(1)
+	for _, stmt := range []string{
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA synchronous=NORMAL",
+		"CREATE TABLE IF NOT EXISTS header (id INTEGER PRIMARY KEY CHECK (id = 0), data BLOB NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS entries (seq INTEGER PRIMARY KEY, data BLOB NOT NULL, actions INTEGER NOT NULL DEFAULT -1)",
+	} {
+		if _, err := s.db.Exec(stmt); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	// The entries table of a database created before the action counts
+	// were added doesn't have their column.
+	var hasActions bool
+	err = s.db.QueryRow("SELECT COUNT(*) > 0 FROM pragma_table_info('entries') WHERE name = 'actions'").Scan(&hasActions)
+	if err == nil && !hasActions {
+		_, err = s.db.Exec("ALTER TABLE entries ADD COLUMN actions INTEGER NOT NULL DEFAULT -1")
+	}
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("add actions column failed: %w", err)
+	}
+	err = s.db.QueryRow("SELECT data FROM header WHERE id = 0").Scan(&s.header)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		s.Close()
+		return nil, err
+	}
+	// Entries are only added or removed at the ends, so their sequence
+	// numbers are consecutive. MIN(seq) is NULL if there are none.
+	var first sql.NullInt64
+	err = s.db.QueryRow("SELECT MIN(seq), COUNT(*) FROM entries").Scan(&first, &s.n)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("read entries failed: %w", err)
+	}
+	s.first = first.Int64
+	return s, nil
+}
+
+func (s *sqliteStore) Len() int {
+	return s.n
+}
+
+func (s *sqliteStore) Get(start, end int) ([][]byte, error) {
+	rows, err := s.db.Query("SELECT data FROM entries WHERE seq >= ? AND seq < ? ORDER BY seq",
+		s.first+int64(start), s.first+int64(end))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([][]byte, 0, end-start)
+	for rows.Next() {
+		var entry []byte
+		if err := rows.Scan(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) != end-start {
+		return nil, fmt.Errorf("entries %d to %d: read %d", start, end, len(entries))
+	}
+	return entries, nil
+}
+
+func (s *sqliteStore) Info(start, end int) ([]EntryInfo, error) {
+	rows, err := s.db.Query("SELECT length(data), actions FROM entries WHERE seq >= ? AND seq < ? ORDER BY seq",
+		s.first+int64(start), s.first+int64(end))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	infos := make([]EntryInfo, 0, end-start)
+	for rows.Next() {
+		var info EntryInfo
+		if err := rows.Scan(&info.Size, &info.Actions); err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(infos) != end-start {
+		return nil, fmt.Errorf("entries %d to %d: read %d", start, end, len(infos))
+	}
+	return infos, nil
+}
+
+func (s *sqliteStore) Put(entries [][]byte, actions []int, sync bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, entry := range entries {
+		_, err := tx.Exec("INSERT INTO entries (seq, data, actions) VALUES (?, ?, ?)",
+			s.first+int64(s.n+i), entry, actions[i])
+		if err != nil {
+			return fmt.Errorf("entries insert failed: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("entries commit failed: %w", err)
+	}
+	s.n += len(entries)
+	if sync {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (s *sqliteStore) SetActions(start int, actions []int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, n := range actions {
+		if _, err := tx.Exec("UPDATE entries SET actions = ? WHERE seq = ?", n, s.first+int64(start+i)); err != nil {
+			return fmt.Errorf("entries update failed: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Delete(start, end int) error {
+	if start != 0 && end != s.n {
+		return fmt.Errorf("can't delete entries %d to %d of %d", start, end, s.n)
+	}
+	_, err := s.db.Exec("DELETE FROM entries WHERE seq >= ? AND seq < ?",
+		s.first+int64(start), s.first+int64(end))
+	if err != nil {
+		return err
+	}
+	if start == 0 && end < s.n {
+		s.first += int64(end)
+	}
+	s.n -= end - start
+	return nil
+}
+
+func (s *sqliteStore) Header() []byte {
+	return s.header
+}
+
+func (s *sqliteStore) Reset(header []byte) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM entries"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT OR REPLACE INTO header (id, data) VALUES (0, ?)", header); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.header = header
+	s.first, s.n = 0, 0
+	return nil
+}
+
+func (s *sqliteStore) Size() int64 {
+	var size int64
+	for _, name := range []string{s.name, s.name + "-wal"} {
+		if fi, err := os.Stat(name); err == nil {
+			size += fi.Size()
+		}
+	}
+	return size
+}
+
+func (s *sqliteStore) Compact() (int64, error) {
+	before := s.Size()
+	if _, err := s.db.Exec("VACUUM"); err != nil {
+		return 0, err
+	}
+	if _, err := s.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return 0, err
+	}
+	return max(before-s.Size(), 0), nil
+}
+
+func (s *sqliteStore) Sync() error {
+	// With synchronous=NORMAL, commits reach the database file (and disk)
+	// at a checkpoint.
+	_, err := s.db.Exec("PRAGMA wal_checkpoint(FULL)")
+	return err
+}
+
+func (s *sqliteStore) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// fileStoreOf returns the cache's store, which must be the default one.
+func fileStoreOf(c *BlockCache) *fileStore {
+	return c.store.(*fileStore)
+}
+
+// memStore is a CacheStore that keeps its entries in memory, so that
+// TestCacheStores can run the cache tests with a store other than the
+// default. Reopening a store finds the entries it had.
+type memStore struct {
+	header  []byte
+	entries [][]byte
+	actions []int
+}
+
+var (
+	memStoresMutex sync.Mutex
+	memStores      = make(map[string]*memStore)
+)
+
+func openMemStore(dbPath string, chainName string) (CacheStore, error) {
+	memStoresMutex.Lock()
+	defer memStoresMutex.Unlock()
+	name := filepath.Join(dbPath, chainName)
+	if memStores[name] == nil {
+		memStores[name] = &memStore{}
+	}
+	return memStores[name], nil
+}
+
+func (s *memStore) Len() int {
+	return len(s.entries)
+}
+
+func (s *memStore) Get(start, end int) ([][]byte, error) {
+	return slices.Clone(s.entries[start:end]), nil
+}
+
+func (s *memStore) Info(start, end int) ([]EntryInfo, error) {
+	infos := make([]EntryInfo, 0, end-start)
+	for i := start; i < end; i++ {
+		infos = append(infos, EntryInfo{Size: len(s.entries[i]), Actions: s.actions[i]})
+	}
+	return infos, nil
+}
+
+func (s *memStore) Put(entries [][]byte, actions []int, sync bool) error {
+	for _, entry := range entries {
+		s.entries = append(s.entries, slices.Clone(entry))
+	}
+	s.actions = append(s.actions, actions...)
+	return nil
+}
+
+func (s *memStore) SetActions(start int, actions []int) error {
+	copy(s.actions[start:], actions)
+	return nil
+}
+
+func (s *memStore) Delete(start, end int) error {
+	switch {
+	case end == len(s.entries):
+		s.entries = s.entries[:start]
+		s.actions = s.actions[:start]
+	case start == 0:
+		s.entries = s.entries[end:]
+		s.actions = s.actions[end:]
+	default:
+		return fmt.Errorf("can't delete entries %d to %d of %d", start, end, len(s.entries))
+	}
+	return nil
+}
+
+func (s *memStore) Header() []byte {
+	return s.header
+}
+
+func (s *memStore) Reset(header []byte) error {
+	s.header = header
+	s.entries = nil
+	s.actions = nil
+	return nil
+}
+
+func (s *memStore) Size() int64 {
+	size := int64(len(s.header))
+	for _, entry := range s.entries {
+		size += int64(len(entry))
+	}
+	return size
+}
+
+func (s *memStore) Compact() (int64, error) {
+	return 0, nil
+}
+
+func (s *memStore) Sync() error {
+	return nil
+}
+
+func (s *memStore) Close() error {
+	return nil
+}
+
+// TestCacheStores runs the cache tests that don't depend on the default
+// store's db files with each of the other CacheStore implementations.
+func TestCacheStores(t *testing.T) {
+	cacheStores["memory"] = openMemStore
+	defer delete(cacheStores, "memory")
+	defer func(saved string) { CacheBackend = saved }(CacheBackend)
+	for _, name := range slices.Sorted(maps.Keys(cacheStores)) {
+		if name == fileStoreName {
+			continue
+		}
+		CacheBackend = name
+		t.Run(name, func(t *testing.T) {
+			for _, test := range []struct {
+				name string
+				f    func(*testing.T)
+			}{
+				{"Cache", TestCache},
+				{"ConcurrentAccess", TestCacheConcurrentAccess},
+				{"MaxBlocks", TestCacheMaxBlocks},
+				{"GetRange", TestCacheGetRange},
+				{"ReorgCount", TestCacheReorgCount},
+				{"PruneBefore", TestCachePruneBefore},
+				{"Observer", TestCacheObserver},
+				{"Subscribe", TestCacheSubscribe},
+				{"GetNearest", TestCacheGetNearest},
+				{"GetRelative", TestCacheGetRelative},
+				{"Checkpoint", TestCacheCheckpoint},
+				{"ConfirmationDepth", TestCacheConfirmationDepth},
+				{"Snapshot", TestCacheSnapshot},
+				{"TrimForFreeSpace", TestTrimForFreeSpace},
+			} {
+				t.Run(test.name, test.f)
+			}
+			// Only the default store can be shared.
+			if _, err := NewBlockCacheReadOnly(t.TempDir(), unitTestChain); err == nil {
+				t.Fatal("NewBlockCacheReadOnly unexpected success")
+			}
+		})
+	}
+}
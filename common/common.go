@@ -7,12 +7,17 @@
 package common
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand/v2"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -34,28 +39,145 @@ var (
 	DonationAddress = ""
 )
 
+// ProfileBlockParse, if true, causes the time to parse each block fetched
+// from the backend node to be recorded in a Prometheus histogram, so that
+// operators can identify unusually slow blocks (--profile-block-parse).
+var ProfileBlockParse bool
+
+// MinBlockVersion is the lowest block (header) version that's accepted from
+// the backend node; Orchard requires version 4 or later (--min-block-version).
+var MinBlockVersion int32 = 4
+
+// VerifyBlocks, if true, causes each block fetched from the backend node to
+// be checked (its Equihash solution and merkle root) before it's used. This
+// is CPU intensive, but useful if the backend node isn't fully trusted
+// (--verify-blocks).
+var VerifyBlocks bool
+
+// OrchardActivationHeight is the height of the first block that can have
+// Orchard (shielded) data; the ingestor never caches blocks below it, since
+// there's nothing in them to serve. It's set at startup to the backend
+// node's reported NU5 activation height, unless that's overridden
+// (--orchard-activation-height).
+var OrchardActivationHeight int
+
+// ClampStartHeight returns the given ingestion start height, raised to
+// OrchardActivationHeight (and logged) if it's below it.
+func ClampStartHeight(height int) int {
+	if height < OrchardActivationHeight {
+		Log.Warning("start height ", height, " is below the Orchard activation height ",
+			OrchardActivationHeight, ", starting at ", OrchardActivationHeight)
+		return OrchardActivationHeight
+	}
+	return height
+}
+
+// MaxReorgDepth is the most blocks the ingestor will remove from the cache
+// to follow a reorg (0 means no limit). A deeper reorg most likely means the
+// backend node is on the wrong chain, so instead the ingestor logs an error
+// and waits for the backend to return to the cached chain; to follow the
+// new chain anyway, restart with --sync-from-height (--max-reorg-depth).
+var MaxReorgDepth = 100
+
+// IngestBatchThreshold, if nonzero, causes the ingestor, when it's at least
+// this many blocks behind the backend node, to fetch each window of blocks
+// (see IngestWindow) using two batch RPC requests (see RPCBatchClient)
+// rather than two requests per block; with the default window of 64, that's
+// 2 round trips instead of 128 (--ingest-batch-threshold). If the backend
+// doesn't support batches, blocks are fetched individually.
+var IngestBatchThreshold int
+
+// batchUnsupported is set when the backend node replies to a batch request
+// in a way that shows it doesn't support batches (see ErrBatchUnsupported),
+// so that batches aren't attempted again.
+var batchUnsupported atomic.Bool
+
+// During initial sync (when the backend node is more than one block ahead),
+// the ingestor fetches up to IngestWindow blocks ahead of the cache, using
+// IngestWorkers concurrent requests, then adds them to the cache in order.
+// IngestWorkers <= 1 fetches blocks one at a time (--ingest-workers,
+// --ingest-window).
+var (
+	IngestWorkers = 1
+	IngestWindow  = 64
+)
+
+// IngestTipDistance is how close (in blocks) the ingestor must get to the
+// backend node's latest block to switch from catching up to following the
+// tip, and how many blocks it must add, while following the tip, without
+// catching up to switch back (--ingest-tip-distance); see ingestState.
+var IngestTipDistance = 10
+
+// The ingestor retries failing requests to the backend node (for example,
+// while it's restarting) after a delay that starts at RPCBackoffInitial and
+// doubles with each consecutive failure, up to RPCBackoffMax. Each delay is
+// randomly varied by up to RPCBackoffJitter (a fraction) so that multiple
+// lightwalletd instances don't retry in lockstep (--rpc-backoff-initial,
+// --rpc-backoff-max, --rpc-backoff-jitter).
+var (
+	RPCBackoffInitial = time.Second
+	RPCBackoffMax     = time.Minute
+	RPCBackoffJitter  = 0.2
+)
+
+// RPCTimeout, if nonzero, limits how long the ingestor waits for each
+// request to the backend node; a request that times out is retried with
+// backoff, like one that fails, so a hung node can't stall the ingestor
+// (--rpc-timeout).
+var RPCTimeout time.Duration
+
 type Options struct {
-	GRPCBindAddr        string `json:"grpc_bind_address,omitempty"`
-	GRPCLogging         bool   `json:"grpc_logging_insecure,omitempty"`
-	HTTPBindAddr        string `json:"http_bind_address,omitempty"`
-	TLSCertPath         string `json:"tls_cert_path,omitempty"`
-	TLSKeyPath          string `json:"tls_cert_key,omitempty"`
-	LogLevel            uint64 `json:"log_level,omitempty"`
-	LogFile             string `json:"log_file,omitempty"`
-	ZcashConfPath       string `json:"zcash_conf,omitempty"`
-	RPCUser             string `json:"rpcuser"`
-	RPCPassword         string `json:"rpcpassword"`
-	RPCHost             string `json:"rpchost"`
-	RPCPort             string `json:"rpcport"`
-	NoTLSVeryInsecure   bool   `json:"no_tls_very_insecure,omitempty"`
-	GenCertVeryInsecure bool   `json:"gen_cert_very_insecure,omitempty"`
-	Redownload          bool   `json:"redownload"`
-	NoCache             bool   `json:"nocache"`
-	SyncFromHeight      int    `json:"sync_from_height"`
-	DataDir             string `json:"data_dir"`
-	PingEnable          bool   `json:"ping_enable"`
-	Darkside            bool   `json:"darkside"`
-	DarksideTimeout     uint64 `json:"darkside_timeout"`
+	GRPCBindAddr         string        `json:"grpc_bind_address,omitempty"`
+	GRPCLogging          bool          `json:"grpc_logging_insecure,omitempty"`
+	HTTPBindAddr         string        `json:"http_bind_address,omitempty"`
+	TLSCertPath          string        `json:"tls_cert_path,omitempty"`
+	TLSKeyPath           string        `json:"tls_cert_key,omitempty"`
+	LogLevel             uint64        `json:"log_level,omitempty"`
+	LogFile              string        `json:"log_file,omitempty"`
+	ZcashConfPath        string        `json:"zcash_conf,omitempty"`
+	RPCUser              string        `json:"rpcuser"`
+	RPCPassword          string        `json:"rpcpassword"`
+	RPCHost              string        `json:"rpchost"`
+	RPCPort              string        `json:"rpcport"`
+	NoTLSVeryInsecure    bool          `json:"no_tls_very_insecure,omitempty"`
+	GenCertVeryInsecure  bool          `json:"gen_cert_very_insecure,omitempty"`
+	Redownload           bool          `json:"redownload"`
+	NoCache              bool          `json:"nocache"`
+	SyncFromHeight       int           `json:"sync_from_height"`
+	DataDir              string        `json:"data_dir"`
+	PingEnable           bool          `json:"ping_enable"`
+	Darkside             bool          `json:"darkside"`
+	DarksideTimeout      uint64        `json:"darkside_timeout"`
+	ProfileBlockParse    bool          `json:"profile_block_parse,omitempty"`
+	VerifyBlocks         bool          `json:"verify_blocks,omitempty"`
+	MinBlockVersion      int32         `json:"min_block_version,omitempty"`
+	CacheMaxBlocks       int           `json:"cache_max_blocks,omitempty"`
+	ConfirmationDepth    int           `json:"confirmation_depth,omitempty"`
+	CacheCompress        bool          `json:"cache_compress,omitempty"`
+	CacheBackend         string        `json:"cache_backend,omitempty"`
+	CacheReadOnly        bool          `json:"cache_read_only,omitempty"`
+	ImportSnapshot       string        `json:"import_snapshot,omitempty"`
+	CacheMaxAge          time.Duration `json:"cache_max_age,omitempty"`
+	CacheMinFreeSpace    uint64        `json:"cache_min_free_space,omitempty"`
+	CacheFullBlocks      bool          `json:"cache_full_blocks,omitempty"`
+	CacheSyncEvery       int           `json:"cache_sync_every,omitempty"`
+	CacheWriteBuffer     int           `json:"cache_write_buffer,omitempty"`
+	RPCBackoffInitial    time.Duration `json:"rpc_backoff_initial,omitempty"`
+	RPCBackoffMax        time.Duration `json:"rpc_backoff_max,omitempty"`
+	RPCBackoffJitter     float64       `json:"rpc_backoff_jitter,omitempty"`
+	IngestWorkers        int           `json:"ingest_workers,omitempty"`
+	IngestWindow         int           `json:"ingest_window,omitempty"`
+	IngestTipDistance    int           `json:"ingest_tip_distance,omitempty"`
+	IngestBatchThreshold int           `json:"ingest_batch_threshold,omitempty"`
+	MaxReorgDepth        int           `json:"max_reorg_depth,omitempty"`
+	HealthMaxLag         int           `json:"health_max_lag,omitempty"`
+	OrchardHeight        int           `json:"orchard_activation_height,omitempty"`
+	ZMQEndpoint          string        `json:"zmq_endpoint,omitempty"`
+	RPCTimeout           time.Duration `json:"rpc_timeout,omitempty"`
+	MaxMissFetches       int           `json:"max_miss_fetches,omitempty"`
+	MaxBlockRange        int           `json:"max_block_range,omitempty"`
+	GetTxMempool         bool          `json:"get_transaction_mempool,omitempty"`
+	RPCFallbacks         []string      `json:"rpc_fallbacks,omitempty"`
 }
 
 // RawRequest points to the function to send an RPC request to zcashd;
@@ -63,6 +185,24 @@ type Options struct {
 // in unit tests it points to a function to mock RPCs to zcashd.
 var RawRequest func(method string, params []json.RawMessage) (json.RawMessage, error)
 
+// RPCRequest is one of the requests sent together by an RPCBatchClient.
+type RPCRequest struct {
+	Method string
+	Params []json.RawMessage
+}
+
+// RPCResult is the reply to one of the requests sent by an RPCBatchClient;
+// Result and Err are as returned by RawRequest.
+type RPCResult struct {
+	Result json.RawMessage
+	Err    error
+}
+
+// ErrBatchUnsupported is returned (wrapped) by an RPCBatchClient if the
+// backend node doesn't support batch requests, as opposed to failing to
+// reply.
+var ErrBatchUnsupported = errors.New("batch requests are not supported")
+
 // Time allows time-related functions to be mocked for testing,
 // so that tests can be deterministic and so they don't require
 // real time to elapse. In production, these point to the standard
@@ -94,6 +234,7 @@ type (
 		Chain           string
 		Upgrades        map[string]Upgradeinfo
 		Blocks          int
+		PruneHeight     int // lowest block stored, only if pruned
 		BestBlockHash   string
 		Consensus       ConsensusInfo
 		EstimatedHeight int
@@ -162,9 +303,10 @@ type (
 
 	// reply to getblock verbose=1 (json includes txid list)
 	ZcashRpcReplyGetblock1 struct {
-		Hash  string
-		Tx    []string
-		Trees struct {
+		Hash   string
+		Height int
+		Tx     []string
+		Trees  struct {
 			Sapling struct {
 				Size uint32
 			}
@@ -245,7 +387,12 @@ func FirstRPC() {
 }
 
 func GetBlockChainInfo() (*ZcashdRpcReplyGetblockchaininfo, error) {
-	result, rpcErr := RawRequest("getblockchaininfo", []json.RawMessage{})
+	return getBlockChainInfo(RPCFunc(RawRequest))
+}
+
+// getBlockChainInfo is GetBlockChainInfo using the given client.
+func getBlockChainInfo(client RPCClient) (*ZcashdRpcReplyGetblockchaininfo, error) {
+	result, rpcErr := client.RawRequest("getblockchaininfo", []json.RawMessage{})
 	if rpcErr != nil {
 		return nil, rpcErr
 	}
@@ -254,9 +401,44 @@ func GetBlockChainInfo() (*ZcashdRpcReplyGetblockchaininfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	backendHeight.Store(int64(getblockchaininfoReply.Blocks))
 	return &getblockchaininfoReply, nil
 }
 
+// HealthMaxLag is the most blocks the cache can be behind the backend node
+// for Healthz to report lightwalletd as healthy (--health-max-lag).
+var HealthMaxLag = 10
+
+// HealthStatus describes lightwalletd's sync state, as of a Healthz call.
+type HealthStatus struct {
+	Height       int `json:"height"`        // the backend node's latest block
+	CachedHeight int `json:"cached_height"` // the cache's latest block
+	Lag          int `json:"lag"`           // blocks the cache is behind
+}
+
+// Healthz checks that the backend node is reachable and that the cache, c,
+// is synced with it (within HealthMaxLag blocks). The status is returned
+// unless the backend node couldn't be reached; the error is nil only if
+// healthy. If c is nil (there's no cache), only reachability is checked.
+func Healthz(c *BlockCache) (*HealthStatus, error) {
+	info, err := GetBlockChainInfo()
+	if err != nil {
+		return nil, fmt.Errorf("%s unreachable: %w", NodeName, err)
+	}
+	status := &HealthStatus{Height: info.Blocks, CachedHeight: info.Blocks}
+	if c == nil {
+		return status, nil
+	}
+	// This includes blocks hidden by the confirmation depth; they're synced.
+	status.CachedHeight = c.GetNextHeight() - 1
+	status.Lag = max(status.Height-status.CachedHeight, 0)
+	if status.Lag > HealthMaxLag {
+		return status, fmt.Errorf("cache is %d blocks behind %s (height %d)",
+			status.Lag, NodeName, status.Height)
+	}
+	return status, nil
+}
+
 func GetLightdInfo() (*walletrpc.LightdInfo, error) {
 	result, rpcErr := RawRequest("getinfo", []json.RawMessage{})
 	if rpcErr != nil {
@@ -317,7 +499,212 @@ func GetLightdInfo() (*walletrpc.LightdInfo, error) {
 	}, nil
 }
 
+// GetGenesisHash returns the hash of the node's genesis block (height 0),
+// in internal byte order, for GenesisHash.
+func GetGenesisHash() (hash32.T, error) {
+	result, rpcErr := RawRequest("getblockhash", []json.RawMessage{json.RawMessage("0")})
+	if rpcErr != nil {
+		return hash32.Nil, rpcErr
+	}
+	var hashHex string
+	if err := json.Unmarshal(result, &hashHex); err != nil {
+		return hash32.Nil, err
+	}
+	return hash32.FromHexReversed(hashHex)
+}
+
+// getBlockFromRPC fetches the block at the given height using RawRequest,
+// as the frontend's requests do.
 func getBlockFromRPC(height int) (*walletrpc.CompactBlock, error) {
+	block, _, err := getFullBlockFromRPC(context.Background(), RPCFunc(RawRequest), height)
+	return block, err
+}
+
+// rawRequestContext sends a request using client, but returns ctx.Err() as
+// soon as ctx is done, and times out after RPCTimeout. The request is
+// cancelled if client is an RPCContextClient; otherwise its result is
+// discarded.
+func rawRequestContext(ctx context.Context, client RPCClient, method string, params []json.RawMessage) (json.RawMessage, error) {
+	if RPCTimeout > 0 {
+		parent := ctx
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, RPCTimeout)
+		defer cancel()
+		result, err := rawRequestCancellable(ctx, client, method, params)
+		if err != nil && parent.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("%s rpc timed out after %v: %w", method, RPCTimeout, context.DeadlineExceeded)
+		}
+		return result, err
+	}
+	return rawRequestCancellable(ctx, client, method, params)
+}
+
+// rawBatchRequestContext sends the requests using client's RawBatchRequest,
+// timing out after RPCTimeout like rawRequestContext.
+func rawBatchRequestContext(ctx context.Context, client RPCBatchClient, requests []RPCRequest) ([]RPCResult, error) {
+	if RPCTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, RPCTimeout)
+		defer cancel()
+	}
+	return client.RawBatchRequest(ctx, requests)
+}
+
+// rawRequestCancellable is rawRequestContext without the timeout.
+func rawRequestCancellable(ctx context.Context, client RPCClient, method string, params []json.RawMessage) (json.RawMessage, error) {
+	if c, ok := client.(RPCContextClient); ok {
+		return c.RawRequestContext(ctx, method, params)
+	}
+	if ctx.Done() == nil {
+		return client.RawRequest(method, params)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type reply struct {
+		result json.RawMessage
+		err    error
+	}
+	ch := make(chan reply, 1)
+	go func() {
+		result, err := client.RawRequest(method, params)
+		ch <- reply{result, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.result, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// sleepContext is like Time.Sleep, but returns early if ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) {
+	if ctx.Done() == nil {
+		Time.Sleep(d)
+		return
+	}
+	sleep := Time.Sleep
+	done := make(chan struct{})
+	go func() {
+		sleep(d)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// backoffRand returns a pseudo-random number in [0, 1); tests replace it.
+var backoffRand = rand.Float64
+
+// backoffDelay returns the time to wait before retrying a request to the
+// backend node after the given number (1 or more) of consecutive failures.
+func backoffDelay(failures int) time.Duration {
+	d := RPCBackoffInitial
+	for i := 1; i < failures && d < RPCBackoffMax; i++ {
+		d *= 2
+	}
+	d = min(d, RPCBackoffMax)
+	d = time.Duration(float64(d) * (1 + RPCBackoffJitter*(2*backoffRand()-1)))
+	return max(min(d, RPCBackoffMax), 0)
+}
+
+// ErrBlockPruned means that the backend node can't return a block because
+// it's a pruned node, and the block is below its pruning horizon.
+var ErrBlockPruned = errors.New("block not available (pruned)")
+
+// isPrunedError returns whether err is zcashd's getblock error for a block
+// that has been pruned ("Block not available (pruned data)").
+func isPrunedError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "block not available") || strings.Contains(msg, "pruned")
+}
+
+// lowestServableHeight is the lowest height the backend node can serve, as
+// discovered when it failed to serve a (pruned) block; 0 if unknown.
+var lowestServableHeight atomic.Int64
+
+// LowestServableHeight returns the lowest height that the backend node, if
+// it's a pruned node, has been found to be able to serve, or 0 if it hasn't
+// been found to be pruned.
+func LowestServableHeight() int {
+	return int(lowestServableHeight.Load())
+}
+
+// findLowestServable returns the lowest height above the given (pruned)
+// height that the backend node can serve: its reported pruning height, if
+// any, else found by binary search up to its latest block.
+func findLowestServable(ctx context.Context, client RPCClient, pruned int) (int, error) {
+	info, err := getBlockChainInfo(client)
+	if err != nil {
+		return 0, err
+	}
+	if info.PruneHeight > pruned {
+		return info.PruneHeight, nil
+	}
+	// Heights below low can't be served; high can be (or is past the tip).
+	low, high := pruned+1, info.Blocks+1
+	for low < high {
+		mid := low + (high-low)/2
+		_, _, err := getFullBlockFromRPC(ctx, client, mid)
+		switch {
+		case errors.Is(err, ErrBlockPruned):
+			low = mid + 1
+		case err != nil:
+			return 0, err
+		default:
+			high = mid
+		}
+	}
+	return low, nil
+}
+
+// ingestPruned is called by the ingestor when the backend node can't serve
+// the block at the given height, the cache's next height, because it's
+// pruned. If the cache is empty, it's moved up to start at the lowest block
+// the backend can serve; otherwise the cache can't be extended (that would
+// leave a gap), so ingestPruned logs an error and returns true to stop the
+// ingestor.
+func ingestPruned(ctx context.Context, client RPCClient, c *BlockCache, height int) (bool, error) {
+	lowest, err := findLowestServable(ctx, client, height)
+	if err != nil {
+		return false, err
+	}
+	lowestServableHeight.Store(int64(lowest))
+	if c.GetFirstHeight() != height {
+		Log.Error(NodeName, " is pruned below height ", lowest, " so it can't serve block ", height,
+			"; stopping block ingestion (use an unpruned node, or restart with --redownload)")
+		return true, nil
+	}
+	Log.Warning("Skipping blocks ", height, " to ", lowest-1, ", which ", NodeName, " has pruned")
+	c.DropBefore(lowest)
+	return false, nil
+}
+
+// FetchBlock fetches the block at the given height from the backend node
+// and parses it, exactly as the block ingestor does, returning both the
+// parsed (full) block and its compact form. If the backend node doesn't
+// have a block at this height yet, it returns nil blocks and no error. An
+// error from a block that can't be parsed (including one with unsupported
+// Sapling or Sprout elements) wraps ErrBlockParse; see ClassifyIngestError.
+func FetchBlock(height int) (*parser.Block, *walletrpc.CompactBlock, error) {
+	block, compact, _, err := fetchBlock(context.Background(), RPCFunc(RawRequest), height)
+	return block, compact, err
+}
+
+// getFullBlockFromRPC is like getBlockFromRPC, using client, but also
+// returns the full (raw) block data; the requests are abandoned if ctx is
+// done.
+func getFullBlockFromRPC(ctx context.Context, client RPCClient, height int) (*walletrpc.CompactBlock, []byte, error) {
+	_, compact, data, err := fetchBlock(ctx, client, height)
+	return compact, data, err
+}
+
+// fetchBlock is FetchBlock, using client, that also returns the raw block
+// data; the requests are abandoned if ctx is done.
+func fetchBlock(ctx context.Context, client RPCClient, height int) (*parser.Block, *walletrpc.CompactBlock, []byte, error) {
 	// `block.ParseFromSlice` correctly parses blocks containing v5
 	// transactions, but incorrectly computes the IDs of the v5 transactions.
 	// We temporarily paper over this bug by fetching the correct txids via a
@@ -338,106 +725,291 @@ func getBlockFromRPC(height int) (*walletrpc.CompactBlock, error) {
 	// by height in case a reorg occurs between the two getblock calls;
 	// using block hash ensures that we're fetching the same block.
 	params := []json.RawMessage{heightJSON, json.RawMessage("1")}
-	result, rpcErr := RawRequest("getblock", params)
+	result, rpcErr := rawRequestContext(ctx, client, "getblock", params)
 	if rpcErr != nil {
 		// Check to see if we are requesting a height the zcashd doesn't have yet
 		if (strings.Split(rpcErr.Error(), ":"))[0] == "-8" {
-			return nil, nil
+			return nil, nil, nil, nil
 		}
-		return nil, fmt.Errorf("error requesting verbose block: %w", rpcErr)
+		if isPrunedError(rpcErr) {
+			return nil, nil, nil, fmt.Errorf("block %d: %w (%v)", height, ErrBlockPruned, rpcErr)
+		}
+		return nil, nil, nil, fmt.Errorf("error requesting verbose block: %w", rpcErr)
 	}
 	var block1 ZcashRpcReplyGetblock1
 	err = json.Unmarshal(result, &block1)
 	if err != nil {
 		Log.Fatal("getBlockFromRPC: Can't unmarshal block:", err)
 	}
+	return fetchRawBlock(ctx, client, height, &block1)
+}
+
+// fetchRawBlock fetches (by hash, using client) and parses the block that
+// block1, a verbose getblock reply, describes.
+func fetchRawBlock(ctx context.Context, client RPCClient, height int, block1 *ZcashRpcReplyGetblock1) (*parser.Block, *walletrpc.CompactBlock, []byte, error) {
 	blockHash, err := json.Marshal(block1.Hash)
 	if err != nil {
 		Log.Fatal("getBlockFromRPC bad block hash", block1.Hash)
 	}
 	// non-verbose (raw hex) version of block
-	params = []json.RawMessage{blockHash, json.RawMessage("0")}
-	result, rpcErr = RawRequest("getblock", params)
+	params := []json.RawMessage{blockHash, json.RawMessage("0")}
+	result, rpcErr := rawRequestContext(ctx, client, "getblock", params)
 
 	// For some reason, the error responses are not JSON
 	if rpcErr != nil {
-		return nil, fmt.Errorf("error requesting block: %w", rpcErr)
+		if isPrunedError(rpcErr) {
+			return nil, nil, nil, fmt.Errorf("block %d: %w (%v)", height, ErrBlockPruned, rpcErr)
+		}
+		return nil, nil, nil, fmt.Errorf("error requesting block: %w", rpcErr)
 	}
+	block, compact, blockData, err := parseBlockFromRPC(height, block1, result)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %w", ErrBlockParse, err)
+	}
+	return block, compact, blockData, nil
+}
 
+// GetBlockBatch returns the compact blocks, and full block data, at the
+// given heights, using two batch requests (see RPCBatchClient); if client
+// can't send batches, the error is ErrBatchUnsupported. Like fetchBlocks,
+// it returns the blocks up to the first one that's unavailable; the error
+// is returned only if the batch requests themselves failed.
+func GetBlockBatch(ctx context.Context, client RPCClient, heights []int) ([]*walletrpc.CompactBlock, [][]byte, error) {
+	batchClient, ok := client.(RPCBatchClient)
+	if !ok {
+		return nil, nil, ErrBatchUnsupported
+	}
+	requests := make([]RPCRequest, len(heights))
+	for i, height := range heights {
+		heightJSON, err := json.Marshal(strconv.Itoa(height))
+		if err != nil {
+			Log.Fatal("GetBlockBatch bad height argument", height, err)
+		}
+		requests[i] = RPCRequest{"getblock", []json.RawMessage{heightJSON, json.RawMessage("1")}}
+	}
+	results, err := rawBatchRequestContext(ctx, batchClient, requests)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(results) != len(requests) {
+		return nil, nil, fmt.Errorf("batch getblock returned %d replies, expected %d", len(results), len(requests))
+	}
+	// Request the raw blocks by hash, as getFullBlockFromRPC does.
+	blocks1 := make([]ZcashRpcReplyGetblock1, 0, len(heights))
+	requests = requests[:0]
+	for i, r := range results {
+		var block1 ZcashRpcReplyGetblock1
+		if r.Err != nil || json.Unmarshal(r.Result, &block1) != nil {
+			if r.Err != nil && (strings.Split(r.Err.Error(), ":"))[0] != "-8" {
+				Log.Info("getblock ", heights[i], " failed: ", r.Err)
+			}
+			break
+		}
+		blockHash, err := json.Marshal(block1.Hash)
+		if err != nil {
+			Log.Fatal("GetBlockBatch bad block hash", block1.Hash)
+		}
+		blocks1 = append(blocks1, block1)
+		requests = append(requests, RPCRequest{"getblock", []json.RawMessage{blockHash, json.RawMessage("0")}})
+	}
+	if len(requests) == 0 {
+		return nil, nil, nil
+	}
+	results, err = rawBatchRequestContext(ctx, batchClient, requests)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(results) != len(requests) {
+		return nil, nil, fmt.Errorf("batch getblock returned %d replies, expected %d", len(results), len(requests))
+	}
+	var blocks []*walletrpc.CompactBlock
+	var data [][]byte
+	for i, r := range results {
+		if r.Err != nil {
+			logIngestError(heights[i], r.Err, "getblock failed")
+			break
+		}
+		_, block, blockData, err := parseBlockFromRPC(heights[i], &blocks1[i], r.Result)
+		if err != nil {
+			logIngestError(heights[i], fmt.Errorf("%w: %w", ErrBlockParse, err), "getblock failed")
+			break
+		}
+		blocks = append(blocks, block)
+		data = append(data, blockData)
+	}
+	return blocks, data, nil
+}
+
+// parseBlockFromRPC returns the parsed block, the compact block, and the
+// full block data given the replies to the verbose and raw getblock RPCs for
+// the given height.
+func parseBlockFromRPC(height int, block1 *ZcashRpcReplyGetblock1, result json.RawMessage) (*parser.Block, *walletrpc.CompactBlock, []byte, error) {
 	var blockDataHex string
-	err = json.Unmarshal(result, &blockDataHex)
+	err := json.Unmarshal(result, &blockDataHex)
 	if err != nil {
-		return nil, fmt.Errorf("error reading JSON response: %w", err)
+		return nil, nil, nil, fmt.Errorf("error reading JSON response: %w", err)
 	}
 
 	blockData, err := hex.DecodeString(blockDataHex)
 	if err != nil {
-		return nil, fmt.Errorf("error decoding getblock output: %w", err)
+		return nil, nil, nil, fmt.Errorf("error decoding getblock output: %w", err)
 	}
 
 	block := parser.NewBlock()
+	var parseStart time.Time
+	if ProfileBlockParse {
+		parseStart = Time.Now()
+	}
 	rest, err := block.ParseFromSlice(blockData)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing block: %w", err)
+		if errors.Is(err, parser.ErrUnsupportedSapling) {
+			unsupportedBlocksTotal.WithLabelValues("sapling").Inc()
+		} else if errors.Is(err, parser.ErrUnsupportedSprout) {
+			unsupportedBlocksTotal.WithLabelValues("sprout").Inc()
+		}
+		return nil, nil, nil, fmt.Errorf("error parsing block: %w", err)
+	}
+	if ProfileBlockParse {
+		blockParseSeconds.Observe(Time.Now().Sub(parseStart).Seconds())
 	}
 	if len(rest) != 0 {
-		return nil, errors.New("received overlong message")
+		return nil, nil, nil, errors.New("received overlong message")
+	}
+	// The hash was used to request this block, so a mismatch means the
+	// reply was corrupted (or tampered with) in transit.
+	if hash := hash32.Encode(block.ComputeHash()); hash != block1.Hash {
+		return nil, nil, nil, fmt.Errorf("block %d hash %s doesn't match %s's hash %s",
+			height, hash, NodeName, block1.Hash)
+	}
+	if block.Version() < MinBlockVersion {
+		return nil, nil, nil, fmt.Errorf("block %d version %d is below the minimum supported version %d",
+			height, block.Version(), MinBlockVersion)
+	}
+	if VerifyBlocks {
+		if err := block.VerifyEquihash(parser.EquihashN, parser.EquihashK); err != nil {
+			return nil, nil, nil, fmt.Errorf("block %d failed verification: %w", height, err)
+		}
 	}
-	if block.GetHeight() != height {
-		return nil, errors.New("received unexpected height block")
+	coinbaseHeight, err := block.CoinbaseHeight()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error reading block height: %w", err)
+	}
+	if coinbaseHeight != height {
+		return nil, nil, nil, fmt.Errorf("received unexpected height block (%d, expected %d)", coinbaseHeight, height)
 	}
 	for i, t := range block.Transactions() {
 		txidBigEndian, err := hash32.Decode(block1.Tx[i])
 		if err != nil {
-			return nil, fmt.Errorf("error decoding getblock txid: %w", err)
+			return nil, nil, nil, fmt.Errorf("error decoding getblock txid: %w", err)
 		}
 		// convert from big-endian
 		t.SetTxID(hash32.Reverse(txidBigEndian))
 	}
+	if VerifyBlocks {
+		if err := block.VerifyMerkleRoot(); err != nil {
+			return nil, nil, nil, fmt.Errorf("block %d failed verification: %w", height, err)
+		}
+	}
 	r := block.ToCompact()
 	r.ChainMetadata.SaplingCommitmentTreeSize = 0 // Juno Cash: Sapling not supported
 	r.ChainMetadata.OrchardCommitmentTreeSize = block1.Trees.Orchard.Size
-	return r, nil
+	return block, r, blockData, nil
 }
 
+// The (darkside) ingestor started by startIngestor; ingestorCancel is nil
+// if it isn't running.
 var (
-	ingestorRunning  bool
-	stopIngestorChan = make(chan struct{})
+	ingestorCancel context.CancelFunc
+	ingestorDone   chan struct{}
 )
 
 func startIngestor(c *BlockCache) {
-	if !ingestorRunning {
-		ingestorRunning = true
-		go BlockIngestor(c, 0)
+	if ingestorCancel == nil {
+		var ctx context.Context
+		ctx, ingestorCancel = context.WithCancel(context.Background())
+		ingestorDone = make(chan struct{})
+		go func() {
+			BlockIngestorContext(ctx, RPCFunc(darksideRawRequest), c, 0)
+			close(ingestorDone)
+		}()
 	}
 }
+
+// stopIngestor stops the ingestor and waits for it to return.
 func stopIngestor() {
-	if ingestorRunning {
-		ingestorRunning = false
-		stopIngestorChan <- struct{}{}
+	if ingestorCancel != nil {
+		ingestorCancel()
+		<-ingestorDone
+		ingestorCancel = nil
 	}
 }
 
-// BlockIngestor runs as a goroutine and polls zcashd for new blocks, adding them
-// to the cache. The repetition count, rep, is nonzero only for unit-testing.
-func BlockIngestor(c *BlockCache, rep int) {
+// BlockIngestor runs as a goroutine and polls zcashd, using client, for new
+// blocks, adding them to the cache. The repetition count, rep, is nonzero
+// only for unit-testing.
+func BlockIngestor(client RPCClient, c *BlockCache, rep int) {
+	BlockIngestorContext(context.Background(), client, c, rep)
+}
+
+// BlockIngestorContext is BlockIngestor that returns when ctx is done. A
+// block that's being added to the cache is added completely, and buffered
+// blocks are written, so the cache is left consistent. It also returns if
+// the backend node can't serve the blocks needed to extend the cache (see
+// ingestPruned).
+func BlockIngestorContext(ctx context.Context, client RPCClient, c *BlockCache, rep int) {
 	lastLog := Time.Now()
 	lastHeightLogged := 0
+	reorgDepth := 0 // blocks removed by the reorg in progress
+	failures := 0   // consecutive failed requests to the backend node
+	reorgAborted := false
+	var lastPrune time.Time
+	rate := ingestRate{start: Time.Now()}
+	ingestedHeight.Store(int64(c.GetNextHeight() - 1))
+	state := ingestBulk
+	sinceSynced := 0 // blocks added since the cache was last synced
+	enterIngestState(c, state)
+	defer c.SetSyncPolicy(CacheSyncPolicy)
+	setState := func(s ingestState) {
+		if s == state {
+			return
+		}
+		state = s
+		enterIngestState(c, s)
+		if s == ingestTip {
+			Log.Info("Following the tip of the chain at height ", c.GetNextHeight()-1)
+		} else {
+			Log.Info("Catching up with ", NodeName, " from height ", c.GetNextHeight()-1)
+		}
+	}
+
+	// Never cache blocks below the Orchard activation height.
+	if first := c.GetFirstHeight(); first < OrchardActivationHeight {
+		if n := c.DropBefore(ClampStartHeight(first)); n > 0 {
+			Log.Info("Removed ", n, " pre-Orchard blocks from cache")
+		}
+	}
 
 	// Start listening for new blocks
 	for i := 0; rep == 0 || i < rep; i++ {
 		// stop if requested
-		select {
-		case <-stopIngestorChan:
-			return
-		default:
+		if ctx.Err() != nil {
+			break
 		}
 
-		result, err := RawRequest("getbestblockhash", []json.RawMessage{})
+		result, err := rawRequestContext(ctx, client, "getbestblockhash", []json.RawMessage{})
+		if ctx.Err() != nil {
+			break
+		}
 		if err != nil {
+			failures++
+			delay := backoffDelay(failures)
 			Log.WithFields(logrus.Fields{
-				"error": err,
-			}).Fatal("error " + NodeName + " getbestblockhash rpc")
+				"error":    err,
+				"failures": failures,
+				"retry_in": delay,
+			}).Warning("error " + NodeName + " getbestblockhash rpc, will retry")
+			sleepContext(ctx, delay)
+			continue
 		}
 		var hashHex string
 		err = json.Unmarshal(result, &hashHex)
@@ -452,48 +1024,357 @@ func BlockIngestor(c *BlockCache, rep int) {
 		height := c.GetNextHeight()
 		if lastBestBlockHashBE == hash32.Reverse(c.GetLatestHash()) {
 			// Synced
-			c.Sync()
+			failures = resumeIngest(c, failures)
+			rate.synced(c)
+			sinceSynced = 0
+			setState(ingestTip)
+			c.Flush()
 			if lastHeightLogged != height-1 {
 				lastHeightLogged = height - 1
 				Log.Info("Waiting for block: ", height)
+				if err := c.CheckInvariants(); err != nil {
+					Log.Warning("cache inconsistent: ", err)
+				}
+			}
+			// Pruning rewrites the db files, so don't do it often.
+			if CacheMaxAge > 0 && Time.Now().Sub(lastPrune) >= time.Hour {
+				lastPrune = Time.Now()
+				if n := c.PruneBefore(Time.Now().Add(-CacheMaxAge).Unix()); n > 0 {
+					Log.Info("Pruned ", n, " blocks older than ", CacheMaxAge, " from cache")
+				}
 			}
-			Time.Sleep(2 * time.Second)
+			waitForBlock(ctx, 2*time.Second)
 			lastLog = Time.Now()
 			continue
 		}
+		if state == ingestBulk && (IngestWorkers > 1 || IngestBatchThreshold > 0) && IngestWindow > 1 {
+			if n := ingestWindow(ctx, client, c, height); n > 0 {
+				failures = resumeIngest(c, failures)
+				rate.added(c, n)
+				sinceSynced += n
+				if int(backendHeight.Load())-(c.GetNextHeight()-1) <= IngestTipDistance {
+					setState(ingestTip)
+				}
+				if reorgDepth > 0 {
+					logReorg(height, reorgDepth)
+					reorgDepth = 0
+					reorgAborted = false
+				}
+				if DarksideEnabled || Time.Now().Sub(lastLog).Seconds() >= 4 {
+					lastLog = Time.Now()
+					Log.Info("Adding blocks to cache ", height, "-", height+n-1, " ",
+						c.GetLatestHash().Short())
+				}
+				continue
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		var parsed *parser.Block
 		var block *walletrpc.CompactBlock
-		block, err = getBlockFromRPC(height)
-		if err != nil {
-			Log.Info("getblock ", height, " failed, will retry: ", err)
-			Time.Sleep(8 * time.Second)
-			continue
+		var blockData []byte
+		parsed, block, blockData, err = fetchBlock(ctx, client, height)
+		if ctx.Err() != nil {
+			break
 		}
-		if block != nil && c.HashMatch(hash32.T(block.PrevHash)) {
-			if err = c.Add(height, block); err != nil {
-				Log.Fatal("Cache add failed:", err)
+		if errors.Is(err, ErrBlockPruned) {
+			var stop bool
+			stop, err = ingestPruned(ctx, client, c, height)
+			if stop || ctx.Err() != nil {
+				break
 			}
-			// Don't log these too often.
-			if DarksideEnabled || Time.Now().Sub(lastLog).Seconds() >= 4 {
-				lastLog = Time.Now()
-				Log.Info("Adding block to cache ", height, " ", displayHash(hash32.T(block.Hash)))
+			if err == nil {
+				continue
 			}
+		}
+		if err != nil {
+			failures++
+			delay := backoffDelay(failures)
+			logIngestError(height, err, fmt.Sprint("getblock failed, will retry in ", delay))
+			sleepContext(ctx, delay)
 			continue
 		}
+		failures = resumeIngest(c, failures)
+		if block != nil {
+			// Only cache the block if it links to the latest cached block;
+			// otherwise the chain we have cached has been reorged away.
+			if c.HashMatch(hash32.T(block.PrevHash)) {
+				if err = c.AddFull(height, block, blockData); err != nil {
+					failures++
+					delay := backoffDelay(failures)
+					logIngestError(height, fmt.Errorf("%w: %w", ErrCacheWrite, err),
+						fmt.Sprint("cache add failed, will retry in ", delay))
+					sleepContext(ctx, delay)
+					continue
+				}
+				rate.added(c, 1)
+				sinceSynced++
+				if state == ingestTip && sinceSynced > IngestTipDistance {
+					setState(ingestBulk)
+				}
+				if reorgDepth > 0 {
+					logReorg(height, reorgDepth)
+					reorgDepth = 0
+					reorgAborted = false
+				}
+				// Don't log these too often.
+				if DarksideEnabled || Time.Now().Sub(lastLog).Seconds() >= 4 {
+					lastLog = Time.Now()
+					Log.Info("Adding block to cache ", height, " ", hash32.T(block.Hash).Short(),
+						" actions ", parsed.CompactActionCount())
+				}
+				continue
+			}
+			Log.Info("Skipping block ", height, " ", hash32.T(block.Hash).Short(),
+				" actions ", parsed.CompactActionCount(), ": prevhash ", hash32.T(block.PrevHash).Short(),
+				" doesn't link to cached block ", c.GetLatestHash().Short())
+		}
 		if height == c.GetFirstHeight() {
-			c.Sync()
+			c.Flush()
 			Log.Info("Waiting for "+NodeName+" height to reach Orchard activation height ",
 				"(", c.GetFirstHeight(), ")...")
-			Time.Sleep(120 * time.Second)
+			sleepContext(ctx, 120*time.Second)
+			continue
+		}
+		if MaxReorgDepth > 0 && reorgDepth >= MaxReorgDepth {
+			if !reorgAborted {
+				reorgAborted = true
+				reorgsAbortedTotal.Inc()
+				Log.Error("REORG: not following a reorg deeper than ", MaxReorgDepth,
+					" blocks below height ", height, "; waiting for ", NodeName,
+					" to return to the cached chain (or restart with --sync-from-height)")
+			}
+			failures++
+			sleepContext(ctx, backoffDelay(failures))
 			continue
 		}
-		Log.Info("REORG: dropping block ", height-1, " ", displayHash(c.GetLatestHash()))
-		c.Reorg(height - 1)
+		Log.Info("REORG: dropping block ", height-1, " ", c.GetLatestHash().Short())
+		removed, _ := c.Reorg(height - 1)
+		reorgDepth += removed
+		rate.added(c, 0)
+	}
+	c.Flush()
+}
+
+// ingestWindow fetches the blocks from the given height (the cache's next
+// height) up to the backend node's latest block, or IngestWindow blocks,
+// concurrently or in a batch, and adds the ones that arrived and link (to the cache and
+// to each other) to the cache. It returns the number of blocks added; if
+// it's zero, the caller falls back to fetching a single block (which handles
+// errors, reorgs and the end of the chain).
+func ingestWindow(ctx context.Context, client RPCClient, c *BlockCache, height int) int {
+	result, err := rawRequestContext(ctx, client, "getblockchaininfo", []json.RawMessage{})
+	if err != nil {
+		return 0
+	}
+	var info ZcashdRpcReplyGetblockchaininfo
+	if err := json.Unmarshal(result, &info); err != nil {
+		return 0
+	}
+	backendHeight.Store(int64(info.Blocks))
+	count := min(IngestWindow, info.Blocks-height+1)
+	if count <= 1 {
+		return 0
+	}
+	var blocks []*walletrpc.CompactBlock
+	var data [][]byte
+	batched := false
+	_, canBatch := client.(RPCBatchClient)
+	if IngestBatchThreshold > 0 && canBatch && !batchUnsupported.Load() &&
+		info.Blocks-height+1 >= IngestBatchThreshold {
+		heights := make([]int, count)
+		for i := range heights {
+			heights[i] = height + i
+		}
+		blocks, data, err = GetBlockBatch(ctx, client, heights)
+		batched = err == nil
+		if errors.Is(err, ErrBatchUnsupported) {
+			Log.Warning("backend doesn't support batch requests, fetching blocks individually: ", err)
+			batchUnsupported.Store(true)
+		} else if err != nil {
+			// Probably transient (a timeout, say); batches are tried
+			// again for the next window.
+			Log.Warning("batch getblock failed, fetching blocks individually: ", err)
+		}
+	}
+	if !batched {
+		blocks, data = fetchBlocks(ctx, client, height, count)
+	}
+	for i, block := range blocks {
+		var links bool
+		if i == 0 {
+			links = c.HashMatch(hash32.T(block.PrevHash))
+		} else {
+			links = bytes.Equal(block.PrevHash, blocks[i-1].Hash)
+		}
+		if !links {
+			blocks = blocks[:i]
+			break
+		}
+	}
+	if len(blocks) == 0 {
+		return 0
+	}
+	// If adding fails, the caller retries the first block that wasn't added
+	// (which logs the failure).
+	if CacheFullBlocks {
+		err = c.AddFullBatch(blocks, data[:len(blocks)])
+	} else {
+		err = c.AddBatch(blocks)
+	}
+	if err != nil {
+		return 0
+	}
+	return len(blocks)
+}
+
+// fetchBlocks fetches the blocks at heights height through height+count-1
+// using up to IngestWorkers concurrent requests. The blocks (and their full
+// data) are returned in height order, up to the first one that couldn't be
+// fetched, whatever order the requests complete in.
+func fetchBlocks(ctx context.Context, client RPCClient, height, count int) ([]*walletrpc.CompactBlock, [][]byte) {
+	blocks := make([]*walletrpc.CompactBlock, count)
+	data := make([][]byte, count)
+	errs := make([]error, count)
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range min(IngestWorkers, count) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				blocks[i], data[i], errs[i] = getFullBlockFromRPC(ctx, client, height+i)
+			}
+		}()
+	}
+	for i := range count {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	for i := range count {
+		if errs[i] != nil || blocks[i] == nil {
+			if errs[i] != nil && ctx.Err() == nil {
+				logIngestError(height+i, errs[i], "getblock failed")
+			}
+			return blocks[:i], data[:i]
+		}
+	}
+	return blocks, data
+}
+
+// resumeIngest is called when a request to the backend node succeeds; if
+// earlier ones failed, it logs that ingestion is resuming (from the cache's
+// next height, since nothing is cached while the backend is unavailable).
+// It returns the new number of consecutive failures, zero.
+func resumeIngest(c *BlockCache, failures int) int {
+	if failures > 0 {
+		Log.Info("Reconnected to ", NodeName, " after ", failures,
+			" failed requests, resuming at block ", c.GetNextHeight())
+	}
+	return 0
+}
+
+// ingestRate measures the rate at which the ingestor adds blocks to the
+// cache, for the lightwalletd_ingest_blocks_per_second metric.
+type ingestRate struct {
+	start  time.Time
+	blocks int
+}
+
+// added updates the ingestion metrics after n blocks have been added to the
+// cache (or, if n is 0, after blocks were removed by a reorg).
+func (r *ingestRate) added(c *BlockCache, n int) {
+	ingestedHeight.Store(int64(c.GetNextHeight() - 1))
+	ingestedBlocksTotal.Add(float64(n))
+	r.blocks += n
+	if elapsed := Time.Now().Sub(r.start); elapsed >= 4*time.Second {
+		ingestBlocksPerSecond.Set(float64(r.blocks) / elapsed.Seconds())
+		r.start = Time.Now()
+		r.blocks = 0
+	}
+}
+
+// synced updates the ingestion metrics when the cache has caught up with
+// the backend node.
+func (r *ingestRate) synced(c *BlockCache) {
+	height := int64(c.GetNextHeight() - 1)
+	ingestedHeight.Store(height)
+	backendHeight.Store(height)
+	ingestBlocksPerSecond.Set(0)
+	r.start = Time.Now()
+	r.blocks = 0
+}
+
+// ingestState is the block ingestor's mode. While it's far behind the
+// backend node (ingestBulk), it fetches windows of blocks (see IngestWindow)
+// and syncs the cache only as CacheSyncPolicy says, for a fast initial sync.
+// Near the tip (ingestTip), it fetches blocks one at a time as the node
+// announces them (or as it finds them by polling), and syncs the cache after
+// each, so that a crash loses nothing. It starts in ingestBulk; see
+// IngestTipDistance for the transitions.
+type ingestState int
+
+const (
+	ingestBulk ingestState = iota
+	ingestTip
+)
+
+func (s ingestState) String() string {
+	if s == ingestTip {
+		return "tip"
+	}
+	return "bulk"
+}
+
+// enterIngestState applies the ingestor's state s to the cache c, and
+// records it in the lightwalletd_ingest_state metric.
+func enterIngestState(c *BlockCache, s ingestState) {
+	policy := CacheSyncPolicy
+	if s == ingestTip {
+		policy = SyncAlways
+	}
+	c.SetSyncPolicy(policy)
+	for _, state := range []ingestState{ingestBulk, ingestTip} {
+		value := 0.0
+		if state == s {
+			value = 1
+		}
+		ingestStateGauge.WithLabelValues(state.String()).Set(value)
+	}
+}
+
+// deepReorgDepth is the number of replaced blocks at or above which a
+// reorg is logged as a warning and counted in deepReorgsTotal.
+const deepReorgDepth = 10
+
+// logReorg reports a completed reorg, of the given depth (blocks replaced),
+// that rejoined the backend node's chain at the given height.
+func logReorg(height, depth int) {
+	if depth < deepReorgDepth {
+		Log.Info("REORG: replaced ", depth, " blocks below height ", height)
+		return
+	}
+	deepReorgsTotal.Inc()
+	Log.Warning("DEEP REORG: replaced ", depth, " blocks below height ", height)
+}
+
+// CacheRefresher keeps a read-only cache (see NewBlockCacheReadOnly) up to
+// date with the lightwalletd instance that writes it; it's run instead of
+// BlockIngestor. As with BlockIngestor, rep == 0 means loop forever.
+func CacheRefresher(c *BlockCache, rep int) {
+	for i := 0; rep == 0 || i < rep; i++ {
+		if err := c.Refresh(); err != nil {
+			Log.Warning("cache refresh failed: ", err)
+		}
+		Time.Sleep(2 * time.Second)
 	}
 }
 
 // GetBlock returns the compact block at the requested height, first by querying
-// the cache, then, if not found, will request the block from zcashd. It returns
-// nil if no block exists at this height.
+// the cache, then, if not found, will request the block from zcashd (see
+// MaxMissFetches). It returns nil if no block exists at this height.
 // This returns gRPC-compatible errors.
 func GetBlock(cache *BlockCache, height int) (*walletrpc.CompactBlock, error) {
 	// First, check the cache to see if we have the block
@@ -503,11 +1384,21 @@ func GetBlock(cache *BlockCache, height int) (*walletrpc.CompactBlock, error) {
 		if block != nil {
 			return block, nil
 		}
+		if cache.hides(height) {
+			// Don't fetch it from the backend node either.
+			return nil, status.Errorf(codes.OutOfRange,
+				"GetBlock: block %d is newer than the latest block", height)
+		}
 	}
 
 	// Not in the cache
-	block, err := getBlockFromRPC(height)
+	block, err := missFetches.fetch(height, getBlockFromRPC)
 	if err != nil {
+		if cache != nil && cache.GetLatestHeight() >= 0 {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"GetBlock: height %d unavailable, have %d-%d, getblock failed, error: %s",
+				height, cache.GetFirstHeight(), cache.GetLatestHeight(), err.Error())
+		}
 		return nil, status.Errorf(codes.InvalidArgument,
 			"GetBlock: getblock failed, error: %s", err.Error())
 	}
@@ -519,8 +1410,70 @@ func GetBlock(cache *BlockCache, height int) (*walletrpc.CompactBlock, error) {
 	return block, nil
 }
 
+// GetBlockByHash returns the compact block with the given hash (in
+// little-endian wire order). The backend node resolves the hash to a
+// height; the block is served from the cache if the cached block at that
+// height has this hash, else (it isn't cached, or it's on a branch that has
+// since been reorged away) it's fetched from the node.
+func GetBlockByHash(cache *BlockCache, hash hash32.T) (*walletrpc.CompactBlock, error) {
+	hashHex := hash32.Encode(hash32.Reverse(hash))
+	hashJSON, err := json.Marshal(hashHex)
+	if err != nil {
+		Log.Fatal("GetBlockByHash bad block hash", hashHex, err)
+	}
+	ctx := context.Background()
+	client := RPCFunc(RawRequest)
+	params := []json.RawMessage{hashJSON, json.RawMessage("1")}
+	result, rpcErr := rawRequestContext(ctx, client, "getblock", params)
+	if rpcErr != nil {
+		return nil, status.Errorf(codes.NotFound,
+			"GetBlock: block %s not found, getblock failed, error: %s", hashHex, rpcErr.Error())
+	}
+	var block1 ZcashRpcReplyGetblock1
+	if err := json.Unmarshal(result, &block1); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"GetBlock: can't unmarshal block %s: %s", hashHex, err.Error())
+	}
+	if cache != nil {
+		block := cache.Get(block1.Height)
+		if block != nil && bytes.Equal(block.Hash, hash[:]) {
+			return block, nil
+		}
+		if cache.hides(block1.Height) {
+			return nil, status.Errorf(codes.OutOfRange,
+				"GetBlock: block %d is newer than the latest block", block1.Height)
+		}
+	}
+	_, block, _, err := fetchRawBlock(ctx, client, block1.Height, &block1)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"GetBlock: getblock %s failed, error: %s", hashHex, err.Error())
+	}
+	if !bytes.Equal(block.Hash, hash[:]) {
+		return nil, status.Errorf(codes.Internal,
+			"GetBlock: getblock %s returned block %s", hashHex,
+			hash32.Encode(hash32.Reverse(hash32.T(block.Hash))))
+	}
+	return block, nil
+}
+
+// MaxBlockRange is the most blocks a GetBlockRange request may span; zero
+// means no limit (--max-block-range).
+var MaxBlockRange = 0
+
 // GetBlockRange returns a sequence of consecutive blocks in the given range.
-func GetBlockRange(cache *BlockCache, blockOut chan<- *walletrpc.CompactBlock, errOut chan<- error, start, end int) {
+// It sends each block on blockOut (which is unbuffered, so it doesn't get
+// ahead of the receiver), then nil on errOut, or the error that stopped it.
+// It returns without sending anything more if ctx is done.
+func GetBlockRange(ctx context.Context, cache *BlockCache, blockOut chan<- *walletrpc.CompactBlock, errOut chan<- error, start, end int) {
+	send := func(block *walletrpc.CompactBlock) bool {
+		select {
+		case blockOut <- block:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
 	// Go over [start, end] inclusive
 	low := start
 	high := end
@@ -528,22 +1481,46 @@ func GetBlockRange(cache *BlockCache, blockOut chan<- *walletrpc.CompactBlock, e
 		// reverse the order
 		low, high = end, start
 	}
+	if cache != nil && start <= end {
+		// Stream what's cached, then fall back to the backend node.
+		for block := range cache.GetRange(low, high) {
+			if !send(block) {
+				return
+			}
+			low = int(block.Height) + 1
+		}
+	}
 	for i := low; i <= high; i++ {
 		j := i
 		if start > end {
 			// reverse the order
 			j = high - (i - low)
 		}
+		if ctx.Err() != nil {
+			return
+		}
 		block, err := GetBlock(cache, j)
 		if err != nil {
-			errOut <- err
+			select {
+			case errOut <- err:
+			case <-ctx.Done():
+			}
 			return
 		}
-		blockOut <- block
+		if !send(block) {
+			return
+		}
+	}
+	select {
+	case errOut <- nil:
+	case <-ctx.Done():
 	}
-	errOut <- nil
 }
 
+// GetTxMempool allows GetTransaction to return transactions that
+// are in the mempool, not yet mined (--get-transaction-mempool).
+var GetTxMempool = false
+
 // ParseRawTransaction converts between the JSON result of a `zcashd`
 // `getrawtransaction` call and the `RawTransaction` protobuf type.
 //
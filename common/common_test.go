@@ -6,16 +6,27 @@ package common
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
+	"math/rand/v2"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/sirupsen/logrus"
+	"github.com/zcash/lightwalletd/hash32"
+	"github.com/zcash/lightwalletd/parser"
 	"github.com/zcash/lightwalletd/walletrpc"
 )
 
@@ -34,9 +45,9 @@ var (
 
 const (
 	testTxid      = "1234000000000000000000000000000000000000000000000000000000000000"
-	testBlockid40 = "0000000000000000000000000000000000000000000000000000000000380640"
-	testBlockid41 = "0000000000000000000000000000000000000000000000000000000000380641"
-	testBlockid42 = "0000000000000000000000000000000000000000000000000000000000380642"
+	testBlockid40 = "000a5e44b3b238d0cc36de7c0cb1ae5ac6e16f8727173abd295a83ebfa073b91"
+	testBlockid41 = "0001f0720f39cc3fcc6394134ea26f6332bb697ba1dcdb3ded9209181e099338"
+	testBlockid42 = "0007d6e226c0a130efb44ab38d155114e920714158d3c1a8a708c291f49519d9"
 )
 
 // TestMain does common setup that's shared across multiple tests
@@ -194,6 +205,29 @@ func TestGetLightdInfo(t *testing.T) {
 	sleepDuration = 0
 }
 
+func TestGetGenesisHash(t *testing.T) {
+	const genesisHex = "00040fe8ec8471911baa1db1266ea15dd06b4a8a5c453883c000b031973dce08"
+	RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		if method != "getblockhash" || len(params) != 1 || string(params[0]) != "0" {
+			t.Fatal("unexpected call", method, params)
+		}
+		return json.Marshal(genesisHex)
+	}
+	hash, err := GetGenesisHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash32.Encode(hash32.Reverse(hash)) != genesisHex {
+		t.Fatal("unexpected genesis hash ", hash)
+	}
+	RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		return json.Marshal("1234")
+	}
+	if _, err := GetGenesisHash(); err == nil {
+		t.Fatal("GetGenesisHash of a bad hash unexpected success")
+	}
+}
+
 // ------------------------------------------ BlockIngestor()
 
 func checkSleepMethod(count int, duration time.Duration, expected string, method string) {
@@ -385,12 +419,14 @@ func blockIngestorStub(method string, params []json.RawMessage) (json.RawMessage
 
 func TestBlockIngestor(t *testing.T) {
 	testT = t
-	RawRequest = blockIngestorStub
+	// The ingestor sends its requests only to the client it's given.
+	defer func(saved func(string, []json.RawMessage) (json.RawMessage, error)) { RawRequest = saved }(RawRequest)
+	RawRequest = nil
 	Time.Sleep = sleepStub
 	Time.Now = nowStub
 	os.RemoveAll(unitTestPath)
 	testcache = NewBlockCache(unitTestPath, unitTestChain, 380640, -1)
-	BlockIngestor(testcache, 11)
+	BlockIngestor(RPCFunc(blockIngestorStub), testcache, 11)
 	if step != 24 {
 		t.Error("unexpected final step", step)
 	}
@@ -400,6 +436,931 @@ func TestBlockIngestor(t *testing.T) {
 	os.RemoveAll(unitTestPath)
 }
 
+// A block that doesn't link to the latest cached block must not be cached;
+// instead, the ingestor should drop the latest cached block (reorg).
+func blockIngestorLinkStub(method string, params []json.RawMessage) (json.RawMessage, error) {
+	step++
+	switch step {
+	case 1:
+		checkSleepMethod(0, 0, "getbestblockhash", method)
+		r, _ := json.Marshal(strings.Repeat("01", 32))
+		return r, nil
+	case 2:
+		checkSleepMethod(0, 0, "getblock", method)
+		return []byte("{\"Tx\": [\"" + testTxid + "\"], \"Hash\": \"" + testBlockid41 + "\"}"), nil
+	case 3:
+		checkSleepMethod(0, 0, "getblock", method)
+		return blocks[1], nil
+	}
+	testT.Error("blockIngestorLinkStub called too many times")
+	return nil, nil
+}
+
+func TestBlockIngestorLink(t *testing.T) {
+	testT = t
+	RawRequest = blockIngestorLinkStub
+	Time.Sleep = sleepStub
+	Time.Now = nowStub
+	os.RemoveAll(unitTestPath)
+	testcache = NewBlockCache(unitTestPath, unitTestChain, 380640, -1)
+
+	// Cache a block at 380640 whose hash isn't block 380641's prevhash.
+	err := testcache.Add(380640, &walletrpc.CompactBlock{
+		Height: 380640,
+		Hash:   bytes.Repeat([]byte{0xee}, 32),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	BlockIngestor(RPCFunc(RawRequest), testcache, 1)
+	if step != 3 {
+		t.Error("unexpected final step", step)
+	}
+	if testcache.GetNextHeight() != 380640 {
+		t.Error("non-linking block was not rejected", testcache.GetNextHeight())
+	}
+	step = 0
+	os.RemoveAll(unitTestPath)
+}
+
+func TestBackoffDelay(t *testing.T) {
+	defer func() { backoffRand = rand.Float64 }()
+	// Without jitter, the delay doubles up to the maximum.
+	backoffRand = func() float64 { return 0.5 }
+	expected := []time.Duration{1, 2, 4, 8, 16, 32, 60, 60, 60}
+	for i, e := range expected {
+		if d := backoffDelay(i + 1); d != e*time.Second {
+			t.Fatal("failures", i+1, "unexpected delay", d)
+		}
+	}
+	// Jitter varies the delay by up to 20%, but never above the maximum.
+	backoffRand = func() float64 { return 0 }
+	if d := backoffDelay(3); d != 3200*time.Millisecond {
+		t.Fatal("unexpected low jitter delay", d)
+	}
+	if d := backoffDelay(100); d != 48*time.Second {
+		t.Fatal("unexpected low jitter delay at maximum", d)
+	}
+	backoffRand = func() float64 { return 0.75 }
+	if d := backoffDelay(3); d != 4400*time.Millisecond {
+		t.Fatal("unexpected high jitter delay", d)
+	}
+	if d := backoffDelay(7); d != time.Minute {
+		t.Fatal("jitter exceeded the maximum", d)
+	}
+	backoffRand = rand.Float64
+	for i := 1; i < 20; i++ {
+		if d := backoffDelay(i); d <= 0 || d > time.Minute {
+			t.Fatal("failures", i, "delay out of range", d)
+		}
+	}
+}
+
+// While the backend node is unreachable, the ingestor should retry with
+// increasing delays, then carry on from the latest cached block.
+func blockIngestorBackoffStub(method string, params []json.RawMessage) (json.RawMessage, error) {
+	step++
+	switch step {
+	case 1:
+		checkSleepMethod(0, 0, "getbestblockhash", method)
+		r, _ := json.Marshal(strings.Repeat("01", 32))
+		return r, nil
+	case 2:
+		checkSleepMethod(0, 0, "getblock", method)
+		return []byte("{\"Tx\": [\"" + testTxid + "\"], \"Hash\": \"" + testBlockid40 + "\"}"), nil
+	case 3:
+		checkSleepMethod(0, 0, "getblock", method)
+		return blocks[0], nil
+	case 4:
+		// The backend goes away.
+		checkSleepMethod(0, 0, "getbestblockhash", method)
+		return nil, errors.New("connection refused")
+	case 5:
+		checkSleepMethod(1, 1, "getbestblockhash", method)
+		return nil, errors.New("connection refused")
+	case 6:
+		checkSleepMethod(2, 3, "getbestblockhash", method)
+		r, _ := json.Marshal(strings.Repeat("01", 32))
+		return r, nil
+	case 7:
+		checkSleepMethod(2, 3, "getblock", method)
+		return nil, errors.New("connection refused")
+	case 8:
+		// It's back; ingestion continues at the next block.
+		checkSleepMethod(3, 7, "getbestblockhash", method)
+		r, _ := json.Marshal(strings.Repeat("01", 32))
+		return r, nil
+	case 9:
+		checkSleepMethod(3, 7, "getblock", method)
+		var arg string
+		json.Unmarshal(params[0], &arg)
+		if arg != "380641" {
+			testT.Fatal("incorrect height requested", arg)
+		}
+		return []byte("{\"Tx\": [\"" + testTxid + "\"], \"Hash\": \"" + testBlockid41 + "\"}"), nil
+	case 10:
+		checkSleepMethod(3, 7, "getblock", method)
+		return blocks[1], nil
+	case 11:
+		// The delay starts over after a success.
+		checkSleepMethod(3, 7, "getbestblockhash", method)
+		return nil, errors.New("connection refused")
+	}
+	testT.Error("blockIngestorBackoffStub called too many times")
+	return nil, nil
+}
+
+func TestBlockIngestorBackoff(t *testing.T) {
+	testT = t
+	RawRequest = blockIngestorBackoffStub
+	Time.Sleep = sleepStub
+	Time.Now = nowStub
+	defer func() { backoffRand = rand.Float64 }()
+	backoffRand = func() float64 { return 0.5 }
+	cache := NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+	defer cache.Close()
+	BlockIngestor(RPCFunc(RawRequest), cache, 6)
+	if step != 11 {
+		t.Error("unexpected final step", step)
+	}
+	if sleepCount != 4 || sleepDuration != 8*time.Second {
+		t.Error("unexpected sleeps", sleepCount, sleepDuration)
+	}
+	if cache.GetNextHeight() != 380642 {
+		t.Error("unexpected next height", cache.GetNextHeight())
+	}
+	step = 0
+	sleepCount = 0
+	sleepDuration = 0
+}
+
+// A request that the backend node doesn't answer within RPCTimeout is
+// retried with backoff.
+func TestBlockIngestorTimeout(t *testing.T) {
+	testT = t
+	Time.Sleep = sleepStub
+	Time.Now = nowStub
+	defer func() { sleepCount, sleepDuration = 0, 0 }()
+	defer func() { backoffRand = rand.Float64 }()
+	backoffRand = func() float64 { return 0.5 }
+	defer func(saved time.Duration) { RPCTimeout = saved }(RPCTimeout)
+	RPCTimeout = 20 * time.Millisecond
+	chain := forkTestChain("a", 2)
+	serve := chainStub(func() testChain { return chain })
+	release := make(chan struct{})
+	defer close(release)
+	var hung atomic.Bool
+	RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		// The first getblock hangs.
+		if method == "getblock" && !hung.Swap(true) {
+			<-release
+		}
+		return serve(method, params)
+	}
+
+	cache := NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+	defer cache.Close()
+	start := time.Now()
+	BlockIngestor(RPCFunc(RawRequest), cache, 3)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatal("ingestor took", elapsed)
+	}
+	if sleepCount != 1 || sleepDuration != RPCBackoffInitial {
+		t.Fatal("unexpected backoff", sleepCount, sleepDuration)
+	}
+	if cache.GetNextHeight() != 380642 {
+		t.Fatal("unexpected next height", cache.GetNextHeight())
+	}
+}
+
+// The ingestor fetches a window of blocks concurrently during initial sync;
+// they must be cached in height order even if they arrive out of order.
+func TestBlockIngestorWindow(t *testing.T) {
+	testT = t
+	Time.Sleep = sleepStub
+	Time.Now = nowStub
+	savedWorkers, savedWindow := IngestWorkers, IngestWindow
+	defer func() { IngestWorkers, IngestWindow = savedWorkers, savedWindow }()
+	IngestWorkers, IngestWindow = 3, 8
+	cache := NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+	defer cache.Close()
+
+	var mutex sync.Mutex
+	var arrivals []int
+	hashes := []string{testBlockid40, testBlockid41, testBlockid42}
+	RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		switch method {
+		case "getbestblockhash":
+			r, _ := json.Marshal(strings.Repeat("01", 32))
+			return r, nil
+		case "getblockchaininfo":
+			return json.Marshal(&ZcashdRpcReplyGetblockchaininfo{Blocks: 380642})
+		}
+		var arg string
+		if err := json.Unmarshal(params[0], &arg); err != nil {
+			t.Error("could not unmarshal height")
+		}
+		if string(params[1]) == "1" {
+			height, _ := strconv.Atoi(arg)
+			return []byte("{\"Tx\": [\"" + testTxid + "\"], \"Hash\": \"" +
+				hashes[height-380640] + "\"}"), nil
+		}
+		i := slices.Index(hashes, arg)
+		// Later blocks are returned sooner.
+		time.Sleep(time.Duration(len(hashes)-i) * 50 * time.Millisecond)
+		mutex.Lock()
+		arrivals = append(arrivals, i)
+		mutex.Unlock()
+		return blocks[i], nil
+	}
+	BlockIngestor(RPCFunc(RawRequest), cache, 1)
+	if !slices.Equal(arrivals, []int{2, 1, 0}) {
+		t.Fatal("blocks weren't fetched concurrently", arrivals)
+	}
+	if cache.GetNextHeight() != 380643 {
+		t.Fatal("unexpected next height", cache.GetNextHeight())
+	}
+	for i := range hashes {
+		block := cache.Get(380640 + i)
+		if block == nil || int(block.Height) != 380640+i {
+			t.Fatal("bad block at height", 380640+i)
+		}
+	}
+	if err := cache.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// batchClient is an RPCBatchClient that sends single requests using
+// RawRequest, and batches using batch.
+type batchClient struct {
+	batch func(ctx context.Context, requests []RPCRequest) ([]RPCResult, error)
+}
+
+func (b *batchClient) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	return RawRequest(method, params)
+}
+
+func (b *batchClient) RawBatchRequest(ctx context.Context, requests []RPCRequest) ([]RPCResult, error) {
+	return b.batch(ctx, requests)
+}
+
+// During initial sync, the ingestor fetches blocks with batch requests,
+// falling back to individual requests if batches aren't supported.
+func TestBlockIngestorBatch(t *testing.T) {
+	testT = t
+	Time.Sleep = sleepStub
+	Time.Now = nowStub
+	savedThreshold, savedWindow := IngestBatchThreshold, IngestWindow
+	defer func() {
+		IngestBatchThreshold, IngestWindow = savedThreshold, savedWindow
+		batchUnsupported.Store(false)
+	}()
+	IngestBatchThreshold, IngestWindow = 2, 8
+
+	hashes := []string{testBlockid40, testBlockid41, testBlockid42}
+	singleRequests := 0
+	RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		switch method {
+		case "getbestblockhash":
+			r, _ := json.Marshal(strings.Repeat("01", 32))
+			return r, nil
+		case "getblockchaininfo":
+			return json.Marshal(&ZcashdRpcReplyGetblockchaininfo{Blocks: 380642})
+		}
+		singleRequests++
+		var arg string
+		json.Unmarshal(params[0], &arg)
+		if string(params[1]) == "1" {
+			height, _ := strconv.Atoi(arg)
+			return []byte("{\"Tx\": [\"" + testTxid + "\"], \"Hash\": \"" +
+				hashes[height-380640] + "\"}"), nil
+		}
+		return blocks[slices.Index(hashes, arg)], nil
+	}
+	batches := 0
+	client := &batchClient{}
+	client.batch = func(ctx context.Context, requests []RPCRequest) ([]RPCResult, error) {
+		batches++
+		if len(requests) != 3 {
+			t.Fatal("unexpected batch size", len(requests))
+		}
+		results := make([]RPCResult, len(requests))
+		for i, r := range requests {
+			if r.Method != "getblock" || len(r.Params) != 2 {
+				t.Fatal("unexpected request", r)
+			}
+			var arg string
+			json.Unmarshal(r.Params[0], &arg)
+			switch batches {
+			case 1:
+				// Verbose, by height.
+				if arg != strconv.Itoa(380640+i) || string(r.Params[1]) != "1" {
+					t.Fatal("unexpected verbose request", arg, string(r.Params[1]))
+				}
+				results[i].Result = []byte("{\"Tx\": [\"" + testTxid + "\"], \"Hash\": \"" + hashes[i] + "\"}")
+			case 2:
+				// Raw, by hash.
+				if arg != hashes[i] || string(r.Params[1]) != "0" {
+					t.Fatal("unexpected raw request", arg, string(r.Params[1]))
+				}
+				results[i].Result = blocks[i]
+			default:
+				t.Fatal("unexpected batch")
+			}
+		}
+		return results, nil
+	}
+	cache := NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+	BlockIngestor(client, cache, 1)
+	if batches != 2 || singleRequests != 0 {
+		t.Fatal("unexpected requests", batches, singleRequests)
+	}
+	if cache.GetNextHeight() != 380643 {
+		t.Fatal("unexpected next height", cache.GetNextHeight())
+	}
+	for i := range hashes {
+		if block := cache.Get(380640 + i); block == nil || int(block.Height) != 380640+i {
+			t.Fatal("bad block at height", 380640+i)
+		}
+	}
+	cache.Close()
+
+	// A batch that times out is fetched individually, but batches are
+	// still used afterwards.
+	defer func(saved time.Duration) { RPCTimeout = saved }(RPCTimeout)
+	RPCTimeout = 20 * time.Millisecond
+	client.batch = func(ctx context.Context, requests []RPCRequest) ([]RPCResult, error) {
+		batches++
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	batches = 0
+	cache = NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+	BlockIngestor(client, cache, 1)
+	if batches != 1 || singleRequests != 6 {
+		t.Fatal("unexpected requests", batches, singleRequests)
+	}
+	if cache.GetNextHeight() != 380643 || batchUnsupported.Load() {
+		t.Fatal("unexpected next height", cache.GetNextHeight())
+	}
+	cache.Close()
+
+	// A backend that doesn't support batches.
+	client.batch = func(ctx context.Context, requests []RPCRequest) ([]RPCResult, error) {
+		batches++
+		return nil, fmt.Errorf("%w: status code: 500", ErrBatchUnsupported)
+	}
+	batches, singleRequests = 0, 0
+	cache = NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+	defer cache.Close()
+	BlockIngestor(client, cache, 1)
+	if batches != 1 || singleRequests != 6 {
+		t.Fatal("unexpected requests", batches, singleRequests)
+	}
+	if cache.GetNextHeight() != 380643 || !batchUnsupported.Load() {
+		t.Fatal("unexpected next height", cache.GetNextHeight())
+	}
+}
+
+// testChain is a chain of test blocks (starting at 380640) served by
+// chainStub.
+type testChain struct {
+	name   string
+	blocks [][]byte // raw
+}
+
+// forkTestChain returns a chain whose first block is test block 0 and whose
+// later blocks are the test blocks modified (their times changed, depending
+// on the chain name) so that they're distinct from those of other chains,
+// but link to each other.
+func forkTestChain(name string, n int) testChain {
+	chain := testChain{name: name}
+	var prevHash hash32.T
+	for i := range n {
+		var blockHex string
+		json.Unmarshal(blocks[i], &blockHex)
+		raw, _ := hex.DecodeString(blockHex)
+		if i > 0 {
+			copy(raw[4:36], prevHash[:])
+			raw[100] += name[0] // time
+		}
+		block := parser.NewBlock()
+		if _, err := block.ParseFromSlice(raw); err != nil {
+			testT.Fatal(err)
+		}
+		prevHash = block.GetEncodableHash()
+		chain.blocks = append(chain.blocks, raw)
+	}
+	return chain
+}
+
+// height returns the height of the chain's block with the given (display)
+// hash, or -1 if there's no such block.
+func (chain testChain) height(hash string) int {
+	for i, raw := range chain.blocks {
+		block := parser.NewBlock()
+		block.ParseFromSlice(raw)
+		if block.GetDisplayHashString() == hash {
+			return 380640 + i
+		}
+	}
+	return -1
+}
+
+// chainStub returns a RawRequest stub that serves the chain returned by
+// *current.
+func chainStub(current func() testChain) func(string, []json.RawMessage) (json.RawMessage, error) {
+	return func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		chain := current()
+		switch method {
+		case "getbestblockhash":
+			block := parser.NewBlock()
+			block.ParseFromSlice(chain.blocks[len(chain.blocks)-1])
+			return json.Marshal(block.GetDisplayHashString())
+		case "getblock":
+			var arg string
+			json.Unmarshal(params[0], &arg)
+			if string(params[1]) == "1" {
+				height, _ := strconv.Atoi(arg)
+				if height-380640 >= len(chain.blocks) {
+					return nil, errors.New("-8: Block height out of range")
+				}
+				block := parser.NewBlock()
+				block.ParseFromSlice(chain.blocks[height-380640])
+				txids := make([]string, len(block.Transactions()))
+				for i := range txids {
+					txids[i] = testTxid
+				}
+				return json.Marshal(&ZcashRpcReplyGetblock1{Hash: block.GetDisplayHashString(), Tx: txids})
+			}
+			height := chain.height(arg)
+			if height < 0 {
+				return nil, errors.New("-5: Block not found")
+			}
+			return json.Marshal(hex.EncodeToString(chain.blocks[height-380640]))
+		}
+		testT.Fatal("unexpected method", method)
+		return nil, nil
+	}
+}
+
+// After a chain split, the ingestor walks back to the common ancestor and
+// follows the new chain.
+func TestBlockIngestorChainSplit(t *testing.T) {
+	testT = t
+	Time.Sleep = sleepStub
+	Time.Now = nowStub
+	defer func() { sleepCount, sleepDuration = 0, 0 }()
+	chainA := forkTestChain("a", 3)
+	chainB := forkTestChain("b", 4)
+	chain := chainA
+	RawRequest = chainStub(func() testChain { return chain })
+	cache := NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+	defer cache.Close()
+
+	// Sync chain A (three blocks, then synced).
+	BlockIngestor(RPCFunc(RawRequest), cache, 4)
+	if cache.GetNextHeight() != 380643 {
+		t.Fatal("chain A not synced", cache.GetNextHeight())
+	}
+
+	// Chain B splits from chain A after its first block, and is longer.
+	if bytes.Equal(chainA.blocks[1], chainB.blocks[1]) {
+		t.Fatal("chains didn't split")
+	}
+	chain = chainB
+	BlockIngestor(RPCFunc(RawRequest), cache, 10)
+	if cache.GetNextHeight() != 380644 {
+		t.Fatal("chain B not synced", cache.GetNextHeight())
+	}
+	for i, raw := range chainB.blocks {
+		block := parser.NewBlock()
+		block.ParseFromSlice(raw)
+		hash := block.GetEncodableHash()
+		if cached := cache.Get(380640 + i); cached == nil || !bytes.Equal(cached.Hash, hash[:]) {
+			t.Fatal("block", 380640+i, "isn't on chain B")
+		}
+	}
+	if err := cache.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The ingestor doesn't follow a reorg deeper than MaxReorgDepth.
+func TestBlockIngestorMaxReorgDepth(t *testing.T) {
+	testT = t
+	Time.Sleep = sleepStub
+	Time.Now = nowStub
+	defer func() { sleepCount, sleepDuration = 0, 0 }()
+	defer func(saved int) { MaxReorgDepth = saved }(MaxReorgDepth)
+	MaxReorgDepth = 1
+	chainA := forkTestChain("a", 3)
+	chainB := forkTestChain("b", 4)
+	chain := chainA
+	RawRequest = chainStub(func() testChain { return chain })
+	cache := NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+	defer cache.Close()
+	BlockIngestor(RPCFunc(RawRequest), cache, 4)
+
+	// Following chain B would remove two blocks.
+	chain = chainB
+	m := &dto.Metric{}
+	count := func() float64 {
+		if err := reorgsAbortedTotal.Write(m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetCounter().GetValue()
+	}
+	aborted := count()
+	BlockIngestor(RPCFunc(RawRequest), cache, 10)
+	if cache.GetNextHeight() != 380642 {
+		t.Fatal("unexpected next height", cache.GetNextHeight())
+	}
+	if count() != aborted+1 {
+		t.Fatal("aborted reorg not counted")
+	}
+	block := parser.NewBlock()
+	block.ParseFromSlice(chainA.blocks[1])
+	if !cache.HashMatch(block.GetEncodableHash()) {
+		t.Fatal("latest cached block isn't on chain A")
+	}
+
+	// The backend node returns to chain A.
+	chain = chainA
+	BlockIngestor(RPCFunc(RawRequest), cache, 2)
+	if cache.GetNextHeight() != 380643 {
+		t.Fatal("chain A not resumed", cache.GetNextHeight())
+	}
+}
+
+// prunedStub returns a chainStub (serving chain) for a backend node that's
+// pruned below horizon, reporting pruneHeight in getblockchaininfo.
+func prunedStub(chain testChain, horizon, pruneHeight int) func(string, []json.RawMessage) (json.RawMessage, error) {
+	serve := chainStub(func() testChain { return chain })
+	return func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		switch method {
+		case "getblockchaininfo":
+			return json.Marshal(&ZcashdRpcReplyGetblockchaininfo{
+				Blocks:      380640 + len(chain.blocks) - 1,
+				PruneHeight: pruneHeight,
+			})
+		case "getblock":
+			var arg string
+			json.Unmarshal(params[0], &arg)
+			height, err := strconv.Atoi(arg)
+			if err != nil {
+				height = chain.height(arg)
+			}
+			if height < horizon {
+				return nil, errors.New("-1: Block not available (pruned data)")
+			}
+		}
+		return serve(method, params)
+	}
+}
+
+// An empty cache skips the blocks a pruned backend node can't serve.
+func TestBlockIngestorPruned(t *testing.T) {
+	testT = t
+	Time.Sleep = sleepStub
+	Time.Now = nowStub
+	defer func() { sleepCount, sleepDuration = 0, 0 }()
+	defer lowestServableHeight.Store(0)
+	chain := forkTestChain("a", 3)
+
+	// The horizon is found by searching, or reported by the node.
+	for _, pruneHeight := range []int{0, 380641} {
+		lowestServableHeight.Store(0)
+		RawRequest = prunedStub(chain, 380641, pruneHeight)
+		cache := NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+		defer cache.Close()
+		BlockIngestor(RPCFunc(RawRequest), cache, 4)
+		if cache.GetFirstHeight() != 380641 || cache.GetNextHeight() != 380643 {
+			t.Fatal("unexpected cache range", cache.GetFirstHeight(), cache.GetNextHeight())
+		}
+		if LowestServableHeight() != 380641 {
+			t.Fatal("unexpected lowest servable height", LowestServableHeight())
+		}
+		if err := cache.CheckInvariants(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// A non-empty cache can't be extended past pruned blocks (that would leave
+// a gap), so the ingestor stops rather than retrying forever.
+func TestBlockIngestorPrunedGap(t *testing.T) {
+	testT = t
+	Time.Sleep = sleepStub
+	Time.Now = nowStub
+	defer func() { sleepCount, sleepDuration = 0, 0 }()
+	defer lowestServableHeight.Store(0)
+	chain := forkTestChain("a", 3)
+	RawRequest = chainStub(func() testChain { return chain })
+	cache := NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+	defer cache.Close()
+	BlockIngestor(RPCFunc(RawRequest), cache, 1)
+	if cache.GetNextHeight() != 380641 {
+		t.Fatal("unexpected next height", cache.GetNextHeight())
+	}
+
+	// The node is pruned below 380642, so it can't serve 380641.
+	RawRequest = prunedStub(chain, 380642, 0)
+	BlockIngestor(RPCFunc(RawRequest), cache, 100)
+	if cache.GetFirstHeight() != 380640 || cache.GetNextHeight() != 380641 {
+		t.Fatal("cache changed", cache.GetFirstHeight(), cache.GetNextHeight())
+	}
+	if LowestServableHeight() != 380642 {
+		t.Fatal("unexpected lowest servable height", LowestServableHeight())
+	}
+	if sleepCount != 0 {
+		t.Fatal("ingestor retried", sleepCount)
+	}
+}
+
+// metricValue returns the value of a gauge or counter.
+func metricValue(t *testing.T, metric prometheus.Metric) float64 {
+	m := &dto.Metric{}
+	if err := metric.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Gauge != nil {
+		return m.Gauge.GetValue()
+	}
+	return m.Counter.GetValue()
+}
+
+// The lag metrics follow the backend node's chain as it advances.
+func TestIngestMetrics(t *testing.T) {
+	testT = t
+	Time.Sleep = sleepStub
+	Time.Now = nowStub
+	defer func() { sleepCount, sleepDuration = 0, 0 }()
+	savedWorkers, savedWindow := IngestWorkers, IngestWindow
+	defer func() { IngestWorkers, IngestWindow = savedWorkers, savedWindow }()
+	IngestWorkers, IngestWindow = 2, 2
+	check := func(what string, backend, ingested, behind int) {
+		t.Helper()
+		if b, i, l := metricValue(t, backendHeightGauge), metricValue(t, ingestedHeightGauge),
+			metricValue(t, blocksBehindGauge); b != float64(backend) || i != float64(ingested) || l != float64(behind) {
+			t.Fatal(what, ": unexpected backend height, ingested height, blocks behind ", b, i, l)
+		}
+	}
+	// (prunedStub serves getblockchaininfo; nothing is pruned.)
+	RawRequest = prunedStub(forkTestChain("a", 3), 380640, 0)
+	cache := NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+	defer cache.Close()
+	ingested := metricValue(t, ingestedBlocksTotal)
+
+	// A window of two blocks.
+	BlockIngestor(RPCFunc(RawRequest), cache, 1)
+	check("first window", 380642, 380641, 1)
+
+	// The last block, then synced.
+	BlockIngestor(RPCFunc(RawRequest), cache, 2)
+	check("synced", 380642, 380642, 0)
+
+	// The chain advances.
+	RawRequest = prunedStub(forkTestChain("a", 4), 380640, 0)
+	if _, err := GetBlockChainInfo(); err != nil {
+		t.Fatal(err)
+	}
+	check("new block", 380643, 380642, 1)
+	BlockIngestor(RPCFunc(RawRequest), cache, 2)
+	check("synced again", 380643, 380643, 0)
+	if n := metricValue(t, ingestedBlocksTotal) - ingested; n != 4 {
+		t.Fatal("unexpected number of blocks ingested", n)
+	}
+}
+
+func TestIngestRate(t *testing.T) {
+	Time.Now = nowStub
+	defer func() { sleepCount, sleepDuration = 0, 0 }()
+	cache := NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+	defer cache.Close()
+	rate := ingestRate{start: Time.Now()}
+	ingestBlocksPerSecond.Set(0)
+	rate.added(cache, 3)
+	if r := metricValue(t, ingestBlocksPerSecond); r != 0 {
+		t.Fatal("rate updated too soon", r)
+	}
+	sleepDuration += 5 * time.Second
+	rate.added(cache, 7)
+	if r := metricValue(t, ingestBlocksPerSecond); r != 2 {
+		t.Fatal("unexpected rate", r)
+	}
+	rate.synced(cache)
+	if r := metricValue(t, ingestBlocksPerSecond); r != 0 {
+		t.Fatal("rate not reset when synced", r)
+	}
+}
+
+func TestRecordRPC(t *testing.T) {
+	request := InstrumentRawRequest(func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		if method == "getblock" {
+			return nil, errors.New("-8: Block height out of range")
+		}
+		return json.RawMessage("1"), nil
+	})
+	requests, errs := metricValue(t, rpcRequestsTotal.WithLabelValues("getblock")),
+		metricValue(t, rpcErrorsTotal.WithLabelValues("getblock"))
+	infoErrs := metricValue(t, rpcErrorsTotal.WithLabelValues("getinfo"))
+	request("getblock", nil)
+	request("getinfo", nil)
+	request("getblock", nil)
+	if n := metricValue(t, rpcRequestsTotal.WithLabelValues("getblock")) - requests; n != 2 {
+		t.Fatal("unexpected getblock requests", n)
+	}
+	if n := metricValue(t, rpcErrorsTotal.WithLabelValues("getblock")) - errs; n != 2 {
+		t.Fatal("unexpected getblock errors", n)
+	}
+	if n := metricValue(t, rpcErrorsTotal.WithLabelValues("getinfo")) - infoErrs; n != 0 {
+		t.Fatal("unexpected getinfo errors", n)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	var backendHeight int
+	var backendErr error
+	RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		if method != "getblockchaininfo" {
+			t.Fatal("unexpected method", method)
+		}
+		if backendErr != nil {
+			return nil, backendErr
+		}
+		return json.Marshal(&ZcashdRpcReplyGetblockchaininfo{Blocks: backendHeight})
+	}
+	defer func(saved int) { HealthMaxLag = saved }(HealthMaxLag)
+	HealthMaxLag = 2
+	cache := NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+	defer cache.Close()
+	for i := range 3 {
+		if err := cache.Add(380640+i, testCompactBlock(380640+i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Healthy: synced, then within the tolerance.
+	for _, backendHeight = range []int{380642, 380644} {
+		status, err := Healthz(cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Height != backendHeight || status.CachedHeight != 380642 ||
+			status.Lag != backendHeight-380642 {
+			t.Fatal("unexpected status", *status)
+		}
+	}
+
+	// Lagging.
+	backendHeight = 380645
+	status, err := Healthz(cache)
+	if err == nil || !strings.Contains(err.Error(), "3 blocks behind") {
+		t.Fatal("unexpected error", err)
+	}
+	if status == nil || status.Lag != 3 {
+		t.Fatal("unexpected status", status)
+	}
+
+	// Without a cache, only reachability matters.
+	if status, err := Healthz(nil); err != nil || status.Lag != 0 {
+		t.Fatal("unexpected status", status, err)
+	}
+
+	// Disconnected.
+	backendErr = errors.New("connection refused")
+	status, err = Healthz(cache)
+	if err == nil || !errors.Is(err, backendErr) || status != nil {
+		t.Fatal("unexpected result", status, err)
+	}
+}
+
+func TestClampStartHeight(t *testing.T) {
+	defer func(saved int) { OrchardActivationHeight = saved }(OrchardActivationHeight)
+	defer func(saved *logrus.Entry) { Log = saved }(Log)
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&buf)
+	Log = l.WithField("app", "test")
+
+	OrchardActivationHeight = 1000
+	if h := ClampStartHeight(1200); h != 1200 || buf.Len() != 0 {
+		t.Fatal("unexpected clamp", h, buf.String())
+	}
+	if h := ClampStartHeight(900); h != 1000 {
+		t.Fatal("start height not clamped", h)
+	}
+	if !strings.Contains(buf.String(), "start height 900 is below the Orchard activation height 1000") {
+		t.Fatal("clamp not logged:", buf.String())
+	}
+}
+
+// The ingestor removes any cached blocks below the Orchard activation
+// height, and never ingests below it.
+func TestBlockIngestorActivation(t *testing.T) {
+	defer func(saved int) { OrchardActivationHeight = saved }(OrchardActivationHeight)
+	OrchardActivationHeight = 1005
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // just the startup checks
+
+	cache := NewBlockCache(t.TempDir(), unitTestChain, 1000, 0)
+	defer cache.Close()
+	BlockIngestorContext(ctx, RPCFunc(RawRequest), cache, 1)
+	if cache.GetFirstHeight() != 1005 || cache.GetNextHeight() != 1005 {
+		t.Fatal("empty cache not clamped", cache.GetFirstHeight(), cache.GetNextHeight())
+	}
+
+	cache = NewBlockCache(t.TempDir(), unitTestChain, 1000, 0)
+	defer cache.Close()
+	for height := 1000; height < 1010; height++ {
+		if err := cache.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	BlockIngestorContext(ctx, RPCFunc(RawRequest), cache, 1)
+	if cache.GetFirstHeight() != 1005 || cache.GetNextHeight() != 1010 {
+		t.Fatal("pre-activation blocks not removed", cache.GetFirstHeight(), cache.GetNextHeight())
+	}
+	if block := cache.Get(1005); block == nil || block.Height != 1005 {
+		t.Fatal("bad first block after clamping")
+	}
+	if err := cache.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Cancelling the ingestor's context while it's waiting for the backend
+// must stop it promptly, leaving the blocks it has already cached intact.
+func TestBlockIngestorCancel(t *testing.T) {
+	testT = t
+	Time.Sleep = sleepStub
+	Time.Now = nowStub
+	savedWriteBuffer := CacheWriteBuffer
+	defer func() { CacheWriteBuffer = savedWriteBuffer }()
+	CacheWriteBuffer = 1 << 20 // make sure pending blocks are flushed
+	dir := t.TempDir()
+	cache := NewBlockCache(dir, unitTestChain, 380640, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	defer close(release)
+	RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		if method == "getbestblockhash" {
+			r, _ := json.Marshal(strings.Repeat("01", 32))
+			return r, nil
+		}
+		var arg string
+		if err := json.Unmarshal(params[0], &arg); err != nil {
+			t.Error("could not unmarshal height")
+		}
+		if len(params) > 1 && string(params[1]) == "1" {
+			height, _ := strconv.Atoi(arg)
+			hash := []string{testBlockid40, testBlockid41, testBlockid42}[height-380640]
+			return []byte("{\"Tx\": [\"" + testTxid + "\"], \"Hash\": \"" + hash + "\"}"), nil
+		}
+		if arg == testBlockid42 {
+			// The backend hangs; the ingestor must not wait for it.
+			cancel()
+			<-release
+			return nil, errors.New("unreachable")
+		}
+		return blocks[cache.GetNextHeight()-380640], nil
+	}
+	done := make(chan struct{})
+	go func() {
+		BlockIngestorContext(ctx, RPCFunc(RawRequest), cache, 0)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("ingestor did not stop after cancellation")
+	}
+	if cache.GetNextHeight() != 380642 {
+		t.Fatal("unexpected next height", cache.GetNextHeight())
+	}
+	if err := cache.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+
+	cache = NewBlockCache(dir, unitTestChain, 380640, -1)
+	defer cache.Close()
+	if cache.GetNextHeight() != 380642 {
+		t.Fatal("blocks were lost", cache.GetNextHeight())
+	}
+	for height := 380640; height < 380642; height++ {
+		block := cache.Get(height)
+		if block == nil || int(block.Height) != height {
+			t.Fatal("bad block at height", height)
+		}
+	}
+	sleepCount = 0
+	sleepDuration = 0
+}
+
 // ------------------------------------------ GetBlockRange()
 
 // There are four test blocks, 0..3
@@ -450,7 +1411,7 @@ func TestGetBlockRange(t *testing.T) {
 	testcache = NewBlockCache(unitTestPath, unitTestChain, 380640, 0)
 	blockChan := make(chan *walletrpc.CompactBlock)
 	errChan := make(chan error)
-	go GetBlockRange(testcache, blockChan, errChan, 380640, 380642)
+	go GetBlockRange(context.Background(), testcache, blockChan, errChan, 380640, 380642)
 
 	// read in block 380640
 	select {
@@ -549,7 +1510,7 @@ func TestGetBlockRangeReverse(t *testing.T) {
 	errChan := make(chan error)
 
 	// Request the blocks in reverse order by specifying start greater than end
-	go GetBlockRange(testcache, blockChan, errChan, 380642, 380640)
+	go GetBlockRange(context.Background(), testcache, blockChan, errChan, 380642, 380640)
 
 	// read in block 380642
 	select {
@@ -590,6 +1551,175 @@ func TestGetBlockRangeReverse(t *testing.T) {
 	os.RemoveAll(unitTestPath)
 }
 
+// ------------------------------------------ ProfileBlockParse
+
+func parseProfileStub(method string, params []json.RawMessage) (json.RawMessage, error) {
+	var arg string
+	err := json.Unmarshal(params[0], &arg)
+	if err != nil {
+		testT.Fatal("could not unmarshal arg")
+	}
+	if arg == "380640" {
+		return []byte("{\"Tx\": [\"" + testTxid + "\"], \"Hash\": \"" + testBlockid40 + "\"}"), nil
+	}
+	if arg != testBlockid40 {
+		testT.Error("unexpected hash")
+	}
+	return blocks[0], nil
+}
+
+func parseSampleCount(t *testing.T) uint64 {
+	m := &dto.Metric{}
+	if err := blockParseSeconds.Write(m); err != nil {
+		t.Fatal("could not read block parse histogram:", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestProfileBlockParse(t *testing.T) {
+	testT = t
+	RawRequest = parseProfileStub
+	Time.Now = time.Now
+	defer func() { ProfileBlockParse = false }()
+
+	before := parseSampleCount(t)
+	ProfileBlockParse = false
+	if _, err := getBlockFromRPC(380640); err != nil {
+		t.Fatal("getBlockFromRPC failed:", err)
+	}
+	if parseSampleCount(t) != before {
+		t.Fatal("block parse time recorded with profiling disabled")
+	}
+	ProfileBlockParse = true
+	if _, err := getBlockFromRPC(380640); err != nil {
+		t.Fatal("getBlockFromRPC failed:", err)
+	}
+	if parseSampleCount(t) != before+1 {
+		t.Fatal("block parse time not recorded with profiling enabled")
+	}
+}
+
+// ------------------------------------------ VerifyBlocks
+
+var verifyBlock json.RawMessage
+
+// verifyBlocksStub serves verifyBlock at height 380640, reporting the hash
+// of its header (which is all that's parsed), as the backend node would.
+func verifyBlocksStub(method string, params []json.RawMessage) (json.RawMessage, error) {
+	var arg string
+	json.Unmarshal(params[0], &arg)
+	if arg == "380640" {
+		var blockHex string
+		json.Unmarshal(verifyBlock, &blockHex)
+		raw, _ := hex.DecodeString(blockHex)
+		hdr := parser.NewBlockHeader()
+		hdr.ParseFromSlice(raw)
+		return []byte("{\"Tx\": [\"" + testTxid + "\"], \"Hash\": \"" + hdr.GetDisplayHashString() + "\"}"), nil
+	}
+	return verifyBlock, nil
+}
+
+// A block whose hash doesn't match the one the backend node reported (and
+// that was used to request it) is rejected.
+func TestBlockHashMismatch(t *testing.T) {
+	testT = t
+	RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		var arg string
+		json.Unmarshal(params[0], &arg)
+		if arg == "380640" {
+			return []byte("{\"Tx\": [\"" + testTxid + "\"], \"Hash\": \"" + testBlockid41 + "\"}"), nil
+		}
+		return blocks[0], nil
+	}
+	_, err := getBlockFromRPC(380640)
+	if !errors.Is(err, ErrBlockParse) || !strings.Contains(err.Error(), "doesn't match") {
+		t.Fatal("getBlockFromRPC unexpected result for mismatched hash:", err)
+	}
+}
+
+func TestVerifyBlocks(t *testing.T) {
+	testT = t
+	RawRequest = verifyBlocksStub
+	defer func() { VerifyBlocks = false }()
+
+	// Flip a bit in the header nonce (the solution is then invalid);
+	// the block is a JSON string, so skip the opening quote.
+	badBlock := bytes.Clone(blocks[0])
+	nonceOffset := 1 + 2*108
+	if badBlock[nonceOffset] == '0' {
+		badBlock[nonceOffset] = '1'
+	} else {
+		badBlock[nonceOffset] = '0'
+	}
+
+	VerifyBlocks = false
+	verifyBlock = badBlock
+	if _, err := getBlockFromRPC(380640); err != nil {
+		t.Fatal("getBlockFromRPC failed:", err)
+	}
+	VerifyBlocks = true
+	verifyBlock = blocks[0]
+	if _, err := getBlockFromRPC(380640); err != nil {
+		t.Fatal("getBlockFromRPC failed:", err)
+	}
+	verifyBlock = badBlock
+	_, err := getBlockFromRPC(380640)
+	if err == nil || !strings.Contains(err.Error(), "failed verification") {
+		t.Fatal("getBlockFromRPC unexpected result for invalid block:", err)
+	}
+}
+
+func TestMinBlockVersion(t *testing.T) {
+	testT = t
+	RawRequest = verifyBlocksStub
+	verifyBlock = blocks[0]
+	defer func() { MinBlockVersion = 4 }()
+
+	// The test blocks are version 4.
+	MinBlockVersion = 4
+	if _, err := getBlockFromRPC(380640); err != nil {
+		t.Fatal("getBlockFromRPC failed:", err)
+	}
+	MinBlockVersion = 5
+	_, err := getBlockFromRPC(380640)
+	if err == nil || !strings.Contains(err.Error(), "below the minimum supported version") {
+		t.Fatal("getBlockFromRPC unexpected result for old block version:", err)
+	}
+}
+
+func TestUnsupportedBlocksMetric(t *testing.T) {
+	testT = t
+	RawRequest = verifyBlocksStub
+
+	// The second test compact block (289461) has Sapling transactions.
+	var compactTests []struct {
+		Full string `json:"full"`
+	}
+	blockJSON, err := os.ReadFile("../testdata/compact_blocks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(blockJSON, &compactTests); err != nil {
+		t.Fatal(err)
+	}
+	verifyBlock, _ = json.Marshal(compactTests[1].Full)
+
+	m := &dto.Metric{}
+	count := func() float64 {
+		if err := unsupportedBlocksTotal.WithLabelValues("sapling").Write(m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetCounter().GetValue()
+	}
+	before := count()
+	if _, err = getBlockFromRPC(380640); err == nil {
+		t.Fatal("getBlockFromRPC unexpected success")
+	}
+	if count() != before+1 {
+		t.Fatal("unsupported block not counted")
+	}
+}
+
 func TestGenerateCerts(t *testing.T) {
 	if GenerateCerts() == nil {
 		t.Fatal("GenerateCerts returned nil")
@@ -695,7 +1825,6 @@ func mempoolStub(method string, params []json.RawMessage) (json.RawMessage, erro
 
 func TestMempoolStream(t *testing.T) {
 	testT = t
-	RawRequest = mempoolStub
 	Time.Sleep = sleepStub
 	Time.Now = nowStub
 	// In real life, wall time is not close to zero, simulate that.
@@ -703,7 +1832,7 @@ func TestMempoolStream(t *testing.T) {
 
 	var replies []*walletrpc.RawTransaction
 	// The first request after startup immediately returns an empty list.
-	err := GetMempool(func(tx *walletrpc.RawTransaction) error {
+	err := GetMempool(RPCFunc(mempoolStub), func(tx *walletrpc.RawTransaction) error {
 		t.Fatal("send to client function called on initial GetMempool call")
 		return nil
 	})
@@ -712,7 +1841,7 @@ func TestMempoolStream(t *testing.T) {
 	}
 
 	// This should return two transactions.
-	err = GetMempool(func(tx *walletrpc.RawTransaction) error {
+	err = GetMempool(RPCFunc(mempoolStub), func(tx *walletrpc.RawTransaction) error {
 		replies = append(replies, tx)
 		return nil
 	})
@@ -808,3 +1937,111 @@ func TestParseRawTransaction(t *testing.T) {
 		t.Errorf("Unmarshalled incorrect height: got: %d, expected: 0.", rt2.Height)
 	}
 }
+
+func TestLogReorgDeep(t *testing.T) {
+	m := &dto.Metric{}
+	count := func() float64 {
+		if err := deepReorgsTotal.Write(m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetCounter().GetValue()
+	}
+	before := count()
+	logReorg(380650, deepReorgDepth-1)
+	if count() != before {
+		t.Fatal("shallow reorg counted as deep")
+	}
+	logReorg(380650, deepReorgDepth)
+	if count() != before+1 {
+		t.Fatal("deep reorg not counted")
+	}
+}
+
+func TestFetchBlock(t *testing.T) {
+	RawRequest = verifyStub(t, 2, func(int, *ZcashRpcReplyGetblock1) {})
+	block, compact, err := FetchBlock(380641)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height, _ := block.CoinbaseHeight(); height != 380641 || compact.Height != 380641 {
+		t.Fatal("unexpected heights", height, compact.Height)
+	}
+	if block.GetEncodableHash() != hash32.T(compact.Hash) {
+		t.Fatal("full and compact blocks differ")
+	}
+
+	// Not yet mined
+	block, compact, err = FetchBlock(380642)
+	if block != nil || compact != nil || err != nil {
+		t.Fatal("unexpected result for a future block", block, compact, err)
+	}
+
+	// A block that can't be parsed
+	RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		if string(params[1]) == "1" {
+			return json.RawMessage(`{"hash": "` + strings.Repeat("00", 32) + `", "tx": []}`), nil
+		}
+		return json.RawMessage(`"0400"`), nil
+	}
+	if _, _, err := FetchBlock(380640); !errors.Is(err, ErrBlockParse) {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+// The ingestor catches up with windows of blocks, then follows the tip one
+// block at a time, syncing the cache after each; if it falls behind while
+// following the tip, it goes back to catching up.
+func TestIngestState(t *testing.T) {
+	testT = t
+	Time.Sleep = sleepStub
+	Time.Now = nowStub
+	defer func() { sleepCount, sleepDuration = 0, 0 }()
+	savedWorkers, savedWindow, savedDistance := IngestWorkers, IngestWindow, IngestTipDistance
+	defer func() { IngestWorkers, IngestWindow, IngestTipDistance = savedWorkers, savedWindow, savedDistance }()
+	IngestWorkers, IngestWindow, IngestTipDistance = 2, 2, 1
+
+	cache := NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+	defer cache.Close()
+	chain := forkTestChain("a", 4)
+	reps, infoRequests := 0, 0
+	var policies []SyncPolicy
+	serve := chainStub(func() testChain {
+		// Two blocks at first, then two more.
+		if reps <= 2 {
+			return testChain{name: chain.name, blocks: chain.blocks[:2]}
+		}
+		return chain
+	})
+	RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		switch method {
+		case "getbestblockhash":
+			reps++
+			policies = append(policies, cache.syncPolicy)
+		case "getblockchaininfo":
+			infoRequests++
+			return json.Marshal(&ZcashdRpcReplyGetblockchaininfo{Blocks: 380641})
+		}
+		return serve(method, params)
+	}
+	// 1: window of two blocks, now at the tip; 2: synced; 3, 4: one block
+	// each, the second puts it more than one block from being synced, so
+	// it's catching up again; 5: synced.
+	BlockIngestor(RPCFunc(RawRequest), cache, 5)
+	if cache.GetNextHeight() != 380644 {
+		t.Fatal("unexpected next height", cache.GetNextHeight())
+	}
+	expected := []SyncPolicy{SyncNever, SyncAlways, SyncAlways, SyncAlways, SyncNever}
+	if !slices.Equal(policies, expected) {
+		t.Fatal("unexpected sync policies", policies)
+	}
+	if infoRequests != 1 {
+		t.Fatal("windows fetched while following the tip", infoRequests)
+	}
+	if metricValue(t, ingestStateGauge.WithLabelValues("tip")) != 1 ||
+		metricValue(t, ingestStateGauge.WithLabelValues("bulk")) != 0 {
+		t.Fatal("unexpected state metrics")
+	}
+	if cache.syncPolicy != CacheSyncPolicy {
+		t.Fatal("sync policy not restored")
+	}
+}
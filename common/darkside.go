@@ -8,12 +8,17 @@ package common
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"os"
 	"slices"
 	"strconv"
 	"strings"
@@ -33,8 +38,9 @@ type darksideState struct {
 	cache       *BlockCache
 
 	// This is the highest (latest) block height currently being presented
-	// by the mock zcashd.
+	// by the mock zcashd, and its hash (nil if there are no blocks).
 	latestHeight int
+	latestHash   hash32.T
 
 	// Size of the Sapling commitment tree as of `startHeight - 1`.
 	// Juno Cash: Always 0 (Sapling not supported).
@@ -60,6 +66,11 @@ type darksideState struct {
 	// activeBlocks by ApplyStaged() (and this list then cleared).
 	stagedTransactions []stagedTx
 
+	// These transactions come from StageMempoolTx(); they're in the mock
+	// zcashd's mempool until ApplyStaged() makes a block containing them
+	// active.
+	mempoolTransactions [][]byte
+
 	// Unordered list of replies
 	getAddressUtxos []ZcashdRpcReplyGetaddressutxos
 
@@ -73,6 +84,26 @@ type darksideState struct {
 	// Cache of artificial z_getsubtreebyindex subtree entries,
 	// indexed by protocol (currently, sapling (0) or orchard (1)).
 	subtrees map[walletrpc.ShieldedProtocol]darksideProtocolSubtreeRoots
+
+	// Candidate branches (competing forks) staged by StageBranch(), indexed
+	// by name; ActivateBranch() makes one the active chain from its first
+	// block's height up.
+	branches map[string][][]byte
+
+	// Transaction ids assigned by StageTxWithID(), indexed by the double
+	// SHA-256 of the transaction; see darksideSetTxID.
+	txids map[hash32.T]hash32.T
+
+	// Counts of what darkside has done since Reset(), see DarksideGetStats.
+	stats DarksideStats
+
+	// Fault injection, set by SetLatency() and SetErrorRate(): each request
+	// to the mock zcashd is delayed by latency, and the fraction errorRate
+	// of them fail; errorCredit accumulates errorRate per request, and a
+	// request fails when it reaches 1.
+	latency     time.Duration
+	errorRate   float64
+	errorCredit float64
 }
 
 var state darksideState
@@ -86,6 +117,8 @@ type activeBlock struct {
 	bytes           []byte
 	saplingTreeSize uint32 // Juno Cash: Always 0 (Sapling not supported)
 	orchardTreeSize uint32
+	malformation    DarksideMalformation // how the block is served by getblock
+	gap             bool                 // getblock fails, see DarksideStageGap
 }
 
 type stagedTx struct {
@@ -118,14 +151,18 @@ type darksideProtocolSubtreeRoots struct {
 // the command line.
 var DarksideEnabled bool
 
+// darksideSetTxID sets the transaction's id to the one assigned by
+// StageTxWithID(), if any, otherwise to its SHA256d. That's correct for V4
+// transactions, but not for V5, but in this test environment, it's harmless
+// (the incorrect txid calculation can't be detected), and it's
+// deterministic. The caller must hold the mutex.
 func darksideSetTxID(tx *parser.Transaction) {
-	// SHA256d
-	// This is correct for V4 transactions, but not for V5, but in this test
-	// environment, it's harmless (the incorrect txid calculation can't be
-	// detected). This will be fixed when lightwalletd calculates txids correctly .
-	digest := sha256.Sum256(tx.Bytes())
-	digest = sha256.Sum256(digest[:])
-	tx.SetTxID(hash32.T(digest))
+	hash := hash32.Sum256d(tx.Bytes())
+	if txid, ok := state.txids[hash]; ok {
+		tx.SetTxID(txid)
+		return
+	}
+	tx.SetTxID(hash)
 }
 
 func darksideSetBlockTxID(block *parser.Block) {
@@ -152,6 +189,12 @@ func DarksideReset(sa int, bi, cn string, sst, sot uint32) error {
 	Log.Info("DarksideReset(orchardActivation=", sa, ")")
 	mutex.Lock()
 	defer mutex.Unlock()
+	resetState(sa, bi, cn, sst, sot)
+	return nil
+}
+
+// resetState is the body of DarksideReset; the caller must hold the mutex.
+func resetState(sa int, bi, cn string, sst, sot uint32) {
 	stopIngestor()
 	state = darksideState{
 		resetted:               true,
@@ -166,12 +209,14 @@ func DarksideReset(sa int, bi, cn string, sst, sot uint32) error {
 		stagedBlocks:           make([][]byte, 0),
 		incomingTransactions:   make([][]byte, 0),
 		stagedTransactions:     make([]stagedTx, 0),
+		mempoolTransactions:    make([][]byte, 0),
 		stagedTreeStates:       make(map[uint64]*DarksideTreeState),
 		stagedTreeStatesByHash: make(map[string]*DarksideTreeState),
 		subtrees:               make(map[walletrpc.ShieldedProtocol]darksideProtocolSubtreeRoots),
+		txids:                  make(map[hash32.T]hash32.T),
+		branches:               make(map[string][][]byte),
 	}
 	state.cache.Reset(sa)
-	return nil
 }
 
 // DarksideAddBlock adds a single block to the active blocks list.
@@ -228,11 +273,41 @@ func addBlockActive(blockBytes []byte) error {
 	return nil
 }
 
+// removeMinedMempoolTxs removes the mempool transactions that are now in
+// active blocks.
+func removeMinedMempoolTxs() {
+	if len(state.mempoolTransactions) == 0 {
+		return
+	}
+	mined := make(map[hash32.T]bool)
+	for _, b := range state.activeBlocks {
+		block := parser.NewBlock()
+		block.ParseFromSlice(b.bytes)
+		for _, tx := range block.Transactions() {
+			mined[hash32.Sum256d(tx.Bytes())] = true
+		}
+	}
+	state.mempoolTransactions = slices.DeleteFunc(state.mempoolTransactions, func(txBytes []byte) bool {
+		return mined[hash32.Sum256d(txBytes)]
+	})
+}
+
+// activeBlockHash returns the hash of the active block at the given index.
+func activeBlockHash(index int) hash32.T {
+	block := parser.NewBlock()
+	block.ParseFromSlice(state.activeBlocks[index].bytes)
+	return block.GetEncodableHash()
+}
+
 // Set missing prev hashes of the blocks in the active chain
 func setPrevhash() {
 	prevhash := hash32.Nil
 	for _, activeBlock := range state.activeBlocks {
-		// Set this block's prevhash.
+		// Set this block's prevhash before parsing it, since its hash (the
+		// next block's prevhash) depends on it.
+		if prevhash != hash32.Nil {
+			copy(activeBlock.bytes[4:4+32], prevhash[:])
+		}
 		block := parser.NewBlock()
 		rest, err := block.ParseFromSlice(activeBlock.bytes)
 		if err != nil {
@@ -241,9 +316,6 @@ func setPrevhash() {
 		if len(rest) != 0 {
 			Log.Fatal(errors.New("block is too long"))
 		}
-		if prevhash != hash32.Nil {
-			copy(activeBlock.bytes[4:4+32], prevhash[:])
-		}
 		prevhash = block.GetEncodableHash()
 		Log.Info("Darkside active block height ", block.GetHeight(), " hash ",
 			block.GetDisplayHashString(),
@@ -265,6 +337,116 @@ func DarksideApplyStaged(height int) error {
 		return errors.New(fmt.Sprint("height ", height,
 			" is less than activation height ", state.startHeight))
 	}
+	return applyStaged(height)
+}
+
+// DarksideReorg drops the active blocks from the given height up, then
+// applies the staged blocks and transactions (as DarksideApplyStaged does)
+// as the new branch, and presents its tip as the latest block. If nothing
+// is staged, the chain is just rewound. Either way, the block ingestor
+// sees a reorg.
+func DarksideReorg(height int) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !state.resetted {
+		return errors.New("please call Reset first")
+	}
+	Log.Info("DarksideReorg(height=", height, ")")
+	if height < state.startHeight || height > state.startHeight+len(state.activeBlocks) {
+		return errors.New(fmt.Sprint("reorg height ", height, " is outside the active blocks ",
+			state.startHeight, " to ", state.startHeight+len(state.activeBlocks)-1))
+	}
+	state.activeBlocks = state.activeBlocks[:height-state.startHeight]
+	return applyStaged(math.MaxInt)
+}
+
+// DarksideStageBranch saves the given blocks, which must be at consecutive
+// heights, as the named candidate branch (replacing any branch with that
+// name), for DarksideActivateBranch. Like staged blocks, they needn't link
+// to each other or to the chain; their prevhashes are set on activation.
+func DarksideStageBranch(name string, blocks [][]byte) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !state.resetted {
+		return errors.New("please call Reset first")
+	}
+	Log.Info("DarksideStageBranch(name=", name, ", blocks=", len(blocks), ")")
+	if name == "" || len(blocks) == 0 {
+		return errors.New("a branch needs a name and at least one block")
+	}
+	var branch [][]byte
+	var first int
+	for i, blockBytes := range blocks {
+		block := parser.NewBlock()
+		rest, err := block.ParseFromSlice(blockBytes)
+		if err != nil {
+			return err
+		}
+		if len(rest) != 0 {
+			return errors.New("block serialization is too long")
+		}
+		if i == 0 {
+			first = block.GetHeight()
+			if first < state.startHeight {
+				return errors.New(fmt.Sprint("block height ", first,
+					" is less than activation height ", state.startHeight))
+			}
+		} else if block.GetHeight() != first+i {
+			return errors.New(fmt.Sprint("branch block at height ", block.GetHeight(),
+				" doesn't follow the one at ", first+i-1))
+		}
+		branch = append(branch, bytes.Clone(blockBytes))
+	}
+	state.branches[name] = branch
+	state.stats.BlocksStaged += len(branch)
+	return nil
+}
+
+// DarksideActivateBranch makes the named branch (see DarksideStageBranch)
+// the active chain from its first block's height up, and presents its tip
+// as the latest block, as DarksideReorg does with staged blocks (anything
+// staged is applied too, after the branch). The branch's first block must
+// be at most one above the active chain's tip. A fork of any depth can be
+// simulated, and switching back to an earlier branch returns the chain to
+// it.
+func DarksideActivateBranch(name string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !state.resetted {
+		return errors.New("please call Reset first")
+	}
+	Log.Info("DarksideActivateBranch(name=", name, ")")
+	branch, ok := state.branches[name]
+	if !ok {
+		return errors.New(fmt.Sprint("no branch named ", name))
+	}
+	height := firstHeight(branch)
+	if height > state.startHeight+len(state.activeBlocks) {
+		return errors.New(fmt.Sprint("branch ", name, " starts at height ", height,
+			", above the active blocks ", state.startHeight, " to ",
+			state.startHeight+len(state.activeBlocks)-1))
+	}
+	// The active blocks are modified in place (see setPrevhash), so they
+	// must not share the branch's.
+	var blocks [][]byte
+	for _, blockBytes := range branch {
+		blocks = append(blocks, bytes.Clone(blockBytes))
+	}
+	state.activeBlocks = state.activeBlocks[:height-state.startHeight]
+	state.stagedBlocks = append(blocks, state.stagedBlocks...)
+	return applyStaged(math.MaxInt)
+}
+
+// firstHeight returns the height of the first of the given blocks.
+func firstHeight(blocks [][]byte) int {
+	block := parser.NewBlock()
+	block.ParseFromSlice(blocks[0])
+	return block.GetHeight()
+}
+
+// applyStaged is the body of DarksideApplyStaged; the caller must hold the
+// mutex.
+func applyStaged(height int) error {
 	// Move the staged blocks into active list
 	stagedBlocks := state.stagedBlocks
 	state.stagedBlocks = nil
@@ -329,7 +511,15 @@ func DarksideApplyStaged(height int) error {
 		height = maxHeight
 	}
 	setPrevhash()
+	removeMinedMempoolTxs()
+	if state.latestHash != hash32.Nil {
+		index := state.latestHeight - state.startHeight
+		if index >= len(state.activeBlocks) || activeBlockHash(index) != state.latestHash {
+			state.stats.Reorgs++
+		}
+	}
 	state.latestHeight = height
+	state.latestHash = activeBlockHash(height - state.startHeight)
 	Log.Info("darkside: active blocks from ", state.startHeight,
 		" to ", state.startHeight+len(state.activeBlocks)-1,
 		", latest presented height ", state.latestHeight)
@@ -343,6 +533,144 @@ func DarksideApplyStaged(height int) error {
 	return nil
 }
 
+// DarksideMalformation is a way of corrupting a block's Orchard actions, to
+// test how lightwalletd handles blocks it can't parse.
+type DarksideMalformation int
+
+const (
+	DarksideWellFormed DarksideMalformation = iota
+	// DarksideTruncatedCiphertext ends the block partway through the
+	// encrypted ciphertext of the first Orchard action.
+	DarksideTruncatedCiphertext
+	// DarksideOversizedActionCount sets the number of Orchard actions to
+	// 2^16, more than a transaction can have.
+	DarksideOversizedActionCount
+)
+
+func (m DarksideMalformation) String() string {
+	switch m {
+	case DarksideWellFormed:
+		return "well-formed"
+	case DarksideTruncatedCiphertext:
+		return "truncated ciphertext"
+	case DarksideOversizedActionCount:
+		return "oversized action count"
+	}
+	return fmt.Sprint("malformation ", int(m))
+}
+
+// DarksideMalformBlock returns a copy of the given block with the first
+// transaction that has Orchard actions corrupted as specified.
+func DarksideMalformBlock(blockBytes []byte, how DarksideMalformation) ([]byte, error) {
+	block := parser.NewBlock()
+	rest, err := block.ParseFromSlice(blockBytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("block serialization is too long")
+	}
+	// The transactions are at the end of the block.
+	txs := block.Transactions()
+	start := len(blockBytes)
+	for _, tx := range txs {
+		start -= len(tx.Bytes())
+	}
+	for _, tx := range txs {
+		end := start + len(tx.Bytes())
+		actions := tx.OrchardActionsCount()
+		if actions == 0 {
+			start = end
+			continue
+		}
+		// The Orchard bundle ends a v5 transaction; it begins with the
+		// (compact size) number of actions, then the actions, each of which
+		// begins with cv, nullifier, rk, cmx and ephemeralKey (32 bytes
+		// each), then the 580-byte encCiphertext.
+		bundle := end - tx.OrchardBundleSize()
+		countSize := 1
+		if actions >= 253 {
+			countSize = 3
+		}
+		switch how {
+		case DarksideTruncatedCiphertext:
+			return bytes.Clone(blockBytes[:bundle+countSize+5*32+580/2]), nil
+		case DarksideOversizedActionCount:
+			malformed := bytes.Clone(blockBytes[:bundle])
+			malformed = append(malformed, 0xfe, 0, 0, 1, 0)
+			return append(malformed, blockBytes[bundle+countSize:]...), nil
+		}
+		return nil, errors.New(fmt.Sprint("unknown malformation ", int(how)))
+	}
+	return nil, errors.New("block has no Orchard actions")
+}
+
+// DarksideSetMalformation makes the mock zcashd serve the active block at
+// the given height malformed as specified (see DarksideMalformBlock), or,
+// with DarksideWellFormed, correctly again. Only the served block is
+// changed; its hash (as reported by the mock zcashd) is that of the
+// correct block. It lasts until the block is replaced by ApplyStaged.
+func DarksideSetMalformation(height int, how DarksideMalformation) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !state.resetted {
+		return errors.New("please call Reset first")
+	}
+	Log.Info("DarksideSetMalformation(height=", height, ", ", how, ")")
+	index := height - state.startHeight
+	if index < 0 || index >= len(state.activeBlocks) {
+		return errors.New(fmt.Sprint("no active block at height ", height))
+	}
+	if how != DarksideWellFormed {
+		if _, err := DarksideMalformBlock(state.activeBlocks[index].bytes, how); err != nil {
+			return err
+		}
+	}
+	state.activeBlocks[index].malformation = how
+	return nil
+}
+
+// DarksideStageGap makes the mock zcashd fail to serve the active block at
+// the given height (getblock returns an error, as zcashd does for a block
+// it doesn't have on disk), leaving a gap in an otherwise-continuous chain,
+// to test how lightwalletd and wallets handle a height that can't be
+// served. It takes effect at once, and lasts until the block is replaced by
+// ApplyStaged (staging the same block again fills the gap).
+func DarksideStageGap(height int) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !state.resetted {
+		return errors.New("please call Reset first")
+	}
+	Log.Info("DarksideStageGap(height=", height, ")")
+	index := height - state.startHeight
+	if index < 0 || index >= len(state.activeBlocks) {
+		return errors.New(fmt.Sprint("no active block at height ", height))
+	}
+	state.activeBlocks[index].gap = true
+	return nil
+}
+
+// DarksideStats are counts of what the darkside mock has done since the
+// last Reset, so a test can check that the harness did what it expected
+// (these complement lightwalletd's own metrics, which show what the
+// ingestor made of it).
+type DarksideStats struct {
+	BlocksStaged       int // by the Stage*() functions, including branches
+	TransactionsStaged int // to be mined, not including the mempool's
+	MempoolTxs         int // added to the mempool by StageMempoolTx()
+	Reorgs             int // applies that replaced or removed the latest presented block
+	InjectedErrors     int // requests failed by SetErrorRate()
+}
+
+// DarksideGetStats returns the counts of what darkside has done since the
+// last Reset.
+func DarksideGetStats() DarksideStats {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return state.stats
+}
+
 // DarksideGetIncomingTransactions returns all transactions we're
 // received via SendTransaction().
 func DarksideGetIncomingTransactions() [][]byte {
@@ -370,6 +698,7 @@ func darksideStageBlock(caller string, b []byte) error {
 			" is less than activation height ", state.startHeight))
 	}
 	state.stagedBlocks = append(state.stagedBlocks, b)
+	state.stats.BlocksStaged++
 	return nil
 }
 
@@ -387,9 +716,33 @@ func DarksideStageBlocks(url string) error {
 		return err
 	}
 	defer resp.Body.Close()
+	return stageBlocksFrom("DarksideStageBlocks", resp.Body)
+}
+
+// DarksideStageBlocksFile is DarksideStageBlocks for a local file of hex
+// blocks, one per line, such as testdata/blocks; it's for tests that run
+// darkside in-process rather than through the gRPC interface.
+func DarksideStageBlocksFile(name string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !state.resetted {
+		return errors.New("please call Reset first")
+	}
+	Log.Info("DarksideStageBlocksFile(name=", name, ")")
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return stageBlocksFrom("DarksideStageBlocksFile", f)
+}
+
+// stageBlocksFrom stages the hex blocks read from r, one per line. The
+// caller must hold the mutex.
+func stageBlocksFrom(caller string, r io.Reader) error {
 	// some blocks are too large, especially when encoded in hex, for the
 	// default buffer size, so set up a larger one; 8mb should be enough.
-	scan := bufio.NewScanner(resp.Body)
+	scan := bufio.NewScanner(r)
 	var scanbuf []byte
 	scan.Buffer(scanbuf, 8*1000*1000)
 	for scan.Scan() { // each line (block)
@@ -402,7 +755,7 @@ func DarksideStageBlocks(url string) error {
 		if err != nil {
 			return err
 		}
-		if err = darksideStageBlock("DarksideStageBlocks", blockBytes); err != nil {
+		if err = darksideStageBlock(caller, blockBytes); err != nil {
 			return err
 		}
 	}
@@ -487,6 +840,34 @@ func DarksideStageBlocksCreate(height int32, nonce int32, count int32) error {
 	return nil
 }
 
+// DarksideStageBlockWithTime sets the header time (Unix epoch seconds) and
+// nBits (compact difficulty target) of the staged block at the given height,
+// the most recently staged one if there are several. The values aren't
+// checked (against the neighbouring blocks, or the block's proof of work).
+func DarksideStageBlockWithTime(height int, blockTime, bits uint32) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !state.resetted {
+		return errors.New("please call Reset first")
+	}
+	Log.Info("DarksideStageBlockWithTime(height=", height, ", time=", blockTime, ", bits=", bits, ")")
+	for i := len(state.stagedBlocks) - 1; i >= 0; i-- {
+		block := parser.NewBlock()
+		block.ParseFromSlice(state.stagedBlocks[i])
+		if block.GetHeight() != height {
+			continue
+		}
+		// The header time starts at byte 100, nBits at 104 (see
+		// DarksideStageBlocksCreate).
+		b := bytes.Clone(state.stagedBlocks[i])
+		binary.LittleEndian.PutUint32(b[100:], blockTime)
+		binary.LittleEndian.PutUint32(b[104:], bits)
+		state.stagedBlocks[i] = b
+		return nil
+	}
+	return errors.New(fmt.Sprint("no staged block at height ", height))
+}
+
 // DarksideClearIncomingTransactions empties the incoming transaction list.
 func DarksideClearIncomingTransactions() {
 	mutex.Lock()
@@ -494,7 +875,63 @@ func DarksideClearIncomingTransactions() {
 	mutex.Unlock()
 }
 
+// DarksideSetLatency makes the mock zcashd delay its reply to each request
+// by d (0 to reply at once), to simulate a slow node. Unlike the other
+// requests, this doesn't hold up darkside's gRPCs (or the mutex).
+func DarksideSetLatency(d time.Duration) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !state.resetted {
+		return errors.New("please call Reset first")
+	}
+	Log.Info("DarksideSetLatency(", d, ")")
+	if d < 0 {
+		return errors.New(fmt.Sprint("negative latency ", d))
+	}
+	state.latency = d
+	return nil
+}
+
+// DarksideSetErrorRate makes the mock zcashd fail the fraction p (0 to 1)
+// of requests, to simulate an unreliable node. The failures are spread
+// evenly rather than randomly, so tests are deterministic: with p = 0.25,
+// every fourth request fails, and with p = 1, every request does.
+func DarksideSetErrorRate(p float64) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !state.resetted {
+		return errors.New("please call Reset first")
+	}
+	Log.Info("DarksideSetErrorRate(", p, ")")
+	if !(p >= 0 && p <= 1) {
+		return errors.New(fmt.Sprint("error rate ", p, " is not between 0 and 1"))
+	}
+	state.errorRate = p
+	state.errorCredit = 0
+	return nil
+}
+
+// darksideRawRequest is the mock zcashd's RawRequest; it injects the
+// latency and errors set by DarksideSetLatency and DarksideSetErrorRate,
+// then serves the request.
 func darksideRawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	mutex.Lock()
+	latency := state.latency
+	state.errorCredit += state.errorRate
+	fail := state.errorCredit >= 1
+	if fail {
+		state.errorCredit--
+		state.stats.InjectedErrors++
+	}
+	mutex.Unlock()
+	Time.Sleep(latency)
+	if fail {
+		return nil, errors.New(fmt.Sprint("darkside: injected ", method, " rpc error"))
+	}
+	return darksideServeRequest(method, params)
+}
+
+func darksideServeRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
 	mutex.Lock()
 	defer mutex.Unlock()
 	switch method {
@@ -560,29 +997,31 @@ func darksideRawRequest(method string, params []json.RawMessage) (json.RawMessag
 				// iterating the activeBlocks list.
 				blockIndex = state.cacheBlockIndex
 			} else {
-				var b *activeBlock
-				for blockIndex, b = range state.activeBlocks {
+				blockIndex = slices.IndexFunc(state.activeBlocks, func(b *activeBlock) bool {
 					block := parser.NewBlock()
 					block.ParseFromSlice(b.bytes)
-					if heightOrHashStr == block.GetDisplayHashString() {
-						break
-					}
-				}
-				if blockIndex >= len(state.activeBlocks) {
+					return heightOrHashStr == block.GetDisplayHashString()
+				})
+				if blockIndex < 0 {
 					return nil, errors.New(fmt.Sprint("getblock: hash ", heightOrHashStr,
 						" not found"))
 				}
 			}
 		}
+		if state.activeBlocks[blockIndex].gap {
+			return nil, errors.New(fmt.Sprint("-1: Block ", state.startHeight+blockIndex,
+				" not found on disk"))
+		}
 		if len(params) > 1 && string(params[1]) == "1" {
 			// verbose mode, all that's currently needed is txid
 			block := parser.NewBlock()
 			block.ParseFromSlice(state.activeBlocks[blockIndex].bytes)
 			darksideSetBlockTxID(block)
 			var r struct {
-				Tx    []string `json:"tx"`
-				Hash  string   `json:"hash"`
-				Trees struct {
+				Tx     []string `json:"tx"`
+				Hash   string   `json:"hash"`
+				Height int      `json:"height"`
+				Trees  struct {
 					Sapling struct {
 						Size uint32
 					}
@@ -596,17 +1035,25 @@ func darksideRawRequest(method string, params []json.RawMessage) (json.RawMessag
 				r.Tx = append(r.Tx, tx.GetDisplayHashString())
 			}
 			r.Hash = block.GetDisplayHashString()
+			r.Height = state.startHeight + blockIndex
 			r.Trees.Sapling.Size = state.activeBlocks[blockIndex].saplingTreeSize
 			r.Trees.Orchard.Size = state.activeBlocks[blockIndex].orchardTreeSize
 			state.cacheBlockHash = r.Hash
 			state.cacheBlockIndex = blockIndex
 			return json.Marshal(r)
 		}
-		return json.Marshal(hex.EncodeToString(state.activeBlocks[blockIndex].bytes))
+		blockBytes := state.activeBlocks[blockIndex].bytes
+		if how := state.activeBlocks[blockIndex].malformation; how != DarksideWellFormed {
+			// This was checked by DarksideSetMalformation.
+			blockBytes, _ = DarksideMalformBlock(blockBytes, how)
+		}
+		return json.Marshal(hex.EncodeToString(blockBytes))
 
 	case "getbestblockhash":
 		if len(state.activeBlocks) == 0 {
-			Log.Fatal("getbestblockhash: no blocks")
+			// A request the ingestor abandoned (when it was stopped by
+			// Reset) can arrive after the blocks are gone.
+			return nil, errors.New("getbestblockhash: no blocks")
 		}
 		index := state.latestHeight - state.startHeight
 		block := parser.NewBlock()
@@ -662,6 +1109,9 @@ func darksideRawRequest(method string, params []json.RawMessage) (json.RawMessag
 		for _, tx := range state.stagedTransactions {
 			addTxToReply(tx.bytes)
 		}
+		for _, txBytes := range state.mempoolTransactions {
+			addTxToReply(txBytes)
+		}
 		return json.Marshal(reply)
 
 	case "getaddressutxos":
@@ -828,6 +1278,14 @@ func darksideGetRawTransaction(params []json.RawMessage) (json.RawMessage, error
 			return marshalReply(tx, 0), nil
 		}
 	}
+	for _, txBytes := range state.mempoolTransactions {
+		tx := parser.NewTransaction()
+		_, _ = tx.ParseFromSlice(txBytes)
+		darksideSetTxID(tx)
+		if tx.GetDisplayHashString() == txidBigEndian {
+			return marshalReply(tx, 0), nil
+		}
+	}
 	return nil, errors.New("-5: No information available about transaction")
 }
 
@@ -852,6 +1310,7 @@ func stageTransaction(height int, txBytes []byte) error {
 			orchardActions: tx.OrchardActionsCount(),
 			bytes:          txBytes,
 		})
+	state.stats.TransactionsStaged++
 	return nil
 }
 
@@ -862,6 +1321,57 @@ func DarksideStageTransaction(height int, txBytes []byte) error {
 	return stageTransaction(height, txBytes)
 }
 
+// DarksideStageTxWithID is DarksideStageTransaction, except that the mock
+// zcashd reports the given txid (little-endian, as in CompactTx.txid) for
+// the transaction, wherever it appears, instead of its SHA256d, so wallet
+// tests can use the real ids of V5 transactions. If the transaction's id
+// can be computed locally (see parser.Transaction.ComputeTxID), the given
+// txid must match it.
+func DarksideStageTxWithID(height int, txBytes []byte, txid hash32.T) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !state.resetted {
+		return errors.New("please call Reset first")
+	}
+	Log.Info("DarksideStageTxWithID(height=", height, ", txid=", hash32.Encode(hash32.Reverse(txid)), ")")
+	tx := parser.NewTransaction()
+	if _, err := tx.ParseFromSlice(txBytes); err != nil {
+		return err
+	}
+	if computed, ok := tx.ComputeTxID(); ok && computed != txid {
+		return errors.New(fmt.Sprint("txid ", hash32.Encode(hash32.Reverse(txid)),
+			" doesn't match the transaction's id ", hash32.Encode(hash32.Reverse(computed))))
+	}
+	if err := stageTransaction(height, txBytes); err != nil {
+		return err
+	}
+	state.txids[hash32.Sum256d(txBytes)] = txid
+	return nil
+}
+
+// DarksideStageMempoolTx adds the given transaction to the mock zcashd's
+// mempool, where it stays until it's mined, that is, until ApplyStaged()
+// makes a block containing it active.
+func DarksideStageMempoolTx(txBytes []byte) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !state.resetted {
+		return errors.New("please call Reset first")
+	}
+	Log.Info("DarksideStageMempoolTx()")
+	tx := parser.NewTransaction()
+	rest, err := tx.ParseFromSlice(txBytes)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return errors.New("transaction serialization is too long")
+	}
+	state.mempoolTransactions = append(state.mempoolTransactions, txBytes)
+	state.stats.MempoolTxs++
+	return nil
+}
+
 // DarksideStageTransactionsURL reads a list of transactions (hex-encoded, one
 // per line) from the given URL, and associates them with the given height.
 func DarksideStageTransactionsURL(height int, url string) error {
@@ -0,0 +1,210 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/zcash/lightwalletd/hash32"
+)
+
+// A darkside snapshot, the chain served by the mock zcashd, is:
+//
+//	darksideSnapshotMagic (8 bytes, includes the format version)
+//	chain name length (1 byte), chain name
+//	branch ID length (1 byte), branch ID
+//	activation (start) height, latest height (uint64 little-endian each)
+//	start Sapling and Orchard tree sizes (uint32 little-endian each)
+//	number of blocks (uint64 little-endian)
+//	for each block: checksum (8 bytes), Sapling and Orchard tree sizes
+//	    (uint32 little-endian each), malformation (1 byte), gap (1 byte),
+//	    length (uint32 little-endian), raw block
+//	number of assigned txids (uint64 little-endian)
+//	for each: transaction SHA256d, txid (32 bytes each; see darksideSetTxID)
+//
+// Staged blocks and transactions, the mempool, and the transactions sent by
+// wallets aren't included.
+var darksideSnapshotMagic = []byte("lwddark3")
+
+// DarksideSaveSnapshot writes the chain the mock zcashd is serving to the
+// named file, so a test suite can set up a scenario once and restore it
+// (with DarksideLoadSnapshot) for each test. It pairs with the cache
+// snapshot (see BlockCache.ExportSnapshot).
+func DarksideSaveSnapshot(name string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !state.resetted {
+		return errors.New("please call Reset first")
+	}
+	Log.Info("DarksideSaveSnapshot(name=", name, ")")
+	if len(state.activeBlocks) == 0 {
+		return errors.New("no active blocks to snapshot")
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := writeDarksideSnapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeDarksideSnapshot writes the served chain to w; the caller must hold
+// the mutex.
+func writeDarksideSnapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	header := append([]byte(nil), darksideSnapshotMagic...)
+	header = append(header, byte(len(state.chainName)))
+	header = append(header, state.chainName...)
+	header = append(header, byte(len(state.branchID)))
+	header = append(header, state.branchID...)
+	header = binary.LittleEndian.AppendUint64(header, uint64(state.startHeight))
+	header = binary.LittleEndian.AppendUint64(header, uint64(state.latestHeight))
+	header = binary.LittleEndian.AppendUint32(header, state.startSaplingTreeSize)
+	header = binary.LittleEndian.AppendUint32(header, state.startOrchardTreeSize)
+	header = binary.LittleEndian.AppendUint64(header, uint64(len(state.activeBlocks)))
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+	for i, b := range state.activeBlocks {
+		entry := checksum(state.startHeight+i, b.bytes)
+		entry = binary.LittleEndian.AppendUint32(entry, b.saplingTreeSize)
+		entry = binary.LittleEndian.AppendUint32(entry, b.orchardTreeSize)
+		entry = append(entry, byte(b.malformation))
+		if b.gap {
+			entry = append(entry, 1)
+		} else {
+			entry = append(entry, 0)
+		}
+		entry = binary.LittleEndian.AppendUint32(entry, uint32(len(b.bytes)))
+		if _, err := bw.Write(append(entry, b.bytes...)); err != nil {
+			return err
+		}
+	}
+	txids := binary.LittleEndian.AppendUint64(nil, uint64(len(state.txids)))
+	for hash, txid := range state.txids {
+		txids = append(append(txids, hash[:]...), txid[:]...)
+	}
+	if _, err := bw.Write(txids); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// DarksideLoadSnapshot replaces the mock zcashd's state with the chain in
+// the named file (written by DarksideSaveSnapshot). Like DarksideReset, it
+// clears everything else (including the cache), so it needn't be preceded
+// by a Reset; the block ingestor then syncs the restored chain.
+func DarksideLoadSnapshot(name string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	Log.Info("DarksideLoadSnapshot(name=", name, ")")
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return loadDarksideSnapshot(f)
+}
+
+// loadDarksideSnapshot reads a snapshot from r and, if it's valid, makes it
+// the served chain; the caller must hold the mutex.
+func loadDarksideSnapshot(r io.Reader) error {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(darksideSnapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return fmt.Errorf("DarksideLoadSnapshot: reading header: %w", err)
+	}
+	if !bytes.Equal(magic, darksideSnapshotMagic) {
+		return errors.New("DarksideLoadSnapshot: not a darkside snapshot (or unsupported version)")
+	}
+	readString := func() (string, error) {
+		length, err := br.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		s := make([]byte, length)
+		_, err = io.ReadFull(br, s)
+		return string(s), err
+	}
+	chainName, err := readString()
+	if err != nil {
+		return fmt.Errorf("DarksideLoadSnapshot: reading header: %w", err)
+	}
+	branchID, err := readString()
+	if err != nil {
+		return fmt.Errorf("DarksideLoadSnapshot: reading header: %w", err)
+	}
+	fields := make([]byte, 32)
+	if _, err := io.ReadFull(br, fields); err != nil {
+		return fmt.Errorf("DarksideLoadSnapshot: reading header: %w", err)
+	}
+	startHeight := int(binary.LittleEndian.Uint64(fields[0:]))
+	latestHeight := int(binary.LittleEndian.Uint64(fields[8:]))
+	startSaplingTreeSize := binary.LittleEndian.Uint32(fields[16:])
+	startOrchardTreeSize := binary.LittleEndian.Uint32(fields[20:])
+	count := int(binary.LittleEndian.Uint64(fields[24:]))
+	if count == 0 || latestHeight < startHeight || latestHeight >= startHeight+count {
+		return fmt.Errorf("DarksideLoadSnapshot: latest height %d is outside the %d blocks from %d",
+			latestHeight, count, startHeight)
+	}
+
+	var activeBlocks []*activeBlock
+	entryHeader := make([]byte, 22)
+	for height := startHeight; height < startHeight+count; height++ {
+		if _, err := io.ReadFull(br, entryHeader); err != nil {
+			return fmt.Errorf("DarksideLoadSnapshot: block %d: %w", height, err)
+		}
+		length := binary.LittleEndian.Uint32(entryHeader[18:])
+		if length > maxSnapshotBlockLength {
+			return fmt.Errorf("DarksideLoadSnapshot: block %d: bad length %d", height, length)
+		}
+		blockBytes := make([]byte, length)
+		if _, err := io.ReadFull(br, blockBytes); err != nil {
+			return fmt.Errorf("DarksideLoadSnapshot: block %d: %w", height, err)
+		}
+		if !bytes.Equal(checksum(height, blockBytes), entryHeader[:8]) {
+			return fmt.Errorf("DarksideLoadSnapshot: block %d: bad checksum", height)
+		}
+		activeBlocks = append(activeBlocks, &activeBlock{
+			bytes:           blockBytes,
+			saplingTreeSize: binary.LittleEndian.Uint32(entryHeader[8:]),
+			orchardTreeSize: binary.LittleEndian.Uint32(entryHeader[12:]),
+			malformation:    DarksideMalformation(entryHeader[16]),
+			gap:             entryHeader[17] != 0,
+		})
+	}
+
+	if _, err := io.ReadFull(br, fields[:8]); err != nil {
+		return fmt.Errorf("DarksideLoadSnapshot: reading txids: %w", err)
+	}
+	txids := make(map[hash32.T]hash32.T)
+	pair := make([]byte, 64)
+	for range binary.LittleEndian.Uint64(fields[:8]) {
+		if _, err := io.ReadFull(br, pair); err != nil {
+			return fmt.Errorf("DarksideLoadSnapshot: reading txids: %w", err)
+		}
+		txids[hash32.T(pair[:32])] = hash32.T(pair[32:])
+	}
+
+	resetState(startHeight, branchID, chainName, startSaplingTreeSize, startOrchardTreeSize)
+	state.activeBlocks = activeBlocks
+	state.latestHeight = latestHeight
+	state.latestHash = activeBlockHash(latestHeight - startHeight)
+	state.txids = txids
+	Log.Info("darkside: restored active blocks from ", state.startHeight,
+		" to ", state.startHeight+len(state.activeBlocks)-1,
+		", latest presented height ", state.latestHeight)
+	startIngestor(state.cache)
+	return nil
+}
@@ -0,0 +1,791 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zcash/lightwalletd/hash32"
+	"github.com/zcash/lightwalletd/parser"
+	"github.com/zcash/lightwalletd/walletrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// testLatency is the latency TestDarksideFaults injects.
+const testLatency = time.Second
+
+// darksideTest runs the darkside mock node in-process, for tests; it's
+// DarksideInit without the shutdown timer. The returned function waits for
+// the block ingestor to catch up to the mock node's tip (the given next
+// height).
+func darksideTest(t *testing.T) (*BlockCache, func(int)) {
+	// Polls and backoffs take a millisecond, but testLatency is real, so
+	// that requests time out.
+	Time.Sleep = func(d time.Duration) {
+		if d == testLatency {
+			time.Sleep(d)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	Time.Now = nowStub
+	cache := NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+	state.cache = cache
+	RawRequest = darksideRawRequest
+	t.Cleanup(func() {
+		mutex.Lock()
+		stopIngestor()
+		state = darksideState{}
+		mutex.Unlock()
+		cache.Close()
+		Time.Sleep, Time.Now = sleepStub, nowStub
+	})
+
+	tip := func() hash32.T {
+		t.Helper()
+		result, err := darksideRawRequest("getbestblockhash", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hashHex string
+		json.Unmarshal(result, &hashHex)
+		hash, err := hash32.Decode(hashHex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash32.Reverse(hash)
+	}
+	return cache, func(next int) {
+		t.Helper()
+		for start := time.Now(); cache.GetNextHeight() != next || cache.GetLatestHash() != tip(); time.Sleep(time.Millisecond) {
+			if time.Since(start) > 5*time.Second {
+				t.Fatal("timed out waiting for the ingestor, next height ", cache.GetNextHeight())
+			}
+		}
+	}
+}
+
+// testBlockHex returns the test block at the given height, hex-encoded.
+func testBlockHex(height int) string {
+	var blockHex string
+	json.Unmarshal(blocks[height-380640], &blockHex)
+	return blockHex
+}
+
+// The darkside mock node, run in-process: load a chain from a file of hex
+// blocks, advance the tip, and reorg, with the real ingestor following.
+func TestDarksideChain(t *testing.T) {
+	cache, waitSynced := darksideTest(t)
+	if err := DarksideStageBlocksFile("../testdata/blocks"); err == nil {
+		t.Fatal("staging before Reset succeeded")
+	}
+	if err := DarksideReset(380640, "c2d6d0b4", "main", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := DarksideStageBlocksFile("../testdata/blocks"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DarksideApplyStaged(380642); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380643)
+
+	// Advance the tip.
+	if err := DarksideApplyStaged(380643); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380644)
+	oldHash := cache.GetLatestHash()
+	oldParent := cache.Get(380642).Hash
+
+	// Replace the last two blocks, the first altered (its nonce), so both
+	// get new hashes.
+	raw, _ := hex.DecodeString(testBlockHex(380642))
+	raw[108]++
+	for _, blockHex := range []string{hex.EncodeToString(raw), testBlockHex(380643)} {
+		if err := DarksideStageBlockStream(blockHex); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := DarksideApplyStaged(380643); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380644)
+	parent, block := cache.Get(380642), cache.Get(380643)
+	if cache.GetLatestHash() == oldHash || bytes.Equal(parent.Hash, oldParent) ||
+		!bytes.Equal(block.PrevHash, parent.Hash) {
+		t.Fatal("reorg not followed")
+	}
+}
+
+// After a Reorg, a wallet syncing from below the reorg height gets the new
+// branch.
+func TestDarksideReorg(t *testing.T) {
+	cache, waitSynced := darksideTest(t)
+	DarksideReset(380640, "c2d6d0b4", "main", 0, 0)
+	if err := DarksideReorg(380640); err == nil {
+		t.Fatal("reorg to an empty chain succeeded")
+	}
+	if err := DarksideStageBlocksFile("../testdata/blocks"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DarksideApplyStaged(380643); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380644)
+	if err := DarksideReorg(380645); err == nil {
+		t.Fatal("reorg above the tip succeeded")
+	}
+	var oldHashes [][]byte
+	for height := 380640; height <= 380643; height++ {
+		oldHashes = append(oldHashes, cache.Get(height).Hash)
+	}
+
+	// Just rewinding the chain drops blocks from the cache.
+	if err := DarksideReorg(380643); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380643)
+
+	// A new branch from 380641 (each block altered, so it has a new hash).
+	for height := 380641; height <= 380643; height++ {
+		raw, _ := hex.DecodeString(testBlockHex(height))
+		raw[108]++
+		if err := DarksideStageBlockStream(hex.EncodeToString(raw)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := DarksideReorg(380641); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380644)
+
+	blockOut := make(chan *walletrpc.CompactBlock)
+	errOut := make(chan error)
+	go GetBlockRange(context.Background(), cache, blockOut, errOut, 380640, 380643)
+	var synced []*walletrpc.CompactBlock
+	for done := false; !done; {
+		select {
+		case block := <-blockOut:
+			synced = append(synced, block)
+		case err := <-errOut:
+			if err != nil {
+				t.Fatal(err)
+			}
+			done = true
+		}
+	}
+	if len(synced) != 4 || !bytes.Equal(synced[0].Hash, oldHashes[0]) {
+		t.Fatal("unexpected blocks", synced)
+	}
+	for i, block := range synced[1:] {
+		if !bytes.Equal(block.PrevHash, synced[i].Hash) || bytes.Equal(block.Hash, oldHashes[i+1]) {
+			t.Fatal("block ", block.Height, " isn't from the new branch")
+		}
+	}
+}
+
+// orchardTestTx returns an Orchard-only v5 transaction from the test vectors.
+func orchardTestTx(t *testing.T) []byte {
+	s, err := os.ReadFile("../testdata/tx_v5.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors [][]any
+	json.Unmarshal(s, &vectors)
+	for _, v := range vectors[2:] {
+		// tx, ..., nSpendsSapling (9), nOutputsSapling (10), ..., nActionsOrchard (14)
+		if v[9].(float64) == 0 && v[10].(float64) == 0 && v[14].(float64) > 0 {
+			tx, _ := hex.DecodeString(v[0].(string))
+			return tx
+		}
+	}
+	t.Fatal("no Orchard-only test transaction found")
+	return nil
+}
+
+// The ingestor rejects a block with malformed Orchard actions, logging and
+// counting it, without crashing, and resumes when it's served correctly.
+func TestDarksideMalformed(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(saved *logrus.Entry) { Log = saved }(Log)
+	l := logrus.New()
+	l.SetOutput(&buf)
+	Log = l.WithField("app", "test")
+	cache, waitSynced := darksideTest(t)
+
+	DarksideReset(380640, "c2d6d0b4", "main", 0, 0)
+	if err := DarksideStageBlocksFile("../testdata/blocks"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DarksideStageTransaction(380642, orchardTestTx(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := DarksideApplyStaged(380641); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380642)
+	if err := DarksideSetMalformation(380641, DarksideTruncatedCiphertext); err == nil {
+		t.Fatal("malformed a block without Orchard actions")
+	}
+
+	for _, how := range []DarksideMalformation{DarksideTruncatedCiphertext, DarksideOversizedActionCount} {
+		if err := DarksideSetMalformation(380642, how); err != nil {
+			t.Fatal(err)
+		}
+		parseErrors := metricValue(t, ingestErrorsTotal.WithLabelValues(string(IngestErrorParse)))
+		if err := DarksideApplyStaged(380642); err != nil {
+			t.Fatal(err)
+		}
+		for start := time.Now(); metricValue(t, ingestErrorsTotal.WithLabelValues(string(IngestErrorParse))) == parseErrors; time.Sleep(time.Millisecond) {
+			if time.Since(start) > 5*time.Second {
+				t.Fatal("timed out waiting for the parse error, ", how)
+			}
+		}
+		if _, err := GetBlock(cache, 380642); err == nil {
+			t.Fatal("served a malformed block, ", how)
+		}
+		if cache.GetNextHeight() != 380642 {
+			t.Fatal("malformed block cached, ", how)
+		}
+		if err := DarksideSetMalformation(380642, DarksideWellFormed); err != nil {
+			t.Fatal(err)
+		}
+		waitSynced(380643)
+		if block := cache.Get(380642); len(block.Vtx) == 0 || len(block.Vtx[0].Actions) == 0 {
+			t.Fatal("Orchard actions missing after recovery")
+		}
+		// Back to the previous tip, for the next case.
+		if err := DarksideApplyStaged(380641); err != nil {
+			t.Fatal(err)
+		}
+		waitSynced(380642)
+	}
+
+	mutex.Lock()
+	stopIngestor()
+	mutex.Unlock()
+	for _, want := range []string{"could not read action encCiphertext", "must be less than 2^16"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatal("rejection not logged: ", want)
+		}
+	}
+}
+
+// A transaction staged in the mempool is streamed to wallets, and leaves
+// the mempool when a block containing it is applied.
+func TestDarksideMempool(t *testing.T) {
+	cache, waitSynced := darksideTest(t)
+	// Each call is a second later, so GetMempool polls on every pass.
+	var clock atomic.Int64
+	Time.Now = func() time.Time { return time.Unix(clock.Add(1), 0) }
+
+	DarksideReset(380640, "c2d6d0b4", "main", 0, 0)
+	if err := DarksideStageBlocksFile("../testdata/blocks"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DarksideApplyStaged(380642); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380643)
+	tx := orchardTestTx(t)
+	if err := DarksideStageMempoolTx(tx[:100]); err == nil {
+		t.Fatal("staged a malformed transaction")
+	}
+	if err := DarksideStageMempoolTx(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := make(chan *walletrpc.RawTransaction, 10)
+	streamDone := make(chan error, 1)
+	go func() {
+		// GetMempool returns when the tip changes, which it may see at once.
+		for seen := false; !seen; {
+			err := GetMempool(RPCFunc(darksideRawRequest), func(tx *walletrpc.RawTransaction) error {
+				seen = true
+				sent <- tx
+				return nil
+			})
+			if err != nil {
+				streamDone <- err
+				return
+			}
+		}
+		streamDone <- nil
+	}()
+	select {
+	case rawtx := <-sent:
+		if !bytes.Equal(rawtx.Data, tx) || rawtx.Height != 0 {
+			t.Fatal("unexpected mempool transaction", rawtx.Height)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the mempool transaction")
+	}
+
+	// Mine it.
+	if err := DarksideStageTransaction(380643, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := DarksideApplyStaged(380643); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-streamDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the mempool stream to end")
+	}
+	result, err := darksideRawRequest("getrawmempool", nil)
+	if err != nil || string(result) != "[]" {
+		t.Fatal("unexpected mempool", string(result), err)
+	}
+	waitSynced(380644)
+	if block := cache.Get(380643); len(block.Vtx) != 1 || len(block.Vtx[0].Actions) == 0 {
+		t.Fatal("transaction not mined")
+	}
+	if stats := DarksideGetStats(); stats.MempoolTxs != 1 || stats.TransactionsStaged != 1 ||
+		stats.BlocksStaged != 4 || stats.Reorgs != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+// Header times and nBits set on staged blocks are served, and reach the
+// compact blocks wallets sync.
+func TestDarksideBlockTime(t *testing.T) {
+	cache, waitSynced := darksideTest(t)
+	DarksideReset(380640, "c2d6d0b4", "main", 0, 0)
+	if err := DarksideStageBlocksFile("../testdata/blocks"); err != nil {
+		t.Fatal(err)
+	}
+	// Difficulties are relative to the first block's target.
+	const genesisBits = 0x1f07ffff
+	if err := DarksideStageBlockWithTime(380650, 1, genesisBits); err == nil {
+		t.Fatal("set the time of a block that isn't staged")
+	}
+	times := map[int]uint32{380641: 1700000000, 380642: 1600000000, 380643: 1800000000}
+	bits := map[int]uint32{380641: genesisBits, 380642: 0x1e07ffff, 380643: 0x1d07ffff}
+	for height := range times {
+		if err := DarksideStageBlockWithTime(height, times[height], bits[height]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := DarksideApplyStaged(380643); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380644)
+
+	var fetched []*parser.Block
+	for height := 380641; height <= 380643; height++ {
+		compact, err := GetBlock(cache, height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compact.Time != times[height] {
+			t.Fatal("unexpected time at ", height, ": ", compact.Time)
+		}
+		block, _, err := FetchBlock(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header := block.Header(); header.Time != times[height] || header.Bits != bits[height] {
+			t.Fatal("unexpected header at ", height, ": ", header.Time, header.Bits)
+		}
+		fetched = append(fetched, block)
+	}
+	for i, want := range []float64{1, 256, 65536} {
+		if d := fetched[i].Difficulty(genesisBits); d != want {
+			t.Fatal("unexpected difficulty ", d, " at ", 380641+i)
+		}
+	}
+	if mtp, err := parser.MedianTimePast(fetched); err != nil || mtp != 1700000000 {
+		t.Fatal("unexpected median-time-past ", mtp, err)
+	}
+}
+
+// A saved snapshot of the served chain, restored after the state has
+// changed, reproduces the exact blocks served.
+func TestDarksideSnapshot(t *testing.T) {
+	_, waitSynced := darksideTest(t)
+	name := t.TempDir() + "/darkside.snapshot"
+	DarksideReset(380640, "c2d6d0b4", "main", 0, 5)
+	if err := DarksideSaveSnapshot(name); err == nil {
+		t.Fatal("saved a snapshot without blocks")
+	}
+	if err := DarksideStageBlocksFile("../testdata/blocks"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DarksideStageTxWithID(380641, orchardTestTx(t), hash32.T{1}); err != nil {
+		t.Fatal(err)
+	}
+	// The latest presented block isn't the last active one.
+	if err := DarksideApplyStaged(380642); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380643)
+
+	served := func() []string {
+		t.Helper()
+		var replies []string
+		for _, req := range []struct {
+			method string
+			params []json.RawMessage
+		}{
+			{"getblockchaininfo", nil},
+			{"getbestblockhash", nil},
+			{"getblock", []json.RawMessage{json.RawMessage(`"380640"`), json.RawMessage("0")}},
+			{"getblock", []json.RawMessage{json.RawMessage(`"380641"`), json.RawMessage("0")}},
+			{"getblock", []json.RawMessage{json.RawMessage(`"380641"`), json.RawMessage("1")}},
+			{"getblock", []json.RawMessage{json.RawMessage(`"380642"`), json.RawMessage("1")}},
+		} {
+			result, err := darksideRawRequest(req.method, req.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			replies = append(replies, string(result))
+		}
+		return replies
+	}
+	before := served()
+	if err := DarksideSaveSnapshot(name); err != nil {
+		t.Fatal(err)
+	}
+
+	DarksideReset(380640, "e9ff75a6", "test", 0, 0)
+	if err := DarksideStageBlocksFile("../testdata/blocks"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DarksideApplyStaged(380643); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380644)
+	s, _ := os.ReadFile(name)
+	s[len(s)/2]++
+	corrupt := t.TempDir() + "/corrupt.snapshot"
+	os.WriteFile(corrupt, s, 0o644)
+	if err := DarksideLoadSnapshot(corrupt); err == nil || !strings.Contains(err.Error(), "bad checksum") {
+		t.Fatal("loaded a corrupt snapshot, ", err)
+	}
+
+	if err := DarksideLoadSnapshot(name); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380643)
+	after := served()
+	for i := range before {
+		if after[i] != before[i] {
+			t.Fatal("unexpected reply after restoring\n", after[i], "\nexpected\n", before[i])
+		}
+	}
+	// The restored chain is usable.
+	if err := DarksideApplyStaged(380643); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380644)
+}
+
+// Injected errors and latency stall the ingestor, which retries with
+// backoff, and recovers once they're turned off.
+func TestDarksideFaults(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(saved *logrus.Entry) { Log = saved }(Log)
+	l := logrus.New()
+	l.SetOutput(&buf)
+	Log = l.WithField("app", "test")
+	defer func(saved time.Duration) { RPCTimeout = saved }(RPCTimeout)
+	RPCTimeout = 50 * time.Millisecond
+	cache, waitSynced := darksideTest(t)
+
+	DarksideReset(380640, "c2d6d0b4", "main", 0, 0)
+	if err := DarksideSetErrorRate(1.5); err == nil {
+		t.Fatal("set an error rate above 1")
+	}
+	// The errors are evenly spread.
+	if err := DarksideSetErrorRate(0.25); err != nil {
+		t.Fatal(err)
+	}
+	var failed []int
+	for i := range 8 {
+		if _, err := darksideRawRequest("getinfo", nil); err != nil {
+			failed = append(failed, i)
+		}
+	}
+	if len(failed) != 2 || failed[0] != 3 || failed[1] != 7 {
+		t.Fatal("unexpected failures", failed)
+	}
+	if stats := DarksideGetStats(); stats.InjectedErrors != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	DarksideSetErrorRate(0)
+
+	if err := DarksideStageBlocksFile("../testdata/blocks"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DarksideApplyStaged(380641); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380642)
+	stalled := func(what string) {
+		t.Helper()
+		time.Sleep(100 * time.Millisecond)
+		if cache.GetNextHeight() != 380642 {
+			t.Fatal("ingestor not stalled by ", what)
+		}
+	}
+
+	DarksideSetErrorRate(1)
+	if err := DarksideApplyStaged(380642); err != nil {
+		t.Fatal(err)
+	}
+	stalled("errors")
+	DarksideSetErrorRate(0)
+	waitSynced(380643)
+	DarksideApplyStaged(380641)
+	waitSynced(380642)
+
+	DarksideSetLatency(testLatency)
+	if err := DarksideApplyStaged(380642); err != nil {
+		t.Fatal(err)
+	}
+	stalled("latency")
+	DarksideSetLatency(0)
+	waitSynced(380643)
+
+	mutex.Lock()
+	stopIngestor()
+	mutex.Unlock()
+	for _, want := range []string{"injected getbestblockhash rpc error", "timed out after 50ms", "Reconnected to"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatal("not logged: ", want)
+		}
+	}
+}
+
+// A transaction staged with an assigned txid gets that id, reproducibly, in
+// the compact blocks and from getrawtransaction.
+func TestDarksideTxID(t *testing.T) {
+	cache, waitSynced := darksideTest(t)
+	tx := orchardTestTx(t)
+	txid := hash32.Sum256d([]byte("assigned txid"))
+	run := func() *walletrpc.CompactBlock {
+		t.Helper()
+		DarksideReset(380640, "c2d6d0b4", "main", 0, 0)
+		if err := DarksideStageBlocksFile("../testdata/blocks"); err != nil {
+			t.Fatal(err)
+		}
+		if err := DarksideStageTxWithID(380642, tx, txid); err != nil {
+			t.Fatal(err)
+		}
+		if err := DarksideApplyStaged(380642); err != nil {
+			t.Fatal(err)
+		}
+		waitSynced(380643)
+		block, err := GetBlock(cache, 380642)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return block
+	}
+	first, second := run(), run()
+	if !proto.Equal(first, second) {
+		t.Fatal("compact blocks differ between runs")
+	}
+	if len(first.Vtx) != 1 || !bytes.Equal(first.Vtx[0].Txid, txid[:]) {
+		t.Fatal("assigned txid not used", first.Vtx)
+	}
+	txidJSON, _ := json.Marshal(hash32.Encode(hash32.Reverse(txid)))
+	result, err := darksideRawRequest("getrawtransaction", []json.RawMessage{txidJSON, json.RawMessage("0")})
+	if err != nil || string(result) != `"`+hex.EncodeToString(tx)+`"` {
+		t.Fatal("transaction not found by its assigned txid", err)
+	}
+
+	// An assigned txid must match one that's computed locally (V4).
+	raw, _ := hex.DecodeString(testBlockHex(380643))
+	block := parser.NewBlock()
+	block.ParseFromSlice(raw)
+	v4 := block.Transactions()[1]
+	if err := DarksideStageTxWithID(380642, v4.Bytes(), txid); err == nil {
+		t.Fatal("assigned a txid that doesn't match the computed one")
+	}
+	computed, _ := v4.ComputeTxID()
+	if err := DarksideStageTxWithID(380642, v4.Bytes(), computed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Switching between competing branches: a reorg no deeper than
+// MaxReorgDepth is followed, a deeper one is refused (and counted), and
+// returning to the cached branch resumes ingestion.
+func TestDarksideBranches(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(saved *logrus.Entry) { Log = saved }(Log)
+	l := logrus.New()
+	l.SetOutput(&buf)
+	Log = l.WithField("app", "test")
+	defer func(saved int) { MaxReorgDepth = saved }(MaxReorgDepth)
+	MaxReorgDepth = 2
+	cache, waitSynced := darksideTest(t)
+
+	// branch returns the test blocks from the given height up, altered
+	// (given a new hash) if nonce isn't 0.
+	branch := func(height int, nonce byte) [][]byte {
+		var blocks [][]byte
+		for ; height <= 380643; height++ {
+			raw, _ := hex.DecodeString(testBlockHex(height))
+			raw[108] += nonce
+			blocks = append(blocks, raw)
+		}
+		return blocks
+	}
+	DarksideReset(380640, "c2d6d0b4", "main", 0, 0)
+	for name, blocks := range map[string][][]byte{
+		"main":    branch(380640, 0),
+		"shallow": branch(380643, 1),
+		"deep":    branch(380641, 2),
+	} {
+		if err := DarksideStageBranch(name, blocks); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := DarksideStageBranch("gap", [][]byte{branch(380641, 0)[0], branch(380643, 0)[0]}); err == nil {
+		t.Fatal("staged a branch with a gap")
+	}
+	if err := DarksideActivateBranch("deep"); err == nil {
+		t.Fatal("activated a branch above the tip")
+	}
+	if err := DarksideActivateBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380644)
+	mainHash := cache.GetLatestHash()
+
+	if err := DarksideActivateBranch("shallow"); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380644)
+	if cache.GetLatestHash() == mainHash {
+		t.Fatal("shallow reorg not followed")
+	}
+
+	aborted := metricValue(t, reorgsAbortedTotal)
+	if err := DarksideActivateBranch("deep"); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); metricValue(t, reorgsAbortedTotal) == aborted; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("timed out waiting for the reorg to be aborted")
+		}
+	}
+	if next := cache.GetNextHeight(); next != 380642 {
+		t.Fatal("unexpected next height after aborting the reorg", next)
+	}
+
+	// Back to the main branch, which links to what's still cached.
+	if err := DarksideActivateBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380644)
+	if cache.GetLatestHash() != mainHash {
+		t.Fatal("not back on the main branch")
+	}
+	mutex.Lock()
+	stopIngestor()
+	mutex.Unlock()
+	if !strings.Contains(buf.String(), "not following a reorg deeper than 2 blocks") {
+		t.Fatal("aborted reorg not logged")
+	}
+	// The harness did what was intended: three reorgs (shallow, deep, and
+	// back to main), and the blocks of the three branches staged.
+	if stats := DarksideGetStats(); stats.Reorgs != 3 || stats.BlocksStaged != 8 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+// A gap (a height the node can't serve) stalls the ingestor below it, and
+// GetBlock reports the height as unavailable, with the cached range, until
+// the block is served again.
+func TestDarksideGetBlockByHash(t *testing.T) {
+	cache, waitSynced := darksideTest(t)
+	DarksideReset(380640, "c2d6d0b4", "main", 0, 0)
+	if err := DarksideStageBlocksFile("../testdata/blocks"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DarksideApplyStaged(380641); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380642)
+	want := cache.Get(380641)
+	block, err := GetBlockByHash(cache, hash32.T(want.Hash))
+	if err != nil || block.Height != 380641 || !bytes.Equal(block.Hash, want.Hash) {
+		t.Fatal("GetBlockByHash unexpected result", err)
+	}
+	if _, err := GetBlockByHash(cache, hash32.T{1}); status.Code(err) != codes.NotFound {
+		t.Fatal("GetBlockByHash of an unknown hash: unexpected error", err)
+	}
+}
+
+func TestDarksideGap(t *testing.T) {
+	cache, waitSynced := darksideTest(t)
+	DarksideReset(380640, "c2d6d0b4", "main", 0, 0)
+	if err := DarksideStageBlocksFile("../testdata/blocks"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DarksideApplyStaged(380641); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380642)
+	if err := DarksideStageGap(380644); err == nil {
+		t.Fatal("staged a gap above the active blocks")
+	}
+	// Blocks 380642 and 380643 are active, just not yet presented.
+	if err := DarksideStageGap(380642); err != nil {
+		t.Fatal(err)
+	}
+	if err := DarksideApplyStaged(380643); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := GetBlock(cache, 380642)
+	if status.Code(err) != codes.InvalidArgument ||
+		!strings.Contains(err.Error(), "height 380642 unavailable, have 380640-380641") ||
+		!strings.Contains(err.Error(), "not found on disk") {
+		t.Fatal("unexpected error", err)
+	}
+	// The blocks above the gap are served (not cached).
+	if block, err := GetBlock(cache, 380643); err != nil || block.Height != 380643 {
+		t.Fatal("block above the gap not served", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if next := cache.GetNextHeight(); next != 380642 {
+		t.Fatal("ingested past the gap", next)
+	}
+
+	// Serving the block again fills the gap (staging it drops the blocks
+	// above it, so they're staged again too).
+	for _, height := range []int{380642, 380643} {
+		if err := DarksideStageBlockStream(testBlockHex(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := DarksideApplyStaged(380643); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(380644)
+	if _, err := GetBlock(cache, 380642); err != nil {
+		t.Fatal(err)
+	}
+}
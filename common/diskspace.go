@@ -0,0 +1,92 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"context"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CacheMinFreeSpace, if nonzero, is the free disk space, in bytes, that
+// MonitorDiskSpace keeps on the filesystem containing the disk cache by
+// evicting the oldest blocks (--cache-min-free-space). Evicting rewrites the
+// db files, which needs (temporarily) as much space as the blocks that are
+// kept; when there isn't that much, only the latest block is kept, and if
+// even that can't be rewritten, the cache is emptied (see TrimForFreeSpace).
+var CacheMinFreeSpace uint64
+
+// diskCheckInterval is how often MonitorDiskSpace checks the free space.
+var diskCheckInterval = time.Minute
+
+// DiskFree returns the disk space, in bytes, available to unprivileged
+// users on the filesystem containing path; it's a variable for testing.
+var DiskFree = func(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+// MonitorDiskSpace checks the free disk space for the cache c every
+// diskCheckInterval, trimming the cache (see TrimForFreeSpace) whenever it's
+// below CacheMinFreeSpace, until ctx is done.
+func MonitorDiskSpace(ctx context.Context, c *BlockCache) {
+	for ctx.Err() == nil {
+		c.TrimForFreeSpace(CacheMinFreeSpace)
+		sleepContext(ctx, diskCheckInterval)
+	}
+}
+
+// TrimForFreeSpace evicts the oldest cached blocks, but never the latest
+// block, if the free space on the filesystem containing the db files is
+// below minFree: enough blocks that their (stored) size covers the
+// shortfall. If there isn't enough free space to rewrite the db files with
+// the remaining blocks, all but the latest block are evicted; if even that
+// fails, the cache is emptied, which needs no space (the ingestor then
+// continues from the next block). It returns the number of blocks evicted;
+// requests for them are then served from the backend node.
+func (c *BlockCache) TrimForFreeSpace(minFree uint64) int {
+	free, err := DiskFree(c.dir)
+	if err != nil {
+		c.log().Warning("can't get free disk space: ", err)
+		return 0
+	}
+	if free >= minFree {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	nBlocks := c.nextBlock - c.firstBlock
+	if c.readOnly || nBlocks < 2 {
+		return 0
+	}
+	shortfall := int64(minFree - free)
+	// Find the fewest blocks (other than the latest) whose size covers the
+	// shortfall, or all of them.
+	n := 1 + sort.Search(nBlocks-1, func(i int) bool {
+		return c.starts[i+1]-c.starts[0] >= shortfall
+	})
+	n = min(n, nBlocks-1)
+	if kept := c.starts[nBlocks] - c.starts[n] + int64(4*(nBlocks-n)); uint64(kept) >= free {
+		n = nBlocks - 1
+	}
+	if err := c.evict(n); err != nil {
+		c.log().Warning("can't evict blocks for free disk space, emptying the cache: ", err)
+		n = nBlocks
+		c.empty(c.nextBlock)
+	}
+	c.log().WithFields(logrus.Fields{
+		"free":     free,
+		"min_free": minFree,
+		"evicted":  n,
+		"first":    c.firstBlock,
+	}).Warning("disk space low, evicted the oldest blocks from the cache")
+	return n
+}
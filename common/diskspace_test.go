@@ -0,0 +1,124 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// When free disk space falls below the threshold, the oldest blocks are
+// evicted to make up the shortfall.
+func TestTrimForFreeSpace(t *testing.T) {
+	defer func(saved *logrus.Entry) { Log = saved }(Log)
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&buf)
+	Log = l.WithField("app", "test")
+	defer func(saved func(string) (uint64, error)) { DiskFree = saved }(DiskFree)
+	var free uint64
+	var diskErr error
+	DiskFree = func(string) (uint64, error) { return free, diskErr }
+
+	const startHeight = 1000
+	c := NewBlockCache(t.TempDir(), unitTestChain, startHeight, -1)
+	defer c.Close()
+	for height := startHeight; height < startHeight+10; height++ {
+		if err := c.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blockSize := uint64(c.starts[1] - c.starts[0])
+
+	const minFree = 1 << 30
+	free = minFree
+	if n := c.TrimForFreeSpace(minFree); n != 0 || c.GetFirstHeight() != startHeight {
+		t.Fatal("trimmed with enough free space", n)
+	}
+	diskErr = errors.New("no such file")
+	if n := c.TrimForFreeSpace(minFree); n != 0 || !strings.Contains(buf.String(), "no such file") {
+		t.Fatal("unexpected result with an error", n, buf.String())
+	}
+	diskErr = nil
+
+	// Just over two blocks short.
+	free = minFree - 2*blockSize - 1
+	if n := c.TrimForFreeSpace(minFree); n != 3 || c.GetFirstHeight() != startHeight+3 {
+		t.Fatal("unexpected trim", n, c.GetFirstHeight())
+	}
+	if !strings.Contains(buf.String(), "disk space low") || !strings.Contains(buf.String(), "evicted=3") {
+		t.Fatal("unexpected log:", buf.String())
+	}
+
+	// The latest block is always kept.
+	free = 0
+	if n := c.TrimForFreeSpace(minFree); n != 6 || c.GetFirstHeight() != startHeight+9 {
+		t.Fatal("unexpected trim", n, c.GetFirstHeight())
+	}
+	if c.Get(startHeight+9) == nil {
+		t.Fatal("latest block not served")
+	}
+	if n := c.TrimForFreeSpace(minFree); n != 0 {
+		t.Fatal("trimmed the latest block")
+	}
+}
+
+// Evicting needs free space to rewrite the db files with the blocks that are
+// kept; with less than that, only the latest block is kept, and if even that
+// can't be rewritten, the cache is emptied.
+func TestTrimForFreeSpaceFull(t *testing.T) {
+	defer func(saved func(string) (uint64, error)) { DiskFree = saved }(DiskFree)
+	var free uint64
+	DiskFree = func(string) (uint64, error) { return free, nil }
+
+	const startHeight = 1000
+	c := NewBlockCache(t.TempDir(), unitTestChain, startHeight, -1)
+	defer c.Close()
+	add := func(start, end int) {
+		t.Helper()
+		for height := start; height < end; height++ {
+			if err := c.Add(height, testCompactBlock(height)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	add(startHeight, startHeight+10)
+	blockSize := uint64(c.starts[1] - c.starts[0])
+
+	// One block short, but the other eight don't fit in the free space.
+	free = 4 * blockSize
+	if n := c.TrimForFreeSpace(5 * blockSize); n != 9 || c.GetFirstHeight() != startHeight+9 {
+		t.Fatal("unexpected trim", n, c.GetFirstHeight())
+	}
+	if err := c.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The db files can't be rewritten at all (as if the disk were full).
+	add(startHeight+10, startHeight+12)
+	if err := os.Mkdir(fileStoreOf(c).blocksName+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.TrimForFreeSpace(5 * blockSize); n != 3 {
+		t.Fatal("unexpected trim", n)
+	}
+	if c.GetFirstHeight() != startHeight+12 || c.GetNextHeight() != startHeight+12 {
+		t.Fatal("cache not emptied", c.GetFirstHeight(), c.GetNextHeight())
+	}
+	if err := c.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ingestion continues from the next block.
+	add(startHeight+12, startHeight+13)
+	if c.Get(startHeight+12) == nil {
+		t.Fatal("block not cached after emptying")
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+// FailoverThreshold is the number of consecutive failed requests to the
+// active backend node after which an RPCFailover switches to the next one.
+var FailoverThreshold = 3
+
+// FailoverRetryPrimary is how often an RPCFailover that has failed over
+// sends a request to the primary backend node, to switch back to it.
+var FailoverRetryPrimary = time.Minute
+
+// RPCEndpoint is one of the backend nodes used by an RPCFailover.
+type RPCEndpoint struct {
+	Name   string // such as the host and port, for logs and metrics
+	Client RPCClient
+}
+
+// RPCFailover sends requests to the first (primary) of several backend
+// nodes; after FailoverThreshold consecutive requests fail without a reply
+// from the node, it switches to the next one (wrapping around), and so on.
+// While it isn't using the primary, it tries it again every
+// FailoverRetryPrimary, switching back if it replies. It's an
+// RPCContextClient and an RPCBatchClient.
+type RPCFailover struct {
+	endpoints    []RPCEndpoint
+	mutex        sync.Mutex
+	active       int       // index of the endpoint in use
+	failures     int       // consecutive failures of the active endpoint
+	primaryTried time.Time // when the primary last failed
+}
+
+// NewRPCFailover returns an RPCFailover using the given endpoints, the
+// first being the primary.
+func NewRPCFailover(endpoints []RPCEndpoint) *RPCFailover {
+	f := &RPCFailover{endpoints: endpoints}
+	f.setActive(0)
+	return f
+}
+
+// Active returns the name of the endpoint in use.
+func (f *RPCFailover) Active() string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.endpoints[f.active].Name
+}
+
+// RawRequest sends a request to the active endpoint.
+func (f *RPCFailover) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := f.do(context.Background(), func(e *RPCEndpoint) (err error) {
+		result, err = e.Client.RawRequest(method, params)
+		return err
+	})
+	return result, err
+}
+
+// RawRequestContext sends a request to the active endpoint, cancelling it
+// when ctx is done if the endpoint's client is an RPCContextClient.
+func (f *RPCFailover) RawRequestContext(ctx context.Context, method string, params []json.RawMessage) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := f.do(ctx, func(e *RPCEndpoint) (err error) {
+		if c, ok := e.Client.(RPCContextClient); ok {
+			result, err = c.RawRequestContext(ctx, method, params)
+		} else {
+			result, err = e.Client.RawRequest(method, params)
+		}
+		return err
+	})
+	return result, err
+}
+
+// RawBatchRequest sends a batch of requests to the active endpoint, if its
+// client is an RPCBatchClient.
+func (f *RPCFailover) RawBatchRequest(ctx context.Context, requests []RPCRequest) ([]RPCResult, error) {
+	var results []RPCResult
+	err := f.do(ctx, func(e *RPCEndpoint) (err error) {
+		c, ok := e.Client.(RPCBatchClient)
+		if !ok {
+			return fmt.Errorf("%w by %s", ErrBatchUnsupported, e.Name)
+		}
+		results, err = c.RawBatchRequest(ctx, requests)
+		return err
+	})
+	return results, err
+}
+
+// do sends a request to the active endpoint (or to the primary, if it's due
+// to be retried, then, if it fails, to the active endpoint).
+func (f *RPCFailover) do(ctx context.Context, request func(*RPCEndpoint) error) error {
+	i, retry := f.choose()
+	err := request(&f.endpoints[i])
+	if ctx.Err() != nil {
+		// Cancelled by the caller; this says nothing about the endpoint.
+		return err
+	}
+	f.record(i, unreachable(err))
+	if retry && unreachable(err) {
+		i, _ = f.choose()
+		err = request(&f.endpoints[i])
+		if ctx.Err() == nil {
+			f.record(i, unreachable(err))
+		}
+	}
+	return err
+}
+
+// unreachable returns whether err means that the backend node didn't reply
+// (as opposed to replying with an error).
+func unreachable(err error) bool {
+	var rpcErr *btcjson.RPCError
+	return err != nil && !errors.As(err, &rpcErr)
+}
+
+// choose returns the endpoint to send a request to, and whether it's a
+// retry of the primary.
+func (f *RPCFailover) choose() (int, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.active != 0 && Time.Now().Sub(f.primaryTried) >= FailoverRetryPrimary {
+		f.primaryTried = Time.Now()
+		return 0, true
+	}
+	return f.active, false
+}
+
+// record records the result of a request to endpoint i, switching
+// endpoints if needed.
+func (f *RPCFailover) record(i int, failed bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if i != f.active {
+		// A retry of the primary
+		if !failed {
+			Log.Info("Primary backend node ", f.endpoints[0].Name, " is back, switching to it")
+			f.setActive(0)
+		}
+		return
+	}
+	if !failed {
+		f.failures = 0
+		return
+	}
+	f.failures++
+	if f.failures < FailoverThreshold || len(f.endpoints) < 2 {
+		return
+	}
+	next := (i + 1) % len(f.endpoints)
+	Log.Warning("Backend node ", f.endpoints[i].Name, " failed ", f.failures,
+		" requests, switching to ", f.endpoints[next].Name)
+	rpcFailoversTotal.Inc()
+	if i == 0 {
+		f.primaryTried = Time.Now()
+	}
+	f.setActive(next)
+}
+
+// setActive makes endpoint i the active one. Caller should hold f.mutex
+// (or be the constructor).
+func (f *RPCFailover) setActive(i int) {
+	f.active = i
+	f.failures = 0
+	for j, e := range f.endpoints {
+		value := 0.0
+		if j == i {
+			value = 1
+		}
+		rpcEndpointActive.WithLabelValues(e.Name).Set(value)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+// testEndpoint returns an endpoint that fails (without a reply) while *down
+// is true, and counts its requests.
+func testEndpoint(name string, down *bool, requests *int) RPCEndpoint {
+	return RPCEndpoint{
+		Name: name,
+		Client: RPCFunc(func(method string, params []json.RawMessage) (json.RawMessage, error) {
+			*requests++
+			if *down {
+				return nil, errors.New("connection refused")
+			}
+			if method == "getblock" {
+				// A reply, even if it's an error, means the node is up.
+				return nil, &btcjson.RPCError{Code: -8, Message: "Block height out of range"}
+			}
+			return json.RawMessage(`"` + name + `"`), nil
+		}),
+	}
+}
+
+func TestRPCFailover(t *testing.T) {
+	Time.Now = nowStub
+	defer func() { sleepDuration = 0 }()
+	var primaryDown, fallbackDown bool
+	var primaryRequests, fallbackRequests int
+	f := NewRPCFailover([]RPCEndpoint{
+		testEndpoint("primary", &primaryDown, &primaryRequests),
+		testEndpoint("fallback", &fallbackDown, &fallbackRequests),
+	})
+	request := func() string {
+		t.Helper()
+		result, err := f.RawRequestContext(context.Background(), "getbestblockhash", nil)
+		if err != nil {
+			return ""
+		}
+		var name string
+		json.Unmarshal(result, &name)
+		return name
+	}
+	active := func(name string) float64 {
+		return metricValue(t, rpcEndpointActive.WithLabelValues(name))
+	}
+	if request() != "primary" || active("primary") != 1 || active("fallback") != 0 {
+		t.Fatal("primary not in use")
+	}
+
+	// Node errors don't count as failures.
+	for range FailoverThreshold {
+		if _, err := f.RawRequest("getblock", nil); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	if f.Active() != "primary" {
+		t.Fatal("failed over after node errors")
+	}
+
+	failovers := metricValue(t, rpcFailoversTotal)
+	primaryDown = true
+	for range FailoverThreshold {
+		if request() != "" {
+			t.Fatal("request to a down primary succeeded")
+		}
+	}
+	if f.Active() != "fallback" || active("primary") != 0 || active("fallback") != 1 {
+		t.Fatal("didn't fail over", f.Active())
+	}
+	if metricValue(t, rpcFailoversTotal) != failovers+1 {
+		t.Fatal("failover not counted")
+	}
+	primaryRequests = 0
+	if request() != "fallback" || primaryRequests != 0 {
+		t.Fatal("fallback not used")
+	}
+
+	// The primary is retried periodically; while it's still down, the
+	// fallback serves the request.
+	sleepDuration += FailoverRetryPrimary
+	if request() != "fallback" || primaryRequests != 1 || f.Active() != "fallback" {
+		t.Fatal("unexpected primary retry", primaryRequests, f.Active())
+	}
+	if request() != "fallback" || primaryRequests != 1 {
+		t.Fatal("primary retried too soon")
+	}
+	primaryDown = false
+	sleepDuration += FailoverRetryPrimary
+	if request() != "primary" || f.Active() != "primary" || active("primary") != 1 {
+		t.Fatal("didn't switch back to the primary", f.Active())
+	}
+}
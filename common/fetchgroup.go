@@ -0,0 +1,81 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"sync"
+
+	"github.com/zcash/lightwalletd/walletrpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// MaxMissFetches limits the number of concurrent requests to the backend
+// node for blocks that aren't in the cache (see GetBlock), so that many
+// wallets requesting an uncached range don't overwhelm it; zero means no
+// limit. Concurrent requests for the same block share one fetch regardless
+// (--max-miss-fetches).
+var MaxMissFetches = 16
+
+// missFetches are the fetches of uncached blocks in progress.
+var missFetches fetchGroup
+
+// fetchGroup limits and coalesces concurrent fetches of blocks by height.
+type fetchGroup struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond         // signalled when a fetch completes
+	calls  map[int]*fetchCall // by height, including those waiting to start
+	active int                // fetches in progress, at most MaxMissFetches
+}
+
+// fetchCall is a fetch of one block, whose result is shared by the callers
+// that requested it.
+type fetchCall struct {
+	done  chan struct{} // closed when block and err are set
+	block *walletrpc.CompactBlock
+	err   error
+}
+
+// fetch returns the result of get(height), waiting until fewer than
+// MaxMissFetches other fetches are in progress. If a fetch of the same
+// height is already in progress (or waiting), it returns a copy of that
+// fetch's result instead of calling get again.
+func (g *fetchGroup) fetch(height int, get func(int) (*walletrpc.CompactBlock, error)) (*walletrpc.CompactBlock, error) {
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = make(map[int]*fetchCall)
+		g.cond = sync.NewCond(&g.mutex)
+	}
+	if call := g.calls[height]; call != nil {
+		missFetchesCoalescedTotal.Inc()
+		g.mutex.Unlock()
+		<-call.done
+		if call.block == nil {
+			return nil, call.err
+		}
+		// Callers may modify the block (see GetBlockNullifiers).
+		return proto.Clone(call.block).(*walletrpc.CompactBlock), call.err
+	}
+	call := &fetchCall{done: make(chan struct{})}
+	g.calls[height] = call
+	for MaxMissFetches > 0 && g.active >= MaxMissFetches {
+		g.cond.Wait()
+	}
+	g.active++
+	g.mutex.Unlock()
+
+	block, err := get(height)
+
+	g.mutex.Lock()
+	g.active--
+	delete(g.calls, height)
+	g.cond.Signal()
+	g.mutex.Unlock()
+	if block != nil {
+		call.block = proto.Clone(block).(*walletrpc.CompactBlock)
+	}
+	call.err = err
+	close(call.done)
+	return block, err
+}
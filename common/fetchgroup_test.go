@@ -0,0 +1,101 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+// Concurrent cache misses for the same block make one request to the
+// backend node.
+func TestFetchGroupCoalesce(t *testing.T) {
+	stub := verifyStub(t, 1, func(int, *ZcashRpcReplyGetblock1) {})
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		if string(params[1]) == "1" {
+			fetches.Add(1)
+			<-release
+		}
+		return stub(method, params)
+	}
+	cache := NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+	defer cache.Close()
+
+	const callers = 10
+	coalesced := metricValue(t, missFetchesCoalescedTotal)
+	results := make([]*walletrpc.CompactBlock, callers)
+	var wg sync.WaitGroup
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			block, err := GetBlock(cache, 380640)
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = block
+		}()
+	}
+	for start := time.Now(); metricValue(t, missFetchesCoalescedTotal) < coalesced+callers-1; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("callers didn't share the fetch")
+		}
+	}
+	close(release)
+	wg.Wait()
+	if fetches.Load() != 1 {
+		t.Fatal("unexpected number of fetches", fetches.Load())
+	}
+	for i, block := range results {
+		if block == nil || block.Height != 380640 {
+			t.Fatal("unexpected block", i, block)
+		}
+		// Each caller has its own copy.
+		if i > 0 && block == results[0] {
+			t.Fatal("callers share a block")
+		}
+	}
+}
+
+// No more than MaxMissFetches blocks are fetched at once.
+func TestFetchGroupLimit(t *testing.T) {
+	defer func(saved int) { MaxMissFetches = saved }(MaxMissFetches)
+	MaxMissFetches = 2
+	var g fetchGroup
+	var active, peak atomic.Int32
+	get := func(height int) (*walletrpc.CompactBlock, error) {
+		n := active.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		active.Add(-1)
+		return &walletrpc.CompactBlock{Height: uint64(height)}, nil
+	}
+	var wg sync.WaitGroup
+	for height := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if block, _ := g.fetch(height, get); block.Height != uint64(height) {
+				t.Error("unexpected block", block.Height, height)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak.Load() != 2 {
+		t.Fatal("unexpected concurrent fetches", peak.Load())
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/zcash/lightwalletd/hash32"
+	"github.com/zcash/lightwalletd/parser"
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+// CacheFullBlocks, if true, causes the cache to also store the full
+// (raw) blocks, so that GetTransaction can be served without a round trip
+// to the backend node (--cache-full-blocks). This roughly doubles (or more)
+// the cache's disk usage.
+var CacheFullBlocks bool
+
+// fullBlockStore holds the full blocks for (the most recent) part of a
+// BlockCache's range, in a parallel pair of db files. The fulllengths file
+// has the height and length of each block (uint32 little-endian each); the
+// fullblocks file has each block preceded by its checksum. It's always
+// accessed with the BlockCache's mutex held.
+type fullBlockStore struct {
+	lengthsName, blocksName string
+	lengthsFile, blocksFile *os.File
+	first                   int     // height of the first stored block
+	starts                  []int64 // offset of each block within blocksFile, plus the end
+	txids                   map[hash32.T]txLocation
+}
+
+// txLocation identifies a transaction in a stored full block. Only the
+// transactions that are in the compact blocks are indexed (that's where
+// wallets find the txids they request).
+type txLocation struct {
+	height, index int
+}
+
+func fullDbFileNames(dbPath string, chainName string) (string, string) {
+	return filepath.Join(dbPath, chainName, "fulllengths"),
+		filepath.Join(dbPath, chainName, "fullblocks")
+}
+
+// openFullBlockStore opens (creating if needed) the full block db files and
+// makes them consistent with the cache c, whose mutex must be held.
+func openFullBlockStore(c *BlockCache, dbPath string, chainName string) (*fullBlockStore, error) {
+	f := &fullBlockStore{txids: make(map[hash32.T]txLocation)}
+	f.lengthsName, f.blocksName = fullDbFileNames(dbPath, chainName)
+	var err error
+	f.blocksFile, err = os.OpenFile(f.blocksName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.lengthsFile, err = os.OpenFile(f.lengthsName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		f.close()
+		return nil, err
+	}
+	lengths, err := os.ReadFile(f.lengthsName)
+	if err != nil {
+		f.close()
+		return nil, err
+	}
+	f.starts = []int64{0}
+	for i := 0; i+8 <= len(lengths); i += 8 {
+		height := int(binary.LittleEndian.Uint32(lengths[i:]))
+		length := binary.LittleEndian.Uint32(lengths[i+4:])
+		if i == 0 {
+			f.first = height
+		}
+		if height != f.first+len(f.starts)-1 || length > maxSnapshotBlockLength {
+			Log.Warning("full blocks lengths file is inconsistent at height ", height)
+			break
+		}
+		f.starts = append(f.starts, f.starts[len(f.starts)-1]+8+int64(length))
+	}
+	// Keep only the blocks that are in the cache, which must extend to
+	// the cache's latest block.
+	if f.first < c.firstBlock || f.next() < c.nextBlock {
+		f.truncate(f.first)
+	}
+	f.truncate(c.nextBlock)
+	// A crash during Add can leave the last block incomplete.
+	if f.next() > f.first {
+		if _, err := f.get(f.next() - 1); err != nil {
+			Log.Warning("full block ", f.next()-1, ": ", err)
+			f.truncate(f.first)
+		}
+	}
+	if f.next() == f.first {
+		f.first = c.nextBlock
+	}
+	// Index the stored blocks' transactions.
+	for height := f.first; height < f.next(); height++ {
+		block := c.readBlock(height)
+		if block == nil {
+			f.truncate(f.first)
+			f.first = c.nextBlock
+			break
+		}
+		f.index(block)
+	}
+	return f, nil
+}
+
+// next returns the height of the first block not stored.
+func (f *fullBlockStore) next() int {
+	return f.first + len(f.starts) - 1
+}
+
+// index adds the compact block's transactions to the txid index.
+func (f *fullBlockStore) index(block *walletrpc.CompactBlock) {
+	for _, tx := range block.Vtx {
+		if txid, err := hash32.FromSlice(tx.Txid); err == nil && !txid.IsNil() {
+			f.txids[txid] = txLocation{height: int(block.Height), index: int(tx.Index)}
+		}
+	}
+}
+
+// unindex removes the transactions of blocks at heights outside [low, high).
+func (f *fullBlockStore) unindex(low, high int) {
+	for txid, loc := range f.txids {
+		if loc.height < low || loc.height >= high {
+			delete(f.txids, txid)
+		}
+	}
+}
+
+// add stores the full block data for the given (compact) block, which has
+// just been added to the cache. If data is nil (the block was added without
+// it), the stored blocks are discarded, since they must be consecutive.
+func (f *fullBlockStore) add(block *walletrpc.CompactBlock, data []byte) {
+	height := int(block.Height)
+	if data == nil {
+		f.truncate(f.first)
+		f.first = height + 1
+		return
+	}
+	if height != f.next() {
+		f.truncate(f.first)
+		f.first = height
+	}
+	b := append(checksum(height, data), data...)
+	if _, err := f.blocksFile.Write(b); err != nil {
+		Log.Fatal("full blocks write failed: ", err)
+	}
+	b = binary.LittleEndian.AppendUint32(nil, uint32(height))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(data)))
+	if _, err := f.lengthsFile.Write(b); err != nil {
+		Log.Fatal("full lengths write failed: ", err)
+	}
+	f.starts = append(f.starts, f.starts[len(f.starts)-1]+8+int64(len(data)))
+	f.index(block)
+}
+
+// truncate removes the stored blocks at and above the given height.
+func (f *fullBlockStore) truncate(height int) {
+	if height >= f.next() {
+		return
+	}
+	height = max(height, f.first)
+	index := height - f.first
+	if err := f.lengthsFile.Truncate(int64(8 * index)); err != nil {
+		Log.Fatal("truncate full lengths file failed: ", err)
+	}
+	if err := f.blocksFile.Truncate(f.starts[index]); err != nil {
+		Log.Fatal("truncate full blocks file failed: ", err)
+	}
+	f.starts = f.starts[:index+1]
+	f.unindex(f.first, height)
+}
+
+// dropBefore removes the stored blocks below the given height. If the db
+// files can't be rewritten without them (for example, because the disk is
+// full), all the stored blocks are discarded instead; they're optional.
+func (f *fullBlockStore) dropBefore(height int) {
+	if height <= f.first {
+		return
+	}
+	if height >= f.next() {
+		f.truncate(f.first)
+		f.first = height
+		return
+	}
+	n := height - f.first
+	offset := f.starts[n]
+	if err := f.rewriteFront(n, offset); err != nil {
+		Log.Warning("can't evict full blocks, discarding them: ", err)
+		f.truncate(f.first)
+		f.first = height
+		return
+	}
+	starts := make([]int64, 0, len(f.starts)-n)
+	for _, start := range f.starts[n:] {
+		starts = append(starts, start-offset)
+	}
+	f.starts = starts
+	f.first = height
+	f.unindex(f.first, f.next())
+}
+
+// rewriteFront rewrites the db files without the first n blocks, which
+// start at the given offset; see BlockCache.evict.
+func (f *fullBlockStore) rewriteFront(n int, offset int64) error {
+	blocksTmp, err := rewriteFront(f.blocksName, f.blocksFile, nil, offset)
+	if err != nil {
+		return err
+	}
+	lengthsTmp, err := rewriteFront(f.lengthsName, f.lengthsFile, nil, int64(8*n))
+	if err != nil {
+		discardTemp(blocksTmp)
+		return err
+	}
+	if err := replaceFile(f.blocksName, &f.blocksFile, blocksTmp); err != nil {
+		discardTemp(lengthsTmp)
+		return err
+	}
+	if err := replaceFile(f.lengthsName, &f.lengthsFile, lengthsTmp); err != nil {
+		// The files no longer match; the caller discards the blocks.
+		return err
+	}
+	return nil
+}
+
+// get returns the stored full block at the given height.
+func (f *fullBlockStore) get(height int) ([]byte, error) {
+	if height < f.first || height >= f.next() {
+		return nil, nil
+	}
+	index := height - f.first
+	b := make([]byte, f.starts[index+1]-f.starts[index])
+	if _, err := f.blocksFile.ReadAt(b, f.starts[index]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(checksum(height, b[8:]), b[:8]) {
+		return nil, errors.New("bad checksum")
+	}
+	return b[8:], nil
+}
+
+func (f *fullBlockStore) sync() {
+	f.lengthsFile.Sync()
+	f.blocksFile.Sync()
+}
+
+func (f *fullBlockStore) close() {
+	if f.lengthsFile != nil {
+		f.lengthsFile.Close()
+		f.lengthsFile = nil
+	}
+	if f.blocksFile != nil {
+		f.blocksFile.Close()
+		f.blocksFile = nil
+	}
+}
+
+// GetFullBlock returns the full (raw) block at the given height, or nil
+// if it isn't stored (see CacheFullBlocks).
+func (c *BlockCache) GetFullBlock(height int) []byte {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.full == nil {
+		return nil
+	}
+	data, err := c.full.get(height)
+	if err != nil {
+		Log.Warning("full block ", height, ": ", err)
+		return nil
+	}
+	return data
+}
+
+// GetTransaction returns the transaction with the given txid (in internal,
+// little-endian order) from the stored full blocks, or nil if it isn't
+// available (see CacheFullBlocks). Only transactions that are included in
+// the compact blocks can be found.
+func (c *BlockCache) GetTransaction(txid hash32.T) *walletrpc.RawTransaction {
+	c.mutex.RLock()
+	if c.full == nil {
+		c.mutex.RUnlock()
+		return nil
+	}
+	loc, ok := c.full.txids[txid]
+	var data []byte
+	var err error
+	if ok {
+		data, err = c.full.get(loc.height)
+	}
+	c.mutex.RUnlock()
+	if !ok || err != nil || data == nil {
+		return nil
+	}
+
+	block := parser.NewBlock()
+	if _, err := block.ParseFromSlice(data); err != nil {
+		Log.Warning("full block ", loc.height, ": ", err)
+		return nil
+	}
+	txs := block.Transactions()
+	if loc.index >= len(txs) {
+		return nil
+	}
+	return &walletrpc.RawTransaction{
+		Data:   txs[loc.index].Bytes(),
+		Height: uint64(loc.height),
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/zcash/lightwalletd/hash32"
+	"github.com/zcash/lightwalletd/parser"
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+func TestCacheFullBlocks(t *testing.T) {
+	CacheFullBlocks = true
+	defer func() { CacheFullBlocks = false }()
+
+	testBlocks, err := os.Open("../testdata/blocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testBlocks.Close()
+	var fullBlocks []*parser.Block
+	var rawBlocks [][]byte
+	scan := bufio.NewScanner(testBlocks)
+	for scan.Scan() {
+		data, err := hex.DecodeString(scan.Text())
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := parser.NewBlock()
+		if _, err := block.ParseFromSlice(data); err != nil {
+			t.Fatal(err)
+		}
+		// The ingestor gets the txids from the backend node.
+		for _, tx := range block.Transactions() {
+			tx.SetTxID(hash32.Sum256d(tx.Bytes()))
+		}
+		fullBlocks = append(fullBlocks, block)
+		rawBlocks = append(rawBlocks, data)
+	}
+	first := fullBlocks[0].GetHeight()
+
+	dir := t.TempDir()
+	c := NewBlockCache(dir, unitTestChain, first, 0)
+	var compacts []*walletrpc.CompactBlock
+	for i, block := range fullBlocks {
+		compact := block.ToCompactFiltered(parser.CompactFilter{OmitTransparent: true, KeepCoinbase: true})
+		if err := c.AddFull(first+i, compact, rawBlocks[i]); err != nil {
+			t.Fatal(err)
+		}
+		compacts = append(compacts, compact)
+	}
+	check := func(c *BlockCache, n int) {
+		t.Helper()
+		for i, block := range fullBlocks {
+			if got := c.GetFullBlock(first + i); (got != nil) != (i < n) ||
+				got != nil && !bytes.Equal(got, rawBlocks[i]) {
+				t.Fatal("unexpected full block at height", first+i)
+			}
+			coinbase := block.Transactions()[0]
+			tx := c.GetTransaction(coinbase.GetEncodableHash())
+			if i >= n {
+				if tx != nil {
+					t.Fatal("unexpected transaction at height", first+i)
+				}
+				continue
+			}
+			if tx == nil {
+				t.Fatal("transaction not found at height", first+i)
+			}
+			if !bytes.Equal(tx.Data, coinbase.Bytes()) || tx.Height != uint64(first+i) {
+				t.Fatal("unexpected transaction at height", first+i)
+			}
+		}
+	}
+	check(c, len(fullBlocks))
+	if c.GetTransaction(hash32.Nil) != nil {
+		t.Fatal("unexpected transaction for unknown txid")
+	}
+
+	// The index is rebuilt on restart.
+	c.Close()
+	c = NewBlockCache(dir, unitTestChain, first, -1)
+	check(c, len(fullBlocks))
+
+	// A reorg removes the full blocks too.
+	c.Reorg(first + 2)
+	check(c, 2)
+	if err := c.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A batch stores its full blocks.
+	if err := c.AddFullBatch(compacts[2:], rawBlocks[2:]); err != nil {
+		t.Fatal(err)
+	}
+	check(c, len(fullBlocks))
+	c.Reorg(first + 2)
+
+	// Adding a block without its full data discards the full blocks.
+	if err := c.Add(first+2, compacts[2]); err != nil {
+		t.Fatal(err)
+	}
+	check(c, 0)
+	c.Close()
+}
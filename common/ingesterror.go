@@ -0,0 +1,77 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zcash/lightwalletd/parser"
+)
+
+// ErrBlockParse means that a block received from the backend node couldn't
+// be parsed or was rejected (see parseBlockFromRPC).
+var ErrBlockParse = errors.New("invalid block")
+
+// ErrCacheWrite means that a block couldn't be added to the disk cache.
+var ErrCacheWrite = errors.New("cache write failed")
+
+// IngestErrorClass is the kind of a block ingestion failure; each is logged
+// at its own severity and counted by the lightwalletd_ingest_errors_total
+// metric (with the class as the label).
+type IngestErrorClass string
+
+const (
+	// The block has Sapling or Sprout elements, which aren't supported; this
+	// is expected (for old blocks), so it's logged at INFO.
+	IngestErrorUnsupported IngestErrorClass = "unsupported"
+	// The block couldn't be parsed, or failed verification (WARN).
+	IngestErrorParse IngestErrorClass = "parse"
+	// The backend node couldn't supply the block (WARN).
+	IngestErrorRPC IngestErrorClass = "rpc"
+	// The block couldn't be written to the disk cache (ERROR).
+	IngestErrorCache IngestErrorClass = "cache"
+)
+
+// ClassifyIngestError returns the class of an error from fetching, parsing,
+// or caching a block. Errors that aren't parse or cache errors are assumed
+// to be from the backend node.
+func ClassifyIngestError(err error) IngestErrorClass {
+	switch {
+	case errors.Is(err, ErrCacheWrite):
+		return IngestErrorCache
+	case errors.Is(err, parser.ErrUnsupportedSapling), errors.Is(err, parser.ErrUnsupportedSprout):
+		return IngestErrorUnsupported
+	case errors.Is(err, ErrBlockParse):
+		return IngestErrorParse
+	default:
+		return IngestErrorRPC
+	}
+}
+
+// Level returns the log severity for failures of this class.
+func (class IngestErrorClass) Level() logrus.Level {
+	switch class {
+	case IngestErrorUnsupported:
+		return logrus.InfoLevel
+	case IngestErrorCache:
+		return logrus.ErrorLevel
+	default:
+		return logrus.WarnLevel
+	}
+}
+
+// logIngestError logs and counts a failure to ingest the block at the given
+// height, according to its class, which it returns.
+func logIngestError(height int, err error, msg string) IngestErrorClass {
+	class := ClassifyIngestError(err)
+	ingestErrorsTotal.WithLabelValues(string(class)).Inc()
+	Log.WithFields(logrus.Fields{
+		"height": height,
+		"class":  class,
+		"error":  err,
+	}).Log(class.Level(), msg)
+	return class
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zcash/lightwalletd/parser"
+)
+
+func TestClassifyIngestError(t *testing.T) {
+	for _, tt := range []struct {
+		err   error
+		class IngestErrorClass
+		level logrus.Level
+	}{
+		{fmt.Errorf("%w: error parsing block: %w", ErrBlockParse, parser.ErrUnsupportedSapling),
+			IngestErrorUnsupported, logrus.InfoLevel},
+		{fmt.Errorf("%w: %w", ErrBlockParse, parser.ErrUnsupportedSprout),
+			IngestErrorUnsupported, logrus.InfoLevel},
+		{fmt.Errorf("%w: received overlong message", ErrBlockParse), IngestErrorParse, logrus.WarnLevel},
+		{errors.New("error requesting block: connection refused"), IngestErrorRPC, logrus.WarnLevel},
+		{fmt.Errorf("block 5: %w", ErrBlockPruned), IngestErrorRPC, logrus.WarnLevel},
+		{fmt.Errorf("%w: cache.Add: cache is read-only", ErrCacheWrite), IngestErrorCache, logrus.ErrorLevel},
+	} {
+		class := ClassifyIngestError(tt.err)
+		if class != tt.class || class.Level() != tt.level {
+			t.Fatal("unexpected class for", tt.err, class, class.Level())
+		}
+	}
+}
+
+// Each class of failure is logged at its severity and counted separately.
+func TestLogIngestError(t *testing.T) {
+	defer func(saved *logrus.Entry) { Log = saved }(Log)
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&buf)
+	Log = l.WithField("app", "test")
+
+	parseErrors := metricValue(t, ingestErrorsTotal.WithLabelValues("parse"))
+	cacheErrors := metricValue(t, ingestErrorsTotal.WithLabelValues("cache"))
+
+	// The backend node returns a block that can't be parsed.
+	RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		if string(params[1]) == "1" {
+			return json.RawMessage(`{"hash": "` + strings.Repeat("00", 32) + `", "tx": []}`), nil
+		}
+		return json.RawMessage(`"0400"`), nil
+	}
+	_, _, err := getFullBlockFromRPC(context.Background(), RPCFunc(RawRequest), 380640)
+	if class := logIngestError(380640, err, "getblock failed"); class != IngestErrorParse {
+		t.Fatal("unexpected class", class, err)
+	}
+	if !strings.Contains(buf.String(), "level=warning msg=\"getblock failed\"") ||
+		!strings.Contains(buf.String(), "class=parse") ||
+		!strings.Contains(buf.String(), "height=380640") {
+		t.Fatal("unexpected log:", buf.String())
+	}
+	if n := metricValue(t, ingestErrorsTotal.WithLabelValues("parse")); n != parseErrors+1 {
+		t.Fatal("parse error not counted", n)
+	}
+
+	buf.Reset()
+	logIngestError(380640, fmt.Errorf("%w: disk full", ErrCacheWrite), "cache add failed")
+	if !strings.Contains(buf.String(), "level=error msg=\"cache add failed\"") {
+		t.Fatal("unexpected log:", buf.String())
+	}
+	if n := metricValue(t, ingestErrorsTotal.WithLabelValues("cache")); n != cacheErrors+1 {
+		t.Fatal("cache error not counted", n)
+	}
+}
@@ -1,10 +1,15 @@
 package common
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"sync"
 	"time"
 
+	"github.com/zcash/lightwalletd/hash32"
+	"github.com/zcash/lightwalletd/parser"
 	"github.com/zcash/lightwalletd/walletrpc"
 )
 
@@ -34,7 +39,9 @@ var (
 	g_lock sync.Mutex
 )
 
-func GetMempool(sendToClient func(*walletrpc.RawTransaction) error) error {
+// GetMempool sends the transactions in zcashd's mempool, which it fetches
+// using client, to sendToClient as they arrive, until a new block is mined.
+func GetMempool(client RPCClient, sendToClient func(*walletrpc.RawTransaction) error) error {
 	g_lock.Lock()
 	index := 0
 	// Stay in this function until the tip block hash changes.
@@ -45,7 +52,7 @@ func GetMempool(sendToClient func(*walletrpc.RawTransaction) error) error {
 		// Don't fetch the mempool more often than every 2 seconds.
 		now := Time.Now()
 		if now.After(g_lastTime.Add(2 * time.Second)) {
-			blockChainInfo, err := GetBlockChainInfo()
+			blockChainInfo, err := getBlockChainInfo(client)
 			if err != nil {
 				g_lock.Unlock()
 				return err
@@ -59,7 +66,7 @@ func GetMempool(sendToClient func(*walletrpc.RawTransaction) error) error {
 				g_lastTime = time.Time{}
 				break
 			}
-			if err = refreshMempoolTxns(); err != nil {
+			if err = refreshMempoolTxns(client); err != nil {
 				g_lock.Unlock()
 				return err
 			}
@@ -86,9 +93,9 @@ func GetMempool(sendToClient func(*walletrpc.RawTransaction) error) error {
 }
 
 // RefreshMempoolTxns gets all new mempool txns and sends any new ones to waiting clients
-func refreshMempoolTxns() error {
+func refreshMempoolTxns(client RPCClient) error {
 	params := []json.RawMessage{}
-	result, rpcErr := RawRequest("getrawmempool", params)
+	result, rpcErr := client.RawRequest("getrawmempool", params)
 	if rpcErr != nil {
 		return rpcErr
 	}
@@ -113,7 +120,7 @@ func refreshMempoolTxns() error {
 		}
 
 		params := []json.RawMessage{txidJSON, json.RawMessage("1")}
-		result, rpcErr := RawRequest("getrawtransaction", params)
+		result, rpcErr := client.RawRequest("getrawtransaction", params)
 		if rpcErr != nil {
 			// Not an error; mempool transactions can disappear
 			continue
@@ -134,3 +141,208 @@ func refreshMempoolTxns() error {
 	}
 	return nil
 }
+
+// mempoolSubscriberBuffer is the number of new transactions that can be
+// waiting for a MempoolPoller subscriber; one that falls further behind is
+// dropped (its channel is closed).
+const mempoolSubscriberBuffer = 1000
+
+// MempoolPoller keeps compact versions of the (shielded) transactions in
+// zcashd's mempool, which it polls with getrawmempool. Each transaction is
+// fetched and parsed only once, when it's first seen, and then sent to the
+// subscribers.
+type MempoolPoller struct {
+	client RPCClient
+	mutex  sync.Mutex
+	// The transactions in the mempool as of the last poll, by (big-endian
+	// hex) txid; the value is nil if the transaction has no shielded parts.
+	seen map[string]*walletrpc.CompactTx
+	// The txids in seen, in the order they were first seen.
+	order       []string
+	subscribers map[chan *walletrpc.CompactTx]struct{}
+}
+
+// NewMempoolPoller returns a MempoolPoller, which sends its requests using
+// client, with an empty mempool; call Poll or Run to populate it.
+func NewMempoolPoller(client RPCClient) *MempoolPoller {
+	return &MempoolPoller{
+		client:      client,
+		seen:        make(map[string]*walletrpc.CompactTx),
+		subscribers: make(map[chan *walletrpc.CompactTx]struct{}),
+	}
+}
+
+// Run polls the mempool every interval until ctx is done. Poll errors are
+// logged (zcashd may be temporarily unavailable).
+func (p *MempoolPoller) Run(ctx context.Context, interval time.Duration) {
+	for ctx.Err() == nil {
+		if _, err := p.Poll(); err != nil {
+			Log.Warning("mempool poll failed: ", err)
+		}
+		sleepContext(ctx, interval)
+	}
+}
+
+// Poll fetches zcashd's mempool, fetches and parses the transactions that
+// are new since the last poll, and sends them to the subscribers. It returns
+// the new (shielded) transactions.
+func (p *MempoolPoller) Poll() ([]*walletrpc.CompactTx, error) {
+	result, rpcErr := p.client.RawRequest("getrawmempool", []json.RawMessage{})
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	var mempoolList []string
+	if err := json.Unmarshal(result, &mempoolList); err != nil {
+		return nil, err
+	}
+
+	// Fetch the new transactions without holding the mutex.
+	p.mutex.Lock()
+	var newTxids []string
+	for _, txidstr := range mempoolList {
+		if _, ok := p.seen[txidstr]; !ok {
+			newTxids = append(newTxids, txidstr)
+		}
+	}
+	p.mutex.Unlock()
+	fetched := make(map[string]*walletrpc.CompactTx, len(newTxids))
+	for _, txidstr := range newTxids {
+		tx, err := getMempoolCompactTx(p.client, txidstr)
+		if errors.Is(err, errMempoolTxGone) {
+			continue
+		}
+		if err != nil {
+			// Don't try it again (it's treated as unshielded).
+			Log.Warning("mempool transaction ", txidstr, ": ", err)
+		}
+		fetched[txidstr] = tx
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	inMempool := make(map[string]struct{}, len(mempoolList))
+	for _, txidstr := range mempoolList {
+		inMempool[txidstr] = struct{}{}
+	}
+	// Forget the transactions that have left the mempool (mined or expired).
+	order := p.order[:0]
+	for _, txidstr := range p.order {
+		if _, ok := inMempool[txidstr]; ok {
+			order = append(order, txidstr)
+		} else {
+			delete(p.seen, txidstr)
+		}
+	}
+	p.order = order
+	var newTxs []*walletrpc.CompactTx
+	for _, txidstr := range newTxids {
+		tx, ok := fetched[txidstr]
+		if _, dup := p.seen[txidstr]; !ok || dup {
+			continue
+		}
+		p.seen[txidstr] = tx
+		p.order = append(p.order, txidstr)
+		if tx != nil {
+			newTxs = append(newTxs, tx)
+		}
+	}
+	for _, tx := range newTxs {
+		for ch := range p.subscribers {
+			select {
+			case ch <- tx:
+			default:
+				Log.Warning("mempool subscriber fell behind, dropping it")
+				delete(p.subscribers, ch)
+				close(ch)
+			}
+		}
+	}
+	return newTxs, nil
+}
+
+// errMempoolTxGone means that a transaction has left the mempool.
+var errMempoolTxGone = errors.New("transaction is no longer in the mempool")
+
+// getMempoolCompactTx fetches the mempool transaction with the given
+// (big-endian hex) txid and returns its compact form, or nil if it has no
+// shielded parts.
+func getMempoolCompactTx(client RPCClient, txidstr string) (*walletrpc.CompactTx, error) {
+	txidJSON, err := json.Marshal(txidstr)
+	if err != nil {
+		return nil, err
+	}
+	params := []json.RawMessage{txidJSON, json.RawMessage("1")}
+	result, rpcErr := client.RawRequest("getrawtransaction", params)
+	if rpcErr != nil {
+		// Not an error; mempool transactions can disappear
+		return nil, errMempoolTxGone
+	}
+	rawtx, err := ParseRawTransaction(result)
+	if err != nil {
+		return nil, err
+	}
+	if rawtx.Height != 0 {
+		// It has been mined since the list of txids was retrieved.
+		return nil, errMempoolTxGone
+	}
+	tx := parser.NewTransaction()
+	rest, err := tx.ParseFromSlice(rawtx.Data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("extra data deserializing transaction")
+	}
+	if !tx.HasShieldedElements() {
+		return nil, nil
+	}
+	txidBigEndian, err := hex.DecodeString(txidstr)
+	if err != nil {
+		return nil, err
+	}
+	txid, err := hash32.FromSlice(txidBigEndian)
+	if err != nil {
+		return nil, err
+	}
+	// convert from big endian bytes to little endian and set as the txid
+	tx.SetTxID(hash32.Reverse(txid))
+	return tx.ToCompact( /* height */ 0), nil
+}
+
+// Snapshot returns the (shielded) transactions currently in the mempool,
+// in the order they were first seen.
+func (p *MempoolPoller) Snapshot() []*walletrpc.CompactTx {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var txs []*walletrpc.CompactTx
+	for _, txidstr := range p.order {
+		if tx := p.seen[txidstr]; tx != nil {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+// Subscribe returns a channel that receives each new (shielded) mempool
+// transaction, from the next Poll on. A subscriber that falls too far behind
+// is dropped and its channel closed; it can call Snapshot to catch up.
+func (p *MempoolPoller) Subscribe() <-chan *walletrpc.CompactTx {
+	ch := make(chan *walletrpc.CompactTx, mempoolSubscriberBuffer)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// Unsubscribe stops sending transactions to a channel returned by
+// Subscribe, and closes it.
+func (p *MempoolPoller) Unsubscribe(ch <-chan *walletrpc.CompactTx) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for c := range p.subscribers {
+		if c == ch {
+			delete(p.subscribers, c)
+			close(c)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/zcash/lightwalletd/hash32"
+	"github.com/zcash/lightwalletd/parser"
+	"github.com/zcash/lightwalletd/walletrpc"
+)
+
+// mempoolTestTxs returns (the hex of) the v5 test vector transactions that
+// can be parsed, separated into those with and without shielded parts.
+func mempoolTestTxs(t *testing.T) (shielded, unshielded []string) {
+	s, err := os.ReadFile("../testdata/tx_v5.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var testdata [][]json.RawMessage
+	if err := json.Unmarshal(s, &testdata); err != nil {
+		t.Fatal(err)
+	}
+	for _, onetx := range testdata[2:] {
+		var txHex string
+		json.Unmarshal(onetx[0], &txHex)
+		txBytes, _ := hex.DecodeString(txHex)
+		tx := parser.NewTransaction()
+		if rest, err := tx.ParseFromSlice(txBytes); err != nil || len(rest) > 0 {
+			continue
+		}
+		if tx.HasShieldedElements() {
+			shielded = append(shielded, txHex)
+		} else {
+			unshielded = append(unshielded, txHex)
+		}
+	}
+	if len(shielded) < 3 || len(unshielded) < 1 {
+		t.Fatal("not enough test transactions")
+	}
+	return shielded, unshielded
+}
+
+func TestMempoolPoller(t *testing.T) {
+	shielded, unshielded := mempoolTestTxs(t)
+	// The (big-endian) txids are fake.
+	txidA := strings.Repeat("0a", 32)
+	txidB := strings.Repeat("0b", 32)
+	txidC := strings.Repeat("0c", 32)
+	txidD := strings.Repeat("0d", 32)
+	txHex := map[string]string{
+		txidA: shielded[0],
+		txidB: unshielded[0],
+		txidC: shielded[1],
+		txidD: shielded[2],
+	}
+	var mempool []string
+	gone := map[string]bool{}
+	fetches := map[string]int{}
+	RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		switch method {
+		case "getrawmempool":
+			return json.Marshal(mempool)
+		case "getrawtransaction":
+			var txid string
+			json.Unmarshal(params[0], &txid)
+			fetches[txid]++
+			if gone[txid] {
+				return nil, errors.New("-5: No such mempool or blockchain transaction")
+			}
+			return json.Marshal(ZcashdRpcReplyGetrawtransaction{Hex: txHex[txid]})
+		}
+		t.Fatal("unexpected method", method)
+		return nil, nil
+	}
+	txidsOf := func(txs []*walletrpc.CompactTx) []string {
+		var txids []string
+		for _, tx := range txs {
+			txid, _ := hash32.FromSlice(tx.Txid)
+			txids = append(txids, hash32.Encode(hash32.Reverse(txid)))
+		}
+		return txids
+	}
+	check := func(what string, txs []*walletrpc.CompactTx, expected ...string) {
+		t.Helper()
+		if actual := txidsOf(txs); strings.Join(actual, ",") != strings.Join(expected, ",") {
+			t.Fatal(what, ": expected ", expected, " actual ", actual)
+		}
+	}
+	received := func(ch <-chan *walletrpc.CompactTx) []*walletrpc.CompactTx {
+		var txs []*walletrpc.CompactTx
+		for {
+			select {
+			case tx := <-ch:
+				txs = append(txs, tx)
+			default:
+				return txs
+			}
+		}
+	}
+
+	p := NewMempoolPoller(RPCFunc(RawRequest))
+	updates := p.Subscribe()
+
+	// B has no shielded parts, so it isn't returned.
+	mempool = []string{txidA, txidB, txidC}
+	newTxs, err := p.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("first poll", newTxs, txidA, txidC)
+	check("first snapshot", p.Snapshot(), txidA, txidC)
+	check("first updates", received(updates), txidA, txidC)
+	if len(newTxs[0].Actions) == 0 {
+		t.Fatal("compact transaction has no actions")
+	}
+
+	// A was mined; D is new but already gone when it's fetched.
+	mempool = []string{txidB, txidC, txidD}
+	gone[txidD] = true
+	newTxs, err = p.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("second poll", newTxs)
+	check("second snapshot", p.Snapshot(), txidC)
+	check("second updates", received(updates))
+
+	// D is available now.
+	gone[txidD] = false
+	newTxs, err = p.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("third poll", newTxs, txidD)
+	check("third snapshot", p.Snapshot(), txidC, txidD)
+	check("third updates", received(updates), txidD)
+
+	// Each transaction is fetched once (apart from retrying D).
+	for txid, n := range fetches {
+		if n != 1 && !(txid == txidD && n == 2) {
+			t.Fatal("transaction", txid, "fetched", n, "times")
+		}
+	}
+
+	// A transaction that's mined, then returns to the mempool, is new again.
+	mempool = []string{txidA, txidC, txidD}
+	newTxs, _ = p.Poll()
+	check("fourth poll", newTxs, txidA)
+	check("fourth snapshot", p.Snapshot(), txidC, txidD, txidA)
+	check("fourth updates", received(updates), txidA)
+
+	p.Unsubscribe(updates)
+	if _, ok := <-updates; ok {
+		t.Fatal("channel not closed by Unsubscribe")
+	}
+	mempool = nil
+	if _, err := p.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Snapshot()) != 0 {
+		t.Fatal("unexpected snapshot after the mempool emptied")
+	}
+}
+
+// A subscriber that doesn't keep up is dropped rather than blocking Poll.
+func TestMempoolPollerSlowSubscriber(t *testing.T) {
+	shielded, _ := mempoolTestTxs(t)
+	var mempool []string
+	RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		if method == "getrawmempool" {
+			return json.Marshal(mempool)
+		}
+		return json.Marshal(ZcashdRpcReplyGetrawtransaction{Hex: shielded[0]})
+	}
+	for i := 0; i <= mempoolSubscriberBuffer; i++ {
+		mempool = append(mempool, hex.EncodeToString(bytes.Repeat([]byte{byte(i), byte(i >> 8)}, 16)))
+	}
+	p := NewMempoolPoller(RPCFunc(RawRequest))
+	updates := p.Subscribe()
+	if _, err := p.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for range updates {
+		n++
+	}
+	if n != mempoolSubscriberBuffer {
+		t.Fatal("unexpected number of updates", n)
+	}
+	// Unsubscribing a dropped subscriber is harmless.
+	p.Unsubscribe(updates)
+}
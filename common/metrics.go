@@ -0,0 +1,240 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus metrics exported by the common package; these are served,
+// along with the gRPC metrics, on the http /metrics endpoint.
+var (
+	// Time taken to parse each full block fetched from the backend node,
+	// recorded only if ProfileBlockParse is enabled.
+	blockParseSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "lightwalletd_block_parse_seconds",
+		Help:    "Time to parse a full block received from the backend node.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10), // 100us to ~26s
+	})
+
+	// Blocks from the backend node that couldn't be parsed because they
+	// contain unsupported (Sapling or Sprout) elements.
+	unsupportedBlocksTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lightwalletd_unsupported_blocks_total",
+		Help: "Blocks rejected because they contain unsupported shielded elements.",
+	}, []string{"kind"})
+
+	// Failures to ingest a block, by class (see ClassifyIngestError).
+	ingestErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lightwalletd_ingest_errors_total",
+		Help: "Failures to fetch, parse, or cache a block, by class.",
+	}, []string{"class"})
+
+	// Requests for uncached blocks that shared another request's fetch from
+	// the backend node (see fetchGroup).
+	missFetchesCoalescedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_miss_fetches_coalesced_total",
+		Help: "Requests for uncached blocks that shared a fetch already in progress.",
+	})
+
+	// Which backend node an RPCFailover is using (1) or not (0), by
+	// endpoint name, and the number of times it switched.
+	rpcEndpointActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lightwalletd_rpc_endpoint_active",
+		Help: "Whether the backend node endpoint is the one in use.",
+	}, []string{"endpoint"})
+	rpcFailoversTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_rpc_failovers_total",
+		Help: "Switches to the next backend node endpoint after repeated failures.",
+	})
+
+	// Reorgs of at least deepReorgDepth blocks seen by the block ingestor.
+	deepReorgsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_deep_reorgs_total",
+		Help: "Reorgs that replaced at least 10 cached blocks.",
+	})
+
+	// Reorgs that the block ingestor refused to follow because they were
+	// deeper than MaxReorgDepth.
+	reorgsAbortedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_reorgs_aborted_total",
+		Help: "Reorgs not followed because they exceeded the maximum reorg depth.",
+	})
+
+	// Blocks added to the cache by the block ingestor, and the rate at
+	// which it added them recently (see ingestRate).
+	ingestedBlocksTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_ingested_blocks_total",
+		Help: "Blocks added to the cache by the block ingestor.",
+	})
+	ingestBlocksPerSecond = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lightwalletd_ingest_blocks_per_second",
+		Help: "Rate at which the block ingestor added blocks over the last few seconds.",
+	})
+
+	// The block ingestor's state (see ingestState): 1 for the current one,
+	// 0 for the other.
+	ingestStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lightwalletd_ingest_state",
+		Help: "Whether the block ingestor is catching up (bulk) or following the tip (tip).",
+	}, []string{"state"})
+
+	// Requests to the backend node, and those that failed, by method; see
+	// RecordRPC.
+	rpcRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lightwalletd_rpc_requests_total",
+		Help: "Requests to the backend node, by method.",
+	}, []string{"method"})
+	rpcErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lightwalletd_rpc_errors_total",
+		Help: "Failed requests to the backend node, by method.",
+	}, []string{"method"})
+)
+
+// The backend node's latest height, as last reported to lightwalletd, and
+// the height of the latest block the ingestor has cached; 0 if unknown.
+var (
+	backendHeight  atomic.Int64
+	ingestedHeight atomic.Int64
+)
+
+// Gauges for the above heights, and the difference (how far the ingestor is
+// behind the backend node), taken at scrape time.
+var (
+	backendHeightGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "lightwalletd_backend_height",
+		Help: "Latest block height reported by the backend node.",
+	}, func() float64 { return float64(backendHeight.Load()) })
+	ingestedHeightGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "lightwalletd_ingest_height",
+		Help: "Height of the latest block cached by the block ingestor.",
+	}, func() float64 { return float64(ingestedHeight.Load()) })
+	blocksBehindGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "lightwalletd_ingest_blocks_behind",
+		Help: "Blocks the backend node has that the block ingestor hasn't cached yet.",
+	}, func() float64 { return float64(blocksBehind()) })
+)
+
+// blocksBehind returns the number of blocks the ingestor is behind the
+// backend node, or 0 if either height is unknown.
+func blocksBehind() int64 {
+	backend, ingested := backendHeight.Load(), ingestedHeight.Load()
+	if backend == 0 || ingested == 0 {
+		return 0
+	}
+	return max(backend-ingested, 0)
+}
+
+// RecordRPC counts a request to the backend node with the given method, and
+// whether it failed, in the RPC metrics.
+func RecordRPC(method string, err error) {
+	rpcRequestsTotal.WithLabelValues(method).Inc()
+	if err != nil {
+		rpcErrorsTotal.WithLabelValues(method).Inc()
+	}
+}
+
+// InstrumentRawRequest returns a RawRequest function that sends requests
+// using rawRequest, counting them (see RecordRPC).
+func InstrumentRawRequest(rawRequest func(string, []json.RawMessage) (json.RawMessage, error)) func(string, []json.RawMessage) (json.RawMessage, error) {
+	return func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		result, err := rawRequest(method, params)
+		RecordRPC(method, err)
+		return result, err
+	}
+}
+
+func init() {
+	prometheus.MustRegister(blockParseSeconds)
+	prometheus.MustRegister(unsupportedBlocksTotal)
+	prometheus.MustRegister(ingestErrorsTotal)
+	prometheus.MustRegister(missFetchesCoalescedTotal)
+	prometheus.MustRegister(deepReorgsTotal)
+	prometheus.MustRegister(reorgsAbortedTotal)
+	prometheus.MustRegister(ingestedBlocksTotal)
+	prometheus.MustRegister(ingestBlocksPerSecond)
+	prometheus.MustRegister(ingestStateGauge)
+	prometheus.MustRegister(rpcRequestsTotal)
+	prometheus.MustRegister(rpcErrorsTotal)
+	prometheus.MustRegister(rpcEndpointActive)
+	prometheus.MustRegister(rpcFailoversTotal)
+	prometheus.MustRegister(backendHeightGauge)
+	prometheus.MustRegister(ingestedHeightGauge)
+	prometheus.MustRegister(blocksBehindGauge)
+}
+
+// Descriptions of the BlockCache metrics; see cacheCollector.
+var (
+	cacheHitsDesc = prometheus.NewDesc("lightwalletd_cache_hits_total",
+		"Blocks served from the compact block cache.", nil, nil)
+	cacheMissesDesc = prometheus.NewDesc("lightwalletd_cache_misses_total",
+		"Requests for blocks not in the compact block cache.", nil, nil)
+	cacheReorgsDesc = prometheus.NewDesc("lightwalletd_cache_reorgs_total",
+		"Reorgs that removed blocks from the compact block cache.", nil, nil)
+	cacheBlocksDesc = prometheus.NewDesc("lightwalletd_cache_blocks",
+		"Number of blocks in the compact block cache.", nil, nil)
+	cacheFirstHeightDesc = prometheus.NewDesc("lightwalletd_cache_first_height",
+		"Height of the first block in the compact block cache.", nil, nil)
+	cacheNextHeightDesc = prometheus.NewDesc("lightwalletd_cache_next_height",
+		"Height of the first block not in the compact block cache.", nil, nil)
+	cacheDiskBytesDesc = prometheus.NewDesc("lightwalletd_cache_disk_bytes",
+		"Size of the compact block cache db files.", nil, nil)
+	cacheOpsDesc = prometheus.NewDesc("lightwalletd_cache_operations_total",
+		"Compact block cache operations, by operation.", []string{"op"}, nil)
+	cacheOpSecondsDesc = prometheus.NewDesc("lightwalletd_cache_operation_seconds_total",
+		"Time spent in compact block cache operations, by operation.", []string{"op"}, nil)
+)
+
+// cacheCollector exports a BlockCache's statistics, taken at scrape time.
+type cacheCollector struct {
+	cache *BlockCache
+}
+
+func (cc cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheHitsDesc
+	ch <- cacheMissesDesc
+	ch <- cacheReorgsDesc
+	ch <- cacheBlocksDesc
+	ch <- cacheFirstHeightDesc
+	ch <- cacheNextHeightDesc
+	ch <- cacheDiskBytesDesc
+	ch <- cacheOpsDesc
+	ch <- cacheOpSecondsDesc
+}
+
+func (cc cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	m := cc.cache.Metrics()
+	ch <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(m.Hits))
+	ch <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(m.Misses))
+	ch <- prometheus.MustNewConstMetric(cacheReorgsDesc, prometheus.CounterValue, float64(m.Reorgs))
+	ch <- prometheus.MustNewConstMetric(cacheBlocksDesc, prometheus.GaugeValue, float64(m.Blocks))
+	ch <- prometheus.MustNewConstMetric(cacheFirstHeightDesc, prometheus.GaugeValue, float64(m.FirstHeight))
+	ch <- prometheus.MustNewConstMetric(cacheNextHeightDesc, prometheus.GaugeValue, float64(m.NextHeight))
+	ch <- prometheus.MustNewConstMetric(cacheDiskBytesDesc, prometheus.GaugeValue, float64(m.DiskBytes))
+	t := cc.cache.Timings()
+	for _, op := range []struct {
+		name   string
+		timing CacheTiming
+	}{
+		{"add", t.Add},
+		{"get", t.Get},
+		{"get_range", t.GetRange},
+		{"reorg", t.Reorg},
+	} {
+		ch <- prometheus.MustNewConstMetric(cacheOpsDesc, prometheus.CounterValue,
+			float64(op.timing.Count), op.name)
+		ch <- prometheus.MustNewConstMetric(cacheOpSecondsDesc, prometheus.CounterValue,
+			op.timing.Total.Seconds(), op.name)
+	}
+}
+
+// RegisterMetrics registers the cache's statistics (see Metrics) with the
+// given Prometheus registry, normally prometheus.DefaultRegisterer.
+func (c *BlockCache) RegisterMetrics(reg prometheus.Registerer) error {
+	return reg.Register(cacheCollector{c})
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// RPCClient is the transport for requests to the backend node's JSON-RPC
+// interface (getblock, getblockchaininfo, getrawmempool, sendrawtransaction,
+// and so on); each request's params and result are JSON, as the node sends
+// and receives them. frontend.HTTPClient is the default implementation;
+// tests, and operators using a proxy or an alternative node, can provide
+// their own. The block ingestor and the mempool are given the client they
+// use; the other requests (the frontend's) use RawRequest, see SetRPCClient.
+type RPCClient interface {
+	RawRequest(method string, params []json.RawMessage) (json.RawMessage, error)
+}
+
+// RPCContextClient is an RPCClient that can cancel a request when its
+// context is done; otherwise, a request that times out is abandoned (see
+// rawRequestContext).
+type RPCContextClient interface {
+	RPCClient
+	RawRequestContext(ctx context.Context, method string, params []json.RawMessage) (json.RawMessage, error)
+}
+
+// RPCBatchClient is an RPCClient that can send several requests together,
+// in a single (JSON-RPC batch) HTTP request. RawBatchRequest returns their
+// replies in the same order, and cancels the request if ctx is done first.
+// Its error is for the batch as a whole, for example, if the backend node
+// doesn't support batches (see ErrBatchUnsupported). The ingestor uses
+// batches during catch-up (see IngestBatchThreshold).
+type RPCBatchClient interface {
+	RPCClient
+	RawBatchRequest(ctx context.Context, requests []RPCRequest) ([]RPCResult, error)
+}
+
+// RPCFunc adapts a function to an RPCClient.
+type RPCFunc func(method string, params []json.RawMessage) (json.RawMessage, error)
+
+// RawRequest calls f.
+func (f RPCFunc) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	return f(method, params)
+}
+
+// SetRPCClient makes client the transport for the requests to the backend
+// node that aren't given one (see RPCClient) by setting RawRequest.
+func SetRPCClient(client RPCClient) {
+	RawRequest = client.RawRequest
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// mockRPCClient is an RPCContextClient serving only getblockchaininfo.
+type mockRPCClient struct {
+	requests, contextRequests int
+}
+
+func (m *mockRPCClient) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	m.requests++
+	return json.Marshal(&ZcashdRpcReplyGetblockchaininfo{Chain: "mock", Blocks: 380650})
+}
+
+func (m *mockRPCClient) RawRequestContext(ctx context.Context, method string, params []json.RawMessage) (json.RawMessage, error) {
+	m.contextRequests++
+	return m.RawRequest(method, params)
+}
+
+func TestSetRPCClient(t *testing.T) {
+	defer func(saved func(string, []json.RawMessage) (json.RawMessage, error)) { RawRequest = saved }(RawRequest)
+	mock := &mockRPCClient{}
+	SetRPCClient(mock)
+	info, err := GetBlockChainInfo()
+	if err != nil || info.Chain != "mock" || info.Blocks != 380650 || mock.requests != 1 {
+		t.Fatal("unexpected result", info, err, mock.requests)
+	}
+}
+
+func TestRawRequestContext(t *testing.T) {
+	mock := &mockRPCClient{}
+	if _, err := rawRequestContext(context.Background(), mock, "getblockchaininfo", nil); err != nil || mock.contextRequests != 1 {
+		t.Fatal("context request not sent to the client", err, mock.contextRequests)
+	}
+
+	// A client that can't cancel requests.
+	calls := 0
+	client := RPCFunc(func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		calls++
+		return mock.RawRequest(method, params)
+	})
+	if _, err := rawRequestContext(context.Background(), client, "getblockchaininfo", nil); err != nil || calls != 1 {
+		t.Fatal("request not sent to the function", err, calls)
+	}
+}
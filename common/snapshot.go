@@ -0,0 +1,157 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/zcash/lightwalletd/hash32"
+	"github.com/zcash/lightwalletd/walletrpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// A cache snapshot, used to seed a new server's cache from another's, is:
+//
+//	snapshotMagic (8 bytes, includes the format version)
+//	chain name length (1 byte), chain name
+//	first height, number of blocks (uint64 little-endian each)
+//	for each block: checksum (8 bytes), length (uint32 little-endian),
+//	    marshalled compact block
+var snapshotMagic = []byte("lwdsnap1")
+
+// maxSnapshotBlockLength is the largest block length accepted in a snapshot,
+// the same limit as in the cache's lengths file.
+const maxSnapshotBlockLength = 4 * 1000 * 1000
+
+// ExportSnapshot writes all of the cache's blocks to w as a snapshot that
+// can be loaded by ImportSnapshot. It returns the number of blocks written.
+// The cache remains usable (isn't locked) during the export, but it fails
+// if a reorg removes blocks that haven't been written yet.
+func (c *BlockCache) ExportSnapshot(w io.Writer) (int, error) {
+	c.mutex.RLock()
+	first, next := c.firstBlock, c.nextBlock
+	c.mutex.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	header := append([]byte(nil), snapshotMagic...)
+	header = append(header, byte(len(c.chainName)))
+	header = append(header, c.chainName...)
+	header = binary.LittleEndian.AppendUint64(header, uint64(first))
+	header = binary.LittleEndian.AppendUint64(header, uint64(next-first))
+	if _, err := bw.Write(header); err != nil {
+		return 0, err
+	}
+	n := 0
+	for block := range c.GetRange(first, next-1) {
+		data, err := proto.Marshal(block)
+		if err != nil {
+			return n, err
+		}
+		entry := checksum(int(block.Height), data)
+		entry = binary.LittleEndian.AppendUint32(entry, uint32(len(data)))
+		if _, err := bw.Write(append(entry, data...)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if n != next-first {
+		return n, fmt.Errorf("ExportSnapshot: block %d no longer cached", first+n)
+	}
+	return n, bw.Flush()
+}
+
+// ImportSnapshot adds the blocks from a snapshot (written by ExportSnapshot)
+// to the cache. If the cache is empty, it starts at the snapshot's first
+// height (or the cache's first height, if that's higher); otherwise,
+// the snapshot's blocks below the cache's next height are skipped, and the
+// rest must link to the latest cached block. A snapshot for a different
+// chain is rejected. It returns the number of blocks added.
+func (c *BlockCache) ImportSnapshot(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return 0, fmt.Errorf("ImportSnapshot: reading header: %w", err)
+	}
+	if !bytes.Equal(header[:len(snapshotMagic)], snapshotMagic) {
+		return 0, errors.New("ImportSnapshot: not a cache snapshot (or unsupported version)")
+	}
+	chainName := make([]byte, header[len(snapshotMagic)])
+	heights := make([]byte, 16)
+	if _, err := io.ReadFull(br, chainName); err != nil {
+		return 0, fmt.Errorf("ImportSnapshot: reading header: %w", err)
+	}
+	if _, err := io.ReadFull(br, heights); err != nil {
+		return 0, fmt.Errorf("ImportSnapshot: reading header: %w", err)
+	}
+	if string(chainName) != c.chainName {
+		return 0, fmt.Errorf("ImportSnapshot: snapshot is for chain %q, not %q",
+			chainName, c.chainName)
+	}
+	first := int(binary.LittleEndian.Uint64(heights[:8]))
+	count := int(binary.LittleEndian.Uint64(heights[8:]))
+
+	c.mutex.Lock()
+	if c.readOnly {
+		c.mutex.Unlock()
+		return 0, errors.New("ImportSnapshot: cache is read-only")
+	}
+	if c.nextBlock == c.firstBlock && first > c.firstBlock {
+		c.firstBlock = first
+		c.nextBlock = first
+	}
+	c.mutex.Unlock()
+
+	added := 0
+	entryHeader := make([]byte, 12)
+	for height := first; height < first+count; height++ {
+		if _, err := io.ReadFull(br, entryHeader); err != nil {
+			return added, fmt.Errorf("ImportSnapshot: block %d: %w", height, err)
+		}
+		length := binary.LittleEndian.Uint32(entryHeader[8:])
+		if length > maxSnapshotBlockLength {
+			return added, fmt.Errorf("ImportSnapshot: block %d: bad length %d", height, length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return added, fmt.Errorf("ImportSnapshot: block %d: %w", height, err)
+		}
+		if !bytes.Equal(checksum(height, data), entryHeader[:8]) {
+			return added, fmt.Errorf("ImportSnapshot: block %d: bad checksum", height)
+		}
+		if height < c.GetFirstHeight() || height < c.GetNextHeight() {
+			// Already cached (or not wanted).
+			continue
+		}
+		block := &walletrpc.CompactBlock{}
+		if err := proto.Unmarshal(data, block); err != nil {
+			return added, fmt.Errorf("ImportSnapshot: block %d: %w", height, err)
+		}
+		if int(block.Height) != height {
+			return added, fmt.Errorf("ImportSnapshot: block %d: unexpected height %d", height, block.Height)
+		}
+		if height > c.GetNextHeight() {
+			return added, fmt.Errorf("ImportSnapshot: block %d is beyond the cache's next height %d",
+				height, c.GetNextHeight())
+		}
+		prevHash, err := hash32.FromSlice(block.PrevHash)
+		if err != nil {
+			return added, fmt.Errorf("ImportSnapshot: block %d: %w", height, err)
+		}
+		if !c.HashMatch(prevHash) {
+			return added, fmt.Errorf("ImportSnapshot: block %d doesn't link to the cached chain", height)
+		}
+		if err := c.Add(height, block); err != nil {
+			return added, err
+		}
+		added++
+	}
+	c.Flush()
+	return added, nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCacheSnapshot(t *testing.T) {
+	const startHeight = 1000
+	src := NewBlockCache(t.TempDir(), unitTestChain, startHeight, 0)
+	defer src.Close()
+	for height := startHeight + 5; height < startHeight+25; height++ {
+		if height == startHeight+5 {
+			// As if the older blocks had been evicted.
+			src.firstBlock, src.nextBlock = height, height
+		}
+		if err := src.Add(height, testCompactBlock(height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var snapshot bytes.Buffer
+	if n, err := src.ExportSnapshot(&snapshot); err != nil || n != 20 {
+		t.Fatal("ExportSnapshot: ", n, err)
+	}
+
+	// Import into an empty cache.
+	dst := NewBlockCache(t.TempDir(), unitTestChain, startHeight, 0)
+	defer dst.Close()
+	if n, err := dst.ImportSnapshot(bytes.NewReader(snapshot.Bytes())); err != nil || n != 20 {
+		t.Fatal("ImportSnapshot: ", n, err)
+	}
+	if dst.GetFirstHeight() != startHeight+5 || dst.GetLatestHeight() != startHeight+24 {
+		t.Fatal("unexpected height range: ", dst.GetFirstHeight(), dst.GetLatestHeight())
+	}
+	for height := startHeight + 5; height < startHeight+25; height++ {
+		if !proto.Equal(dst.Get(height), src.Get(height)) {
+			t.Fatal("unexpected block at height ", height)
+		}
+	}
+
+	// Importing again, into a cache that has some of the blocks, adds the rest.
+	dst.Reorg(startHeight + 15)
+	if n, err := dst.ImportSnapshot(bytes.NewReader(snapshot.Bytes())); err != nil || n != 10 {
+		t.Fatal("ImportSnapshot: ", n, err)
+	}
+	if dst.GetLatestHash() != src.GetLatestHash() {
+		t.Fatal("unexpected latest hash")
+	}
+
+	// A snapshot of a different chain is rejected.
+	other := NewBlockCache(t.TempDir(), "othernet", startHeight, 0)
+	defer other.Close()
+	_, err := other.ImportSnapshot(bytes.NewReader(snapshot.Bytes()))
+	if err == nil || !strings.Contains(err.Error(), "is for chain") {
+		t.Fatal("ImportSnapshot of wrong chain: ", err)
+	}
+	if other.GetLatestHeight() != -1 {
+		t.Fatal("wrong-chain import changed the cache")
+	}
+
+	// A corrupted or truncated snapshot is rejected.
+	bad := bytes.Clone(snapshot.Bytes())
+	bad[len(bad)-10] ^= 1
+	for _, data := range [][]byte{bad, snapshot.Bytes()[:snapshot.Len()-10]} {
+		c := NewBlockCache(t.TempDir(), unitTestChain, startHeight, 0)
+		n, err := c.ImportSnapshot(bytes.NewReader(data))
+		if err == nil || n != 19 || c.GetLatestHeight() != startHeight+23 {
+			t.Fatal("ImportSnapshot of bad snapshot: ", n, err)
+		}
+		c.Close()
+	}
+	if _, err := other.ImportSnapshot(strings.NewReader("not a snapshot")); err == nil {
+		t.Fatal("ImportSnapshot of garbage unexpected success")
+	}
+}
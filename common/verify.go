@@ -0,0 +1,158 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zcash/lightwalletd/hash32"
+	"github.com/zcash/lightwalletd/parser"
+)
+
+// VerifyMismatch is a difference between a block as parsed by lightwalletd
+// and as reported by the backend node.
+type VerifyMismatch struct {
+	Height int
+	What   string // such as "hash" or "txid 2"
+	Detail string
+}
+
+// VerifyReport is the result of VerifyRange.
+type VerifyReport struct {
+	First, Last int
+	Blocks      int // blocks fetched and compared
+	Txs         int
+	TxidsLocal  int // transactions whose ids were computed locally
+	Mismatches  []VerifyMismatch
+}
+
+// WriteSummary writes a human-readable summary of the report.
+func (r *VerifyReport) WriteSummary(w io.Writer) {
+	fmt.Fprintf(w, "Verified blocks %d to %d: %d blocks, %d transactions (%d txids computed locally)\n",
+		r.First, r.Last, r.Blocks, r.Txs, r.TxidsLocal)
+	if len(r.Mismatches) == 0 {
+		fmt.Fprintln(w, "No mismatches")
+		return
+	}
+	fmt.Fprintf(w, "%d mismatches:\n", len(r.Mismatches))
+	for _, m := range r.Mismatches {
+		fmt.Fprintf(w, "  %d %s: %s\n", m.Height, m.What, m.Detail)
+	}
+}
+
+// VerifyRange fetches the blocks from first to last (inclusive) from the
+// backend node, parses them, and compares the block hashes, heights, and
+// (where they can be computed locally) txids with those the node reports,
+// and the merkle roots with the node's txids. It's a diagnostic, for
+// example after upgrading the node or changing the parser; it doesn't
+// touch the disk cache. Mismatches are logged and returned in the report;
+// an error is returned only if the node (which client sends the requests
+// to) can't supply a block.
+func VerifyRange(ctx context.Context, client RPCClient, first, last int) (*VerifyReport, error) {
+	r := &VerifyReport{First: first, Last: last}
+	for height := first; height <= last; height++ {
+		if err := ctx.Err(); err != nil {
+			return r, err
+		}
+		block1, raw, err := getBlockForVerify(ctx, client, height)
+		if err != nil {
+			return r, err
+		}
+		r.Blocks++
+		r.verifyBlock(height, block1, raw)
+	}
+	return r, nil
+}
+
+// getBlockForVerify returns the backend node's verbose getblock reply for
+// the given height, and the raw block with the hash it reports.
+func getBlockForVerify(ctx context.Context, client RPCClient, height int) (*ZcashRpcReplyGetblock1, []byte, error) {
+	heightJSON, _ := json.Marshal(strconv.Itoa(height))
+	result, err := rawRequestContext(ctx, client, "getblock", []json.RawMessage{heightJSON, json.RawMessage("1")})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error requesting verbose block %d: %w", height, err)
+	}
+	var block1 ZcashRpcReplyGetblock1
+	if err := json.Unmarshal(result, &block1); err != nil {
+		return nil, nil, fmt.Errorf("error reading verbose block %d: %w", height, err)
+	}
+	hashJSON, _ := json.Marshal(block1.Hash)
+	result, err = rawRequestContext(ctx, client, "getblock", []json.RawMessage{hashJSON, json.RawMessage("0")})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error requesting block %d: %w", height, err)
+	}
+	var rawHex string
+	if err := json.Unmarshal(result, &rawHex); err != nil {
+		return nil, nil, fmt.Errorf("error reading block %d: %w", height, err)
+	}
+	raw, err := hex.DecodeString(rawHex)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding block %d: %w", height, err)
+	}
+	return &block1, raw, nil
+}
+
+// verifyBlock compares the raw block at the given height with the backend
+// node's verbose getblock reply, recording any mismatches.
+func (r *VerifyReport) verifyBlock(height int, block1 *ZcashRpcReplyGetblock1, raw []byte) {
+	mismatch := func(what, format string, args ...any) {
+		m := VerifyMismatch{Height: height, What: what, Detail: fmt.Sprintf(format, args...)}
+		Log.WithFields(logrus.Fields{
+			"height": height,
+			"detail": m.Detail,
+		}).Warning("verify: ", what, " mismatch")
+		r.Mismatches = append(r.Mismatches, m)
+	}
+	block := parser.NewBlock()
+	rest, err := block.ParseFromSlice(raw)
+	if err == nil && len(rest) != 0 {
+		err = fmt.Errorf("%d bytes left over", len(rest))
+	}
+	if err != nil {
+		mismatch("parse", "lightwalletd can't parse the block: %v", err)
+		return
+	}
+	if hash := hash32.Encode(block.ComputeHash()); hash != block1.Hash {
+		mismatch("hash", "lightwalletd %s, node %s", hash, block1.Hash)
+	}
+	if coinbaseHeight, err := block.CoinbaseHeight(); err != nil {
+		mismatch("height", "lightwalletd can't read the height: %v", err)
+	} else if coinbaseHeight != height {
+		mismatch("height", "lightwalletd %d, node %d", coinbaseHeight, height)
+	}
+	txs := block.Transactions()
+	r.Txs += len(txs)
+	if len(txs) != len(block1.Tx) {
+		mismatch("txcount", "lightwalletd %d, node %d", len(txs), len(block1.Tx))
+		return
+	}
+	for i, tx := range txs {
+		nodeTxid, err := hash32.Decode(block1.Tx[i])
+		if err != nil {
+			mismatch(fmt.Sprintf("txid %d", i), "node's txid %q is invalid", block1.Tx[i])
+			continue
+		}
+		tx.SetTxID(hash32.Reverse(nodeTxid))
+		txid, ok := tx.ComputeTxID()
+		if !ok {
+			continue
+		}
+		r.TxidsLocal++
+		if txid := hash32.Encode(hash32.Reverse(txid)); txid != block1.Tx[i] {
+			mismatch(fmt.Sprintf("txid %d", i), "lightwalletd %s, node %s", txid, block1.Tx[i])
+		}
+	}
+	// This checks the node's ids of the transactions that can't be computed
+	// locally.
+	if err := block.VerifyMerkleRoot(); err != nil {
+		mismatch("merkleroot", "%v", err)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zcash/lightwalletd/parser"
+)
+
+// verifyStub returns a RawRequest stub that serves the first n test blocks
+// (starting at 380640) with their correct hashes and txids, after calling
+// tamper, which may change the verbose reply, for each.
+func verifyStub(t *testing.T, n int, tamper func(int, *ZcashRpcReplyGetblock1)) func(string, []json.RawMessage) (json.RawMessage, error) {
+	raws := map[string][]byte{}
+	replies := map[int]*ZcashRpcReplyGetblock1{}
+	for i := range n {
+		var blockHex string
+		json.Unmarshal(blocks[i], &blockHex)
+		raw, _ := hex.DecodeString(blockHex)
+		block := parser.NewBlock()
+		if _, err := block.ParseFromSlice(raw); err != nil {
+			t.Fatal(err)
+		}
+		reply := &ZcashRpcReplyGetblock1{Hash: block.GetDisplayHashString()}
+		for _, tx := range block.Transactions() {
+			txid, _ := tx.ComputeTxID()
+			tx.SetTxID(txid)
+			reply.Tx = append(reply.Tx, tx.GetDisplayHashString())
+		}
+		tamper(380640+i, reply)
+		raws[reply.Hash] = raw
+		replies[380640+i] = reply
+	}
+	return func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		if method != "getblock" {
+			t.Fatal("unexpected method", method)
+		}
+		var arg string
+		json.Unmarshal(params[0], &arg)
+		if string(params[1]) == "1" {
+			height, _ := strconv.Atoi(arg)
+			if replies[height] == nil {
+				return nil, errors.New("-8: Block height out of range")
+			}
+			return json.Marshal(replies[height])
+		}
+		return json.Marshal(hex.EncodeToString(raws[arg]))
+	}
+}
+
+func TestVerifyRange(t *testing.T) {
+	RawRequest = verifyStub(t, 4, func(int, *ZcashRpcReplyGetblock1) {})
+	report, err := VerifyRange(context.Background(), RPCFunc(RawRequest), 380640, 380643)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Blocks != 4 || report.Txs != 5 || report.TxidsLocal != 5 || len(report.Mismatches) != 0 {
+		t.Fatal("unexpected report", *report)
+	}
+	var summary strings.Builder
+	report.WriteSummary(&summary)
+	if !strings.Contains(summary.String(), "No mismatches") {
+		t.Fatal("unexpected summary:", summary.String())
+	}
+}
+
+func TestVerifyRangeMismatches(t *testing.T) {
+	wrongTxid := strings.Repeat("ab", 32)
+	RawRequest = verifyStub(t, 4, func(height int, reply *ZcashRpcReplyGetblock1) {
+		switch height {
+		case 380640:
+			// The node's hash is still used to fetch the (same) raw block.
+			reply.Hash = strings.Repeat("0c", 32)
+		case 380641:
+			reply.Tx[0] = wrongTxid
+		case 380643:
+			reply.Tx = reply.Tx[:1]
+		}
+	})
+	report, err := VerifyRange(context.Background(), RPCFunc(RawRequest), 380640, 380643)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for _, m := range report.Mismatches {
+		found = append(found, strconv.Itoa(m.Height)+" "+m.What)
+	}
+	expected := []string{"380640 hash", "380641 txid 0", "380643 txcount"}
+	if strings.Join(found, ",") != strings.Join(expected, ",") {
+		t.Fatal("unexpected mismatches", found)
+	}
+	if report.Blocks != 4 {
+		t.Fatal("unexpected block count", report.Blocks)
+	}
+	var summary strings.Builder
+	report.WriteSummary(&summary)
+	if !strings.Contains(summary.String(), "3 mismatches") ||
+		!strings.Contains(summary.String(), "380641 txid 0: lightwalletd ") ||
+		!strings.Contains(summary.String(), "node "+wrongTxid) {
+		t.Fatal("unexpected summary:", summary.String())
+	}
+
+	// A block the node can't supply ends the run, with a partial report.
+	report, err = VerifyRange(context.Background(), RPCFunc(RawRequest), 380642, 380650)
+	if err == nil || report.Blocks != 2 {
+		t.Fatal("unexpected result", report.Blocks, err)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The backend node can publish notifications over ZMQ (its -zmqpubhashblock
+// option); this is a minimal ZMTP 3.0 subscriber (NULL security mechanism
+// only), enough to receive them without depending on libzmq.
+
+// zmqPollInterval is how often the ingestor polls for new blocks while it's
+// receiving ZMQ notifications, in case one is missed.
+var zmqPollInterval = 30 * time.Second
+
+// blockNotify is signalled when the backend node announces a new block.
+var blockNotify = make(chan struct{}, 1)
+
+// zmqConnected is whether the ZMQ subscriber is receiving notifications; if
+// not, the ingestor polls for new blocks every 2 seconds.
+var zmqConnected atomic.Bool
+
+// NotifyNewBlock wakes the block ingestor, if it's waiting for a new block,
+// so that it fetches the block immediately.
+func NotifyNewBlock() {
+	select {
+	case blockNotify <- struct{}{}:
+	default:
+		// already pending
+	}
+}
+
+// waitForBlock is called by the ingestor, when it's synced, to wait for up
+// to d (or zmqPollInterval, if ZMQ notifications are being received) before
+// checking for a new block; it returns early if ctx is done or a new block
+// is announced.
+func waitForBlock(ctx context.Context, d time.Duration) {
+	if zmqConnected.Load() {
+		d = zmqPollInterval
+	}
+	sleep := Time.Sleep
+	done := make(chan struct{})
+	go func() {
+		sleep(d)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	case <-blockNotify:
+	}
+}
+
+// RunZMQSubscriber subscribes to the backend node's hashblock notifications
+// at the given endpoint (such as tcp://127.0.0.1:28332), waking the block
+// ingestor for each, until ctx is done. If the endpoint can't be reached,
+// or the connection fails, it retries with backoff; meanwhile the ingestor
+// polls for new blocks.
+func RunZMQSubscriber(ctx context.Context, endpoint string) {
+	failures := 0
+	for ctx.Err() == nil {
+		err := zmqSubscribe(ctx, endpoint, "hashblock", func(parts [][]byte) {
+			failures = 0
+			handleZMQMessage(parts)
+		})
+		if zmqConnected.Swap(false) {
+			Log.Warning("Lost ZMQ connection to ", endpoint, ", polling for new blocks")
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		failures++
+		delay := backoffDelay(failures)
+		Log.WithFields(logrus.Fields{
+			"error":    err,
+			"failures": failures,
+			"retry_in": delay,
+		}).Warning("ZMQ notifications from " + NodeName + " unavailable, will retry")
+		sleepContext(ctx, delay)
+	}
+}
+
+// handleZMQMessage handles a notification from the backend node; its parts
+// are the topic, the body, and a sequence number.
+func handleZMQMessage(parts [][]byte) {
+	if len(parts) < 2 {
+		return
+	}
+	switch string(parts[0]) {
+	case "hashblock":
+		Log.Debug("ZMQ new block ", hex.EncodeToString(parts[1]))
+		NotifyNewBlock()
+	}
+}
+
+// zmqSubscribe connects to a ZMQ publisher, subscribes to the given topic,
+// and calls handle for each message received, until the connection fails
+// or ctx is done.
+func zmqSubscribe(ctx context.Context, endpoint, topic string, handle func([][]byte)) error {
+	network, address, err := parseZMQEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	r := bufio.NewReader(conn)
+	if err := zmtpHandshake(conn, r, "SUB"); err != nil {
+		return err
+	}
+	if err := writeZMTPFrame(conn, 0, append([]byte{1}, topic...)); err != nil {
+		return err
+	}
+	zmqConnected.Store(true)
+	Log.Info("Receiving new block notifications from ", endpoint)
+	for {
+		parts, err := readZMTPMessage(r)
+		if err != nil {
+			return err
+		}
+		handle(parts)
+	}
+}
+
+// parseZMQEndpoint returns the network and address of a tcp:// or ipc://
+// ZMQ endpoint.
+func parseZMQEndpoint(endpoint string) (string, string, error) {
+	scheme, address, ok := strings.Cut(endpoint, "://")
+	switch {
+	case !ok || address == "":
+	case scheme == "tcp":
+		return "tcp", address, nil
+	case scheme == "ipc":
+		return "unix", address, nil
+	}
+	return "", "", fmt.Errorf("unsupported ZMQ endpoint %q", endpoint)
+}
+
+// ZMTP frame flags
+const (
+	zmtpMore    = 1
+	zmtpLong    = 2
+	zmtpCommand = 4
+)
+
+// zmtpMaxFrame is the largest frame accepted; notifications are small.
+const zmtpMaxFrame = 1 << 24
+
+// zmtpHandshake exchanges the ZMTP 3.0 greeting and READY commands.
+func zmtpHandshake(w io.Writer, r *bufio.Reader, socketType string) error {
+	greeting := make([]byte, 64)
+	greeting[0] = 0xff // signature
+	greeting[9] = 0x7f
+	greeting[10] = 3 // version 3.0
+	copy(greeting[12:32], "NULL")
+	if _, err := w.Write(greeting); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(r, greeting); err != nil {
+		return err
+	}
+	if greeting[0] != 0xff || greeting[9] != 0x7f || greeting[10] < 3 {
+		return errors.New("ZMQ peer doesn't support ZMTP 3")
+	}
+	if mechanism := string(bytes.TrimRight(greeting[12:32], "\x00")); mechanism != "NULL" {
+		return fmt.Errorf("unsupported ZMQ security mechanism %q", mechanism)
+	}
+
+	ready := []byte("\x05READY\x0bSocket-Type")
+	ready = binary.BigEndian.AppendUint32(ready, uint32(len(socketType)))
+	ready = append(ready, socketType...)
+	if err := writeZMTPFrame(w, zmtpCommand, ready); err != nil {
+		return err
+	}
+	flags, body, err := readZMTPFrame(r)
+	if err != nil {
+		return err
+	}
+	if flags&zmtpCommand == 0 || !bytes.HasPrefix(body, []byte("\x05READY")) {
+		return errors.New("ZMQ peer didn't send READY")
+	}
+	return nil
+}
+
+// writeZMTPFrame writes one frame with the given flags (apart from zmtpLong,
+// which is added if needed).
+func writeZMTPFrame(w io.Writer, flags byte, body []byte) error {
+	var frame []byte
+	if len(body) > 255 {
+		frame = binary.BigEndian.AppendUint64([]byte{flags | zmtpLong}, uint64(len(body)))
+	} else {
+		frame = []byte{flags, byte(len(body))}
+	}
+	_, err := w.Write(append(frame, body...))
+	return err
+}
+
+// readZMTPFrame reads one frame, returning its flags and body.
+func readZMTPFrame(r *bufio.Reader) (byte, []byte, error) {
+	flags, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var size uint64
+	if flags&zmtpLong != 0 {
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(b[:])
+	} else {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		size = uint64(b)
+	}
+	if size > zmtpMaxFrame {
+		return 0, nil, fmt.Errorf("ZMQ frame too large (%d bytes)", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return flags, body, nil
+}
+
+// readZMTPMessage reads the frames of the next message, skipping commands.
+func readZMTPMessage(r *bufio.Reader) ([][]byte, error) {
+	var parts [][]byte
+	for {
+		flags, body, err := readZMTPFrame(r)
+		if err != nil {
+			return nil, err
+		}
+		if flags&zmtpCommand != 0 {
+			continue
+		}
+		parts = append(parts, body)
+		if flags&zmtpMore == 0 {
+			return parts, nil
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseZMQEndpoint(t *testing.T) {
+	for _, tt := range []struct {
+		endpoint, network, address string
+	}{
+		{"tcp://127.0.0.1:28332", "tcp", "127.0.0.1:28332"},
+		{"ipc:///tmp/zmq.sock", "unix", "/tmp/zmq.sock"},
+		{"udp://127.0.0.1:28332", "", ""},
+		{"127.0.0.1:28332", "", ""},
+		{"tcp://", "", ""},
+	} {
+		network, address, err := parseZMQEndpoint(tt.endpoint)
+		if network != tt.network || address != tt.address || (err == nil) != (tt.network != "") {
+			t.Fatal("unexpected result for", tt.endpoint, network, address, err)
+		}
+	}
+}
+
+// Without ZMQ, the synced ingestor polls every 2 seconds; with it, much less
+// often, but it wakes when a block is announced.
+func TestWaitForBlock(t *testing.T) {
+	Time.Sleep = sleepStub
+	defer func() { sleepCount, sleepDuration = 0, 0 }()
+	defer zmqConnected.Store(false)
+	ctx := context.Background()
+	waitForBlock(ctx, 2*time.Second)
+	if sleepCount != 1 || sleepDuration != 2*time.Second {
+		t.Fatal("unexpected sleep", sleepCount, sleepDuration)
+	}
+	zmqConnected.Store(true)
+	waitForBlock(ctx, 2*time.Second)
+	if sleepCount != 2 || sleepDuration != 2*time.Second+zmqPollInterval {
+		t.Fatal("unexpected sleep", sleepCount, sleepDuration)
+	}
+
+	// An announcement (even one made before waiting) ends the wait.
+	blocked := make(chan struct{})
+	defer close(blocked)
+	Time.Sleep = func(time.Duration) { <-blocked }
+	defer func() { Time.Sleep = sleepStub }()
+	NotifyNewBlock()
+	NotifyNewBlock() // coalesced
+	waitForBlock(ctx, 2*time.Second)
+	select {
+	case <-blockNotify:
+		t.Fatal("notifications not coalesced")
+	default:
+	}
+}
+
+// A hashblock notification makes the synced ingestor fetch the new block
+// immediately.
+func TestZMQSubscriber(t *testing.T) {
+	testT = t
+	// Without a notification, the synced ingestor would wait forever.
+	blocked := make(chan struct{})
+	defer close(blocked)
+	Time.Sleep = func(time.Duration) { <-blocked }
+	Time.Now = nowStub
+	defer func() { Time.Sleep = sleepStub }()
+	defer zmqConnected.Store(false)
+
+	chain := forkTestChain("a", 3)
+	var served atomic.Int32
+	served.Store(2)
+	RawRequest = chainStub(func() testChain {
+		return testChain{name: chain.name, blocks: chain.blocks[:served.Load()]}
+	})
+
+	// A fake backend node publishing notifications.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	publish := make(chan []byte)
+	published := make(chan error, 1)
+	go func() {
+		published <- func() error {
+			conn, err := listener.Accept()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			if err := zmtpHandshake(conn, r, "PUB"); err != nil {
+				return err
+			}
+			if _, body, err := readZMTPFrame(r); err != nil || string(body) != "\x01hashblock" {
+				t.Error("unexpected subscription", string(body), err)
+			}
+			for hash := range publish {
+				seq := binary.LittleEndian.AppendUint32(nil, 0)
+				writeZMTPFrame(conn, zmtpMore, []byte("hashblock"))
+				writeZMTPFrame(conn, zmtpMore, hash)
+				if err := writeZMTPFrame(conn, 0, seq); err != nil {
+					return err
+				}
+			}
+			return nil
+		}()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cache := NewBlockCache(t.TempDir(), unitTestChain, 380640, 0)
+	defer cache.Close()
+	ingestDone := make(chan struct{})
+	go func() {
+		BlockIngestorContext(ctx, RPCFunc(RawRequest), cache, 0)
+		close(ingestDone)
+	}()
+	zmqDone := make(chan struct{})
+	go func() {
+		RunZMQSubscriber(ctx, "tcp://"+listener.Addr().String())
+		close(zmqDone)
+	}()
+	defer func() {
+		cancel()
+		<-ingestDone
+		<-zmqDone
+	}()
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		for start := time.Now(); !cond(); time.Sleep(time.Millisecond) {
+			if time.Since(start) > 5*time.Second {
+				t.Fatal("timed out waiting for ", what)
+			}
+		}
+	}
+	waitFor("initial sync", func() bool { return cache.GetNextHeight() == 380642 })
+	waitFor("ZMQ connection", zmqConnected.Load)
+
+	served.Store(3)
+	hash := bytes.Repeat([]byte{1}, 32)
+	publish <- hash
+	waitFor("new block", func() bool { return cache.GetNextHeight() == 380643 })
+	close(publish)
+	if err := <-published; err != nil {
+		t.Fatal(err)
+	}
+}
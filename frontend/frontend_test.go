@@ -11,13 +11,22 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/sirupsen/logrus"
 	"github.com/zcash/lightwalletd/common"
+	"github.com/zcash/lightwalletd/hash32"
+	"github.com/zcash/lightwalletd/parser"
 	"github.com/zcash/lightwalletd/walletrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -33,7 +42,7 @@ const (
 	unitTestPath  = "unittestcache"
 	unitTestChain = "unittestnet"
 	testTxid      = "1234000000000000000000000000000000000000000000000000000000000000"
-	testBlockid   = "0000000000000000000000000000000000000000000000000000000000380640"
+	testBlockid   = "000a5e44b3b238d0cc36de7c0cb1ae5ac6e16f8727173abd295a83ebfa073b91"
 )
 
 // block 380640 used here is a real block from testnet
@@ -138,6 +147,66 @@ func TestGetTransaction(t *testing.T) {
 	}
 }
 
+func TestGetTransactionByID(t *testing.T) {
+	testT = t
+	lwd, _ := testsetup()
+	mined := hash32.T{1}
+	pending := hash32.T{2}
+	failing := hash32.T{3}
+	common.RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		if method != "getrawtransaction" {
+			t.Fatal("unexpected method:", method)
+		}
+		var txid string
+		json.Unmarshal(params[0], &txid)
+		switch txid {
+		case hash32.Encode(hash32.Reverse(mined)):
+			return json.Marshal(&common.ZcashdRpcReplyGetrawtransaction{
+				Hex:    hex.EncodeToString(rawTxData[0]),
+				Height: 380640,
+			})
+		case hash32.Encode(hash32.Reverse(pending)):
+			// zcashd omits the height of a mempool transaction
+			return []byte(`{"Hex": "` + hex.EncodeToString(rawTxData[1]) + `"}`), nil
+		case hash32.Encode(hash32.Reverse(failing)):
+			return nil, errors.New("-28: Loading block index...")
+		}
+		return nil, errors.New("-5: No such mempool or blockchain transaction")
+	}
+	get := func(txid hash32.T) (*walletrpc.RawTransaction, error) {
+		return lwd.GetTransaction(context.Background(), &walletrpc.TxFilter{Hash: hash32.ToSlice(txid)})
+	}
+
+	tx, err := get(mined)
+	if err != nil {
+		t.Fatal("GetTransaction (mined) failed", err)
+	}
+	if !bytes.Equal(tx.Data, rawTxData[0]) || tx.Height != 380640 {
+		t.Fatal("GetTransaction (mined) unexpected result", tx.Height)
+	}
+
+	if _, err := get(pending); status.Code(err) != codes.FailedPrecondition {
+		t.Fatal("GetTransaction (mempool) unexpected error", err)
+	}
+	common.GetTxMempool = true
+	defer func() { common.GetTxMempool = false }()
+	tx, err = get(pending)
+	if err != nil || !bytes.Equal(tx.Data, rawTxData[1]) || tx.Height != 0 {
+		t.Fatal("GetTransaction (mempool, allowed) unexpected result", err)
+	}
+
+	if _, err := get(hash32.T{4}); status.Code(err) != codes.NotFound {
+		t.Fatal("GetTransaction (unknown) unexpected error", err)
+	}
+	if _, err := get(failing); status.Code(err) != codes.Unavailable {
+		t.Fatal("GetTransaction (backend failure) unexpected error", err)
+	}
+	_, err = lwd.GetTransaction(context.Background(), &walletrpc.TxFilter{Hash: []byte{1}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatal("GetTransaction (short txid) unexpected error", err)
+	}
+}
+
 func getLatestBlockStub(method string, params []json.RawMessage) (json.RawMessage, error) {
 	step++
 
@@ -171,14 +240,6 @@ func getLatestBlockStub(method string, params []json.RawMessage) (json.RawMessag
 		}
 		return blocks[0], nil
 	case 3:
-		if method != "getblockchaininfo" {
-			testT.Fatal("unexpected method:", method)
-		}
-		return []byte("{\"Blocks\": 380640, " +
-			"\"BestBlockHash\": " +
-			"\"000a5e44b3b238d0cc36de7c0cb1ae5ac6e16f8727173abd295a83ebfa073b91\"}"), nil
-
-	case 4:
 		return nil, errors.New("getblock test error, too many requests")
 	}
 	testT.Fatal("unexpected call to getLatestBlockStub")
@@ -193,6 +254,12 @@ func TestGetLatestBlock(t *testing.T) {
 	// This argument is not used (it may be in the future)
 	req := &walletrpc.ChainSpec{}
 
+	// Nothing is cached yet, so there's nothing to serve.
+	_, err := lwd.GetLatestBlock(context.Background(), req)
+	if status.Code(err) != codes.Unavailable {
+		t.Fatal("GetLatestBlock on an empty cache: unexpected error", err)
+	}
+
 	// This does zcashd rpc "getblock", calls getLatestBlockStub() above
 	block, err := common.GetBlock(cache, 380640)
 	if err != nil {
@@ -201,17 +268,75 @@ func TestGetLatestBlock(t *testing.T) {
 	if err = cache.Add(380640, block); err != nil {
 		t.Fatal("cache.Add failed:", err)
 	}
-	blockID, err := lwd.GetLatestBlock(context.Background(), req)
+	latest := func(height uint64, hash []byte) {
+		t.Helper()
+		blockID, err := lwd.GetLatestBlock(context.Background(), req)
+		if err != nil {
+			t.Fatal("lwd.GetLatestBlock failed", err)
+		}
+		if blockID.Height != height {
+			t.Fatal("unexpected blockID.height", blockID.Height)
+		}
+		if !bytes.Equal(blockID.Hash, hash) {
+			t.Fatal("unexpected blockID.hash")
+		}
+	}
+	latest(380640, block.Hash)
+
+	next := &walletrpc.CompactBlock{
+		Height:   380641,
+		Hash:     bytes.Repeat([]byte{1}, 32),
+		PrevHash: block.Hash,
+	}
+	if err = cache.Add(380641, next); err != nil {
+		t.Fatal("cache.Add failed:", err)
+	}
+	latest(380641, next.Hash)
+
+	// Blocks within the confirmation depth aren't served.
+	cache.SetConfirmationDepth(1)
+	latest(380640, block.Hash)
+	cache.SetConfirmationDepth(0)
+
+	// A reorg replaces 380641; the replacement is served at once.
+	cache.Reorg(380641)
+	latest(380640, block.Hash)
+	next.Hash = bytes.Repeat([]byte{2}, 32)
+	if err = cache.Add(380641, next); err != nil {
+		t.Fatal("cache.Add failed:", err)
+	}
+	latest(380641, next.Hash)
+	step = 0
+}
+
+func getBlockchainInfoStub(method string, params []json.RawMessage) (json.RawMessage, error) {
+	if method != "getblockchaininfo" {
+		testT.Fatal("unexpected method:", method)
+	}
+	return []byte("{\"Blocks\": 380640, " +
+		"\"BestBlockHash\": " +
+		"\"000a5e44b3b238d0cc36de7c0cb1ae5ac6e16f8727173abd295a83ebfa073b91\"}"), nil
+}
+
+func TestGetLatestBlockNoCache(t *testing.T) {
+	testT = t
+	common.RawRequest = getBlockchainInfoStub
+	// With --nocache there's no cache, so the latest block comes from zcashd.
+	lwd, err := NewLwdStreamer(nil, "main", false /* enablePing */)
+	if err != nil {
+		t.Fatal("NewLwdStreamer failed:", err)
+	}
+	blockID, err := lwd.GetLatestBlock(context.Background(), &walletrpc.ChainSpec{})
 	if err != nil {
 		t.Fatal("lwd.GetLatestBlock failed", err)
 	}
 	if blockID.Height != 380640 {
-		t.Fatal("unexpected blockID.height")
+		t.Fatal("unexpected blockID.height", blockID.Height)
 	}
-	if string(blockID.Hash) != string(block.Hash) {
+	if hash32.Encode(hash32.Reverse(hash32.T(blockID.Hash))) !=
+		"000a5e44b3b238d0cc36de7c0cb1ae5ac6e16f8727173abd295a83ebfa073b91" {
 		t.Fatal("unexpected blockID.hash")
 	}
-	step = 0
 }
 
 // A valid address starts with "t", followed by 34 alpha characters;
@@ -411,8 +536,8 @@ func TestGetBlock(t *testing.T) {
 	if err == nil {
 		t.Fatal("GetBlock should have failed")
 	}
-	if !strings.Contains(err.Error(), "GetBlock: Block hash specifier is not yet implemented") {
-		t.Fatal("GetBlock hash unimplemented error message failed")
+	if !strings.Contains(err.Error(), "GetBlock: block hash has invalid length: 1") {
+		t.Fatal("GetBlock hash length error message failed", err)
 	}
 
 	// getblockStub() case 1: return error
@@ -434,6 +559,99 @@ func TestGetBlock(t *testing.T) {
 	step = 0
 }
 
+// getblockHashStub serves block 380640 by hash, counting requests.
+func getblockHashStub(hashHex string) func(string, []json.RawMessage) (json.RawMessage, error) {
+	return func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		if method != "getblock" {
+			testT.Fatal("unexpected method:", method)
+		}
+		step++
+		var arg string
+		if err := json.Unmarshal(params[0], &arg); err != nil {
+			testT.Fatal("could not unmarshal hash")
+		}
+		if arg != hashHex {
+			return nil, errors.New("-5: Block not found")
+		}
+		if string(params[1]) == "1" {
+			return []byte("{\"Tx\": [\"" + testTxid + "\"], \"Hash\": \"" + hashHex +
+				"\", \"Height\": 380640}"), nil
+		}
+		return blocks[0], nil
+	}
+}
+
+func TestGetBlockByHash(t *testing.T) {
+	testT = t
+	var blockHex string
+	if err := json.Unmarshal(blocks[0], &blockHex); err != nil {
+		t.Fatal(err)
+	}
+	blockData, _ := hex.DecodeString(blockHex)
+	parsed := parser.NewBlock()
+	if _, err := parsed.ParseFromSlice(blockData); err != nil {
+		t.Fatal(err)
+	}
+	hashHex := parsed.GetDisplayHashString()
+	hash := hash32.ToSlice(parsed.GetEncodableHash())
+	common.RawRequest = getblockHashStub(hashHex)
+	lwd, cache := testsetup()
+	step = 0
+
+	// Not cached: fetched (by hash) from zcashd.
+	block, err := lwd.GetBlock(context.Background(), &walletrpc.BlockID{Hash: hash})
+	if err != nil {
+		t.Fatal("GetBlock by hash failed:", err)
+	}
+	if block.Height != 380640 || !bytes.Equal(block.Hash, hash) {
+		t.Fatal("GetBlock by hash returned unexpected block", block.Height)
+	}
+	if step != 2 {
+		t.Fatal("unexpected number of getblock requests", step)
+	}
+
+	// Cached: zcashd only resolves the hash to a height.
+	if err := cache.Add(380640, block); err != nil {
+		t.Fatal("cache.Add failed:", err)
+	}
+	step = 0
+	block, err = lwd.GetBlock(context.Background(), &walletrpc.BlockID{Hash: hash})
+	if err != nil || block.Height != 380640 || step != 1 {
+		t.Fatal("GetBlock by hash (cached) unexpected result", err, step)
+	}
+	block, err = lwd.GetBlock(context.Background(), &walletrpc.BlockID{Height: 380640})
+	if err != nil || !bytes.Equal(block.Hash, hash) || step != 1 {
+		t.Fatal("GetBlock by height (cached) unexpected result", err, step)
+	}
+
+	// Beyond the latest cached block.
+	_, err = lwd.GetBlock(context.Background(), &walletrpc.BlockID{Height: 380641})
+	if status.Code(err) != codes.NotFound {
+		t.Fatal("GetBlock beyond the latest block: unexpected error", err)
+	}
+	if step != 1 {
+		t.Fatal("unexpected getblock request", step)
+	}
+
+	// Unknown to zcashd.
+	_, err = lwd.GetBlock(context.Background(), &walletrpc.BlockID{Hash: make([]byte, 32)})
+	if status.Code(err) != codes.NotFound {
+		t.Fatal("GetBlock unknown hash: unexpected error", err)
+	}
+
+	// Without a cache (--nocache), every block is fetched from zcashd.
+	lwd, err = NewLwdStreamer(nil, "main", false /* enablePing */)
+	if err != nil {
+		t.Fatal("NewLwdStreamer failed:", err)
+	}
+	step = 0
+	block, err = lwd.GetBlock(context.Background(), &walletrpc.BlockID{Hash: hash})
+	if err != nil || block.Height != 380640 || step != 2 {
+		t.Fatal("GetBlock by hash (no cache) unexpected result", err, step)
+	}
+	step = 0
+}
+
 type testgetbrange struct {
 	walletrpc.CompactTxStreamer_GetBlockRangeServer
 }
@@ -494,6 +712,154 @@ func TestGetBlockRangeNilArgs(t *testing.T) {
 	}
 }
 
+type testblockstream struct {
+	walletrpc.CompactTxStreamer_GetBlockRangeServer
+	ctx  context.Context
+	send func(*walletrpc.CompactBlock) error
+}
+
+func (tg *testblockstream) Context() context.Context {
+	return tg.ctx
+}
+
+func (tg *testblockstream) Send(cb *walletrpc.CompactBlock) error {
+	return tg.send(cb)
+}
+
+// parseTestBlock returns test block i (0..3) and the verbose getblock reply
+// for it.
+func parseTestBlock(t *testing.T, i int) (*parser.Block, []byte) {
+	var blockHex string
+	if err := json.Unmarshal(blocks[i], &blockHex); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := hex.DecodeString(blockHex)
+	block := parser.NewBlock()
+	if _, err := block.ParseFromSlice(data); err != nil {
+		t.Fatal(err)
+	}
+	var reply common.ZcashRpcReplyGetblock1
+	reply.Hash = block.GetDisplayHashString()
+	reply.Height = block.GetHeight()
+	for _, tx := range block.Transactions() {
+		reply.Tx = append(reply.Tx, tx.GetDisplayHashString())
+	}
+	verbose, _ := json.Marshal(reply)
+	return block, verbose
+}
+
+func TestGetBlockRangeStreaming(t *testing.T) {
+	testT = t
+	common.RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		t.Fatal("unexpected request", method)
+		return nil, nil
+	}
+	lwd, cache := testsetup()
+	const n = 1000 // several of the cache's GetRange batches
+	for height := 380640; height < 380640+n; height++ {
+		block := &walletrpc.CompactBlock{Height: uint64(height), Hash: make([]byte, 32)}
+		if err := cache.Add(height, block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	span := &walletrpc.BlockRange{
+		Start: &walletrpc.BlockID{Height: 380640},
+		End:   &walletrpc.BlockID{Height: 380640 + n - 1},
+	}
+
+	// The whole range, from the cache.
+	next := uint64(380640)
+	err := lwd.GetBlockRange(span, &testblockstream{
+		ctx: context.Background(),
+		send: func(cb *walletrpc.CompactBlock) error {
+			if cb.Height != next {
+				t.Fatal("unexpected height", cb.Height, "expecting", next)
+			}
+			next++
+			return nil
+		},
+	})
+	if err != nil || next != 380640+n {
+		t.Fatal("GetBlockRange failed", err, next)
+	}
+
+	// The client cancels the stream after receiving three blocks.
+	ctx, cancel := context.WithCancel(context.Background())
+	sent := 0
+	err = lwd.GetBlockRange(span, &testblockstream{
+		ctx: ctx,
+		send: func(cb *walletrpc.CompactBlock) error {
+			if err := ctx.Err(); err != nil {
+				return status.FromContextError(err).Err()
+			}
+			if sent++; sent == 3 {
+				cancel()
+			}
+			return nil
+		},
+	})
+	if status.Code(err) != codes.Canceled || sent != 3 {
+		t.Fatal("cancelled GetBlockRange unexpected result", err, sent)
+	}
+
+	// Too many blocks.
+	common.MaxBlockRange = n - 1
+	defer func() { common.MaxBlockRange = 0 }()
+	err = lwd.GetBlockRange(span, &testblockstream{
+		ctx: context.Background(),
+		send: func(cb *walletrpc.CompactBlock) error {
+			t.Fatal("unexpected block", cb.Height)
+			return nil
+		},
+	})
+	if status.Code(err) != codes.InvalidArgument ||
+		!strings.Contains(err.Error(), "range of 1000 blocks exceeds the maximum of 999") {
+		t.Fatal("GetBlockRange unexpected error", err)
+	}
+}
+
+func TestGetBlockRangePartlyCached(t *testing.T) {
+	testT = t
+	lwd, cache := testsetup()
+	for i := range 2 {
+		block, _ := parseTestBlock(t, i)
+		if err := cache.Add(block.GetHeight(), block.ToCompact()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Block 380642 isn't cached, so it's fetched from zcashd.
+	block, verbose := parseTestBlock(t, 2)
+	var requests []string
+	common.RawRequest = func(method string, params []json.RawMessage) (json.RawMessage, error) {
+		requests = append(requests, string(params[0]))
+		switch string(params[0]) {
+		case `"380642"`:
+			return verbose, nil
+		case `"` + block.GetDisplayHashString() + `"`:
+			return blocks[2], nil
+		}
+		t.Fatal("unexpected request", method, string(params[0]))
+		return nil, nil
+	}
+	var heights []uint64
+	err := lwd.GetBlockRange(&walletrpc.BlockRange{
+		Start: &walletrpc.BlockID{Height: 380640},
+		End:   &walletrpc.BlockID{Height: 380642},
+	}, &testblockstream{
+		ctx: context.Background(),
+		send: func(cb *walletrpc.CompactBlock) error {
+			heights = append(heights, cb.Height)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal("GetBlockRange failed", err)
+	}
+	if fmt.Sprint(heights) != "[380640 380641 380642]" || len(requests) != 2 {
+		t.Fatal("unexpected blocks or requests", heights, requests)
+	}
+}
+
 func sendrawtransactionStub(method string, params []json.RawMessage) (json.RawMessage, error) {
 	step++
 	if method != "sendrawtransaction" {
@@ -597,3 +963,140 @@ func TestMempoolFilter(t *testing.T) {
 	}
 
 }
+
+func TestBatchClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			t.Error("missing credentials")
+		}
+		var requests []struct {
+			JSONRPC string
+			ID      int
+			Method  string
+			Params  []json.RawMessage
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+			t.Error("not a batch request:", err)
+		}
+		if len(requests) != 2 {
+			t.Error("unexpected batch size", len(requests))
+		}
+		for i, req := range requests {
+			if req.JSONRPC != "1.0" || req.ID != i || req.Method != "getblock" || len(req.Params) != 2 {
+				t.Error("unexpected request", req)
+			}
+		}
+		// Reply out of order; the second request fails.
+		w.Write([]byte(`[{"result":null,"error":{"code":-8,"message":"Block height out of range"},"id":1},` +
+			`{"result":{"hash":"00"},"error":null,"id":0}]`))
+	}))
+	defer server.Close()
+
+	client := newHTTPClient(&rpcclient.ConnConfig{
+		Host:       strings.TrimPrefix(server.URL, "http://"),
+		User:       "user",
+		Pass:       "pass",
+		DisableTLS: true,
+	})
+	results, err := client.RawBatchRequest(context.Background(), []common.RPCRequest{
+		{Method: "getblock", Params: []json.RawMessage{[]byte(`"380640"`), []byte("1")}},
+		{Method: "getblock", Params: []json.RawMessage{[]byte(`"380641"`), []byte("1")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err != nil || string(results[0].Result) != `{"hash":"00"}` {
+		t.Fatal("unexpected first result", results[0])
+	}
+	if results[1].Err == nil || !strings.HasPrefix(results[1].Err.Error(), "-8:") {
+		t.Fatal("unexpected second result", results[1])
+	}
+
+	// A server that doesn't support batches.
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"result":null,"error":{"code":-32600,"message":"Invalid request"},"id":null}`))
+	})
+	_, err = client.RawBatchRequest(context.Background(), []common.RPCRequest{{Method: "getblockchaininfo"}})
+	if !errors.Is(err, common.ErrBatchUnsupported) {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+// A request to a hung backend node times out promptly.
+func TestHTTPClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if bytes.Contains(body, []byte(`"getbestblockhash"`)) {
+			w.Write([]byte(`{"result":"00","error":null,"id":0}`))
+			return
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	connCfg := &rpcclient.ConnConfig{
+		Host:       strings.TrimPrefix(server.URL, "http://"),
+		DisableTLS: true,
+	}
+
+	client := newHTTPClient(connCfg)
+	result, err := client.RawRequestContext(context.Background(), "getbestblockhash", nil)
+	if err != nil || string(result) != `"00"` {
+		t.Fatal("unexpected result", string(result), err)
+	}
+
+	// The context's deadline cancels the request, and batches too.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = client.RawRequestContext(ctx, "getblock", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("unexpected error", err)
+	}
+	_, err = client.RawBatchRequest(ctx, []common.RPCRequest{{Method: "getblock"}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("unexpected batch error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatal("requests took", elapsed)
+	}
+}
+
+// A fallback endpoint uses the credentials in its URL, or the primary's.
+func TestNewZRPCEndpoint(t *testing.T) {
+	var user, pass string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ = r.BasicAuth()
+		w.Write([]byte(`{"result":"00","error":null,"id":0}`))
+	}))
+	defer server.Close()
+	primary := newHTTPClient(&rpcclient.ConnConfig{Host: "primary:8232", User: "u", Pass: "p", DisableTLS: true})
+	host := strings.TrimPrefix(server.URL, "http://")
+	for _, tt := range []struct{ url, user, pass string }{
+		{server.URL, "u", "p"},
+		{"http://other:secret@" + host, "other", "secret"},
+	} {
+		endpoint, err := NewZRPCEndpoint(tt.url, primary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if endpoint.Name != host {
+			t.Fatal("unexpected name", endpoint.Name)
+		}
+		if _, ok := endpoint.Client.(common.RPCBatchClient); !ok {
+			t.Fatal("client can't send batches")
+		}
+		result, err := endpoint.Client.RawRequest("getbestblockhash", nil)
+		if err != nil || string(result) != `"00"` || user != tt.user || pass != tt.pass {
+			t.Fatal("unexpected result", string(result), err, user, pass)
+		}
+	}
+	if _, err := NewZRPCEndpoint(host, primary); err == nil {
+		t.Fatal("URL without a scheme accepted")
+	}
+}
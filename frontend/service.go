@@ -49,8 +49,13 @@ type DarksideStreamer struct {
 	walletrpc.UnimplementedDarksideStreamerServer
 }
 
-// NewDarksideStreamer constructs a gRPC context for darksidewalletd.
+// NewDarksideStreamer constructs a gRPC context for darksidewalletd. It
+// fails unless darkside mode is enabled (see common.DarksideInit), so the
+// darkside controls can't be served alongside a real backend node.
 func NewDarksideStreamer(cache *common.BlockCache) (walletrpc.DarksideStreamerServer, error) {
+	if !common.DarksideEnabled {
+		return nil, errors.New("darkside mode is not enabled (--darkside-very-insecure)")
+	}
 	return &DarksideStreamer{cache: cache}, nil
 }
 
@@ -68,10 +73,32 @@ func checkTaddress(taddr string) error {
 	return nil
 }
 
-// GetLatestBlock returns the height and hash of the best chain, according to zcashd.
+// GetLatestBlock returns the height and hash of the latest block that the
+// cache serves to wallets (see BlockCache.SetConfirmationDepth).
 func (s *lwdStreamer) GetLatestBlock(ctx context.Context, placeholder *walletrpc.ChainSpec) (*walletrpc.BlockID, error) {
 	common.Log.Debugf("gRPC GetLatestBlock(%+v)\n", placeholder)
 
+	if s.cache == nil {
+		// Without a cache (--nocache), every block comes from zcashd.
+		return getLatestBlockFromNode()
+	}
+	// Serve the cache's latest block (not zcashd's), which is below zcashd's
+	// tip while the ingestor catches up or with a confirmation depth, so that
+	// wallets never ask for blocks that GetBlockRange can't serve.
+	block := s.cache.GetRelative(0)
+	if block == nil {
+		return nil, status.Error(codes.Unavailable,
+			"GetLatestBlock: no blocks are cached yet, lightwalletd is still syncing")
+	}
+	r := &walletrpc.BlockID{
+		Height: block.Height,
+		Hash:   block.Hash}
+	common.Log.Tracef("  return: %+v\n", r)
+	return r, nil
+}
+
+// getLatestBlockFromNode returns zcashd's best block.
+func getLatestBlockFromNode() (*walletrpc.BlockID, error) {
 	blockChainInfo, err := common.GetBlockChainInfo()
 	if err != nil {
 		return nil, status.Errorf(codes.Unavailable,
@@ -82,7 +109,6 @@ func (s *lwdStreamer) GetLatestBlock(ctx context.Context, placeholder *walletrpc
 		return nil, status.Errorf(codes.Internal,
 			"GetLatestBlock: decode block hash %s failed: %s", blockChainInfo.BestBlockHash, err.Error())
 	}
-	// Binary block hash should always be in little-endian format
 	bestBlockHash := hash32.Reverse(bestBlockHashBigEndian)
 	r := &walletrpc.BlockID{
 		Height: uint64(blockChainInfo.Blocks),
@@ -143,11 +169,14 @@ func (s *lwdStreamer) GetTaddressTransactions(addressBlockFilter *walletrpc.Tran
 	defer cancel()
 
 	for _, txidstr := range txids {
-		txidBigEndian, _ := hex.DecodeString(txidstr)
 		// Txid is read as a string, which is in big-endian order. But when converting
 		// to bytes, it should be little-endian
-		txHash := hash32.ReverseSlice(txidBigEndian)
-		tx, err := s.GetTransaction(timeout, &walletrpc.TxFilter{Hash: txHash})
+		txHash, err := hash32.FromHexReversed(txidstr)
+		if err != nil {
+			return status.Errorf(codes.Internal,
+				"GetTaddressTransactions: getaddresstxids returned bad txid %q: %s", txidstr, err.Error())
+		}
+		tx, err := s.GetTransaction(timeout, &walletrpc.TxFilter{Hash: hash32.ToSlice(txHash)})
 		if err != nil {
 			return err
 		}
@@ -165,8 +194,8 @@ func (s *lwdStreamer) GetTaddressTxids(addressBlockFilter *walletrpc.Transparent
 	return s.GetTaddressTransactions(addressBlockFilter, resp)
 }
 
-// GetBlock returns the compact block at the requested height. Requesting a
-// block by hash is not yet supported.
+// GetBlock returns the compact block with the requested hash or, if no hash
+// is given, at the requested height.
 func (s *lwdStreamer) GetBlock(ctx context.Context, id *walletrpc.BlockID) (*walletrpc.CompactBlock, error) {
 	common.Log.Debugf("gRPC GetBlock(%+v)\n", id)
 	if id.Height == 0 && id.Hash == nil {
@@ -174,20 +203,45 @@ func (s *lwdStreamer) GetBlock(ctx context.Context, id *walletrpc.BlockID) (*wal
 			"GetBlock: request for unspecified identifier")
 	}
 
+	var cBlock *walletrpc.CompactBlock
+	var err error
 	// Precedence: a hash is more specific than a height. If we have it, use it first.
 	if id.Hash != nil {
-		// TODO: Get block by hash
-		// see https://github.com/zcash/lightwalletd/pull/309
-		return nil, status.Error(codes.InvalidArgument,
-			"GetBlock: Block hash specifier is not yet implemented")
+		if len(id.Hash) != 32 {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"GetBlock: block hash has invalid length: %d", len(id.Hash))
+		}
+		cBlock, err = common.GetBlockByHash(s.cache, hash32.T(id.Hash))
+	} else {
+		if err := s.checkServable(int(id.Height)); err != nil {
+			return nil, err
+		}
+		cBlock, err = common.GetBlock(s.cache, int(id.Height))
 	}
-	cBlock, err := common.GetBlock(s.cache, int(id.Height))
-
 	if err != nil {
+		// These return gRPC-compatible errors.
+		return nil, err
+	}
+	if err := s.checkServable(int(cBlock.Height)); err != nil {
 		return nil, err
 	}
 	common.Log.Tracef("  return: %+v\n", cBlock)
-	return cBlock, err
+	return cBlock, nil
+}
+
+// checkServable returns a NotFound error if the given height is above the
+// latest block that GetLatestBlock reports (so that wallets aren't served
+// blocks that the cache hasn't synced, or hides), unless nothing is cached.
+// Without a cache (--nocache), zcashd decides what's available.
+func (s *lwdStreamer) checkServable(height int) error {
+	if s.cache == nil {
+		return nil
+	}
+	if latest := s.cache.GetLatestHeight(); latest >= 0 && height > latest {
+		return status.Errorf(codes.NotFound,
+			"GetBlock: block %d is beyond the latest block %d", height, latest)
+	}
+	return nil
 }
 
 // GetBlockNullifiers is the same as GetBlock except that it returns the compact block
@@ -229,39 +283,47 @@ func (s *lwdStreamer) GetBlockNullifiers(ctx context.Context, id *walletrpc.Bloc
 // 'end' inclusively.
 func (s *lwdStreamer) GetBlockRange(span *walletrpc.BlockRange, resp walletrpc.CompactTxStreamer_GetBlockRangeServer) error {
 	common.Log.Debugf("gRPC GetBlockRange(%+v)\n", span)
-	blockChan := make(chan *walletrpc.CompactBlock)
-	if span.Start == nil || span.End == nil {
-		return status.Error(codes.InvalidArgument,
-			"GetBlockRange: must specify start and end heights")
-	}
-	errChan := make(chan error)
-	go common.GetBlockRange(s.cache, blockChan, errChan, int(span.Start.Height), int(span.End.Height))
-
-	for {
-		select {
-		case err := <-errChan:
-			// this will also catch context.DeadlineExceeded from the timeout
-			return err
-		case cBlock := <-blockChan:
-			err := resp.Send(cBlock)
-			if err != nil {
-				return err
-			}
-		}
-	}
+	return s.streamBlockRange(resp.Context(), "GetBlockRange", span, resp.Send)
 }
 
 // GetBlockRangeNullifiers is the same as GetBlockRange except that only
 // the actions contain only nullifiers (a subset of the full compact block).
 func (s *lwdStreamer) GetBlockRangeNullifiers(span *walletrpc.BlockRange, resp walletrpc.CompactTxStreamer_GetBlockRangeNullifiersServer) error {
 	common.Log.Debugf("gRPC GetBlockRangeNullifiers(%+v)\n", span)
-	blockChan := make(chan *walletrpc.CompactBlock)
+	return s.streamBlockRange(resp.Context(), "GetBlockRangeNullifiers", span, func(cBlock *walletrpc.CompactBlock) error {
+		for _, tx := range cBlock.Vtx {
+			for i, action := range tx.Actions {
+				tx.Actions[i] = &walletrpc.CompactOrchardAction{Nullifier: action.Nullifier}
+			}
+			tx.Outputs = nil
+		}
+		// these are not needed (we prefer to save bandwidth)
+		cBlock.ChainMetadata.SaplingCommitmentTreeSize = 0
+		cBlock.ChainMetadata.OrchardCommitmentTreeSize = 0
+		return resp.Send(cBlock)
+	})
+}
+
+// streamBlockRange calls send with each block in the given range, from the
+// cache, else the backend node. Blocks are fetched only as fast as send
+// (which blocks under gRPC flow control) accepts them, and fetching stops
+// when the client cancels the stream.
+func (s *lwdStreamer) streamBlockRange(ctx context.Context, name string, span *walletrpc.BlockRange, send func(*walletrpc.CompactBlock) error) error {
 	if span.Start == nil || span.End == nil {
-		return status.Error(codes.InvalidArgument,
-			"GetBlockRangeNullifiers: must specify start and end heights")
+		return status.Errorf(codes.InvalidArgument,
+			"%s: must specify start and end heights", name)
 	}
+	start, end := int(span.Start.Height), int(span.End.Height)
+	if n := max(start, end) - min(start, end) + 1; common.MaxBlockRange > 0 && n > common.MaxBlockRange {
+		return status.Errorf(codes.InvalidArgument,
+			"%s: range of %d blocks exceeds the maximum of %d", name, n, common.MaxBlockRange)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	// Stops common.GetBlockRange if we return early.
+	defer cancel()
+	blockChan := make(chan *walletrpc.CompactBlock)
 	errChan := make(chan error)
-	go common.GetBlockRange(s.cache, blockChan, errChan, int(span.Start.Height), int(span.End.Height))
+	go common.GetBlockRange(ctx, s.cache, blockChan, errChan, start, end)
 
 	for {
 		select {
@@ -269,18 +331,11 @@ func (s *lwdStreamer) GetBlockRangeNullifiers(span *walletrpc.BlockRange, resp w
 			// this will also catch context.DeadlineExceeded from the timeout
 			return err
 		case cBlock := <-blockChan:
-			for _, tx := range cBlock.Vtx {
-				for i, action := range tx.Actions {
-					tx.Actions[i] = &walletrpc.CompactOrchardAction{Nullifier: action.Nullifier}
-				}
-				tx.Outputs = nil
-			}
-			// these are not needed (we prefer to save bandwidth)
-			cBlock.ChainMetadata.SaplingCommitmentTreeSize = 0
-			cBlock.ChainMetadata.OrchardCommitmentTreeSize = 0
-			if err := resp.Send(cBlock); err != nil {
+			if err := send(cBlock); err != nil {
 				return err
 			}
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
 		}
 	}
 }
@@ -357,7 +412,9 @@ func (s *lwdStreamer) GetLatestTreeState(ctx context.Context, in *walletrpc.Empt
 }
 
 // GetTransaction returns the raw transaction bytes that are returned
-// by the zcashd 'getrawtransaction' RPC.
+// by the zcashd 'getrawtransaction' RPC, and the height of the block it
+// was mined in. A transaction that's only in the mempool is returned (with
+// height 0) only with --get-transaction-mempool.
 func (s *lwdStreamer) GetTransaction(ctx context.Context, txf *walletrpc.TxFilter) (*walletrpc.RawTransaction, error) {
 	common.Log.Debugf("gRPC GetTransaction(%+v)\n", txf)
 	if txf.Hash != nil {
@@ -365,6 +422,13 @@ func (s *lwdStreamer) GetTransaction(ctx context.Context, txf *walletrpc.TxFilte
 			return nil, status.Errorf(codes.InvalidArgument,
 				"GetTransaction: transaction ID has invalid length: %d", len(txf.Hash))
 		}
+		// Serve it from the cache if it stores full blocks.
+		if s.cache != nil {
+			if tx := s.cache.GetTransaction(hash32.T(txf.Hash)); tx != nil {
+				common.Log.Tracef("  return (cached): %+v\n", tx)
+				return tx, nil
+			}
+		}
 		// Convert from little endian to big endian.
 		txidHex := hash32.Encode(hash32.Reverse(hash32.T(txf.Hash)))
 		txidJSON, err := json.Marshal(txidHex)
@@ -377,7 +441,12 @@ func (s *lwdStreamer) GetTransaction(ctx context.Context, txf *walletrpc.TxFilte
 		result, rpcErr := common.RawRequest("getrawtransaction", params)
 		if rpcErr != nil {
 			// For some reason, the error responses are not JSON
-			return nil, status.Errorf(codes.NotFound,
+			if (strings.Split(rpcErr.Error(), ":"))[0] == "-5" {
+				// No such mempool or blockchain transaction
+				return nil, status.Errorf(codes.NotFound,
+					"GetTransaction: transaction %s not found: %s", txidHex, rpcErr.Error())
+			}
+			return nil, status.Errorf(codes.Unavailable,
 				"GetTransaction: getrawtransaction %s failed: %s", txidHex, rpcErr.Error())
 		}
 		tx, err := common.ParseRawTransaction(result)
@@ -385,6 +454,10 @@ func (s *lwdStreamer) GetTransaction(ctx context.Context, txf *walletrpc.TxFilte
 			return nil, status.Errorf(codes.Internal,
 				"GetTransaction: Cannot parse transaction: %s", err.Error())
 		}
+		if tx.Height == 0 && !common.GetTxMempool {
+			return nil, status.Errorf(codes.FailedPrecondition,
+				"GetTransaction: transaction %s is in the mempool, not yet mined", txidHex)
+		}
 		common.Log.Tracef("  return: %+v\n", tx)
 		return tx, err
 	}
@@ -540,7 +613,7 @@ func (s *lwdStreamer) GetTaddressBalanceStream(addresses walletrpc.CompactTxStre
 
 func (s *lwdStreamer) GetMempoolStream(_empty *walletrpc.Empty, resp walletrpc.CompactTxStreamer_GetMempoolStreamServer) error {
 	common.Log.Debugf("gRPC GetMempoolStream()\n")
-	err := common.GetMempool(func(tx *walletrpc.RawTransaction) error {
+	err := common.GetMempool(common.RPCFunc(common.RawRequest), func(tx *walletrpc.RawTransaction) error {
 		return resp.Send(tx)
 	})
 	return err
@@ -634,8 +707,13 @@ func (s *lwdStreamer) GetMempoolTx(exclude *walletrpc.GetMempoolTxRequest, resp
 					return status.Errorf(codes.Internal,
 						"GetMempoolTx: failed decode txid, error: %s", err.Error())
 				}
+				txid, err := hash32.FromSlice(txidBigEndian)
+				if err != nil {
+					return status.Errorf(codes.Internal,
+						"GetMempoolTx: bad txid, error: %s", err.Error())
+				}
 				// convert from big endian bytes to little endian and set as the txid
-				tx.SetTxID(hash32.Reverse(hash32.T(txidBigEndian)))
+				tx.SetTxID(hash32.Reverse(txid))
 				newmempoolMap[txidstr] = tx.ToCompact( /* height */ 0)
 			}
 		}
@@ -967,6 +1045,15 @@ func (s *DarksideStreamer) StageBlocksCreate(ctx context.Context, e *walletrpc.D
 	return &walletrpc.Empty{}, nil
 }
 
+// StageBlockWithTime sets the header time and nBits of a staged block.
+func (s *DarksideStreamer) StageBlockWithTime(ctx context.Context, b *walletrpc.DarksideBlockTime) (*walletrpc.Empty, error) {
+	if err := common.DarksideStageBlockWithTime(int(b.Height), b.Time, b.Bits); err != nil {
+		return nil, status.Errorf(codes.Unknown,
+			"StageBlockWithTime: DarksideStageBlockWithTime failed, error: %s", err.Error())
+	}
+	return &walletrpc.Empty{}, nil
+}
+
 // StageTransactionsStream adds the given transactions to the staging area.
 func (s *DarksideStreamer) StageTransactionsStream(tx walletrpc.DarksideStreamer_StageTransactionsStreamServer) error {
 	// My current thinking is that this should take a JSON array of {height, txid}, store them,
@@ -999,11 +1086,86 @@ func (s *DarksideStreamer) StageTransactions(ctx context.Context, u *walletrpc.D
 	return &walletrpc.Empty{}, nil
 }
 
+// StageTxWithID stages a transaction with an assigned txid.
+func (s *DarksideStreamer) StageTxWithID(ctx context.Context, tx *walletrpc.DarksideTxWithID) (*walletrpc.Empty, error) {
+	txid, err := hash32.FromSlice(tx.Txid)
+	if err == nil {
+		err = common.DarksideStageTxWithID(int(tx.Height), tx.Data, txid)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Unknown,
+			"StageTxWithID: DarksideStageTxWithID failed, error: %s", err.Error())
+	}
+	return &walletrpc.Empty{}, nil
+}
+
+// StageMempoolTx adds the given transaction to the mock zcashd's mempool.
+func (s *DarksideStreamer) StageMempoolTx(ctx context.Context, tx *walletrpc.RawTransaction) (*walletrpc.Empty, error) {
+	if err := common.DarksideStageMempoolTx(tx.Data); err != nil {
+		return nil, status.Errorf(codes.Unknown,
+			"StageMempoolTx: DarksideStageMempoolTx failed, error: %s", err.Error())
+	}
+	return &walletrpc.Empty{}, nil
+}
+
 // ApplyStaged merges all staged transactions into staged blocks and all staged blocks into the active blockchain.
 func (s *DarksideStreamer) ApplyStaged(ctx context.Context, h *walletrpc.DarksideHeight) (*walletrpc.Empty, error) {
 	return &walletrpc.Empty{}, common.DarksideApplyStaged(int(h.Height))
 }
 
+// Reorg replaces the active blocks from the given height up with the staged blocks.
+func (s *DarksideStreamer) Reorg(ctx context.Context, h *walletrpc.DarksideHeight) (*walletrpc.Empty, error) {
+	return &walletrpc.Empty{}, common.DarksideReorg(int(h.Height))
+}
+
+// StageBranch saves a named candidate branch of hex-encoded blocks.
+func (s *DarksideStreamer) StageBranch(ctx context.Context, b *walletrpc.DarksideBranch) (*walletrpc.Empty, error) {
+	var blocks [][]byte
+	for _, blockHex := range b.Blocks {
+		blockBytes, err := hex.DecodeString(blockHex)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"StageBranch: invalid block hex, error: %s", err.Error())
+		}
+		blocks = append(blocks, blockBytes)
+	}
+	if err := common.DarksideStageBranch(b.Name, blocks); err != nil {
+		return nil, status.Errorf(codes.Unknown,
+			"StageBranch: DarksideStageBranch failed, error: %s", err.Error())
+	}
+	return &walletrpc.Empty{}, nil
+}
+
+// ActivateBranch makes the named branch the active chain.
+func (s *DarksideStreamer) ActivateBranch(ctx context.Context, b *walletrpc.DarksideBranch) (*walletrpc.Empty, error) {
+	if err := common.DarksideActivateBranch(b.Name); err != nil {
+		return nil, status.Errorf(codes.Unknown,
+			"ActivateBranch: DarksideActivateBranch failed, error: %s", err.Error())
+	}
+	return &walletrpc.Empty{}, nil
+}
+
+// StageGap makes the mock zcashd fail to serve the block at a height.
+func (s *DarksideStreamer) StageGap(ctx context.Context, h *walletrpc.DarksideHeight) (*walletrpc.Empty, error) {
+	if err := common.DarksideStageGap(int(h.Height)); err != nil {
+		return nil, status.Errorf(codes.Unknown,
+			"StageGap: DarksideStageGap failed, error: %s", err.Error())
+	}
+	return &walletrpc.Empty{}, nil
+}
+
+// GetStats returns counts of what darkside has done since the last Reset.
+func (s *DarksideStreamer) GetStats(ctx context.Context, e *walletrpc.Empty) (*walletrpc.DarksideStats, error) {
+	st := common.DarksideGetStats()
+	return &walletrpc.DarksideStats{
+		BlocksStaged:       uint64(st.BlocksStaged),
+		TransactionsStaged: uint64(st.TransactionsStaged),
+		MempoolTxs:         uint64(st.MempoolTxs),
+		Reorgs:             uint64(st.Reorgs),
+		InjectedErrors:     uint64(st.InjectedErrors),
+	}, nil
+}
+
 // GetIncomingTransactions returns the transactions that were submitted via SendTransaction().
 func (s *DarksideStreamer) GetIncomingTransactions(in *walletrpc.Empty, resp walletrpc.DarksideStreamer_GetIncomingTransactionsServer) error {
 	// Get all of the incoming transactions we're received via SendTransaction()
@@ -1024,15 +1186,20 @@ func (s *DarksideStreamer) ClearIncomingTransactions(ctx context.Context, e *wal
 
 // AddAddressUtxo adds a UTXO which will be returned by GetAddressUtxos() (above)
 func (s *DarksideStreamer) AddAddressUtxo(ctx context.Context, arg *walletrpc.GetAddressUtxosReply) (*walletrpc.Empty, error) {
+	txid, err := hash32.FromSlice(arg.Txid)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"AddAddressUtxo: bad txid: %s", err.Error())
+	}
 	utxosReply := common.ZcashdRpcReplyGetaddressutxos{
 		Address:     arg.Address,
-		Txid:        hash32.Encode(hash32.Reverse(hash32.T(arg.Txid))),
+		Txid:        hash32.Encode(hash32.Reverse(txid)),
 		OutputIndex: int64(arg.Index),
 		Script:      hex.EncodeToString(arg.Script),
 		Satoshis:    uint64(arg.ValueZat),
 		Height:      int(arg.Height),
 	}
-	err := common.DarksideAddAddressUtxo(utxosReply)
+	err = common.DarksideAddAddressUtxo(utxosReply)
 	if err != nil {
 		return nil, status.Errorf(codes.Unknown,
 			"AddAddressUtxo: DarksideAddAddressUtxo failed, error: %s", err.Error())
@@ -1089,3 +1256,21 @@ func (s *DarksideStreamer) SetSubtreeRoots(ctx context.Context, arg *walletrpc.D
 	}
 	return &walletrpc.Empty{}, err
 }
+
+// SetLatency delays the mock zcashd's replies.
+func (s *DarksideStreamer) SetLatency(ctx context.Context, l *walletrpc.DarksideLatency) (*walletrpc.Empty, error) {
+	if err := common.DarksideSetLatency(time.Duration(l.Milliseconds) * time.Millisecond); err != nil {
+		return nil, status.Errorf(codes.Unknown,
+			"SetLatency: DarksideSetLatency failed, error: %s", err.Error())
+	}
+	return &walletrpc.Empty{}, nil
+}
+
+// SetErrorRate makes the mock zcashd fail a fraction of requests.
+func (s *DarksideStreamer) SetErrorRate(ctx context.Context, r *walletrpc.DarksideErrorRate) (*walletrpc.Empty, error) {
+	if err := common.DarksideSetErrorRate(r.Rate); err != nil {
+		return nil, status.Errorf(codes.Unknown,
+			"SetErrorRate: DarksideSetErrorRate failed, error: %s", err.Error())
+	}
+	return &walletrpc.Empty{}, nil
+}
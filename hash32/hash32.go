@@ -5,8 +5,12 @@
 package hash32
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 )
 
 // This type is for any kind of 32-byte hash, such as block ID,
@@ -16,12 +20,59 @@ type T [32]byte
 
 // It is considered impossible for a hash value to be all zeros,
 // so we use that to represent an unset or undefined hash value.
-var Nil = [32]byte{}
+var Nil = T{}
+
+// Sum256d returns the double SHA-256 (SHA256d) of data, as used for block
+// hashes and (v4) txids, in internal byte order. It doesn't allocate.
+func Sum256d(data []byte) T {
+	digest := sha256.Sum256(data)
+	return sha256.Sum256(digest[:])
+}
+
+// IsNil reports whether the hash is Nil (unset).
+func (h T) IsNil() bool {
+	return h == Nil
+}
+
+// Equal reports whether the two hashes are equal.
+func Equal(a, b T) bool {
+	return a == b
+}
+
+// ConstantTimeEqual is like Equal, but takes time independent of the
+// contents of the hashes, for security-sensitive comparisons.
+func ConstantTimeEqual(a, b T) bool {
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// Compare returns -1, 0, or +1 according to whether a is less than, equal
+// to, or greater than b, comparing the hashes as big-endian numbers (that
+// is, in display order). This gives a deterministic ordering for sorting.
+func Compare(a, b T) int {
+	for i := len(a) - 1; i >= 0; i-- {
+		switch {
+		case a[i] < b[i]:
+			return -1
+		case a[i] > b[i]:
+			return 1
+		}
+	}
+	return 0
+}
 
 func ToSlice(arg T) []byte {
 	return arg[:]
 }
 
+// FromSlice returns the hash contained in b, or an error if b isn't
+// exactly 32 bytes long (rather than panicking, as a conversion would).
+func FromSlice(b []byte) (T, error) {
+	if len(b) != 32 {
+		return Nil, fmt.Errorf("FromSlice: length is %d bytes, not 32", len(b))
+	}
+	return T(b), nil
+}
+
 // Reverse the given hash, returning a slice pointing to new data;
 // the input slice is unchanged.
 func Reverse(arg T) T {
@@ -51,3 +102,63 @@ func Decode(s string) (T, error) {
 func Encode(arg T) string {
 	return hex.EncodeToString(ToSlice(arg))
 }
+
+// IsValidHex reports whether s is a valid hex-encoded hash: exactly 64
+// hex digits (either case). It doesn't decode or allocate, so it's suitable
+// for cheaply rejecting bad input before calling Decode or FromHexReversed.
+func IsValidHex(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for i := range len(s) {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// Short returns an abbreviated form of the hash for logging: the first
+// 8 bytes in display order (16 hex characters). Use Encode for the full value.
+func (h T) Short() string {
+	r := Reverse(h)
+	return hex.EncodeToString(r[:8])
+}
+
+// FromHexReversed decodes a hash given in big-endian display order (as
+// shown to users, for example, a txid or block hash in an RPC request),
+// returning it in little-endian internal (wire) order.
+func FromHexReversed(s string) (T, error) {
+	if len(s) != 64 {
+		return Nil, errors.New("FromHexReversed: length is not 64 hex characters")
+	}
+	h, err := Decode(s)
+	if err != nil {
+		return Nil, err
+	}
+	return Reverse(h), nil
+}
+
+// MarshalJSON encodes the hash as a JSON string in big-endian display order.
+func (h T) MarshalJSON() ([]byte, error) {
+	return json.Marshal(Encode(Reverse(h)))
+}
+
+// UnmarshalJSON decodes a JSON string containing a hash in big-endian
+// display order (64 hex characters). As is conventional, null is a no-op.
+func (h *T) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	r, err := FromHexReversed(s)
+	if err != nil {
+		return err
+	}
+	*h = r
+	return nil
+}
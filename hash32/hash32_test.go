@@ -0,0 +1,231 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package hash32
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// zcash mainnet genesis block hash, display order
+const genesisHex = "00040fe8ec8471911baa1db1266ea15dd06b4a8a5c453883c000b031973dce08"
+
+func TestFromHexReversed(t *testing.T) {
+	h, err := FromHexReversed(genesisHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// internal order has the zero bytes at the end
+	if h[31] != 0x00 || h[30] != 0x04 || h[0] != 0x08 {
+		t.Fatal("unexpected byte order", Encode(h))
+	}
+	if Encode(Reverse(h)) != genesisHex {
+		t.Fatal("round trip failed")
+	}
+	for _, s := range []string{
+		"",
+		genesisHex[2:],
+		genesisHex + "00",
+		"z" + genesisHex[1:],
+	} {
+		if _, err := FromHexReversed(s); err == nil {
+			t.Fatalf("FromHexReversed(%q) unexpected success", s)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	h, _ := FromHexReversed(genesisHex)
+	j, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(j) != `"`+genesisHex+`"` {
+		t.Fatal("unexpected JSON", string(j))
+	}
+	var h2 T
+	if err = json.Unmarshal(j, &h2); err != nil {
+		t.Fatal(err)
+	}
+	if h2 != h {
+		t.Fatal("round trip failed")
+	}
+
+	// Within a struct, and the Nil value
+	type s struct {
+		Hash T
+	}
+	j, err = json.Marshal(s{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(j) != `{"Hash":"`+strings.Repeat("0", 64)+`"}` {
+		t.Fatal("unexpected JSON", string(j))
+	}
+	v := s{Hash: h}
+	if err = json.Unmarshal(j, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Hash != Nil {
+		t.Fatal("Nil round trip failed")
+	}
+
+	for _, bad := range []string{
+		`"` + genesisHex[2:] + `"`,
+		`"` + genesisHex + `00"`,
+		`"xyz"`,
+		`12`,
+	} {
+		if err = json.Unmarshal([]byte(bad), &h2); err == nil {
+			t.Fatalf("Unmarshal(%s) unexpected success", bad)
+		}
+	}
+	if err = json.Unmarshal([]byte(`null`), &h2); err != nil || h2 != h {
+		t.Fatal("Unmarshal(null) unexpected result", err)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	h, _ := FromHexReversed(genesisHex)
+	h2 := h
+	h2[31] ^= 1
+	var zero T
+	for _, tt := range []struct {
+		a, b T
+		want bool
+	}{
+		{h, h, true},
+		{h, h2, false},
+		{h2, h, false},
+		{zero, Nil, true},
+		{zero, h, false},
+	} {
+		if Equal(tt.a, tt.b) != tt.want {
+			t.Fatal("Equal unexpected result", Encode(tt.a), Encode(tt.b))
+		}
+		if ConstantTimeEqual(tt.a, tt.b) != tt.want {
+			t.Fatal("ConstantTimeEqual unexpected result", Encode(tt.a), Encode(tt.b))
+		}
+	}
+	if !zero.IsNil() || !Nil.IsNil() || h.IsNil() {
+		t.Fatal("IsNil unexpected result")
+	}
+}
+
+func TestFromSlice(t *testing.T) {
+	b := make([]byte, 33)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	for _, n := range []int{0, 31, 33} {
+		if _, err := FromSlice(b[:n]); err == nil {
+			t.Fatalf("FromSlice(%d bytes) unexpected success", n)
+		}
+	}
+	h, err := FromSlice(b[:32])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h[0] != 0 || h[31] != 31 {
+		t.Fatal("unexpected hash", Encode(h))
+	}
+	// The result doesn't alias the input.
+	b[0] = 99
+	if h[0] != 0 {
+		t.Fatal("FromSlice result aliases input")
+	}
+}
+
+func TestSum256d(t *testing.T) {
+	for _, tt := range []struct {
+		data string
+		want string
+	}{
+		{"", "5df6e0e2761359d30a8275058e299fcc0381534545f55cf43e41983f5d4c9456"},
+		{"hello", "9595c9df90075148eb06860365df33584b75bff782a510c6cd4883a419833d50"},
+	} {
+		if h := Sum256d([]byte(tt.data)); Encode(h) != tt.want {
+			t.Fatalf("Sum256d(%q) = %s, want %s", tt.data, Encode(h), tt.want)
+		}
+	}
+	if n := testing.AllocsPerRun(10, func() { Sum256d([]byte("hello")) }); n != 0 {
+		t.Fatal("Sum256d allocates", n)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// Hashes in internal order; the last byte is the most significant.
+	var lo, hi, mid T
+	lo[0] = 0xff // larger low-order byte doesn't outweigh the high-order byte
+	hi[31] = 1
+	mid[31] = 1
+	mid[0] = 1
+	for _, tt := range []struct {
+		a, b T
+		want int
+	}{
+		{lo, hi, -1},
+		{hi, lo, 1},
+		{hi, mid, -1},
+		{mid, hi, 1},
+		{mid, mid, 0},
+		{Nil, Nil, 0},
+		{Nil, lo, -1},
+	} {
+		if got := Compare(tt.a, tt.b); got != tt.want {
+			t.Fatalf("Compare(%s, %s) = %d, want %d",
+				Encode(tt.a), Encode(tt.b), got, tt.want)
+		}
+	}
+	// Sorting by Compare matches sorting display-order hex strings.
+	hashes := []T{mid, Nil, hi, lo}
+	slices.SortFunc(hashes, Compare)
+	for i := 1; i < len(hashes); i++ {
+		if Encode(Reverse(hashes[i-1])) > Encode(Reverse(hashes[i])) {
+			t.Fatal("hashes not sorted in display order")
+		}
+	}
+}
+
+func TestIsValidHex(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want bool
+	}{
+		{genesisHex, true},
+		{strings.ToUpper(genesisHex), true},
+		{"", false},
+		{genesisHex[:63], false},
+		{genesisHex + "0", false},
+		{genesisHex[:62] + "0g", false},
+		{genesisHex[:62] + " 0", false},
+		{"0x" + genesisHex[:62], false},
+	} {
+		if got := IsValidHex(tt.s); got != tt.want {
+			t.Fatalf("IsValidHex(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+		if _, err := Decode(tt.s); (err == nil) != tt.want {
+			t.Fatalf("IsValidHex(%q) disagrees with Decode", tt.s)
+		}
+	}
+	if n := testing.AllocsPerRun(10, func() { IsValidHex(genesisHex) }); n != 0 {
+		t.Fatal("IsValidHex allocates", n)
+	}
+}
+
+func TestShort(t *testing.T) {
+	h, err := FromHexReversed(genesisHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := h.Short(); s != genesisHex[:16] {
+		t.Fatalf("Short() = %s, want %s", s, genesisHex[:16])
+	}
+	if !strings.HasPrefix(Encode(Reverse(h)), h.Short()) {
+		t.Fatal("Short isn't a prefix of the display-order hash")
+	}
+}
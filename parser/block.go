@@ -7,20 +7,26 @@
 package parser
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"slices"
 
 	"github.com/zcash/lightwalletd/hash32"
 	"github.com/zcash/lightwalletd/parser/internal/bytestring"
 	"github.com/zcash/lightwalletd/walletrpc"
+	"google.golang.org/protobuf/proto"
 )
 
 // Block represents a full block (not a compact block).
 type Block struct {
-	hdr    *BlockHeader
-	vtx    []*Transaction
-	height int
+	hdr      *BlockHeader
+	vtx      []*Transaction
+	height   int
+	rawBytes []byte
 }
 
 // NewBlock constructs a block instance.
@@ -28,18 +34,60 @@ func NewBlock() *Block {
 	return &Block{height: -1}
 }
 
+// Equal reports whether b and other are the same block, that is, whether
+// they have the same hash and height.
+func (b *Block) Equal(other *Block) bool {
+	if b == nil || other == nil {
+		return b == other
+	}
+	return b.GetDisplayHash() == other.GetDisplayHash() &&
+		b.GetHeight() == other.GetHeight()
+}
+
 // GetVersion returns a block's version number (current 4)
 func (b *Block) GetVersion() int {
 	return int(b.hdr.Version)
 }
 
+// Version returns the block's header version number.
+func (b *Block) Version() int32 {
+	return b.hdr.Version
+}
+
+// Time returns the block's timestamp (Unix seconds) from the header.
+func (b *Block) Time() uint32 {
+	return b.hdr.Time
+}
+
+// medianTimeSpan is the number of blocks used to compute median-time-past.
+const medianTimeSpan = 11
+
+// MedianTimePast returns the median timestamp of the last (up to) 11 of the
+// given blocks, which should be in chain order, as used by the consensus
+// rules for block timestamps.
+func MedianTimePast(blocks []*Block) (int64, error) {
+	if len(blocks) == 0 {
+		return 0, errors.New("no blocks for median-time-past")
+	}
+	if len(blocks) > medianTimeSpan {
+		blocks = blocks[len(blocks)-medianTimeSpan:]
+	}
+	times := make([]int64, len(blocks))
+	for i, b := range blocks {
+		times[i] = int64(b.Time())
+	}
+	slices.Sort(times)
+	return times[len(times)/2], nil
+}
+
 // GetTxCount returns the number of transactions in the block,
 // including the coinbase transaction (minimum 1).
 func (b *Block) GetTxCount() int {
 	return len(b.vtx)
 }
 
-// Transactions returns the list of the block's transactions.
+// Transactions returns the list of the block's transactions, in block
+// order (the coinbase transaction is first).
 func (b *Block) Transactions() []*Transaction {
 	// TODO: these should NOT be mutable
 	return b.vtx
@@ -62,6 +110,14 @@ func (b *Block) GetEncodableHash() hash32.T {
 	return b.hdr.GetEncodableHash()
 }
 
+// ComputeHash returns the block hash in big-endian display order, computed
+// by double-SHA256 hashing the serialized header (including the Equihash
+// solution). Unlike GetDisplayHash, the result is never cached, so it can
+// be used to check the hash reported by the backend node.
+func (b *Block) ComputeHash() hash32.T {
+	return hash32.Reverse(b.hdr.GetEncodableHash())
+}
+
 // GetDisplayPrevHash returns the block's previous hash in big-endian format.
 func (b *Block) GetDisplayPrevHash() hash32.T {
 	return b.hdr.GetDisplayPrevHash()
@@ -71,6 +127,59 @@ func (b *Block) GetDisplayPrevHashString() string {
 	return hash32.Encode(hash32.Reverse(b.hdr.RawBlockHeader.HashPrevBlock))
 }
 
+// SerializedSize returns the size of the serialized block in bytes.
+func (b *Block) SerializedSize() int {
+	size := b.hdr.getSize() + CompactLengthPrefixedLen(len(b.vtx)) - len(b.vtx)
+	for _, tx := range b.vtx {
+		size += tx.SerializedSize()
+	}
+	return size
+}
+
+// Bytes returns the block's raw (serialized) bytes. Note that this slice
+// aliases the data that was passed to ParseFromSlice; it's nil if the block
+// was parsed by ParseFromReader.
+func (b *Block) Bytes() []byte {
+	return b.rawBytes
+}
+
+// TxOffsets returns the offset of each transaction within the serialized
+// block, so that, for example, transaction i is Bytes()[offsets[i]:end],
+// where end is offsets[i+1] (or the length of the block, for the last).
+func (b *Block) TxOffsets() []int {
+	offsets := make([]int, len(b.vtx))
+	offset := b.hdr.getSize() + CompactLengthPrefixedLen(len(b.vtx)) - len(b.vtx)
+	for i, tx := range b.vtx {
+		offsets[i] = offset
+		offset += tx.SerializedSize()
+	}
+	return offsets
+}
+
+// CompactSerializedSize returns the size in bytes of the block's marshaled
+// compact representation (ToCompact), which is what's served to wallets.
+func (b *Block) CompactSerializedSize() int {
+	return proto.Size(b.ToCompact())
+}
+
+// BlockSizeBreakdown divides a block's serialized size into its parts.
+type BlockSizeBreakdown struct {
+	Header      int // including the Equihash solution
+	Transparent int // tx count and transaction data other than Orchard bundles
+	Orchard     int // Orchard bundles (actions, proofs, signatures)
+}
+
+// SizeBreakdown returns the block's serialized size, divided into the
+// header, the transparent parts of transactions, and Orchard bundles.
+func (b *Block) SizeBreakdown() BlockSizeBreakdown {
+	sizes := BlockSizeBreakdown{Header: b.hdr.getSize()}
+	for _, tx := range b.vtx {
+		sizes.Orchard += tx.OrchardBundleSize()
+	}
+	sizes.Transparent = b.SerializedSize() - sizes.Header - sizes.Orchard
+	return sizes
+}
+
 // HasShieldedTransactions indicates if the block contains any shielded tx.
 // Juno Cash: Only Orchard transactions are shielded.
 func (b *Block) HasShieldedTransactions() bool {
@@ -82,6 +191,28 @@ func (b *Block) HasShieldedTransactions() bool {
 	return false
 }
 
+// OrchardCommitmentCount returns the number of Orchard note commitments
+// (one per action) that the block adds to the note commitment tree.
+func (b *Block) OrchardCommitmentCount() int {
+	count := 0
+	for _, tx := range b.vtx {
+		count += len(tx.orchardActions)
+	}
+	return count
+}
+
+// CompactActionCount returns the total number of Orchard actions in the
+// block's compact representation (see ToCompact), without constructing it.
+func (b *Block) CompactActionCount() int {
+	count := 0
+	for _, tx := range b.vtx {
+		if tx.HasShieldedElements() {
+			count += tx.OrchardActionsCount()
+		}
+	}
+	return count
+}
+
 // HasSaplingTransactions is deprecated, use HasShieldedTransactions.
 // Juno Cash: Always returns false (Sapling not supported).
 func (b *Block) HasSaplingTransactions() bool {
@@ -97,26 +228,145 @@ func (b *Block) GetHeight() int {
 	if b.height != -1 {
 		return b.height
 	}
+	height, err := b.CoinbaseHeight()
+	if err != nil {
+		return -1
+	}
+	b.height = height
+	return height
+}
+
+// CoinbaseHeight decodes the block height from the coinbase transaction's
+// scriptSig (see BIP34), returning an error if it's missing or malformed.
+func (b *Block) CoinbaseHeight() (int, error) {
+	if len(b.vtx) == 0 {
+		return -1, errors.New("block has no coinbase transaction")
+	}
+	if len(b.vtx[0].transparentInputs) == 0 {
+		return -1, errors.New("coinbase transaction has no inputs")
+	}
 	coinbaseScript := bytestring.String(b.vtx[0].transparentInputs[0].ScriptSig)
+	if len(coinbaseScript) == 0 {
+		return -1, errors.New("coinbase script is empty")
+	}
 	var heightNum int64
 	if !coinbaseScript.ReadScriptInt64(&heightNum) {
-		return -1
+		return -1, fmt.Errorf("coinbase script too short for height (%d bytes)",
+			len(b.vtx[0].transparentInputs[0].ScriptSig))
 	}
 	if heightNum < 0 {
-		return -1
+		return -1, fmt.Errorf("coinbase height %d is negative", heightNum)
 	}
 	// uint32 should last us a while (Nov 2018)
 	if heightNum > int64(^uint32(0)) {
-		return -1
+		return -1, fmt.Errorf("coinbase height %d is too large", heightNum)
 	}
 	blockHeight := uint32(heightNum)
 
 	if blockHeight == genesisTargetDifficulty {
 		blockHeight = 0
 	}
+	return int(blockHeight), nil
+}
+
+// HeaderFields holds a block's header fields, with the hashes and nonce in
+// big-endian display order (as shown by the node's getblockheader RPC).
+type HeaderFields struct {
+	Version    int32
+	PrevHash   hash32.T
+	MerkleRoot hash32.T
+	Time       uint32
+	Bits       uint32 // compact difficulty target (nBits)
+	Nonce      hash32.T
+	Solution   []byte // Equihash solution, in wire order
+}
+
+// Header returns the block's header fields.
+func (b *Block) Header() HeaderFields {
+	return HeaderFields{
+		Version:    b.hdr.Version,
+		PrevHash:   hash32.Reverse(b.hdr.HashPrevBlock),
+		MerkleRoot: hash32.Reverse(b.hdr.HashMerkleRoot),
+		Time:       b.hdr.Time,
+		Bits:       binary.LittleEndian.Uint32(b.hdr.NBitsBytes[:]),
+		Nonce:      hash32.Reverse(b.hdr.Nonce),
+		Solution:   append([]byte(nil), b.hdr.Solution[:]...),
+	}
+}
+
+// Difficulty returns the block's difficulty relative to the target of the
+// chain's genesis block (see BlockHeader.Difficulty).
+func (b *Block) Difficulty(genesisBits uint32) float64 {
+	return b.hdr.Difficulty(genesisBits)
+}
 
-	b.height = int(blockHeight)
-	return int(blockHeight)
+// MerkleRoot returns the header's transaction merkle root in big-endian
+// display order.
+func (b *Block) MerkleRoot() hash32.T {
+	return hash32.Reverse(b.hdr.HashMerkleRoot)
+}
+
+// VerifyMerkleRoot recomputes the merkle root of the block's transaction ids
+// and checks that it matches the header. The ids of v4 transactions are
+// computed locally; those of later versions must have been set (SetTxID).
+func (b *Block) VerifyMerkleRoot() error {
+	if len(b.vtx) == 0 {
+		return errors.New("block has no transactions")
+	}
+	level := make([]hash32.T, len(b.vtx))
+	for i, tx := range b.vtx {
+		txid, ok := tx.knownTxID()
+		if !ok {
+			return fmt.Errorf("txid of transaction %d is unknown", i)
+		}
+		level[i] = txid
+	}
+	// Bitcoin-style merkle tree: an odd node at any level is paired with
+	// itself.
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([]hash32.T, len(level)/2)
+		for i := range next {
+			next[i] = hash32.Sum256d(append(level[2*i][:], level[2*i+1][:]...))
+		}
+		level = next
+	}
+	if level[0] != b.hdr.HashMerkleRoot {
+		return fmt.Errorf("merkle root mismatch: computed %s, header has %s",
+			hash32.Encode(hash32.Reverse(level[0])), hash32.Encode(b.MerkleRoot()))
+	}
+	return nil
+}
+
+// ErrTxNotFound is returned by FindTransaction if the block doesn't contain
+// the requested transaction.
+var ErrTxNotFound = errors.New("transaction not found in block")
+
+// FindTransaction returns the block's transaction with the given id (in
+// little-endian wire order), and its index within the block. The ids of v4
+// transactions are computed locally; those of later versions must have been
+// set (SetTxID), for example, from the backend node's getblock reply.
+func (b *Block) FindTransaction(txid hash32.T) (*Transaction, int, error) {
+	for i, tx := range b.vtx {
+		if id, ok := tx.knownTxID(); ok && id == txid {
+			return tx, i, nil
+		}
+	}
+	return nil, -1, fmt.Errorf("%w: %s", ErrTxNotFound, hash32.Encode(hash32.Reverse(txid)))
+}
+
+// VerifyEquihash checks the block header's Equihash solution; see
+// BlockHeader.VerifyEquihash.
+func (b *Block) VerifyEquihash(n, k int) error {
+	return b.hdr.VerifyEquihash(n, k)
+}
+
+// PrevHash returns the hash of the block's previous block in big-endian
+// display order; it equals ComputeHash() of the previous block.
+func (b *Block) PrevHash() hash32.T {
+	return b.hdr.GetDisplayPrevHash()
 }
 
 // GetPrevHash returns the hash of the block's previous block (little-endian).
@@ -124,9 +374,29 @@ func (b *Block) GetPrevHash() hash32.T {
 	return b.hdr.HashPrevBlock
 }
 
+// CompactFilter selects which transactions ToCompactFiltered includes.
+type CompactFilter struct {
+	// OmitTransparent omits transactions with no Orchard actions, which
+	// a shielded-only wallet never needs.
+	OmitTransparent bool
+	// KeepCoinbase includes the coinbase transaction even if
+	// OmitTransparent is set.
+	KeepCoinbase bool
+}
+
 // ToCompact returns the compact representation of the full block.
-// Juno Cash: SaplingCommitmentTreeSize is always 0.
+// Only shielded (Orchard) transactions have a meaningful compact encoding,
+// so transparent-only transactions are omitted.
 func (b *Block) ToCompact() *walletrpc.CompactBlock {
+	return b.ToCompactFiltered(CompactFilter{OmitTransparent: true})
+}
+
+// ToCompactFiltered returns the compact representation of the full block,
+// including the transactions selected by filter. Each compact transaction's
+// Index is its position in the full block, whether or not other
+// transactions are omitted.
+// Juno Cash: SaplingCommitmentTreeSize is always 0.
+func (b *Block) ToCompactFiltered(filter CompactFilter) *walletrpc.CompactBlock {
 	compactBlock := &walletrpc.CompactBlock{
 		//TODO ProtoVersion: 1,
 		Height:   uint64(b.GetHeight()),
@@ -138,14 +408,15 @@ func (b *Block) ToCompact() *walletrpc.CompactBlock {
 		},
 	}
 
-	// Only shielded (Orchard) transactions have a meaningful compact encoding
-	shieldedTxns := make([]*walletrpc.CompactTx, 0, len(b.vtx))
+	txns := make([]*walletrpc.CompactTx, 0, len(b.vtx))
 	for idx, tx := range b.vtx {
-		if tx.HasShieldedElements() {
-			shieldedTxns = append(shieldedTxns, tx.ToCompact(idx))
+		if filter.OmitTransparent && !tx.HasShieldedElements() &&
+			!(filter.KeepCoinbase && idx == 0) {
+			continue
 		}
+		txns = append(txns, tx.ToCompact(idx))
 	}
-	compactBlock.Vtx = shieldedTxns
+	compactBlock.Vtx = txns
 	return compactBlock
 }
 
@@ -153,6 +424,32 @@ func (b *Block) ToCompact() *walletrpc.CompactBlock {
 // and returns a slice to the remaining data. The caller should verify
 // there is no remaining data if none is expected.
 func (b *Block) ParseFromSlice(data []byte) (rest []byte, err error) {
+	return b.parseFromSlice(data, false)
+}
+
+// ParseTolerant is like ParseFromSlice, but transactions with Sapling or
+// Sprout elements don't cause the block to fail to parse; those elements
+// are skipped (any Orchard actions are still parsed), and the positions of
+// such transactions are given by UnsupportedTransactions.
+func (b *Block) ParseTolerant(data []byte) (rest []byte, err error) {
+	return b.parseFromSlice(data, true)
+}
+
+// UnsupportedTransactions returns the indices of the block's transactions
+// that have Sapling or Sprout elements; their raw bytes are available from
+// Bytes(). This can only be non-empty for a block parsed by ParseTolerant.
+func (b *Block) UnsupportedTransactions() []int {
+	var indices []int
+	for i, tx := range b.vtx {
+		if tx.HasUnsupportedElements() {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+func (b *Block) parseFromSlice(data []byte, tolerant bool) (rest []byte, err error) {
+	in := data
 	hdr := NewBlockHeader()
 	data, err = hdr.ParseFromSlice(data)
 	if err != nil {
@@ -161,7 +458,7 @@ func (b *Block) ParseFromSlice(data []byte) (rest []byte, err error) {
 
 	s := bytestring.String(data)
 	var txCount int
-	if !s.ReadCompactSize(&txCount) {
+	if !s.ReadCompactSizeBounded(&txCount, len(s)) {
 		return nil, errors.New("could not read tx_count")
 	}
 	data = []byte(s)
@@ -170,7 +467,11 @@ func (b *Block) ParseFromSlice(data []byte) (rest []byte, err error) {
 	var i int
 	for i = 0; i < txCount && len(data) > 0; i++ {
 		tx := NewTransaction()
-		data, err = tx.ParseFromSlice(data)
+		if tolerant {
+			data, err = tx.ParseFromSliceTolerant(data)
+		} else {
+			data, err = tx.ParseFromSlice(data)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("error parsing transaction %d: %w", i, err)
 		}
@@ -181,5 +482,109 @@ func (b *Block) ParseFromSlice(data []byte) (rest []byte, err error) {
 	}
 	b.hdr = hdr
 	b.vtx = vtx
+	b.rawBytes = in[:len(in)-len(data)]
 	return data, nil
 }
+
+// maxBlockSize is the consensus limit on the size of a serialized block.
+const maxBlockSize = 2000000
+
+// blockReader buffers a block's data as it's read, so that it can be parsed
+// one transaction at a time.
+type blockReader struct {
+	r     io.Reader
+	buf   []byte // unparsed data
+	total int    // number of bytes read from r
+	eof   bool
+}
+
+// fill ensures that at least n bytes are buffered, unless the end of the
+// input is reached first. The buffer is reallocated so that it doesn't
+// retain data that has already been parsed.
+func (br *blockReader) fill(n int) error {
+	if len(br.buf) >= n || br.eof {
+		return nil
+	}
+	buf := make([]byte, n)
+	have := copy(buf, br.buf)
+	m, err := io.ReadFull(br.r, buf[have:])
+	br.total += m
+	if br.total > maxBlockSize {
+		return fmt.Errorf("block is larger than %d bytes", maxBlockSize)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		br.eof = true
+	} else if err != nil {
+		return err
+	}
+	br.buf = buf[:have+m]
+	return nil
+}
+
+// ParseFromReader deserializes a block from r, which must contain exactly
+// one block. Unlike ParseFromSlice, it doesn't require the entire block to
+// be in memory at once; it reads and parses one transaction at a time.
+func (b *Block) ParseFromReader(r io.Reader) error {
+	br := &blockReader{r: r}
+	headerSize := serBlockHeaderMinusEquihashSize + CompactLengthPrefixedLen(equihashSizeMainnet)
+	if err := br.fill(headerSize); err != nil {
+		return err
+	}
+	hdr := NewBlockHeader()
+	_, err := hdr.ParseFromSlice(br.buf)
+	if err != nil {
+		return fmt.Errorf("parsing block header: %w", err)
+	}
+
+	// a CompactSize is at most 9 bytes
+	if err = br.fill(headerSize + 9); err != nil {
+		return err
+	}
+	s := bytestring.String(br.buf[headerSize:])
+	var txCount int
+	if !s.ReadCompactSizeBounded(&txCount, maxBlockSize) {
+		return errors.New("could not read tx_count")
+	}
+	br.buf = []byte(s)
+
+	// Not pre-sized: the count isn't checked against the input (which
+	// hasn't all been read), so it may be far too large.
+	var vtx []*Transaction
+	for i := 0; i < txCount; i++ {
+		// Parse the transaction from what's buffered; if that fails,
+		// it may be because the transaction isn't entirely buffered,
+		// so (until the end of the input) buffer more and retry.
+		need := 4096
+		for {
+			if err = br.fill(need); err != nil {
+				return err
+			}
+			if br.eof && len(br.buf) == 0 {
+				return errors.New("parsing block transactions: not enough data")
+			}
+			tx := NewTransaction()
+			rest, err := tx.ParseFromSlice(br.buf)
+			if err == nil {
+				// Don't retain (alias) the buffer.
+				tx.rawBytes = bytes.Clone(tx.rawBytes)
+				vtx = append(vtx, tx)
+				br.buf = rest
+				break
+			}
+			if br.eof {
+				return fmt.Errorf("error parsing transaction %d: %w", i, err)
+			}
+			need = 2 * len(br.buf)
+		}
+	}
+	if err = br.fill(1); err != nil {
+		return err
+	}
+	if len(br.buf) > 0 {
+		return errors.New("extra data after block")
+	}
+	b.hdr = hdr
+	b.vtx = vtx
+	b.rawBytes = nil
+	return nil
+}
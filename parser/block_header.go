@@ -7,7 +7,6 @@ package parser
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
@@ -215,6 +214,30 @@ func parseNBits(b []byte) *big.Int {
 	return new(big.Int).SetBytes(targetBytes)
 }
 
+// Difficulty returns the header's difficulty: the ratio of the genesis
+// target, genesisBits, to the header's target (nBits). The genesis target
+// is the nBits of the chain's genesis block (Block.Header().Bits), so it's
+// taken from the chain itself rather than built in for each network. This
+// is computed the same way as the node's getdifficulty RPC, which gives the
+// same result if the genesis block was mined at the proof-of-work limit (as
+// zcashd's chain parameters do).
+func (hdr *BlockHeader) Difficulty(genesisBits uint32) float64 {
+	nBits := binary.LittleEndian.Uint32(hdr.NBitsBytes[:])
+	if nBits&0x00ffffff == 0 {
+		return 0
+	}
+	shift := int(nBits>>24) & 0xff
+	limitShift := int(genesisBits>>24) & 0xff
+	diff := float64(genesisBits&0x00ffffff) / float64(nBits&0x00ffffff)
+	for ; shift < limitShift; shift++ {
+		diff *= 256
+	}
+	for ; shift > limitShift; shift-- {
+		diff /= 256
+	}
+	return diff
+}
+
 // GetDisplayHash returns the bytes of a block hash in big-endian order.
 func (hdr *BlockHeader) GetDisplayHash() hash32.T {
 	if hdr.cachedHash != hash32.Nil {
@@ -226,12 +249,8 @@ func (hdr *BlockHeader) GetDisplayHash() hash32.T {
 		return hash32.Nil
 	}
 
-	// SHA256d
-	digest := sha256.Sum256(serializedHeader)
-	digest = sha256.Sum256(digest[:])
-
 	// Convert to big-endian
-	hdr.cachedHash = hash32.Reverse(digest)
+	hdr.cachedHash = hash32.Reverse(hash32.Sum256d(serializedHeader))
 	return hdr.cachedHash
 }
 
@@ -248,11 +267,7 @@ func (hdr *BlockHeader) GetEncodableHash() hash32.T {
 		return hash32.Nil
 	}
 
-	// SHA256d
-	digest := sha256.Sum256(serializedHeader)
-	digest = sha256.Sum256(digest[:])
-
-	return digest
+	return hash32.Sum256d(serializedHeader)
 }
 
 // GetDisplayPrevHash returns the block hash in big-endian order.
@@ -6,12 +6,14 @@ package parser
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"math/big"
 	"os"
 	"testing"
 
 	"github.com/zcash/lightwalletd/hash32"
+	"github.com/zcash/lightwalletd/parser/internal/bytestring"
 )
 
 // https://bitcoin.org/en/developer-reference#target-nbits
@@ -148,6 +150,88 @@ func TestBlockHeader(t *testing.T) {
 	}
 }
 
+func TestVerifyEquihash(t *testing.T) {
+	testBlocks, err := os.Open("../testdata/blocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testBlocks.Close()
+
+	scan := bufio.NewScanner(testBlocks)
+	for scan.Scan() {
+		blockData, err := hex.DecodeString(scan.Text())
+		if err != nil {
+			t.Fatal(err)
+		}
+		blockHeader := NewBlockHeader()
+		_, err = blockHeader.ParseFromSlice(blockData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = blockHeader.VerifyEquihash(EquihashN, EquihashK); err != nil {
+			t.Fatal("valid solution failed verification:", err)
+		}
+		if err = blockHeader.VerifyEquihash(144, 5); err == nil {
+			t.Fatal("wrong parameters unexpectedly succeeded")
+		}
+
+		// Changing the nonce invalidates the solution.
+		blockHeader.Nonce[0] ^= 1
+		if err = blockHeader.VerifyEquihash(EquihashN, EquihashK); err == nil {
+			t.Fatal("bad nonce unexpectedly succeeded")
+		}
+		blockHeader.Nonce[0] ^= 1
+
+		// Swapping the first two indices breaks the ordering rule
+		// (the first index is the leftmost 21 bits).
+		saved := blockHeader.Solution
+		r := bytestring.NewBitReader(blockHeader.Solution[:])
+		first, _ := r.ReadBits(21)
+		second, _ := r.ReadBits(21)
+		swapped := second<<21 | first
+		for i := 0; i < 42; i++ {
+			bit := byte(swapped>>(41-i)) & 1
+			blockHeader.Solution[i/8] &^= 0x80 >> (i % 8)
+			blockHeader.Solution[i/8] |= bit << (7 - i%8)
+		}
+		err = blockHeader.VerifyEquihash(EquihashN, EquihashK)
+		if err == nil || err.Error() != "invalid Equihash solution: index tree incorrectly ordered" {
+			t.Fatal("swapped indices unexpected result:", err)
+		}
+		blockHeader.Solution = saved
+		if err = blockHeader.VerifyEquihash(EquihashN, EquihashK); err != nil {
+			t.Fatal("restored solution failed verification:", err)
+		}
+	}
+}
+
+func TestDifficulty(t *testing.T) {
+	// The nBits of the genesis blocks of the chains that testdata's blocks
+	// come from.
+	const (
+		mainGenesisBits = 0x1f07ffff
+		testGenesisBits = 0x2007ffff
+	)
+	for _, tt := range []struct {
+		nBits   uint32
+		genesis uint32
+		want    float64
+	}{
+		{mainGenesisBits, mainGenesisBits, 1},
+		{testGenesisBits, testGenesisBits, 1},
+		{0x1f232690, testGenesisBits, 58.26341707356036}, // testnet block 289460
+		{0x1c0deed0, mainGenesisBits, 9633159.251292296},
+		{0x2007ffff, mainGenesisBits, 1.0 / 256},
+		{0x1f000000, mainGenesisBits, 0},
+	} {
+		hdr := NewBlockHeader()
+		binary.LittleEndian.PutUint32(hdr.NBitsBytes[:], tt.nBits)
+		if diff := hdr.Difficulty(tt.genesis); diff != tt.want {
+			t.Errorf("Difficulty(%x) of %x = %v, want %v", tt.genesis, tt.nBits, diff, tt.want)
+		}
+	}
+}
+
 func TestBadBlockHeader(t *testing.T) {
 	testBlocks, err := os.Open("../testdata/badblocks")
 	if err != nil {
@@ -7,41 +7,89 @@
 package parser
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"io"
 	"os"
+	"slices"
 	"testing"
+	"testing/iotest"
 
 	protobuf "github.com/golang/protobuf/proto"
+	"github.com/zcash/lightwalletd/hash32"
 )
 
-func TestCompactBlocks(t *testing.T) {
-	type compactTest struct {
-		BlockHeight int    `json:"block"`
-		BlockHash   string `json:"hash"`
-		PrevHash    string `json:"prev"`
-		Full        string `json:"full"`
-		Compact     string `json:"compact"`
-	}
-	var compactTests []compactTest
+// compactTest is a block in testdata/compact_blocks.json.
+type compactTest struct {
+	BlockHeight int    `json:"block"`
+	BlockHash   string `json:"hash"`
+	PrevHash    string `json:"prev"`
+	Full        string `json:"full"`
+	Compact     string `json:"compact"`
+}
 
+func loadCompactTests(t *testing.T) []compactTest {
+	t.Helper()
 	blockJSON, err := os.ReadFile("../testdata/compact_blocks.json")
 	if err != nil {
 		t.Fatal(err)
 	}
+	var compactTests []compactTest
+	if err := json.Unmarshal(blockJSON, &compactTests); err != nil {
+		t.Fatal(err)
+	}
+	return compactTests
+}
+
+// testBlock is a block in testdata/blocks (consecutive blocks starting at
+// 380640): its serialized data, and the data parsed.
+type testBlock struct {
+	data  []byte
+	block *Block
+}
 
-	err = json.Unmarshal(blockJSON, &compactTests)
+func loadTestBlocks(t *testing.T) []testBlock {
+	t.Helper()
+	testBlocks, err := os.Open("../testdata/blocks")
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer testBlocks.Close()
 
-	for _, test := range compactTests {
+	var blocks []testBlock
+	scan := bufio.NewScanner(testBlocks)
+	for scan.Scan() {
+		blockData, err := hex.DecodeString(scan.Text())
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := NewBlock()
+		if _, err := block.ParseFromSlice(blockData); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, testBlock{blockData, block})
+	}
+	if len(blocks) == 0 {
+		t.Fatal("no test blocks")
+	}
+	return blocks
+}
+
+func TestCompactBlocks(t *testing.T) {
+	var err error
+	for _, test := range loadCompactTests(t) {
 		blockData, _ := hex.DecodeString(test.Full)
 		block := NewBlock()
 		blockData, err = block.ParseFromSlice(blockData)
 		if err != nil {
 			// Juno Cash: Skip blocks with Sapling transactions (not supported)
-			t.Logf("Skipping testnet block %d (likely has Sapling transactions): %v", test.BlockHeight, err)
+			if !errors.Is(err, ErrUnsupportedSapling) && !errors.Is(err, ErrUnsupportedSprout) {
+				t.Fatalf("testnet block %d: %v", test.BlockHeight, err)
+			}
+			t.Logf("Skipping testnet block %d (has unsupported transactions): %v", test.BlockHeight, err)
 			continue
 		}
 		if len(blockData) > 0 {
@@ -77,3 +125,587 @@ func TestCompactBlocks(t *testing.T) {
 	}
 
 }
+
+func TestBlockHeaderFields(t *testing.T) {
+	// The first test block (testnet 289460)
+	test := loadCompactTests(t)[0]
+	blockData, _ := hex.DecodeString(test.Full)
+	block := NewBlock()
+	_, err := block.ParseFromSlice(blockData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr := block.Header()
+	if hdr.Version != 4 {
+		t.Fatal("unexpected version", hdr.Version)
+	}
+	if block.Version() != hdr.Version || block.GetVersion() != int(hdr.Version) {
+		t.Fatal("unexpected block version", block.Version())
+	}
+	if hash32.Encode(hdr.PrevHash) != test.PrevHash {
+		t.Fatal("unexpected prevhash", hash32.Encode(hdr.PrevHash))
+	}
+	if hash32.Encode(hdr.MerkleRoot) != "23d8da5189a147ce4781bb7b7d99ff61751c4ce4eb5d2f2cd37d4bd021d8a4d7" {
+		t.Fatal("unexpected merkleroot", hash32.Encode(hdr.MerkleRoot))
+	}
+	if hdr.Time != 0x5b980e45 {
+		t.Fatalf("unexpected time %x", hdr.Time)
+	}
+	if hdr.Bits != 0x1f232690 {
+		t.Fatalf("unexpected bits %x", hdr.Bits)
+	}
+	if hash32.Encode(hdr.Nonce) != "0000eba187d33d14aa384c42e82f6d2f6ba1c8cae881700ef5e7aaaaf380001f" {
+		t.Fatal("unexpected nonce", hash32.Encode(hdr.Nonce))
+	}
+	if !bytes.Equal(hdr.Solution, block.hdr.Solution[:]) {
+		t.Fatal("unexpected solution")
+	}
+}
+
+func TestBlockComputeHash(t *testing.T) {
+	compactTests := loadCompactTests(t)
+	// The header is parsed before any transactions, so the hash can be
+	// computed even for blocks whose transactions we can't parse.
+	for i, test := range compactTests {
+		blockData, _ := hex.DecodeString(test.Full)
+		hdr := NewBlockHeader()
+		_, err := hdr.ParseFromSlice(blockData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := &Block{hdr: hdr}
+		if hash32.Encode(block.ComputeHash()) != test.BlockHash {
+			t.Fatalf("incorrect computed hash for block %d", test.BlockHeight)
+		}
+		if hash32.Encode(block.PrevHash()) != test.PrevHash {
+			t.Fatalf("incorrect prevhash for block %d", test.BlockHeight)
+		}
+		if i > 0 && compactTests[i-1].BlockHeight == test.BlockHeight-1 &&
+			compactTests[i-1].BlockHash != test.PrevHash {
+			t.Fatalf("block %d prevhash doesn't match block %d hash",
+				test.BlockHeight, compactTests[i-1].BlockHeight)
+		}
+	}
+}
+
+// orchardTestBlock returns a raw block containing Orchard actions, and the
+// number of actions. None of the test blocks contain Orchard actions, so
+// construct one: take the header and coinbase of a test block, and append
+// the Orchard-only test transactions.
+func orchardTestBlock(t *testing.T) ([]byte, int) {
+	first := loadTestBlocks(t)[0]
+	blockData, block := first.data, first.block
+	headerSize := serBlockHeaderMinusEquihashSize + 3 + equihashSizeMainnet
+	coinbase := block.Transactions()[0].Bytes()
+
+	s, err := os.ReadFile("../testdata/tx_v5.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var testdata []json.RawMessage
+	err = json.Unmarshal(s, &testdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var txs [][]byte
+	actions := 0
+	for _, onetx := range testdata[2:] {
+		var txtestdata TxTestData
+		err = json.Unmarshal(onetx, &txtestdata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if txtestdata.NSpendsSapling > 0 || txtestdata.NoutputsSapling > 0 ||
+			txtestdata.NActionsOrchard == 0 {
+			continue
+		}
+		rawTx, _ := hex.DecodeString(txtestdata.Tx)
+		txs = append(txs, rawTx)
+		actions += txtestdata.NActionsOrchard
+	}
+	if len(txs) < 2 {
+		t.Fatal("not enough Orchard-only test transactions")
+	}
+
+	var buf bytes.Buffer
+	buf.Write(blockData[:headerSize])
+	WriteCompactLengthPrefixedLen(&buf, 1+len(txs))
+	buf.Write(coinbase)
+	for _, rawTx := range txs {
+		buf.Write(rawTx)
+	}
+	return buf.Bytes(), actions
+}
+
+func TestBlockOrchardCommitmentCount(t *testing.T) {
+	blockData, wantCount := orchardTestBlock(t)
+	block := NewBlock()
+	rest, err := block.ParseFromSlice(blockData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 0 {
+		t.Fatal("extra data remaining")
+	}
+	if block.OrchardCommitmentCount() != wantCount {
+		t.Fatalf("OrchardCommitmentCount() = %d, want %d",
+			block.OrchardCommitmentCount(), wantCount)
+	}
+	compactCount := 0
+	for _, ctx := range block.ToCompact().Vtx {
+		compactCount += len(ctx.Actions)
+	}
+	if block.CompactActionCount() != wantCount || compactCount != wantCount {
+		t.Fatalf("CompactActionCount() = %d, compact block has %d, want %d",
+			block.CompactActionCount(), compactCount, wantCount)
+	}
+}
+
+func TestBlockTransactions(t *testing.T) {
+	headerSize := serBlockHeaderMinusEquihashSize + 3 + equihashSizeMainnet
+	for _, tb := range loadTestBlocks(t) {
+		blockData, block := tb.data, tb.block
+		// The tx count follows the header (all test blocks have < 253 txs).
+		txCount := int(blockData[headerSize])
+		txs := block.Transactions()
+		if len(txs) != txCount || block.GetTxCount() != txCount {
+			t.Fatalf("block %d: have %d transactions, want %d",
+				block.GetHeight(), len(txs), txCount)
+		}
+		// The transactions are in on-chain order, coinbase first.
+		var raw []byte
+		for _, tx := range txs {
+			raw = append(raw, tx.Bytes()...)
+		}
+		if !bytes.Equal(raw, blockData[headerSize+1:]) {
+			t.Fatalf("block %d: transactions not in block order", block.GetHeight())
+		}
+	}
+}
+
+func TestMedianTimePast(t *testing.T) {
+	var blocks []*Block
+	for _, tb := range loadTestBlocks(t) {
+		block := tb.block
+		if block.Time() != block.hdr.Time || block.Time() == 0 {
+			t.Fatal("unexpected block time", block.Time())
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) != 4 {
+		t.Fatal("unexpected number of test blocks", len(blocks))
+	}
+	if _, err := MedianTimePast(nil); err == nil {
+		t.Fatal("MedianTimePast(nil) unexpected success")
+	}
+	mtp, err := MedianTimePast(blocks[:1])
+	if err != nil || mtp != int64(blocks[0].Time()) {
+		t.Fatal("unexpected single-block median time", mtp, err)
+	}
+	// The test block times are increasing, so with four blocks the
+	// median is the third (index 2 after sorting).
+	mtp, err = MedianTimePast(blocks)
+	if err != nil || mtp != int64(blocks[2].Time()) {
+		t.Fatal("unexpected median time", mtp, err)
+	}
+	// Only the last 11 blocks are considered; order doesn't matter.
+	var many []*Block
+	for i := 0; i < 4; i++ {
+		many = append(many, blocks[3])
+	}
+	for i := 0; i < 11; i++ {
+		many = append(many, blocks[i%2])
+	}
+	mtp, err = MedianTimePast(many)
+	if err != nil || mtp != int64(blocks[0].Time()) {
+		t.Fatal("unexpected median time over 11 blocks", mtp, err)
+	}
+}
+
+func TestBlockSerializedSize(t *testing.T) {
+	headerSize := serBlockHeaderMinusEquihashSize + 3 + equihashSizeMainnet
+	for _, tb := range loadTestBlocks(t) {
+		blockData, block := tb.data, tb.block
+		if block.SerializedSize() != len(blockData) {
+			t.Fatalf("SerializedSize() = %d, want %d", block.SerializedSize(), len(blockData))
+		}
+		sizes := block.SizeBreakdown()
+		want := BlockSizeBreakdown{
+			Header:      headerSize,
+			Transparent: len(blockData) - headerSize,
+		}
+		if sizes != want {
+			t.Fatalf("unexpected size breakdown %+v", sizes)
+		}
+	}
+
+	blockData, actions := orchardTestBlock(t)
+	block := NewBlock()
+	_, err := block.ParseFromSlice(blockData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.SerializedSize() != len(blockData) {
+		t.Fatalf("SerializedSize() = %d, want %d", block.SerializedSize(), len(blockData))
+	}
+	marshaled, err := protobuf.Marshal(block.ToCompact())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.CompactSerializedSize() != len(marshaled) {
+		t.Fatalf("CompactSerializedSize() = %d, want %d", block.CompactSerializedSize(), len(marshaled))
+	}
+	sizes := block.SizeBreakdown()
+	if sizes.Header+sizes.Transparent+sizes.Orchard != len(blockData) {
+		t.Fatalf("size breakdown %+v doesn't sum to %d", sizes, len(blockData))
+	}
+	// Each action (including its spend authorization signature) is a fixed
+	// size; the bundle also contains the proof and other fields.
+	if sizes.Orchard <= actions*(orchardActionSize+64) {
+		t.Fatalf("Orchard size %d too small for %d actions", sizes.Orchard, actions)
+	}
+}
+
+func TestBlockCoinbaseHeight(t *testing.T) {
+	wantHeight := 380640
+	for _, tb := range loadTestBlocks(t) {
+		block := tb.block
+		height, err := block.CoinbaseHeight()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if height != wantHeight || block.GetHeight() != wantHeight {
+			t.Fatalf("CoinbaseHeight() = %d, want %d", height, wantHeight)
+		}
+		wantHeight++
+	}
+
+	// Malformed coinbase scripts
+	for _, tt := range []struct {
+		script []byte
+		errStr string
+	}{
+		{[]byte{}, "coinbase script is empty"},
+		{[]byte{3, 0x60, 0xcf}, "coinbase script too short for height (3 bytes)"},
+		{[]byte{1, 0x81}, ""}, // 129
+	} {
+		block := &Block{
+			vtx: []*Transaction{{rawTransaction: &rawTransaction{
+				transparentInputs: []txIn{{ScriptSig: tt.script}},
+			}}},
+			height: -1,
+		}
+		height, err := block.CoinbaseHeight()
+		if tt.errStr == "" {
+			if err != nil || height != 129 {
+				t.Fatal("unexpected result", height, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.errStr {
+			t.Fatalf("CoinbaseHeight() error %v, want %s", err, tt.errStr)
+		}
+		if block.GetHeight() != -1 {
+			t.Fatal("GetHeight() should fail")
+		}
+	}
+	if _, err := (&Block{}).CoinbaseHeight(); err == nil {
+		t.Fatal("CoinbaseHeight() of empty block unexpected success")
+	}
+}
+
+func TestBlockToCompactFiltered(t *testing.T) {
+	blockData, _ := orchardTestBlock(t)
+	block := NewBlock()
+	_, err := block.ParseFromSlice(blockData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.GetTxCount() != 4 {
+		t.Fatal("unexpected test block transaction count", block.GetTxCount())
+	}
+	for _, tt := range []struct {
+		filter  CompactFilter
+		indices []uint64
+	}{
+		// The coinbase (index 0) is the only transparent-only transaction.
+		{CompactFilter{}, []uint64{0, 1, 2, 3}},
+		{CompactFilter{KeepCoinbase: true}, []uint64{0, 1, 2, 3}},
+		{CompactFilter{OmitTransparent: true}, []uint64{1, 2, 3}},
+		{CompactFilter{OmitTransparent: true, KeepCoinbase: true}, []uint64{0, 1, 2, 3}},
+	} {
+		compact := block.ToCompactFiltered(tt.filter)
+		if len(compact.Vtx) != len(tt.indices) {
+			t.Fatalf("filter %+v: have %d transactions, want %d",
+				tt.filter, len(compact.Vtx), len(tt.indices))
+		}
+		for i, ctx := range compact.Vtx {
+			if ctx.Index != tt.indices[i] {
+				t.Fatalf("filter %+v: transaction %d has index %d, want %d",
+					tt.filter, i, ctx.Index, tt.indices[i])
+			}
+		}
+	}
+	if !protobuf.Equal(block.ToCompact(), block.ToCompactFiltered(CompactFilter{OmitTransparent: true})) {
+		t.Fatal("ToCompact() doesn't match OmitTransparent filter")
+	}
+}
+
+func TestBlockVerifyMerkleRoot(t *testing.T) {
+	for _, tb := range loadTestBlocks(t) {
+		block := tb.block
+		if block.MerkleRoot() != block.Header().MerkleRoot {
+			t.Fatal("unexpected merkle root")
+		}
+		if err := block.VerifyMerkleRoot(); err != nil {
+			t.Fatal(err)
+		}
+		// Drop the last transaction (as if it hadn't parsed).
+		block.vtx = block.vtx[:len(block.vtx)-1]
+		if err := block.VerifyMerkleRoot(); err == nil {
+			t.Fatal("VerifyMerkleRoot() unexpected success with missing transaction")
+		}
+	}
+
+	// The ids of v5 transactions must be set.
+	blockData, _ := orchardTestBlock(t)
+	block := NewBlock()
+	_, err := block.ParseFromSlice(blockData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = block.VerifyMerkleRoot()
+	if err == nil || err.Error() != "txid of transaction 1 is unknown" {
+		t.Fatal("VerifyMerkleRoot() unexpected result:", err)
+	}
+}
+
+func TestBlockParseFromReader(t *testing.T) {
+	var rawBlocks [][]byte
+	for _, tb := range loadTestBlocks(t) {
+		rawBlocks = append(rawBlocks, tb.data)
+	}
+	orchardBlock, _ := orchardTestBlock(t)
+	rawBlocks = append(rawBlocks, orchardBlock)
+
+	for _, blockData := range rawBlocks {
+		want := NewBlock()
+		_, err := want.ParseFromSlice(blockData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Also read one byte at a time, to exercise partial reads.
+		for _, r := range []io.Reader{
+			bytes.NewReader(blockData),
+			iotest.OneByteReader(bytes.NewReader(blockData)),
+		} {
+			block := NewBlock()
+			if err = block.ParseFromReader(r); err != nil {
+				t.Fatal(err)
+			}
+			if block.GetDisplayHash() != want.GetDisplayHash() ||
+				block.GetTxCount() != want.GetTxCount() ||
+				block.SerializedSize() != len(blockData) {
+				t.Fatal("ParseFromReader() result doesn't match ParseFromSlice()")
+			}
+			for i, tx := range block.Transactions() {
+				if !bytes.Equal(tx.Bytes(), want.Transactions()[i].Bytes()) {
+					t.Fatal("transaction mismatch", i)
+				}
+			}
+			if !protobuf.Equal(block.ToCompact(), want.ToCompact()) {
+				t.Fatal("compact block mismatch")
+			}
+		}
+		// truncated
+		block := NewBlock()
+		if err = block.ParseFromReader(bytes.NewReader(blockData[:len(blockData)-1])); err == nil {
+			t.Fatal("ParseFromReader() truncated block unexpected success")
+		}
+		// overlong
+		if err = block.ParseFromReader(bytes.NewReader(append(bytes.Clone(blockData), 0))); err == nil {
+			t.Fatal("ParseFromReader() overlong block unexpected success")
+		}
+	}
+}
+
+func TestBlockEqual(t *testing.T) {
+	// Each block is parsed twice.
+	var blocks, copies []*Block
+	for _, tb := range loadTestBlocks(t) {
+		blocks = append(blocks, tb.block)
+	}
+	for _, tb := range loadTestBlocks(t) {
+		copies = append(copies, tb.block)
+	}
+	for i := range blocks {
+		for j := range copies {
+			if blocks[i].Equal(copies[j]) != (i == j) {
+				t.Fatalf("block %d Equal(%d) unexpected result", i, j)
+			}
+		}
+	}
+	// A replacement block at the same height (as in a reorg) differs.
+	replacement := NewBlock()
+	replacement.hdr = NewBlockHeader()
+	*replacement.hdr.RawBlockHeader = *blocks[0].hdr.RawBlockHeader
+	replacement.hdr.Nonce[0] ^= 1
+	replacement.vtx = blocks[0].vtx
+	if replacement.GetHeight() != blocks[0].GetHeight() || replacement.Equal(blocks[0]) {
+		t.Fatal("replacement block unexpectedly equal")
+	}
+	var nilBlock *Block
+	if blocks[0].Equal(nil) || !nilBlock.Equal(nil) {
+		t.Fatal("unexpected nil block comparison")
+	}
+}
+
+func TestBlockTxOffsets(t *testing.T) {
+	blockData, _ := orchardTestBlock(t)
+	block := NewBlock()
+	_, err := block.ParseFromSlice(blockData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(block.Bytes(), blockData) {
+		t.Fatal("unexpected block bytes")
+	}
+	offsets := block.TxOffsets()
+	if len(offsets) != block.GetTxCount() {
+		t.Fatal("unexpected number of offsets", len(offsets))
+	}
+	// The coinbase follows the header and the (1-byte) tx count.
+	if offsets[0] != serBlockHeaderMinusEquihashSize+3+equihashSizeMainnet+1 {
+		t.Fatal("unexpected coinbase offset", offsets[0])
+	}
+	for i, offset := range offsets {
+		end := len(blockData)
+		if i+1 < len(offsets) {
+			end = offsets[i+1]
+		}
+		// Re-parse the transaction from just its slice of the block.
+		tx := NewTransaction()
+		rest, err := tx.ParseFromSlice(block.Bytes()[offset:end])
+		if err != nil {
+			t.Fatal("transaction", i, err)
+		}
+		if len(rest) != 0 {
+			t.Fatal("transaction", i, "extra data remaining")
+		}
+		if !bytes.Equal(tx.Bytes(), block.Transactions()[i].Bytes()) {
+			t.Fatal("transaction", i, "mismatch")
+		}
+	}
+
+	// The offsets don't depend on the raw bytes.
+	block = NewBlock()
+	if err = block.ParseFromReader(bytes.NewReader(blockData)); err != nil {
+		t.Fatal(err)
+	}
+	if block.Bytes() != nil || !slices.Equal(block.TxOffsets(), offsets) {
+		t.Fatal("unexpected result from ParseFromReader block")
+	}
+}
+
+func TestBlockParseTolerant(t *testing.T) {
+	// Every block parses in tolerant mode, including those with Sapling
+	// transactions (which ParseFromSlice rejects).
+	unsupported := 0
+	for _, test := range loadCompactTests(t) {
+		blockData, _ := hex.DecodeString(test.Full)
+		block := NewBlock()
+		rest, err := block.ParseTolerant(blockData)
+		if err != nil {
+			t.Fatalf("block %d: %v", test.BlockHeight, err)
+		}
+		if len(rest) != 0 {
+			t.Fatalf("block %d: extra data remaining", test.BlockHeight)
+		}
+		if block.GetHeight() != test.BlockHeight || block.GetDisplayHashString() != test.BlockHash {
+			t.Fatalf("block %d: incorrect height or hash", test.BlockHeight)
+		}
+		if block.SerializedSize() != len(blockData) {
+			t.Fatalf("block %d: unexpected size", test.BlockHeight)
+		}
+		indices := block.UnsupportedTransactions()
+		_, err = NewBlock().ParseFromSlice(blockData)
+		if (err == nil) != (len(indices) == 0) {
+			t.Fatalf("block %d: unsupported transactions %v, ParseFromSlice error %v",
+				test.BlockHeight, indices, err)
+		}
+		for _, i := range indices {
+			// Each unsupported transaction's raw bytes can be found at
+			// its offset within the block.
+			offset := block.TxOffsets()[i]
+			raw := block.Transactions()[i].Bytes()
+			if !bytes.Equal(blockData[offset:offset+len(raw)], raw) {
+				t.Fatalf("block %d: transaction %d bytes mismatch", test.BlockHeight, i)
+			}
+		}
+		unsupported += len(indices)
+	}
+	if unsupported == 0 {
+		t.Fatal("no test blocks have unsupported transactions")
+	}
+
+	// Sapling v5 transactions: the Orchard actions are still parsed.
+	s, err := os.ReadFile("../testdata/tx_v5.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var testdata []json.RawMessage
+	err = json.Unmarshal(s, &testdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, onetx := range testdata[2:] {
+		var txtestdata TxTestData
+		err = json.Unmarshal(onetx, &txtestdata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rawTxData, _ := hex.DecodeString(txtestdata.Tx)
+		tx := NewTransaction()
+		rest, err := tx.ParseFromSliceTolerant(rawTxData)
+		if err != nil {
+			t.Fatalf("txid %s: %v", txtestdata.Txid, err)
+		}
+		if len(rest) != 0 {
+			t.Fatalf("txid %s: extra data remaining", txtestdata.Txid)
+		}
+		hasSapling := txtestdata.NSpendsSapling > 0 || txtestdata.NoutputsSapling > 0
+		if tx.HasUnsupportedElements() != hasSapling {
+			t.Fatalf("txid %s: unexpected HasUnsupportedElements", txtestdata.Txid)
+		}
+		if tx.OrchardActionsCount() != txtestdata.NActionsOrchard {
+			t.Fatalf("txid %s: unexpected action count", txtestdata.Txid)
+		}
+	}
+}
+
+func TestBlockFindTransaction(t *testing.T) {
+	blockData, _ := orchardTestBlock(t)
+	block := NewBlock()
+	_, err := block.ParseFromSlice(blockData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The coinbase is v4, so its id is computed locally.
+	coinbaseID := hash32.Sum256d(block.Transactions()[0].Bytes())
+	tx, i, err := block.FindTransaction(coinbaseID)
+	if err != nil || i != 0 || tx != block.Transactions()[0] {
+		t.Fatal("FindTransaction(coinbase) unexpected result", i, err)
+	}
+
+	// The v5 transactions are only found once their ids are set.
+	v5ID := hash32.T{1, 2, 3}
+	_, _, err = block.FindTransaction(v5ID)
+	if !errors.Is(err, ErrTxNotFound) {
+		t.Fatal("FindTransaction() unexpected result", err)
+	}
+	block.Transactions()[2].SetTxID(v5ID)
+	tx, i, err = block.FindTransaction(v5ID)
+	if err != nil || i != 2 || tx != block.Transactions()[2] {
+		t.Fatal("FindTransaction() unexpected result", i, err)
+	}
+}
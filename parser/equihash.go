@@ -0,0 +1,108 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/zcash/lightwalletd/parser/internal/blake2b"
+	"github.com/zcash/lightwalletd/parser/internal/bytestring"
+)
+
+// Equihash parameters used by mainnet and testnet.
+const (
+	EquihashN = 200
+	EquihashK = 9
+)
+
+// equihashRow is an intermediate node of the solution's index tree: the XOR
+// of the hashes of the indices below it (split into collision-length
+// chunks), and the first (leftmost) of those indices.
+type equihashRow struct {
+	chunks []uint64
+	first  uint64
+}
+
+// VerifyEquihash checks that the header's Equihash solution is valid for
+// the parameters n and k, following zcashd's IsValidSolution(). This is
+// CPU intensive (2^k BLAKE2b hashes), so it's only done if requested.
+func (hdr *BlockHeader) VerifyEquihash(n, k int) error {
+	if n%8 != 0 || k < 1 || k >= n || n%(k+1) != 0 || n/(k+1) > 32 || 512/n < 1 {
+		return fmt.Errorf("invalid Equihash parameters (%d, %d)", n, k)
+	}
+	collisionBits := n / (k + 1)
+	numIndices := 1 << k
+	if numIndices*(collisionBits+1) != 8*len(hdr.Solution) {
+		return fmt.Errorf("solution size %d doesn't match Equihash parameters (%d, %d)",
+			len(hdr.Solution), n, k)
+	}
+	indicesPerHash := 512 / n
+
+	// The BLAKE2b state after hashing the header (minus the solution) is
+	// the same for every index, so compute it only once.
+	personal := make([]byte, blake2b.PersonalSize)
+	copy(personal, "ZcashPoW")
+	binary.LittleEndian.PutUint32(personal[8:], uint32(n))
+	binary.LittleEndian.PutUint32(personal[12:], uint32(k))
+	base, err := blake2b.New(indicesPerHash*n/8, personal)
+	if err != nil {
+		return err
+	}
+	serializedHeader, err := hdr.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	base.Write(serializedHeader[:serBlockHeaderMinusEquihashSize])
+
+	r := bytestring.NewBitReader(hdr.Solution[:])
+	seen := make(map[uint64]bool, numIndices)
+	rows := make([]equihashRow, numIndices)
+	for i := range rows {
+		index, _ := r.ReadBits(collisionBits + 1)
+		if seen[index] {
+			return errors.New("invalid Equihash solution: duplicate indices")
+		}
+		seen[index] = true
+
+		d := *base
+		var b4 [4]byte
+		binary.LittleEndian.PutUint32(b4[:], uint32(index)/uint32(indicesPerHash))
+		d.Write(b4[:])
+		start := int(index%uint64(indicesPerHash)) * n / 8
+		h := bytestring.NewBitReader(d.Sum(nil)[start : start+n/8])
+		rows[i].chunks = make([]uint64, k+1)
+		for j := range rows[i].chunks {
+			rows[i].chunks[j], _ = h.ReadBits(collisionBits)
+		}
+		rows[i].first = index
+	}
+
+	// Each round merges pairs of rows, which must collide on the next
+	// collisionBits bits of their hashes.
+	for round := 0; len(rows) > 1; round++ {
+		merged := make([]equihashRow, len(rows)/2)
+		for i := range merged {
+			a, b := rows[2*i], rows[2*i+1]
+			if a.chunks[round] != b.chunks[round] {
+				return errors.New("invalid Equihash solution: invalid collision")
+			}
+			if b.first < a.first {
+				return errors.New("invalid Equihash solution: index tree incorrectly ordered")
+			}
+			merged[i].chunks = make([]uint64, k+1)
+			for j := range merged[i].chunks {
+				merged[i].chunks[j] = a.chunks[j] ^ b.chunks[j]
+			}
+			merged[i].first = a.first
+		}
+		rows = merged
+	}
+	if rows[0].chunks[k] != 0 {
+		return errors.New("invalid Equihash solution: nonzero final hash")
+	}
+	return nil
+}
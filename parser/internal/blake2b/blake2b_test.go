@@ -0,0 +1,63 @@
+// Copyright (c) 2025 Juno Cash developers
+// Distributed under the MIT software license, see the accompanying
+// file COPYING or https://www.opensource.org/licenses/mit-license.php .
+
+package blake2b
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func sum(t *testing.T, size int, personal, data []byte) string {
+	d, err := New(size, personal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Write(data)
+	return hex.EncodeToString(d.Sum(nil))
+}
+
+func TestSum(t *testing.T) {
+	// RFC 7693 Appendix A, and the BLAKE2b-512 empty-string digest
+	if s := sum(t, 64, nil, []byte("abc")); s != "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923" {
+		t.Fatal("unexpected BLAKE2b-512(\"abc\")", s)
+	}
+	if s := sum(t, 64, nil, nil); s != "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce" {
+		t.Fatal("unexpected BLAKE2b-512(\"\")", s)
+	}
+	// An all-zero personalization is the same as none.
+	if sum(t, 32, make([]byte, 16), []byte("abc")) != sum(t, 32, nil, []byte("abc")) {
+		t.Fatal("zero personalization changed the digest")
+	}
+	if sum(t, 32, []byte("ZcashPoW"), []byte("abc")) == sum(t, 32, nil, []byte("abc")) {
+		t.Fatal("personalization didn't change the digest")
+	}
+}
+
+func TestWriteSplit(t *testing.T) {
+	// Writing in pieces, across block boundaries, and saving the
+	// intermediate state, must give the same result as a single write.
+	data := bytes.Repeat([]byte{1, 2, 3, 4, 5, 6, 7}, 100)
+	want := sum(t, 50, []byte("ZcashPoW"), data)
+	for _, split := range []int{0, 1, 127, 128, 129, 256, 699} {
+		d, _ := New(50, []byte("ZcashPoW"))
+		d.Write(data[:split])
+		saved := *d
+		d.Write(data[split:])
+		if hex.EncodeToString(d.Sum(nil)) != want {
+			t.Fatal("split write mismatch at", split)
+		}
+		saved.Write(data[split:])
+		if hex.EncodeToString(saved.Sum(nil)) != want {
+			t.Fatal("saved state mismatch at", split)
+		}
+	}
+	if _, err := New(65, nil); err == nil {
+		t.Fatal("New(65) unexpected success")
+	}
+	if _, err := New(32, make([]byte, 17)); err == nil {
+		t.Fatal("New() long personalization unexpected success")
+	}
+}
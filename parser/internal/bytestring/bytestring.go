@@ -7,6 +7,8 @@
 package bytestring
 
 import (
+	"encoding/hex"
+	"fmt"
 	"io"
 )
 
@@ -21,8 +23,28 @@ const (
 
 // String represents a string of bytes and provides methods for parsing values
 // from it.
+//
+// Methods that return slices (ReadBytes, ReadCompactLengthPrefixed) return
+// slices that alias the string's underlying data, so they are only valid as
+// long as the caller doesn't modify or reuse that data. Values that are
+// retained beyond parsing (stored in a parsed structure) should be read
+// using ReadBytesCopy instead.
 type String []byte
 
+// FromHex returns the String represented by the hex string h.
+func FromHex(h string) (String, error) {
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		return nil, err
+	}
+	return String(b), nil
+}
+
+// Hex returns the remaining (unread) bytes of the string, hex-encoded.
+func (s *String) Hex() string {
+	return hex.EncodeToString(*s)
+}
+
 // read advances the string by n bytes and returns them. If fewer than n bytes
 // remain, it returns nil.
 func (s *String) read(n int) []byte {
@@ -66,6 +88,16 @@ func (s *String) Skip(n int) bool {
 	return true
 }
 
+// SkipField advances the string by n bytes. If fewer than n bytes remain,
+// it returns an error that names the field being skipped, so that errors
+// from truncated data are formatted the same way everywhere.
+func (s *String) SkipField(n int, name string) error {
+	if !s.Skip(n) {
+		return fmt.Errorf("could not skip %s (need %d bytes, have %d)", name, n, len(*s))
+	}
+	return nil
+}
+
 // ReadByte reads a single byte into out and advances over it. It reports if
 // the read was successful.
 func (s *String) ReadByte(out *byte) bool {
@@ -78,7 +110,7 @@ func (s *String) ReadByte(out *byte) bool {
 }
 
 // ReadBytes reads n bytes into out and advances over them. It reports if the
-// read was successful.
+// read was successful. The result aliases the string's data.
 func (s *String) ReadBytes(out *[]byte, n int) bool {
 	v := s.read(n)
 	if v == nil {
@@ -88,6 +120,34 @@ func (s *String) ReadBytes(out *[]byte, n int) bool {
 	return true
 }
 
+// ReadBytesCopy is like ReadBytes, but out is a newly-allocated copy
+// of the bytes, so it remains valid if the string's data is modified.
+func (s *String) ReadBytesCopy(out *[]byte, n int) bool {
+	v := s.read(n)
+	if v == nil {
+		return false
+	}
+	*out = append([]byte(nil), v...)
+	return true
+}
+
+// ReadReverseBytes reads n bytes into out, in reverse order, and advances
+// over them. It reports if the read was successful. Unlike ReadBytes, out
+// is newly allocated (the string itself is not modified). This is useful for
+// converting a hash from (little-endian) wire order to big-endian display order.
+func (s *String) ReadReverseBytes(out *[]byte, n int) bool {
+	v := s.read(n)
+	if v == nil {
+		return false
+	}
+	r := make([]byte, n)
+	for i := range v {
+		r[n-1-i] = v[i]
+	}
+	*out = r
+	return true
+}
+
 // ReadCompactSize reads and interprets a Bitcoin-custom compact integer
 // encoding used for length-prefixing and count values. If the values fall
 // outside the expected canonical ranges, it returns false.
@@ -137,6 +197,21 @@ func (s *String) ReadCompactSize(size *int) bool {
 	return true
 }
 
+// ReadCompactSizeBounded is like ReadCompactSize, but it also fails if the
+// value is greater than max. Call sites that use the value to size an
+// allocation should use this, so that a corrupt count can't cause a huge
+// allocation.
+func (s *String) ReadCompactSizeBounded(out *int, max int) bool {
+	if !s.ReadCompactSize(out) {
+		return false
+	}
+	if *out > max {
+		*out = 0
+		return false
+	}
+	return true
+}
+
 // ReadCompactLengthPrefixed reads data prefixed by a CompactSize-encoded
 // length field into out. It reports whether the read was successful.
 func (s *String) ReadCompactLengthPrefixed(out *String) bool {
@@ -276,3 +351,44 @@ func (s *String) ReadScriptInt64(num *int64) bool {
 	*num = int64(number)
 	return true
 }
+
+// BitReader reads values that are packed at arbitrary bit boundaries, most
+// significant bit first, such as the indices in an Equihash solution.
+type BitReader struct {
+	s     String
+	cur   byte // partially-consumed byte
+	nbits int  // number of unread (low-order) bits in cur
+}
+
+// NewBitReader returns a BitReader over the bytes of s.
+func NewBitReader(s String) *BitReader {
+	return &BitReader{s: s}
+}
+
+// Remaining returns the number of unread bits.
+func (r *BitReader) Remaining() int {
+	return len(r.s)*8 + r.nbits
+}
+
+// ReadBits reads the next n bits (0 <= n <= 64), MSB-first, and returns them
+// as the low-order bits of the result. It reports whether the read was
+// successful; on failure nothing is consumed.
+func (r *BitReader) ReadBits(n int) (uint64, bool) {
+	if n < 0 || n > 64 || n > r.Remaining() {
+		return 0, false
+	}
+	var v uint64
+	for n > 0 {
+		if r.nbits == 0 {
+			r.cur = r.s[0]
+			r.s = r.s[1:]
+			r.nbits = 8
+		}
+		take := min(n, r.nbits)
+		bits := (r.cur >> (r.nbits - take)) & byte(1<<take-1)
+		v = v<<take | uint64(bits)
+		r.nbits -= take
+		n -= take
+	}
+	return v, true
+}
@@ -5,9 +5,34 @@ package bytestring
 
 import (
 	"bytes"
+	"encoding/hex"
 	"testing"
 )
 
+func TestFromHex(t *testing.T) {
+	s, err := FromHex("16212c")
+	if err != nil {
+		t.Fatal("FromHex() failed:", err)
+	}
+	if !bytes.Equal(s, []byte{22, 33, 44}) {
+		t.Fatal("miscompare after FromHex()")
+	}
+	if s.Hex() != "16212c" {
+		t.Fatal("Hex() unexpected value:", s.Hex())
+	}
+	// Hex() encodes only the unread part of the string
+	s.Skip(1)
+	if s.Hex() != "212c" {
+		t.Fatal("Hex() unexpected value after Skip():", s.Hex())
+	}
+	if _, err = FromHex("16212"); err == nil {
+		t.Fatal("FromHex() odd length unexpectedly succeeded")
+	}
+	if _, err = FromHex("zz"); err == nil {
+		t.Fatal("FromHex() invalid hex unexpectedly succeeded")
+	}
+}
+
 func TestString_read(t *testing.T) {
 	s := String{}
 	if !(s).Empty() {
@@ -138,6 +163,27 @@ func TestString_Skip(t *testing.T) {
 	}
 }
 
+func TestString_SkipField(t *testing.T) {
+	s := String{22, 33, 44}
+	if err := s.SkipField(2, "first"); err != nil {
+		t.Fatal("SkipField() failed:", err)
+	}
+	if len(s) != 1 {
+		t.Fatal("unexpected remaining length after SkipField()")
+	}
+	err := s.SkipField(2, "second")
+	if err == nil {
+		t.Fatal("SkipField() unexpectedly succeeded")
+	}
+	if err.Error() != "could not skip second (need 2 bytes, have 1)" {
+		t.Fatal("SkipField() unexpected error message:", err)
+	}
+	// a failed skip does not advance the string
+	if len(s) != 1 {
+		t.Fatal("SkipField() consumed bytes on failure")
+	}
+}
+
 func TestString_ReadByte(t *testing.T) {
 	s := String{22, 33}
 	var b byte
@@ -185,6 +231,56 @@ func TestString_ReadBytes(t *testing.T) {
 	}
 }
 
+func TestString_ReadBytesCopy(t *testing.T) {
+	s := String{22, 33, 44}
+	orig := s
+	var b []byte
+	if !s.ReadBytesCopy(&b, 2) {
+		t.Fatal("ReadBytesCopy() failed")
+	}
+	if !bytes.Equal(b, []byte{22, 33}) {
+		t.Fatal("miscompare after ReadBytesCopy()")
+	}
+	if len(s) != 1 {
+		t.Fatal("unexpected updated s following ReadBytesCopy()")
+	}
+	// modifying the original data must not affect the copy
+	orig[0] = 99
+	if b[0] != 22 {
+		t.Fatal("ReadBytesCopy() result aliases the string's data")
+	}
+	if s.ReadBytesCopy(&b, 2) {
+		t.Fatal("ReadBytesCopy() unexpected success")
+	}
+}
+
+func TestString_ReadReverseBytes(t *testing.T) {
+	// Zcash mainnet genesis block hash, wire (little-endian) order
+	s, _ := FromHex("08ce3d9731b000c08338455c8a4a6bd05da16e26b11daa1b917184ece80f0400" + "ff")
+	wire := append([]byte{}, s[:32]...)
+	var b []byte
+	if !s.ReadReverseBytes(&b, 32) {
+		t.Fatal("ReadReverseBytes() failed")
+	}
+	// display (big-endian) order
+	if hex.EncodeToString(b) != "00040fe8ec8471911baa1db1266ea15dd06b4a8a5c453883c000b031973dce08" {
+		t.Fatal("miscompare after ReadReverseBytes()")
+	}
+	if !bytes.Equal(s, []byte{0xff}) {
+		t.Fatal("unexpected updated s following ReadReverseBytes()")
+	}
+	// the underlying data must not have been reversed in place
+	s, _ = FromHex("08ce3d9731b000c08338455c8a4a6bd05da16e26b11daa1b917184ece80f0400")
+	orig := s
+	s.ReadReverseBytes(&b, 32)
+	if !bytes.Equal(orig, wire) {
+		t.Fatal("ReadReverseBytes() modified the string's data")
+	}
+	if s.ReadReverseBytes(&b, 1) {
+		t.Fatal("ReadReverseBytes() unexpected success")
+	}
+}
+
 // compact sizes are little-endian (least significant byte first, lower memory addr),
 // see https://en.bitcoin.it/wiki/Protocol_documentation#Variable_length_integer
 var readCompactSizeTests = []struct {
@@ -222,6 +318,34 @@ func TestString_ReadCompactSize(t *testing.T) {
 	}
 }
 
+var readCompactSizeBoundedTests = []struct {
+	s        String
+	max      int
+	ok       bool
+	expected int
+}{
+	/* 00 */ {String{}, 10, false, 0},
+	/* 01 */ {String{10}, 10, true, 10},
+	/* 02 */ {String{11}, 10, false, 0}, // > max
+	/* 03 */ {String{0}, 0, true, 0},
+	/* 04 */ {String{253, 0xff, 0xff}, 0xffff, true, 0xffff},
+	/* 05 */ {String{254, 0, 0, 1, 0}, 0xffff, false, 0}, // > max
+	/* 06 */ {String{254, 1, 0, 0, 2}, 0x7fffffff, false, 0}, // > maxCompactSize
+}
+
+func TestString_ReadCompactSizeBounded(t *testing.T) {
+	for i, tt := range readCompactSizeBoundedTests {
+		var expected int
+		ok := tt.s.ReadCompactSizeBounded(&expected, tt.max)
+		if ok != tt.ok {
+			t.Errorf("ReadCompactSizeBounded case %d: want: %v, have: %v", i, tt.ok, ok)
+		}
+		if expected != tt.expected {
+			t.Errorf("ReadCompactSizeBounded case %d: want: %v, have: %v", i, tt.expected, expected)
+		}
+	}
+}
+
 func TestString_ReadCompactLengthPrefixed(t *testing.T) {
 	// a stream of 3 bytes followed by 2 bytes into the value variable, v
 	s := String{3, 55, 66, 77, 2, 88, 99}
@@ -587,3 +711,64 @@ func TestString_ReadScriptInt64(t *testing.T) {
 		}
 	}
 }
+
+func TestBitReader_ReadBits(t *testing.T) {
+	r := NewBitReader(String{0xab, 0xcd, 0xef})
+	for _, tt := range []struct {
+		n    int
+		want uint64
+	}{
+		{4, 0xa},
+		{8, 0xbc},
+		{1, 1},
+		{3, 0x5},
+		{0, 0},
+		{8, 0xef},
+	} {
+		v, ok := r.ReadBits(tt.n)
+		if !ok {
+			t.Fatalf("ReadBits(%d) failed", tt.n)
+		}
+		if v != tt.want {
+			t.Fatalf("ReadBits(%d) = %#x, want %#x", tt.n, v, tt.want)
+		}
+	}
+	if r.Remaining() != 0 {
+		t.Fatal("unexpected bits remaining")
+	}
+	if _, ok := r.ReadBits(1); ok {
+		t.Fatal("ReadBits() unexpected success")
+	}
+
+	// Two 21-bit values (1 and 2), as packed in an Equihash solution,
+	// followed by 6 bits of padding.
+	r = NewBitReader(String{0x00, 0x00, 0x08, 0x00, 0x00, 0x80})
+	for _, want := range []uint64{1, 2} {
+		v, ok := r.ReadBits(21)
+		if !ok || v != want {
+			t.Fatalf("ReadBits(21) = %d, %v, want %d", v, ok, want)
+		}
+	}
+	if r.Remaining() != 6 {
+		t.Fatal("unexpected bits remaining")
+	}
+	// A failed read doesn't consume anything.
+	if _, ok := r.ReadBits(7); ok {
+		t.Fatal("ReadBits() unexpected success")
+	}
+	if v, ok := r.ReadBits(6); !ok || v != 0 {
+		t.Fatal("ReadBits(6) failed")
+	}
+
+	// A full 64-bit read.
+	r = NewBitReader(String{0xf0, 1, 2, 3, 4, 5, 6, 7, 8})
+	if v, ok := r.ReadBits(4); !ok || v != 0xf {
+		t.Fatal("ReadBits(4) failed")
+	}
+	if v, ok := r.ReadBits(64); !ok || v != 0x0010203040506070 {
+		t.Fatalf("ReadBits(64) = %#x", v)
+	}
+	if _, ok := r.ReadBits(65); ok {
+		t.Fatal("ReadBits(65) unexpected success")
+	}
+}
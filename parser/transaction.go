@@ -16,6 +16,29 @@ import (
 	"github.com/zcash/lightwalletd/walletrpc"
 )
 
+// Errors (see UnsupportedElementError) for transactions that contain
+// shielded elements that Juno Cash doesn't support.
+var (
+	ErrUnsupportedSapling = errors.New("Sapling not supported")
+	ErrUnsupportedSprout  = errors.New("Sprout not supported")
+)
+
+// UnsupportedElementError is returned when a transaction contains an
+// unsupported element; it wraps ErrUnsupportedSapling or ErrUnsupportedSprout,
+// so callers can classify it using errors.Is.
+type UnsupportedElementError struct {
+	Kind    error  // ErrUnsupportedSapling or ErrUnsupportedSprout
+	Element string // for example, "Sapling spends"
+}
+
+func (e *UnsupportedElementError) Error() string {
+	return "Juno Cash: " + e.Element + " not supported"
+}
+
+func (e *UnsupportedElementError) Unwrap() error {
+	return e.Kind
+}
+
 type rawTransaction struct {
 	fOverwintered      bool
 	version            uint32
@@ -25,8 +48,28 @@ type rawTransaction struct {
 	transparentOutputs []txOut
 	// Juno Cash: Orchard-only, no Sapling or Sprout support
 	orchardActions []action
+	// size of the serialized Orchard bundle (zero if there are no actions)
+	orchardBundleSize int
+	// Sapling or Sprout elements were skipped (tolerant parsing only)
+	hasUnsupported bool
 }
 
+const (
+	minTxInSize  = 32 + 4 + 1 + 4 // PrevTxHash, PrevTxOutIndex, empty ScriptSig, SequenceNumber
+	minTxOutSize = 8 + 1          // Value, empty Script
+
+	// cv, nullifier, rk, cmx, ephemeralKey, encCiphertext, outCiphertext
+	orchardActionSize = 32 + 32 + 32 + 32 + 32 + 580 + 80
+
+	// Sizes of the (unsupported) elements that are skipped by tolerant
+	// parsing; see the Zcash protocol specification, section 7.
+	saplingSpendV4Size  = 32 + 32 + 32 + 32 + 192 + 64  // cv, anchor, nullifier, rk, zkproof, spendAuthSig
+	saplingOutputV4Size = 32 + 32 + 32 + 580 + 80 + 192 // cv, cmu, ephemeralKey, encCiphertext, outCiphertext, zkproof
+	joinSplitV4Size     = 8 + 8 + 32 + 64 + 64 + 32 + 32 + 64 + 192 + 1202
+	saplingSpendV5Size  = 32 + 32 + 32            // cv, nullifier, rk
+	saplingOutputV5Size = 32 + 32 + 32 + 580 + 80 // cv, cmu, ephemeralKey, encCiphertext, outCiphertext
+)
+
 // Txin format as described in https://en.bitcoin.it/wiki/Transaction
 type txIn struct {
 	// SHA256d of a previous (to-be-used) transaction
@@ -53,9 +96,12 @@ func (tx *txIn) ParseFromSlice(data []byte) ([]byte, error) {
 		return nil, errors.New("could not skip PrevTxOutIndex")
 	}
 
-	if !s.ReadCompactLengthPrefixed((*bytestring.String)(&tx.ScriptSig)) {
+	var scriptSig bytestring.String
+	if !s.ReadCompactLengthPrefixed(&scriptSig) {
 		return nil, errors.New("could not read ScriptSig")
 	}
+	// Copy, so the retained script doesn't alias the caller's buffer.
+	tx.ScriptSig = append([]byte(nil), scriptSig...)
 
 	if !s.Skip(4) {
 		return nil, errors.New("could not skip SequenceNumber")
@@ -91,7 +137,7 @@ func (tx *txOut) ParseFromSlice(data []byte) ([]byte, error) {
 func (tx *Transaction) ParseTransparent(data []byte) ([]byte, error) {
 	s := bytestring.String(data)
 	var txInCount int
-	if !s.ReadCompactSize(&txInCount) {
+	if !s.ReadCompactSizeBounded(&txInCount, len(s)/minTxInSize) {
 		return nil, errors.New("could not read tx_in_count")
 	}
 	var err error
@@ -105,7 +151,7 @@ func (tx *Transaction) ParseTransparent(data []byte) ([]byte, error) {
 	}
 
 	var txOutCount int
-	if !s.ReadCompactSize(&txOutCount) {
+	if !s.ReadCompactSizeBounded(&txOutCount, len(s)/minTxOutSize) {
 		return nil, errors.New("could not read tx_out_count")
 	}
 	tx.transparentOutputs = make([]txOut, txOutCount)
@@ -136,19 +182,19 @@ func (a *action) ParseFromSlice(data []byte) ([]byte, error) {
 	if !s.Skip(32) {
 		return nil, errors.New("could not read action cv")
 	}
-	if !s.ReadBytes(&a.nullifier, 32) {
+	if !s.ReadBytesCopy(&a.nullifier, 32) {
 		return nil, errors.New("could not read action nullifier")
 	}
 	if !s.Skip(32) {
 		return nil, errors.New("could not read action rk")
 	}
-	if !s.ReadBytes(&a.cmx, 32) {
+	if !s.ReadBytesCopy(&a.cmx, 32) {
 		return nil, errors.New("could not read action cmx")
 	}
-	if !s.ReadBytes(&a.ephemeralKey, 32) {
+	if !s.ReadBytesCopy(&a.ephemeralKey, 32) {
 		return nil, errors.New("could not read action ephemeralKey")
 	}
-	if !s.ReadBytes(&a.encCiphertext, 580) {
+	if !s.ReadBytesCopy(&a.encCiphertext, 580) {
 		return nil, errors.New("could not read action encCiphertext")
 	}
 	if !s.Skip(80) {
@@ -171,6 +217,7 @@ type Transaction struct {
 	*rawTransaction
 	rawBytes []byte
 	txID     hash32.T // from getblock verbose=1
+	tolerant bool     // skip (rather than reject) Sapling and Sprout elements
 }
 
 func (tx *Transaction) SetTxID(txid hash32.T) {
@@ -187,11 +234,45 @@ func (tx *Transaction) GetEncodableHash() hash32.T {
 	return tx.txID
 }
 
-// Bytes returns a full transaction's raw bytes.
+// ComputeTxID returns the transaction's id (in little-endian wire order)
+// computed from its bytes, if that's supported: the ids of v4 transactions
+// are the double SHA-256 of the transaction; those of v5 transactions (ZIP
+// 244) aren't computed locally, so false is returned.
+func (tx *Transaction) ComputeTxID() (hash32.T, bool) {
+	if tx.version <= 4 {
+		return hash32.Sum256d(tx.rawBytes), true
+	}
+	return hash32.Nil, false
+}
+
+// knownTxID returns the transaction's id (in little-endian wire order), if
+// it's known: the ids of v4 transactions are computed locally (the double
+// SHA-256 of the transaction), those of later versions must have been set.
+func (tx *Transaction) knownTxID() (hash32.T, bool) {
+	if txid, ok := tx.ComputeTxID(); ok {
+		return txid, true
+	}
+	return tx.txID, tx.txID != hash32.Nil
+}
+
+// Bytes returns a full transaction's raw bytes. Note that this slice
+// aliases the data that was passed to ParseFromSlice.
 func (tx *Transaction) Bytes() []byte {
 	return tx.rawBytes
 }
 
+// SerializedSize returns the size of the serialized transaction in bytes.
+func (tx *Transaction) SerializedSize() int {
+	return len(tx.rawBytes)
+}
+
+// OrchardBundleSize returns the number of bytes of the serialized
+// transaction that are its Orchard bundle: the actions, which are a fixed
+// size, plus the proof, signatures, and other bundle fields.
+func (tx *Transaction) OrchardBundleSize() int {
+	return tx.orchardBundleSize
+}
+
 // HasShieldedElements indicates whether a transaction has
 // at least one shielded (Orchard) input or output.
 // Juno Cash: Only Orchard is supported.
@@ -210,6 +291,12 @@ func (tx *Transaction) OrchardActionsCount() int {
 	return len(tx.orchardActions)
 }
 
+// HasUnsupportedElements indicates whether the transaction has Sapling or
+// Sprout elements, which were skipped by ParseFromSliceTolerant.
+func (tx *Transaction) HasUnsupportedElements() bool {
+	return tx.hasUnsupported
+}
+
 // ToCompact converts the given (full) transaction to compact format.
 // Juno Cash: Only Orchard actions are populated (no Sapling).
 func (tx *Transaction) ToCompact(index int) *walletrpc.CompactTx {
@@ -238,41 +325,64 @@ func (tx *Transaction) parseV4(data []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	if !s.Skip(4) {
-		return nil, errors.New("could not skip nLockTime")
+	if err = s.SkipField(4, "nLockTime"); err != nil {
+		return nil, err
 	}
 
-	if !s.Skip(4) {
-		return nil, errors.New("could not skip nExpiryHeight")
+	if err = s.SkipField(4, "nExpiryHeight"); err != nil {
+		return nil, err
 	}
 
 	var spendCount, outputCount int
 
-	if !s.Skip(8) {
-		return nil, errors.New("could not skip valueBalance")
+	if err = s.SkipField(8, "valueBalance"); err != nil {
+		return nil, err
 	}
 	if !s.ReadCompactSize(&spendCount) {
 		return nil, errors.New("could not read nShieldedSpend")
 	}
 	// Juno Cash: Sapling spends not allowed
-	if spendCount > 0 {
-		return nil, errors.New("Juno Cash: Sapling spends not supported")
+	if spendCount > 0 && !tx.tolerant {
+		return nil, &UnsupportedElementError{ErrUnsupportedSapling, "Sapling spends"}
+	}
+	if err = s.SkipField(spendCount*saplingSpendV4Size, "vShieldedSpend"); err != nil {
+		return nil, err
 	}
 	if !s.ReadCompactSize(&outputCount) {
 		return nil, errors.New("could not read nShieldedOutput")
 	}
 	// Juno Cash: Sapling outputs not allowed
-	if outputCount > 0 {
-		return nil, errors.New("Juno Cash: Sapling outputs not supported")
+	if outputCount > 0 && !tx.tolerant {
+		return nil, &UnsupportedElementError{ErrUnsupportedSapling, "Sapling outputs"}
+	}
+	if err = s.SkipField(outputCount*saplingOutputV4Size, "vShieldedOutput"); err != nil {
+		return nil, err
 	}
 	var joinSplitCount int
 	if !s.ReadCompactSize(&joinSplitCount) {
 		return nil, errors.New("could not read nJoinSplit")
 	}
 	// Juno Cash: JoinSplits (Sprout) not allowed
+	if joinSplitCount > 0 && !tx.tolerant {
+		return nil, &UnsupportedElementError{ErrUnsupportedSprout, "JoinSplits (Sprout)"}
+	}
 	if joinSplitCount > 0 {
-		return nil, errors.New("Juno Cash: JoinSplits (Sprout) not supported")
+		if err = s.SkipField(joinSplitCount*joinSplitV4Size, "vJoinSplit"); err != nil {
+			return nil, err
+		}
+		if err = s.SkipField(32, "joinSplitPubKey"); err != nil {
+			return nil, err
+		}
+		if err = s.SkipField(64, "joinSplitSig"); err != nil {
+			return nil, err
+		}
+	}
+	if spendCount+outputCount > 0 {
+		if err = s.SkipField(64, "bindingSigSapling"); err != nil {
+			return nil, err
+		}
 	}
+	tx.hasUnsupported = spendCount+outputCount+joinSplitCount > 0
 	return s, nil
 }
 
@@ -287,11 +397,11 @@ func (tx *Transaction) parseV5(data []byte) ([]byte, error) {
 	if tx.nVersionGroupID != 0x26A7270A {
 		return nil, errors.New(fmt.Sprintf("version group ID %d must be 0x26A7270A", tx.nVersionGroupID))
 	}
-	if !s.Skip(4) {
-		return nil, errors.New("could not skip nLockTime")
+	if err = s.SkipField(4, "nLockTime"); err != nil {
+		return nil, err
 	}
-	if !s.Skip(4) {
-		return nil, errors.New("could not skip nExpiryHeight")
+	if err = s.SkipField(4, "nExpiryHeight"); err != nil {
+		return nil, err
 	}
 	s, err = tx.ParseTransparent([]byte(s))
 	if err != nil {
@@ -303,24 +413,51 @@ func (tx *Transaction) parseV5(data []byte) ([]byte, error) {
 		return nil, errors.New("could not read nShieldedSpend")
 	}
 	// Juno Cash: Sapling spends not allowed
-	if spendCount > 0 {
-		return nil, errors.New("Juno Cash: Sapling spends not supported")
+	if spendCount > 0 && !tx.tolerant {
+		return nil, &UnsupportedElementError{ErrUnsupportedSapling, "Sapling spends"}
+	}
+	if err = s.SkipField(spendCount*saplingSpendV5Size, "vSpendsSapling"); err != nil {
+		return nil, err
 	}
 	if !s.ReadCompactSize(&outputCount) {
 		return nil, errors.New("could not read nShieldedOutput")
 	}
 	// Juno Cash: Sapling outputs not allowed
-	if outputCount > 0 {
-		return nil, errors.New("Juno Cash: Sapling outputs not supported")
+	if outputCount > 0 && !tx.tolerant {
+		return nil, &UnsupportedElementError{ErrUnsupportedSapling, "Sapling outputs"}
+	}
+	if err = s.SkipField(outputCount*saplingOutputV5Size, "vOutputsSapling"); err != nil {
+		return nil, err
+	}
+	if spendCount+outputCount > 0 {
+		if err = s.SkipField(8, "valueBalanceSapling"); err != nil {
+			return nil, err
+		}
+		if spendCount > 0 {
+			if err = s.SkipField(32, "anchorSapling"); err != nil {
+				return nil, err
+			}
+		}
+		if err = s.SkipField(192*spendCount, "vSpendProofsSapling"); err != nil {
+			return nil, err
+		}
+		if err = s.SkipField(64*spendCount, "vSpendAuthSigsSapling"); err != nil {
+			return nil, err
+		}
+		if err = s.SkipField(192*outputCount, "vOutputProofsSapling"); err != nil {
+			return nil, err
+		}
+		if err = s.SkipField(64, "bindingSigSapling"); err != nil {
+			return nil, err
+		}
+		tx.hasUnsupported = true
 	}
 
 	// Parse Orchard actions
+	orchardStart := len(s)
 	var actionsCount int
-	if !s.ReadCompactSize(&actionsCount) {
-		return nil, errors.New("could not read nActionsOrchard")
-	}
-	if actionsCount >= (1 << 16) {
-		return nil, errors.New(fmt.Sprintf("actionsCount (%d) must be less than 2^16", actionsCount))
+	if !s.ReadCompactSizeBounded(&actionsCount, (1<<16)-1) {
+		return nil, errors.New("could not read nActionsOrchard (must be less than 2^16)")
 	}
 	tx.orchardActions = make([]action, actionsCount)
 	for i := 0; i < actionsCount; i++ {
@@ -331,28 +468,29 @@ func (tx *Transaction) parseV5(data []byte) ([]byte, error) {
 		}
 	}
 	if actionsCount > 0 {
-		if !s.Skip(1) {
-			return nil, errors.New("could not skip flagsOrchard")
+		if err = s.SkipField(1, "flagsOrchard"); err != nil {
+			return nil, err
 		}
-		if !s.Skip(8) {
-			return nil, errors.New("could not skip valueBalanceOrchard")
+		if err = s.SkipField(8, "valueBalanceOrchard"); err != nil {
+			return nil, err
 		}
-		if !s.Skip(32) {
-			return nil, errors.New("could not skip anchorOrchard")
+		if err = s.SkipField(32, "anchorOrchard"); err != nil {
+			return nil, err
 		}
 		var proofsCount int
 		if !s.ReadCompactSize(&proofsCount) {
 			return nil, errors.New("could not read sizeProofsOrchard")
 		}
-		if !s.Skip(proofsCount) {
-			return nil, errors.New("could not skip proofsOrchard")
+		if err = s.SkipField(proofsCount, "proofsOrchard"); err != nil {
+			return nil, err
 		}
-		if !s.Skip(64 * actionsCount) {
-			return nil, errors.New("could not skip vSpendAuthSigsOrchard")
+		if err = s.SkipField(64*actionsCount, "vSpendAuthSigsOrchard"); err != nil {
+			return nil, err
 		}
-		if !s.Skip(64) {
-			return nil, errors.New("could not skip bindingSigOrchard")
+		if err = s.SkipField(64, "bindingSigOrchard"); err != nil {
+			return nil, err
 		}
+		tx.orchardBundleSize = orchardStart - len(s)
 	}
 	return s, nil
 }
@@ -397,6 +535,14 @@ func (tx *Transaction) ParseFromSlice(data []byte) ([]byte, error) {
 	return []byte(s), nil
 }
 
+// ParseFromSliceTolerant is like ParseFromSlice, but it skips over Sapling
+// and Sprout elements (see HasUnsupportedElements) instead of failing.
+func (tx *Transaction) ParseFromSliceTolerant(data []byte) ([]byte, error) {
+	tx.tolerant = true
+	defer func() { tx.tolerant = false }()
+	return tx.ParseFromSlice(data)
+}
+
 // NewTransaction is the constructor for a full transaction.
 func NewTransaction() *Transaction {
 	return &Transaction{
@@ -7,10 +7,14 @@
 package parser
 
 import (
-	"encoding/hex"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/zcash/lightwalletd/parser/internal/bytestring"
 )
 
 // Some of these values may be "null" (which translates to nil in Go) in
@@ -84,7 +88,10 @@ func TestV5TransactionParser(t *testing.T) {
 			continue
 		}
 
-		rawTxData, _ := hex.DecodeString(txtestdata.Tx)
+		rawTxData, err := bytestring.FromHex(txtestdata.Tx)
+		if err != nil {
+			t.Fatal(err)
+		}
 
 		tx := NewTransaction()
 		rest, err := tx.ParseFromSlice(rawTxData)
@@ -121,3 +128,91 @@ func TestV5TransactionParser(t *testing.T) {
 		}
 	}
 }
+
+// The parsed transaction's retained fields must not alias the input buffer,
+// so that the caller can reuse (modify) the buffer after parsing.
+func TestTransactionNoAlias(t *testing.T) {
+	s, err := os.ReadFile("../testdata/tx_v5.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var testdata []json.RawMessage
+	err = json.Unmarshal(s, &testdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, onetx := range testdata[2:] {
+		var txtestdata TxTestData
+		err = json.Unmarshal(onetx, &txtestdata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if txtestdata.NSpendsSapling > 0 || txtestdata.NoutputsSapling > 0 ||
+			txtestdata.NActionsOrchard == 0 {
+			continue
+		}
+		rawTxData, err := bytestring.FromHex(txtestdata.Tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx := NewTransaction()
+		_, err = tx.ParseFromSlice(rawTxData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Save copies of the parsed fields, then scribble over the input.
+		saved := make([]action, len(tx.orchardActions))
+		for i, a := range tx.orchardActions {
+			saved[i] = action{
+				nullifier:     bytes.Clone(a.nullifier),
+				cmx:           bytes.Clone(a.cmx),
+				ephemeralKey:  bytes.Clone(a.ephemeralKey),
+				encCiphertext: bytes.Clone(a.encCiphertext),
+			}
+		}
+		for i := range rawTxData {
+			rawTxData[i] = ^rawTxData[i]
+		}
+		for i, a := range tx.orchardActions {
+			if !bytes.Equal(a.nullifier, saved[i].nullifier) ||
+				!bytes.Equal(a.cmx, saved[i].cmx) ||
+				!bytes.Equal(a.ephemeralKey, saved[i].ephemeralKey) ||
+				!bytes.Equal(a.encCiphertext, saved[i].encCiphertext) {
+				t.Fatalf("txid %s action %d changed when input was modified", txtestdata.Txid, i)
+			}
+		}
+		return
+	}
+	t.Fatal("no Orchard-only test transaction found")
+}
+
+func TestUnsupportedElementError(t *testing.T) {
+	s, err := os.ReadFile("../testdata/tx_v5.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var testdata []json.RawMessage
+	err = json.Unmarshal(s, &testdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, onetx := range testdata[2:] {
+		var txtestdata TxTestData
+		err = json.Unmarshal(onetx, &txtestdata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if txtestdata.NSpendsSapling == 0 && txtestdata.NoutputsSapling == 0 {
+			continue
+		}
+		rawTxData, _ := bytestring.FromHex(txtestdata.Tx)
+		_, err = NewTransaction().ParseFromSlice(rawTxData)
+		if !errors.Is(err, ErrUnsupportedSapling) || errors.Is(err, ErrUnsupportedSprout) {
+			t.Fatalf("txid %s: unexpected error %v", txtestdata.Txid, err)
+		}
+		var uerr *UnsupportedElementError
+		if !errors.As(err, &uerr) || !strings.HasPrefix(uerr.Element, "Sapling") {
+			t.Fatalf("txid %s: unexpected error %v", txtestdata.Txid, err)
+		}
+	}
+}
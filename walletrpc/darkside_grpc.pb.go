@@ -28,9 +28,17 @@ const (
 	DarksideStreamer_StageBlocksStream_FullMethodName         = "/cash.z.wallet.sdk.rpc.DarksideStreamer/StageBlocksStream"
 	DarksideStreamer_StageBlocks_FullMethodName               = "/cash.z.wallet.sdk.rpc.DarksideStreamer/StageBlocks"
 	DarksideStreamer_StageBlocksCreate_FullMethodName         = "/cash.z.wallet.sdk.rpc.DarksideStreamer/StageBlocksCreate"
+	DarksideStreamer_StageBlockWithTime_FullMethodName        = "/cash.z.wallet.sdk.rpc.DarksideStreamer/StageBlockWithTime"
 	DarksideStreamer_StageTransactionsStream_FullMethodName   = "/cash.z.wallet.sdk.rpc.DarksideStreamer/StageTransactionsStream"
 	DarksideStreamer_StageTransactions_FullMethodName         = "/cash.z.wallet.sdk.rpc.DarksideStreamer/StageTransactions"
+	DarksideStreamer_StageTxWithID_FullMethodName             = "/cash.z.wallet.sdk.rpc.DarksideStreamer/StageTxWithID"
+	DarksideStreamer_StageMempoolTx_FullMethodName            = "/cash.z.wallet.sdk.rpc.DarksideStreamer/StageMempoolTx"
 	DarksideStreamer_ApplyStaged_FullMethodName               = "/cash.z.wallet.sdk.rpc.DarksideStreamer/ApplyStaged"
+	DarksideStreamer_Reorg_FullMethodName                     = "/cash.z.wallet.sdk.rpc.DarksideStreamer/Reorg"
+	DarksideStreamer_StageBranch_FullMethodName               = "/cash.z.wallet.sdk.rpc.DarksideStreamer/StageBranch"
+	DarksideStreamer_ActivateBranch_FullMethodName            = "/cash.z.wallet.sdk.rpc.DarksideStreamer/ActivateBranch"
+	DarksideStreamer_StageGap_FullMethodName                  = "/cash.z.wallet.sdk.rpc.DarksideStreamer/StageGap"
+	DarksideStreamer_GetStats_FullMethodName                  = "/cash.z.wallet.sdk.rpc.DarksideStreamer/GetStats"
 	DarksideStreamer_GetIncomingTransactions_FullMethodName   = "/cash.z.wallet.sdk.rpc.DarksideStreamer/GetIncomingTransactions"
 	DarksideStreamer_ClearIncomingTransactions_FullMethodName = "/cash.z.wallet.sdk.rpc.DarksideStreamer/ClearIncomingTransactions"
 	DarksideStreamer_AddAddressUtxo_FullMethodName            = "/cash.z.wallet.sdk.rpc.DarksideStreamer/AddAddressUtxo"
@@ -39,6 +47,8 @@ const (
 	DarksideStreamer_RemoveTreeState_FullMethodName           = "/cash.z.wallet.sdk.rpc.DarksideStreamer/RemoveTreeState"
 	DarksideStreamer_ClearAllTreeStates_FullMethodName        = "/cash.z.wallet.sdk.rpc.DarksideStreamer/ClearAllTreeStates"
 	DarksideStreamer_SetSubtreeRoots_FullMethodName           = "/cash.z.wallet.sdk.rpc.DarksideStreamer/SetSubtreeRoots"
+	DarksideStreamer_SetLatency_FullMethodName                = "/cash.z.wallet.sdk.rpc.DarksideStreamer/SetLatency"
+	DarksideStreamer_SetErrorRate_FullMethodName              = "/cash.z.wallet.sdk.rpc.DarksideStreamer/SetErrorRate"
 	DarksideStreamer_Stop_FullMethodName                      = "/cash.z.wallet.sdk.rpc.DarksideStreamer/Stop"
 )
 
@@ -67,6 +77,11 @@ type DarksideStreamerClient interface {
 	// lets you create identical blocks (same transactions and height), but with
 	// different hashes.
 	StageBlocksCreate(ctx context.Context, in *DarksideEmptyBlocks, opts ...grpc.CallOption) (*Empty, error)
+	// StageBlockWithTime sets the header timestamp and nBits of the block
+	// staged (by one of the previous three) at the given height, for testing
+	// time- and difficulty-dependent wallet logic. The values are arbitrary;
+	// they're not checked against the neighbouring blocks.
+	StageBlockWithTime(ctx context.Context, in *DarksideBlockTime, opts ...grpc.CallOption) (*Empty, error)
 	// StageTransactionsStream stores the given transaction-height pairs in the
 	// staging area until ApplyStaged() is called. Note that these transactions
 	// are not returned by the production GetTransaction() gRPC until they
@@ -77,6 +92,17 @@ type DarksideStreamerClient interface {
 	// the given url. They are all staged into the block at the given height.
 	// Staging transactions to different heights requires multiple calls.
 	StageTransactions(ctx context.Context, in *DarksideTransactionsURL, opts ...grpc.CallOption) (*Empty, error)
+	// StageTxWithID is like StageTransactionsStream for a single
+	// transaction, except that the mock zcashd reports the given txid for
+	// it (in getblock, getrawtransaction, and so on) rather than computing
+	// one, so that wallet tests are reproducible and can use real V5 txids.
+	// If lightwalletd can compute the transaction's id (V4), the given txid
+	// must match it.
+	StageTxWithID(ctx context.Context, in *DarksideTxWithID, opts ...grpc.CallOption) (*Empty, error)
+	// StageMempoolTx adds the given transaction to the mock zcashd's mempool
+	// (as returned by GetMempoolTx and GetMempoolStream); it stays there until
+	// ApplyStaged() makes a block containing it active.
+	StageMempoolTx(ctx context.Context, in *RawTransaction, opts ...grpc.CallOption) (*Empty, error)
 	// ApplyStaged iterates the list of blocks that were staged by the
 	// StageBlocks*() gRPCs, in the order they were staged, and "merges" each
 	// into the active, working blocks list that the mock zcashd is presenting
@@ -94,6 +120,31 @@ type DarksideStreamerClient interface {
 	// also be used to simply advance the latest block height presented by mock
 	// zcashd. That is, there doesn't need to be anything in the staging area.
 	ApplyStaged(ctx context.Context, in *DarksideHeight, opts ...grpc.CallOption) (*Empty, error)
+	// Reorg rewinds the active blocks to just below the given height, so that
+	// the blocks at and above it are dropped, then applies the staging area
+	// (see ApplyStaged) as the new branch. The latest block height reported by
+	// mock zcashd becomes the tip of the new branch. This makes lightwalletd
+	// reorg, the same as a real reorg; if nothing is staged, the chain simply
+	// becomes shorter.
+	Reorg(ctx context.Context, in *DarksideHeight, opts ...grpc.CallOption) (*Empty, error)
+	// StageBranch saves the given blocks as a named candidate branch (a
+	// competing fork), replacing any branch with that name. Branches are
+	// kept (until Reset) so that a test can switch between them.
+	StageBranch(ctx context.Context, in *DarksideBranch, opts ...grpc.CallOption) (*Empty, error)
+	// ActivateBranch makes the named branch (its blocks are ignored here)
+	// the active chain from its first block's height up, like Reorg, so
+	// that forks of any depth can be simulated, for example to test
+	// lightwalletd's --max-reorg-depth.
+	ActivateBranch(ctx context.Context, in *DarksideBranch, opts ...grpc.CallOption) (*Empty, error)
+	// StageGap makes the mock zcashd fail to serve the active block at the
+	// given height (getblock returns an error, as for a block the node
+	// doesn't have), leaving a gap in the chain, to test how lightwalletd
+	// and wallets handle a height that can't be served. It takes effect at
+	// once, and lasts until the block is replaced (by staging and applying
+	// a block at that height).
+	StageGap(ctx context.Context, in *DarksideHeight, opts ...grpc.CallOption) (*Empty, error)
+	// GetStats returns counts of what darksidewalletd has done since the last Reset.
+	GetStats(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*DarksideStats, error)
 	// Calls to the production gRPC SendTransaction() store the transaction in
 	// a separate area (not the staging area); this method returns all transactions
 	// in this separate area, which is then cleared. The height returned
@@ -118,6 +169,16 @@ type DarksideStreamerClient interface {
 	// Sets the subtree roots cache (for GetSubtreeRoots),
 	// replacing any existing entries
 	SetSubtreeRoots(ctx context.Context, in *DarksideSubtreeRoots, opts ...grpc.CallOption) (*Empty, error)
+	// SetLatency delays each reply from the mock zcashd to lightwalletd by
+	// the given time (0 to reply at once), to simulate a slow node, for
+	// example to test lightwalletd's --rpc-timeout.
+	SetLatency(ctx context.Context, in *DarksideLatency, opts ...grpc.CallOption) (*Empty, error)
+	// SetErrorRate makes the given fraction (0 to 1) of lightwalletd's
+	// requests to the mock zcashd fail, to test lightwalletd's retries and
+	// backoff. The failures are evenly spread (with rate 0.25, every fourth
+	// request fails), so tests are deterministic. Reset sets both this and
+	// the latency back to 0.
+	SetErrorRate(ctx context.Context, in *DarksideErrorRate, opts ...grpc.CallOption) (*Empty, error)
 	// Stop causes the server to shut down cleanly.
 	Stop(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error)
 }
@@ -191,6 +252,15 @@ func (c *darksideStreamerClient) StageBlocksCreate(ctx context.Context, in *Dark
 	return out, nil
 }
 
+func (c *darksideStreamerClient) StageBlockWithTime(ctx context.Context, in *DarksideBlockTime, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, DarksideStreamer_StageBlockWithTime_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *darksideStreamerClient) StageTransactionsStream(ctx context.Context, opts ...grpc.CallOption) (DarksideStreamer_StageTransactionsStreamClient, error) {
 	stream, err := c.cc.NewStream(ctx, &DarksideStreamer_ServiceDesc.Streams[1], DarksideStreamer_StageTransactionsStream_FullMethodName, opts...)
 	if err != nil {
@@ -234,6 +304,24 @@ func (c *darksideStreamerClient) StageTransactions(ctx context.Context, in *Dark
 	return out, nil
 }
 
+func (c *darksideStreamerClient) StageTxWithID(ctx context.Context, in *DarksideTxWithID, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, DarksideStreamer_StageTxWithID_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *darksideStreamerClient) StageMempoolTx(ctx context.Context, in *RawTransaction, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, DarksideStreamer_StageMempoolTx_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *darksideStreamerClient) ApplyStaged(ctx context.Context, in *DarksideHeight, opts ...grpc.CallOption) (*Empty, error) {
 	out := new(Empty)
 	err := c.cc.Invoke(ctx, DarksideStreamer_ApplyStaged_FullMethodName, in, out, opts...)
@@ -243,6 +331,51 @@ func (c *darksideStreamerClient) ApplyStaged(ctx context.Context, in *DarksideHe
 	return out, nil
 }
 
+func (c *darksideStreamerClient) Reorg(ctx context.Context, in *DarksideHeight, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, DarksideStreamer_Reorg_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *darksideStreamerClient) StageBranch(ctx context.Context, in *DarksideBranch, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, DarksideStreamer_StageBranch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *darksideStreamerClient) ActivateBranch(ctx context.Context, in *DarksideBranch, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, DarksideStreamer_ActivateBranch_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *darksideStreamerClient) StageGap(ctx context.Context, in *DarksideHeight, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, DarksideStreamer_StageGap_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *darksideStreamerClient) GetStats(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*DarksideStats, error) {
+	out := new(DarksideStats)
+	err := c.cc.Invoke(ctx, DarksideStreamer_GetStats_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *darksideStreamerClient) GetIncomingTransactions(ctx context.Context, in *Empty, opts ...grpc.CallOption) (DarksideStreamer_GetIncomingTransactionsClient, error) {
 	stream, err := c.cc.NewStream(ctx, &DarksideStreamer_ServiceDesc.Streams[2], DarksideStreamer_GetIncomingTransactions_FullMethodName, opts...)
 	if err != nil {
@@ -338,6 +471,24 @@ func (c *darksideStreamerClient) SetSubtreeRoots(ctx context.Context, in *Darksi
 	return out, nil
 }
 
+func (c *darksideStreamerClient) SetLatency(ctx context.Context, in *DarksideLatency, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, DarksideStreamer_SetLatency_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *darksideStreamerClient) SetErrorRate(ctx context.Context, in *DarksideErrorRate, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, DarksideStreamer_SetErrorRate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *darksideStreamerClient) Stop(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error) {
 	out := new(Empty)
 	err := c.cc.Invoke(ctx, DarksideStreamer_Stop_FullMethodName, in, out, opts...)
@@ -372,6 +523,11 @@ type DarksideStreamerServer interface {
 	// lets you create identical blocks (same transactions and height), but with
 	// different hashes.
 	StageBlocksCreate(context.Context, *DarksideEmptyBlocks) (*Empty, error)
+	// StageBlockWithTime sets the header timestamp and nBits of the block
+	// staged (by one of the previous three) at the given height, for testing
+	// time- and difficulty-dependent wallet logic. The values are arbitrary;
+	// they're not checked against the neighbouring blocks.
+	StageBlockWithTime(context.Context, *DarksideBlockTime) (*Empty, error)
 	// StageTransactionsStream stores the given transaction-height pairs in the
 	// staging area until ApplyStaged() is called. Note that these transactions
 	// are not returned by the production GetTransaction() gRPC until they
@@ -382,6 +538,17 @@ type DarksideStreamerServer interface {
 	// the given url. They are all staged into the block at the given height.
 	// Staging transactions to different heights requires multiple calls.
 	StageTransactions(context.Context, *DarksideTransactionsURL) (*Empty, error)
+	// StageTxWithID is like StageTransactionsStream for a single
+	// transaction, except that the mock zcashd reports the given txid for
+	// it (in getblock, getrawtransaction, and so on) rather than computing
+	// one, so that wallet tests are reproducible and can use real V5 txids.
+	// If lightwalletd can compute the transaction's id (V4), the given txid
+	// must match it.
+	StageTxWithID(context.Context, *DarksideTxWithID) (*Empty, error)
+	// StageMempoolTx adds the given transaction to the mock zcashd's mempool
+	// (as returned by GetMempoolTx and GetMempoolStream); it stays there until
+	// ApplyStaged() makes a block containing it active.
+	StageMempoolTx(context.Context, *RawTransaction) (*Empty, error)
 	// ApplyStaged iterates the list of blocks that were staged by the
 	// StageBlocks*() gRPCs, in the order they were staged, and "merges" each
 	// into the active, working blocks list that the mock zcashd is presenting
@@ -399,6 +566,31 @@ type DarksideStreamerServer interface {
 	// also be used to simply advance the latest block height presented by mock
 	// zcashd. That is, there doesn't need to be anything in the staging area.
 	ApplyStaged(context.Context, *DarksideHeight) (*Empty, error)
+	// Reorg rewinds the active blocks to just below the given height, so that
+	// the blocks at and above it are dropped, then applies the staging area
+	// (see ApplyStaged) as the new branch. The latest block height reported by
+	// mock zcashd becomes the tip of the new branch. This makes lightwalletd
+	// reorg, the same as a real reorg; if nothing is staged, the chain simply
+	// becomes shorter.
+	Reorg(context.Context, *DarksideHeight) (*Empty, error)
+	// StageBranch saves the given blocks as a named candidate branch (a
+	// competing fork), replacing any branch with that name. Branches are
+	// kept (until Reset) so that a test can switch between them.
+	StageBranch(context.Context, *DarksideBranch) (*Empty, error)
+	// ActivateBranch makes the named branch (its blocks are ignored here)
+	// the active chain from its first block's height up, like Reorg, so
+	// that forks of any depth can be simulated, for example to test
+	// lightwalletd's --max-reorg-depth.
+	ActivateBranch(context.Context, *DarksideBranch) (*Empty, error)
+	// StageGap makes the mock zcashd fail to serve the active block at the
+	// given height (getblock returns an error, as for a block the node
+	// doesn't have), leaving a gap in the chain, to test how lightwalletd
+	// and wallets handle a height that can't be served. It takes effect at
+	// once, and lasts until the block is replaced (by staging and applying
+	// a block at that height).
+	StageGap(context.Context, *DarksideHeight) (*Empty, error)
+	// GetStats returns counts of what darksidewalletd has done since the last Reset.
+	GetStats(context.Context, *Empty) (*DarksideStats, error)
 	// Calls to the production gRPC SendTransaction() store the transaction in
 	// a separate area (not the staging area); this method returns all transactions
 	// in this separate area, which is then cleared. The height returned
@@ -423,6 +615,16 @@ type DarksideStreamerServer interface {
 	// Sets the subtree roots cache (for GetSubtreeRoots),
 	// replacing any existing entries
 	SetSubtreeRoots(context.Context, *DarksideSubtreeRoots) (*Empty, error)
+	// SetLatency delays each reply from the mock zcashd to lightwalletd by
+	// the given time (0 to reply at once), to simulate a slow node, for
+	// example to test lightwalletd's --rpc-timeout.
+	SetLatency(context.Context, *DarksideLatency) (*Empty, error)
+	// SetErrorRate makes the given fraction (0 to 1) of lightwalletd's
+	// requests to the mock zcashd fail, to test lightwalletd's retries and
+	// backoff. The failures are evenly spread (with rate 0.25, every fourth
+	// request fails), so tests are deterministic. Reset sets both this and
+	// the latency back to 0.
+	SetErrorRate(context.Context, *DarksideErrorRate) (*Empty, error)
 	// Stop causes the server to shut down cleanly.
 	Stop(context.Context, *Empty) (*Empty, error)
 	mustEmbedUnimplementedDarksideStreamerServer()
@@ -444,15 +646,39 @@ func (UnimplementedDarksideStreamerServer) StageBlocks(context.Context, *Darksid
 func (UnimplementedDarksideStreamerServer) StageBlocksCreate(context.Context, *DarksideEmptyBlocks) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method StageBlocksCreate not implemented")
 }
+func (UnimplementedDarksideStreamerServer) StageBlockWithTime(context.Context, *DarksideBlockTime) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StageBlockWithTime not implemented")
+}
 func (UnimplementedDarksideStreamerServer) StageTransactionsStream(DarksideStreamer_StageTransactionsStreamServer) error {
 	return status.Errorf(codes.Unimplemented, "method StageTransactionsStream not implemented")
 }
 func (UnimplementedDarksideStreamerServer) StageTransactions(context.Context, *DarksideTransactionsURL) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method StageTransactions not implemented")
 }
+func (UnimplementedDarksideStreamerServer) StageTxWithID(context.Context, *DarksideTxWithID) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StageTxWithID not implemented")
+}
+func (UnimplementedDarksideStreamerServer) StageMempoolTx(context.Context, *RawTransaction) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StageMempoolTx not implemented")
+}
 func (UnimplementedDarksideStreamerServer) ApplyStaged(context.Context, *DarksideHeight) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ApplyStaged not implemented")
 }
+func (UnimplementedDarksideStreamerServer) Reorg(context.Context, *DarksideHeight) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reorg not implemented")
+}
+func (UnimplementedDarksideStreamerServer) StageBranch(context.Context, *DarksideBranch) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StageBranch not implemented")
+}
+func (UnimplementedDarksideStreamerServer) ActivateBranch(context.Context, *DarksideBranch) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ActivateBranch not implemented")
+}
+func (UnimplementedDarksideStreamerServer) StageGap(context.Context, *DarksideHeight) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StageGap not implemented")
+}
+func (UnimplementedDarksideStreamerServer) GetStats(context.Context, *Empty) (*DarksideStats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
 func (UnimplementedDarksideStreamerServer) GetIncomingTransactions(*Empty, DarksideStreamer_GetIncomingTransactionsServer) error {
 	return status.Errorf(codes.Unimplemented, "method GetIncomingTransactions not implemented")
 }
@@ -477,6 +703,12 @@ func (UnimplementedDarksideStreamerServer) ClearAllTreeStates(context.Context, *
 func (UnimplementedDarksideStreamerServer) SetSubtreeRoots(context.Context, *DarksideSubtreeRoots) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SetSubtreeRoots not implemented")
 }
+func (UnimplementedDarksideStreamerServer) SetLatency(context.Context, *DarksideLatency) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetLatency not implemented")
+}
+func (UnimplementedDarksideStreamerServer) SetErrorRate(context.Context, *DarksideErrorRate) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetErrorRate not implemented")
+}
 func (UnimplementedDarksideStreamerServer) Stop(context.Context, *Empty) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Stop not implemented")
 }
@@ -573,6 +805,24 @@ func _DarksideStreamer_StageBlocksCreate_Handler(srv interface{}, ctx context.Co
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DarksideStreamer_StageBlockWithTime_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DarksideBlockTime)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DarksideStreamerServer).StageBlockWithTime(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DarksideStreamer_StageBlockWithTime_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DarksideStreamerServer).StageBlockWithTime(ctx, req.(*DarksideBlockTime))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _DarksideStreamer_StageTransactionsStream_Handler(srv interface{}, stream grpc.ServerStream) error {
 	return srv.(DarksideStreamerServer).StageTransactionsStream(&darksideStreamerStageTransactionsStreamServer{stream})
 }
@@ -617,6 +867,42 @@ func _DarksideStreamer_StageTransactions_Handler(srv interface{}, ctx context.Co
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DarksideStreamer_StageTxWithID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DarksideTxWithID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DarksideStreamerServer).StageTxWithID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DarksideStreamer_StageTxWithID_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DarksideStreamerServer).StageTxWithID(ctx, req.(*DarksideTxWithID))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DarksideStreamer_StageMempoolTx_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RawTransaction)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DarksideStreamerServer).StageMempoolTx(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DarksideStreamer_StageMempoolTx_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DarksideStreamerServer).StageMempoolTx(ctx, req.(*RawTransaction))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _DarksideStreamer_ApplyStaged_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(DarksideHeight)
 	if err := dec(in); err != nil {
@@ -635,6 +921,96 @@ func _DarksideStreamer_ApplyStaged_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DarksideStreamer_Reorg_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DarksideHeight)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DarksideStreamerServer).Reorg(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DarksideStreamer_Reorg_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DarksideStreamerServer).Reorg(ctx, req.(*DarksideHeight))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DarksideStreamer_StageBranch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DarksideBranch)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DarksideStreamerServer).StageBranch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DarksideStreamer_StageBranch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DarksideStreamerServer).StageBranch(ctx, req.(*DarksideBranch))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DarksideStreamer_ActivateBranch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DarksideBranch)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DarksideStreamerServer).ActivateBranch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DarksideStreamer_ActivateBranch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DarksideStreamerServer).ActivateBranch(ctx, req.(*DarksideBranch))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DarksideStreamer_StageGap_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DarksideHeight)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DarksideStreamerServer).StageGap(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DarksideStreamer_StageGap_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DarksideStreamerServer).StageGap(ctx, req.(*DarksideHeight))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DarksideStreamer_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DarksideStreamerServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DarksideStreamer_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DarksideStreamerServer).GetStats(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _DarksideStreamer_GetIncomingTransactions_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(Empty)
 	if err := stream.RecvMsg(m); err != nil {
@@ -782,6 +1158,42 @@ func _DarksideStreamer_SetSubtreeRoots_Handler(srv interface{}, ctx context.Cont
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DarksideStreamer_SetLatency_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DarksideLatency)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DarksideStreamerServer).SetLatency(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DarksideStreamer_SetLatency_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DarksideStreamerServer).SetLatency(ctx, req.(*DarksideLatency))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DarksideStreamer_SetErrorRate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DarksideErrorRate)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DarksideStreamerServer).SetErrorRate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DarksideStreamer_SetErrorRate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DarksideStreamerServer).SetErrorRate(ctx, req.(*DarksideErrorRate))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _DarksideStreamer_Stop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(Empty)
 	if err := dec(in); err != nil {
@@ -819,14 +1231,46 @@ var DarksideStreamer_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "StageBlocksCreate",
 			Handler:    _DarksideStreamer_StageBlocksCreate_Handler,
 		},
+		{
+			MethodName: "StageBlockWithTime",
+			Handler:    _DarksideStreamer_StageBlockWithTime_Handler,
+		},
 		{
 			MethodName: "StageTransactions",
 			Handler:    _DarksideStreamer_StageTransactions_Handler,
 		},
+		{
+			MethodName: "StageTxWithID",
+			Handler:    _DarksideStreamer_StageTxWithID_Handler,
+		},
+		{
+			MethodName: "StageMempoolTx",
+			Handler:    _DarksideStreamer_StageMempoolTx_Handler,
+		},
 		{
 			MethodName: "ApplyStaged",
 			Handler:    _DarksideStreamer_ApplyStaged_Handler,
 		},
+		{
+			MethodName: "Reorg",
+			Handler:    _DarksideStreamer_Reorg_Handler,
+		},
+		{
+			MethodName: "StageBranch",
+			Handler:    _DarksideStreamer_StageBranch_Handler,
+		},
+		{
+			MethodName: "ActivateBranch",
+			Handler:    _DarksideStreamer_ActivateBranch_Handler,
+		},
+		{
+			MethodName: "StageGap",
+			Handler:    _DarksideStreamer_StageGap_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _DarksideStreamer_GetStats_Handler,
+		},
 		{
 			MethodName: "ClearIncomingTransactions",
 			Handler:    _DarksideStreamer_ClearIncomingTransactions_Handler,
@@ -855,6 +1299,14 @@ var DarksideStreamer_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SetSubtreeRoots",
 			Handler:    _DarksideStreamer_SetSubtreeRoots_Handler,
 		},
+		{
+			MethodName: "SetLatency",
+			Handler:    _DarksideStreamer_SetLatency_Handler,
+		},
+		{
+			MethodName: "SetErrorRate",
+			Handler:    _DarksideStreamer_SetErrorRate_Handler,
+		},
 		{
 			MethodName: "Stop",
 			Handler:    _DarksideStreamer_Stop_Handler,